@@ -3,6 +3,7 @@ package apachelog
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -10,14 +11,73 @@ const (
 	// DefaultConfPath is the typical Apache configuration file on Debian/Ubuntu.
 	DefaultConfPath = "/etc/apache2/apache2.conf"
 
-	logFormat = "error"
+	// DefaultLogFormat is the LogFormat nickname the CustomLog pipe uses
+	// unless ConfigParams.LogFormat overrides it.
+	DefaultLogFormat = "error"
+	// DefaultDropInName names the drop-in configuration of PlacementDropIn.
+	DefaultDropInName = "nixpersist"
 )
 
-// ConfigParams captures the inputs required to render the Apache CustomLog
-// directive that invokes an external payload.
+// Placements of the rendered directive.
+const (
+	// PlacementMain appends the directive to the main configuration.
+	PlacementMain = "main"
+	// PlacementDropIn writes a standalone configuration: conf-available
+	// enabled with a2enconf on Debian, conf.d elsewhere.
+	PlacementDropIn = "drop-in"
+	// PlacementVHost inserts the directive inside a chosen <VirtualHost>.
+	PlacementVHost = "vhost"
+)
+
+var dropInNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ConfigParams captures the inputs required to render the Apache log
+// directive that invokes an external payload, and where to write it.
 type ConfigParams struct {
 	// Payload is the absolute path to the executable that Apache should invoke.
 	Payload string
+	// Directive is "CustomLog" (the default) or "ErrorLog".
+	Directive string
+	// LogFormat is the CustomLog format, a LogFormat nickname or a format
+	// string; DefaultLogFormat when empty.
+	LogFormat string
+	// Placement is PlacementMain (the default), PlacementDropIn or
+	// PlacementVHost.
+	Placement string
+	// Name is the drop-in configuration name without ".conf";
+	// DefaultDropInName when empty.
+	Name string
+	// VHost selects the <VirtualHost> of PlacementVHost by ServerName,
+	// ServerAlias or address, such as "*:443".
+	VHost string
+}
+
+func (p ConfigParams) directive() string {
+	if strings.EqualFold(p.Directive, "ErrorLog") {
+		return "ErrorLog"
+	}
+	return "CustomLog"
+}
+
+func (p ConfigParams) logFormat() string {
+	if p.LogFormat == "" {
+		return DefaultLogFormat
+	}
+	return p.LogFormat
+}
+
+func (p ConfigParams) placement() string {
+	if p.Placement == "" {
+		return PlacementMain
+	}
+	return p.Placement
+}
+
+func (p ConfigParams) name() string {
+	if p.Name == "" {
+		return DefaultDropInName
+	}
+	return p.Name
 }
 
 // Validate enforces the constraints required to safely render the configuration.
@@ -35,6 +95,38 @@ func (p ConfigParams) Validate() error {
 	if !strings.HasPrefix(payload, "/") {
 		return errors.New("payload must be an absolute path")
 	}
+	return p.validateTarget()
+}
+
+// validateTarget checks the directive and placement, which Remove needs to
+// find the directive without a payload.
+func (p ConfigParams) validateTarget() error {
+	if p.Directive != "" && !strings.EqualFold(p.Directive, "CustomLog") && !strings.EqualFold(p.Directive, "ErrorLog") {
+		return fmt.Errorf("directive must be CustomLog or ErrorLog, got %q", p.Directive)
+	}
+	if p.LogFormat != "" {
+		if p.directive() == "ErrorLog" {
+			return errors.New("log format applies to CustomLog only; ErrorLog pipes use ErrorLogFormat")
+		}
+		if strings.TrimSpace(p.LogFormat) == "" || strings.ContainsAny(p.LogFormat, "\"\n") {
+			return errors.New("log format must not be blank or contain quotes or newlines")
+		}
+	}
+	switch p.placement() {
+	case PlacementMain, PlacementDropIn:
+		if p.VHost != "" {
+			return errors.New("a virtual host applies to the vhost placement only")
+		}
+	case PlacementVHost:
+		if strings.TrimSpace(p.VHost) == "" {
+			return errors.New("the vhost placement requires a virtual host (ServerName, ServerAlias or address)")
+		}
+	default:
+		return fmt.Errorf("placement must be %s, %s or %s, got %q", PlacementMain, PlacementDropIn, PlacementVHost, p.Placement)
+	}
+	if !dropInNameRe.MatchString(p.name()) || strings.HasSuffix(p.name(), ".conf") {
+		return fmt.Errorf("invalid drop-in name %q (letters, digits, '.', '_' and '-', without .conf)", p.Name)
+	}
 	return nil
 }
 
@@ -45,5 +137,30 @@ func RenderConfig(p ConfigParams) (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("CustomLog \"|%s\" %s\n", strings.TrimSpace(p.Payload), logFormat), nil
+	pipe := fmt.Sprintf("%s \"|%s\"", p.directive(), strings.TrimSpace(p.Payload))
+	if p.directive() == "ErrorLog" {
+		return pipe + "\n", nil
+	}
+	format := p.logFormat()
+	if strings.ContainsAny(format, " \t") {
+		format = `"` + format + `"`
+	}
+	return pipe + " " + format + "\n", nil
+}
+
+// matches reports whether d is the directive rendered from p: a directive
+// of the same kind and, for CustomLog, format, piping to p's payload. When
+// p has no payload, any piped command matches.
+func (p ConfigParams) matches(d *Directive) bool {
+	command, piped := pipedCommand(d)
+	if !piped || !d.Is(p.directive()) {
+		return false
+	}
+	if payload := strings.TrimSpace(p.Payload); payload != "" && command != payload {
+		return false
+	}
+	if p.directive() == "ErrorLog" {
+		return len(d.Args) == 1
+	}
+	return len(d.Args) == 2 && d.Args[1] == p.logFormat()
 }
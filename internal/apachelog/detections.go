@@ -0,0 +1,112 @@
+package apachelog
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/sigma"
+)
+
+var apacheTags = []string{"attack.persistence", "attack.t1546"}
+
+// Detections returns Sigma rules for the log pipe rendered from p and
+// written where p places it, with confPath as the main configuration.
+func Detections(p ConfigParams, confPath string) ([]sigma.Rule, error) {
+	directive, err := RenderConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.TrimSpace(p.Payload)
+	target := written(p, confPath)
+	return []sigma.Rule{
+		{
+			Title:       "Apache Configuration Modified",
+			Description: fmt.Sprintf("Detects writes to %s, where NixPersist writes its piped log directive.", strings.Join(target, " and ")),
+			Tags:        apacheTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_event"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{{Name: "selection", Fields: []sigma.Field{{Name: "TargetFilename", Values: target}}}},
+				Condition:  "selection",
+			},
+			FalsePositives: []string{"Package upgrades and configuration management touching Apache"},
+			Level:          "medium",
+		},
+		{
+			Title:       "Apache Piped Log Directive In Configuration",
+			Description: fmt.Sprintf("Detects CustomLog, ErrorLog or TransferLog directives that pipe log lines to a program. The simulation pipes to %s.", payload),
+			Tags:        apacheTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_content"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_file", Fields: []sigma.Field{{Name: "TargetFilename", Modifiers: []string{"startswith"}, Values: []string{"/etc/apache2/", "/etc/httpd/"}}}},
+					{Name: "selection_exact", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains"}, Values: []string{strings.TrimSpace(directive)}}}},
+					{Name: "selection_generic", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"re"}, Values: []string{`(?im)^\s*(CustomLog|ErrorLog|TransferLog)\s+"?\|`}}}},
+				},
+				Condition: "selection_file and (selection_exact or selection_generic)",
+			},
+			FalsePositives: []string{"Log rotation through rotatelogs or cronolog pipes"},
+			Level:          "high",
+		},
+		{
+			Title:       "Apache Spawning Piped Log Payload",
+			Description: fmt.Sprintf("Detects the Apache parent process executing %s, which it keeps running as a piped logger.", payload),
+			Tags:        append([]string{"attack.execution"}, apacheTags...),
+			LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_parent", Fields: []sigma.Field{{Name: "ParentImage", Modifiers: []string{"endswith"}, Values: []string{"/apache2", "/httpd"}}}},
+					{Name: "selection_payload", Fields: []sigma.Field{{Name: "Image", Values: []string{sigma.Image(payload)}}}},
+				},
+				Condition: "all of selection_*",
+			},
+			FalsePositives: []string{"Legitimate piped loggers such as rotatelogs"},
+			Level:          "high",
+		},
+	}, nil
+}
+
+// AuditRules returns auditd rules watching the Apache configuration tree that
+// holds confPath, and the directory written to when outside it, and logging
+// execve of the payload and the Apache binary.
+func AuditRules(p ConfigParams, confPath string) ([]audit.Rule, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	key := audit.KeyPrefix + "apache-log"
+	dirs := []string{filepath.Dir(confPath)}
+	for _, path := range written(p, confPath) {
+		dir := filepath.Dir(path)
+		if !slices.ContainsFunc(dirs, func(d string) bool { return dir == d || strings.HasPrefix(dir, d+"/") }) {
+			dirs = append(dirs, dir)
+		}
+	}
+	var rules []audit.Rule
+	for _, dir := range dirs {
+		rules = append(rules, audit.Watch(dir, "wa", key))
+	}
+	return append(rules,
+		audit.Exec(sigma.Image(strings.TrimSpace(p.Payload)), key+"-exec"),
+		audit.Exec(audit.FirstExisting("/usr/sbin/apache2", "/usr/sbin/httpd"), key+"-exec"),
+	), nil
+}
+
+// written returns the files an install with p writes: the configuration
+// edited or the drop-in and its conf-enabled link. A virtual host that
+// cannot be resolved falls back to confPath.
+func written(p ConfigParams, confPath string) []string {
+	if p.placement() == PlacementDropIn {
+		path, loadedAs := dropInPaths(p, confPath)
+		if loadedAs != path {
+			return []string{path, loadedAs}
+		}
+		return []string{path}
+	}
+	path, err := targetPath(p, confPath)
+	if err != nil {
+		return []string{confPath}
+	}
+	return []string{path}
+}
@@ -0,0 +1,30 @@
+package apachelog
+
+import (
+	"strings"
+	"testing"
+
+	"nixpersist/internal/sigma"
+)
+
+func TestDetectionsMatchRenderedDirective(t *testing.T) {
+	rules, err := Detections(ConfigParams{Payload: "/usr/local/bin/beacon --quiet"}, "/etc/apache2/apache2.conf")
+	if err != nil {
+		t.Fatalf("Detections returned error: %v", err)
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{
+		"TargetFilename: '/etc/apache2/apache2.conf'",
+		`Content|contains: 'CustomLog "|/usr/local/bin/beacon --quiet" error'`,
+		"ParentImage|endswith:",
+		"Image: '/usr/local/bin/beacon'",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := Detections(ConfigParams{Payload: "relative"}, DefaultConfPath); err == nil {
+		t.Fatal("expected invalid params to be rejected")
+	}
+}
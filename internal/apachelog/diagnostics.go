@@ -3,24 +3,47 @@ package apachelog
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"nixpersist/internal/selinux"
 )
 
+// httpdDomain is the SELinux domain the targeted policy runs httpd and its
+// piped loggers in.
+const httpdDomain = "httpd_t"
+
 // Result captures diagnostic data about the Apache environment.
 type Result struct {
-	ConfigPath         string
-	ConfigExists       bool
-	ConfigWritable     bool
-	RunningAsRoot      bool
-	SystemctlAvailable bool
-	ApacheCtlAvailable bool
-	ServiceActive      bool
-	Notes              []string
+	// Layout is the distribution packaging whose paths and service are used.
+	Layout             Layout `json:"layout"`
+	ConfigPath         string `json:"config_path"`
+	ConfigExists       bool   `json:"config_exists"`
+	ConfigWritable     bool   `json:"config_writable"`
+	RunningAsRoot      bool   `json:"running_as_root"`
+	SystemctlAvailable bool   `json:"systemctl_available"`
+	ApacheCtlAvailable bool   `json:"apachectl_available"`
+	ServiceActive      bool   `json:"service_active"`
+	// ReloadCommand is how a graceful reload would be applied; empty when
+	// neither systemctl, apachectl nor service is available.
+	ReloadCommand string `json:"reload_command,omitempty"`
+	// Validation is the outcome of apachectl -t on a staged copy; empty when
+	// no config was validated.
+	Validation string `json:"validation,omitempty"`
+	// Effect is where the directive would take effect once installed, as
+	// evaluated from the loaded configuration; empty when not evaluated.
+	Effect string `json:"effect,omitempty"`
+	// SELinux is the mode, httpd's domain and whether it may execute the
+	// payload.
+	SELinux  selinux.Report `json:"selinux"`
+	Warnings []string       `json:"warnings,omitempty"`
+	Notes    []string       `json:"notes"`
 }
 
 // HasAccess reports whether Apache is likely manageable with the current privileges.
 func (r Result) HasAccess() bool {
-	return r.ConfigExists && (r.ConfigWritable || r.RunningAsRoot) && r.SystemctlAvailable
+	return r.ConfigExists && (r.ConfigWritable || r.RunningAsRoot) && r.ReloadCommand != ""
 }
 
 // Render formats the diagnostic information in a human-readable form.
@@ -33,12 +56,32 @@ func (r Result) Render() string {
 		}
 		fmt.Fprintf(&b, "- %s: %s\n", label, status)
 	}
+	fmt.Fprintf(&b, "- layout: %s (%s)\n", r.Layout.Name, r.Layout.DetectedBy)
 	writeLine(fmt.Sprintf("config present (%s)", r.ConfigPath), r.ConfigExists)
 	writeLine("config writable", r.ConfigWritable)
 	writeLine("running as root", r.RunningAsRoot)
 	writeLine("systemctl available", r.SystemctlAvailable)
-	writeLine("apachectl/apache2ctl available", r.ApacheCtlAvailable)
-	writeLine("apache2 service active", r.ServiceActive)
+	writeLine(strings.Join(r.Layout.Validators, "/")+" available", r.ApacheCtlAvailable)
+	writeLine(r.Layout.Service+" service active", r.ServiceActive)
+	if r.ReloadCommand != "" {
+		fmt.Fprintf(&b, "- graceful reload: %s\n", r.ReloadCommand)
+	}
+	if r.Validation != "" {
+		fmt.Fprintf(&b, "- config validation (-t on a staged copy): %s\n", r.Validation)
+	}
+	if r.Effect != "" {
+		fmt.Fprintf(&b, "- directive takes effect in: %s\n", r.Effect)
+	}
+	for _, line := range r.SELinux.Lines() {
+		b.WriteString(line + "\n")
+	}
+
+	if len(r.Warnings) > 0 {
+		b.WriteString("\nWarnings:\n")
+		for _, w := range r.Warnings {
+			fmt.Fprintf(&b, "- %s\n", w)
+		}
+	}
 
 	if len(r.Notes) > 0 {
 		b.WriteString("\nNotes:\n")
@@ -52,15 +95,15 @@ func (r Result) Render() string {
 
 // Check inspects the local system to determine whether Apache log piping can be installed.
 func Check(confPath string) Result {
+	var r Result
+	r.Layout = layoutFor(confPath)
 	if strings.TrimSpace(confPath) == "" {
-		confPath = DefaultConfPath
+		confPath = r.Layout.ConfPath
 	}
-
-	var r Result
 	r.ConfigPath = confPath
 	r.RunningAsRoot = os.Geteuid() == 0
 	if !r.RunningAsRoot {
-		r.Notes = append(r.Notes, "not running as root; writes to apache2.conf may fail")
+		r.Notes = append(r.Notes, fmt.Sprintf("not running as root; writes to %s may fail", filepath.Base(confPath)))
 	}
 
 	if _, err := os.Stat(confPath); err == nil {
@@ -78,28 +121,74 @@ func Check(confPath string) Result {
 
 	if _, err := lookPath("systemctl"); err == nil {
 		r.SystemctlAvailable = true
-		cmd := execCommand("systemctl", "is-active", serviceName)
+		cmd := execCommand("systemctl", "is-active", r.Layout.Service)
 		output, err := cmd.CombinedOutput()
 		if err == nil {
 			r.ServiceActive = strings.TrimSpace(string(output)) == "active"
 		} else {
-			r.Notes = append(r.Notes, fmt.Sprintf("systemctl is-active %s failed: %v", serviceName, err))
+			r.Notes = append(r.Notes, fmt.Sprintf("systemctl is-active %s failed: %v", r.Layout.Service, err))
 		}
 	} else {
-		r.Notes = append(r.Notes, "systemctl binary not found; manual service restart required")
+		r.Notes = append(r.Notes, "systemctl binary not found; reloads fall back to apachectl -k or service")
 	}
 
-	if _, err := lookPath("apache2ctl"); err == nil {
-		r.ApacheCtlAvailable = true
-	} else if _, err := lookPath("apachectl"); err == nil {
+	if _, ok := r.Layout.validator(); ok {
 		r.ApacheCtlAvailable = true
 	} else {
-		r.Notes = append(r.Notes, "apachectl/apache2ctl not found on PATH")
+		r.Notes = append(r.Notes, strings.Join(r.Layout.Validators, "/")+" not found on PATH")
+	}
+
+	if command, err := reloadCommand(r.Layout, ReloadGraceful); err == nil {
+		r.ReloadCommand = strings.Join(command, " ")
+	} else {
+		r.Notes = append(r.Notes, err.Error()+"; manual restart required")
 	}
 
 	return r
 }
 
+// inspectSELinux fills r.SELinux for payload; with avc it also lists the
+// denials of the last selinux.RecentWindow.
+func (r *Result) inspectSELinux(payload string, avc bool) {
+	var notes []string
+	r.SELinux, notes = selinux.Inspect(httpdNames, httpdDomain, payload)
+	r.Notes = append(r.Notes, notes...)
+	if avc {
+		if err := r.SELinux.LoadDenials(time.Now().Add(-selinux.RecentWindow)); err != nil {
+			r.Notes = append(r.Notes, err.Error())
+		}
+	}
+	if p := r.SELinux.Prediction; p != nil && p.Verdict == selinux.Denied {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("SELinux would deny %s executing %s, so httpd logs the pipe as failed and never runs it: %s", r.SELinux.Domain, r.SELinux.Program, p.Reason))
+	}
+}
+
+// warnSELinux warns when SELinux is predicted to keep httpd from executing
+// payload.
+func warnSELinux(payload string) {
+	r, _ := selinux.Inspect(httpdNames, httpdDomain, payload)
+	if r.Prediction != nil && r.Prediction.Verdict == selinux.Denied {
+		fmt.Fprintf(warnOut, "warning: SELinux would deny %s executing %s: %s\n", r.Domain, r.Program, r.Prediction.Reason)
+	}
+}
+
+// selinuxDenials prints the AVC denials logged for httpd's domain since
+// since and returns a summary for the install message.
+func selinuxDenials(since time.Time) string {
+	r, _ := selinux.Inspect(httpdNames, httpdDomain, "")
+	if r.Mode == selinux.Disabled {
+		return "; SELinux disabled"
+	}
+	if err := r.LoadDenials(since); err != nil {
+		fmt.Fprintf(warnOut, "warning: %v\n", err)
+		return "; AVC denials not read"
+	}
+	for _, d := range r.Denials {
+		fmt.Fprintf(warnOut, "AVC denial: %s\n", d)
+	}
+	return fmt.Sprintf("; %d AVC denial(s) for %s since install", len(r.Denials), r.Domain)
+}
+
 func fileWritable(path string) bool {
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
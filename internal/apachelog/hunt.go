@@ -0,0 +1,117 @@
+package apachelog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// configRoots are the Apache configuration trees of the supported layouts.
+var configRoots = []string{"/etc/apache2", "/etc/httpd"}
+
+// rotationHelpers are programs commonly used as legitimate piped loggers.
+var rotationHelpers = map[string]bool{"rotatelogs": true, "rotatelogs2": true, "cronolog": true, "logger": true}
+
+// Hunt reports every piped CustomLog, ErrorLog, TransferLog and GlobalLog
+// directive in the Apache configuration under root. Directives are rated by
+// the effective configuration each main config loads; files in the
+// configuration trees that no main config loads are reported as dormant.
+func Hunt(root string) hunt.Report {
+	var r hunt.Report
+	// loaded holds the real paths of the files read, as the trees are walked
+	// without following the symlinks *-enabled directories include.
+	loaded := make(map[string]bool)
+	mains := 0
+	for _, main := range mainConfigs {
+		cfg, err := LoadConfig(root, main)
+		if err != nil {
+			continue
+		}
+		mains++
+		for _, f := range cfg.Files {
+			loaded[realPath(root, f)] = true
+		}
+		for _, e := range cfg.Errors {
+			r.Notes = append(r.Notes, e.Error())
+		}
+		for _, pl := range cfg.PipedLogs() {
+			r.Findings = append(r.Findings, pipeFinding(pl, pl.Disabled))
+		}
+	}
+
+	why := ""
+	if mains > 0 {
+		why = "not loaded by " + strings.Join(existing(root, mainConfigs), " or ")
+	} else {
+		r.Notes = append(r.Notes, fmt.Sprintf("no main Apache configuration (%s); configuration trees scanned as if loaded", strings.Join(mainConfigs, ", ")))
+	}
+	for _, dir := range configRoots {
+		for _, file := range hunt.Walk(root, dir, 4, func(string) bool { return true }) {
+			if loaded[realPath(root, file)] {
+				continue
+			}
+			data, err := os.ReadFile(hunt.HostPath(root, file))
+			if err != nil {
+				r.Notes = append(r.Notes, fmt.Sprintf("read %s: %v", file, err))
+				continue
+			}
+			ds, err := ParseConfig(file, data)
+			if err != nil {
+				r.Notes = append(r.Notes, err.Error())
+			}
+			cfg := &Config{Directives: ds}
+			for _, pl := range cfg.PipedLogs() {
+				r.Findings = append(r.Findings, pipeFinding(pl, why))
+			}
+		}
+	}
+	return r
+}
+
+func realPath(root, path string) string {
+	p, err := filepath.EvalSymlinks(hunt.HostPath(root, path))
+	if err != nil {
+		return hunt.HostPath(root, path)
+	}
+	return p
+}
+
+// existing returns the paths that exist under root.
+func existing(root string, paths []string) []string {
+	var out []string
+	for _, p := range paths {
+		if _, err := os.Stat(hunt.HostPath(root, p)); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// pipeFinding reports a piped log. dormant explains why httpd never starts
+// the program; empty when it does.
+func pipeFinding(pl PipedLog, dormant string) hunt.Finding {
+	program := strings.Fields(pl.Command)[0]
+	severity, why := hunt.High, ""
+	switch {
+	case rotationHelpers[path.Base(program)]:
+		severity, why = hunt.Low, " (common log rotation helper)"
+	case dormant != "":
+		severity, why = hunt.Low, " ("+dormant+")"
+	}
+	summary := fmt.Sprintf("Apache %s pipes log lines to %s in %s%s", pl.Directive.Name, program, pl.Scope, why)
+	if len(pl.Overridden) > 0 {
+		summary += fmt.Sprintf("; %d virtual host(s) log elsewhere", len(pl.Overridden))
+	}
+	return hunt.Finding{
+		Technique: "apache-log",
+		Severity:  severity,
+		Path:      pl.Directive.Pos.File,
+		Line:      pl.Directive.Pos.Line,
+		Summary:   summary,
+		Evidence:  pl.Directive.Text,
+	}
+}
@@ -0,0 +1,107 @@
+package apachelog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/hunt"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestPipedCommandVariants(t *testing.T) {
+	cases := map[string]string{
+		`CustomLog "|/usr/bin/x arg" combined`:                "/usr/bin/x arg",
+		`errorlog "||/opt/y"`:                                 "/opt/y",
+		`TransferLog |$/bin/z`:                                "/bin/z",
+		`  ErrorLog "|/usr/sbin/rotatelogs /var/log/e 86400"`: "/usr/sbin/rotatelogs /var/log/e 86400",
+	}
+	for line, want := range cases {
+		ds, err := ParseConfig("t.conf", []byte(line))
+		if err != nil || len(ds) != 1 {
+			t.Fatalf("ParseConfig(%q) = %v, %v", line, ds, err)
+		}
+		if got, ok := pipedCommand(ds[0]); !ok || got != want {
+			t.Fatalf("pipedCommand(%q) = %q, %v; want %q", line, got, ok, want)
+		}
+	}
+	for _, line := range []string{`CustomLog ${APACHE_LOG_DIR}/access.log combined`, `ErrorLog syslog:local1`, `LogFormat "|x" y`, `<IfModule |x>`} {
+		ds, _ := ParseConfig("t.conf", []byte(line+"\n"))
+		if len(ds) > 0 {
+			if _, ok := pipedCommand(ds[0]); ok {
+				t.Fatalf("pipedCommand(%q) matched", line)
+			}
+		}
+	}
+}
+
+func TestHuntScansIncludeTrees(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/apache2/apache2.conf": "ServerName x\nCustomLog \"|/usr/bin/beacon\" error\n" +
+			"IncludeOptional sites-enabled/*.conf\n<IfModule mod_nope.c>\n  ErrorLog |/opt/hidden\n</IfModule>\n",
+		"etc/apache2/sites-available/000-default.conf": "<VirtualHost *:80>\n  ErrorLog \"|/usr/bin/rotatelogs /var/log/e 86400\"\n</VirtualHost>\n",
+		"etc/apache2/conf-available/old.conf":          "# CustomLog \"|/bin/commented\" x\nTransferLog \"|/opt/stale\"\n",
+		"etc/httpd/conf.d/ssl.conf":                    "CustomLog |/tmp/x combined\n",
+	})
+	if err := os.MkdirAll(filepath.Join(root, "etc/apache2/sites-enabled"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink("../sites-available/000-default.conf", filepath.Join(root, "etc/apache2/sites-enabled/000-default.conf")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	r := Hunt(root)
+	want := map[string]hunt.Severity{
+		"/etc/apache2/apache2.conf:2":                   hunt.High,
+		"/etc/apache2/apache2.conf:5":                   hunt.Low,
+		"/etc/apache2/sites-enabled/000-default.conf:2": hunt.Low,
+		"/etc/apache2/conf-available/old.conf:2":        hunt.Low,
+		"/etc/httpd/conf.d/ssl.conf:1":                  hunt.Low,
+	}
+	if len(r.Findings) != len(want) {
+		t.Fatalf("expected %d findings, got %+v", len(want), r.Findings)
+	}
+	for _, f := range r.Findings {
+		sev, ok := want[f.Location()]
+		if !ok || sev != f.Severity {
+			t.Fatalf("unexpected finding %+v", f)
+		}
+		switch f.Location() {
+		case "/etc/apache2/apache2.conf:5":
+			if !strings.Contains(f.Summary, "<IfModule mod_nope.c> at /etc/apache2/apache2.conf:4 is false") {
+				t.Fatalf("disabled pipe not explained: %s", f.Summary)
+			}
+		case "/etc/apache2/sites-enabled/000-default.conf:2":
+			if !strings.Contains(f.Summary, "<VirtualHost *:80>") {
+				t.Fatalf("virtual host scope missing: %s", f.Summary)
+			}
+		case "/etc/apache2/conf-available/old.conf:2":
+			if !strings.Contains(f.Summary, "not loaded by /etc/apache2/apache2.conf") {
+				t.Fatalf("dormant file not explained: %s", f.Summary)
+			}
+		}
+	}
+}
+
+func TestHuntWithoutMainConfig(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"etc/httpd/conf.d/ssl.conf": "CustomLog |/tmp/x combined\n"})
+	r := Hunt(root)
+	if len(r.Findings) != 1 || r.Findings[0].Severity != hunt.High || len(r.Notes) != 1 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+}
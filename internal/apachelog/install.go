@@ -4,89 +4,191 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
-)
 
-const serviceName = "apache2"
+	"nixpersist/internal/snapshot"
+	"nixpersist/internal/txn"
+)
 
 var (
 	execCommand = exec.Command
 	lookPath    = exec.LookPath
+
+	// warnOut receives non-fatal warnings such as configuration drift.
+	warnOut io.Writer = os.Stderr
 )
 
-// Install appends the rendered Apache log pipe configuration to the provided
-// configuration file. When restart is true, systemctl restart apache2 is invoked.
-func Install(params ConfigParams, confPath string, restart bool) error {
+// Install writes the rendered Apache log pipe where params.Placement says:
+// appended to confPath, or to the detected layout's main configuration when
+// confPath is empty; in a drop-in that is enabled with a2enconf on Debian;
+// or inside the selected <VirtualHost>. Unless reload is ReloadNone, httpd
+// is reloaded that way and health-checked; on failure the previous
+// configuration is restored and httpd reloaded again.
+func Install(params ConfigParams, confPath string, reload Reload) error {
+	layout := layoutFor(confPath)
 	if strings.TrimSpace(confPath) == "" {
-		confPath = DefaultConfPath
+		confPath = layout.ConfPath
 	}
+	reloadService := func() error { return reloadApache(layout, reload) }
 
-	cfg, err := RenderConfig(params)
+	st, err := stage(params, confPath)
 	if err != nil {
 		return err
 	}
-
-	original, mode, err := readConfig(confPath)
-	if err != nil {
-		return err
-	}
-
-	if containsCustomLogDirective(string(original), strings.TrimSpace(cfg)) {
+	cfg, _ := RenderConfig(params)
+	if containsCustomLogDirective(string(st.Before), strings.TrimSpace(cfg)) {
 		return errors.New("apache-log snippet already present in configuration")
 	}
 
-	var buf bytes.Buffer
-	buf.Write(original)
-	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
-		buf.WriteByte('\n')
+	var tx txn.Txn
+	if reload != ReloadNone {
+		// Registered first so it runs last, once the file has been restored.
+		tx.OnRollback(reloadService)
 	}
-	buf.WriteString(cfg)
-	if !strings.HasSuffix(cfg, "\n") {
-		buf.WriteByte('\n')
+	abort := func() error { return nil }
+	if !st.Created {
+		snap, err := snapshot.Take(st.Path)
+		if err != nil {
+			return err
+		}
+		if err := snap.Save(st.After); err != nil {
+			return err
+		}
+		abort = snap.Abort
+	} else if err := os.MkdirAll(filepath.Dir(st.Path), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(st.Path), err)
 	}
-
-	if err := os.WriteFile(confPath, buf.Bytes(), mode); err != nil {
+	tx.OnRollback(abort)
+	if err := tx.WriteFile(st.Path, st.After, st.Mode); err != nil {
+		_ = abort()
 		return fmt.Errorf("write apache configuration: %w", err)
 	}
+	if err := enableDropIn(st, params.name(), confPath); err != nil {
+		return tx.Rollback(fmt.Errorf("enable %s: %w", st.Path, err))
+	}
+	tx.OnRollback(func() error { return disableDropIn(st.Path, st.LoadedAs, params.name(), confPath) })
 
-	if restart {
-		if err := restartApache(); err != nil {
-			return err
+	if reload != ReloadNone {
+		if err := reloadService(); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := checkApacheHealthy(layout); err != nil {
+			return tx.Rollback(err)
 		}
 	}
 
 	return nil
 }
 
-// Remove deletes the NixPersist Apache snippet from confPath. When restart is
-// true, systemctl restart apache2 is invoked.
-func Remove(confPath string, restart bool) error {
+// Remove deletes the NixPersist Apache directive placed as params says and
+// returns the file edited. A drop-in is disabled and deleted. Otherwise the
+// directive is looked for in the file it was written to, then in whichever
+// loaded file it was moved to; that file is restored byte for byte when it
+// is unchanged since install, or the directive is removed surgically and the
+// remaining drift is reported. httpd is then reloaded as reload says.
+func Remove(params ConfigParams, confPath string, reload Reload) (string, error) {
+	layout := layoutFor(confPath)
 	if strings.TrimSpace(confPath) == "" {
-		confPath = DefaultConfPath
+		confPath = layout.ConfPath
+	}
+	if err := params.validateTarget(); err != nil {
+		return confPath, err
 	}
 
-	original, mode, err := readConfig(confPath)
+	edited, err := removeDirective(params, confPath)
 	if err != nil {
-		return err
+		return edited, err
 	}
 
-	content, found := removeCustomLogDirective(string(original))
-	if !found {
-		return errors.New("apache-log snippet not found in configuration")
+	if reload != ReloadNone {
+		if err := reloadApache(layout, reload); err != nil {
+			return edited, err
+		}
 	}
-	if err := os.WriteFile(confPath, []byte(content), mode); err != nil {
-		return fmt.Errorf("write apache configuration: %w", err)
+
+	return edited, nil
+}
+
+func removeDirective(params ConfigParams, confPath string) (string, error) {
+	if params.placement() == PlacementDropIn {
+		path, loadedAs := dropInPaths(params, confPath)
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return path, fmt.Errorf("drop-in %s not present", path)
+			}
+			return path, fmt.Errorf("stat %s: %w", path, err)
+		}
+		if err := disableDropIn(path, loadedAs, params.name(), confPath); err != nil {
+			return path, err
+		}
+		if err := os.Remove(path); err != nil {
+			return path, fmt.Errorf("delete %s: %w", path, err)
+		}
+		return path, nil
 	}
 
-	if restart {
-		if err := restartApache(); err != nil {
-			return err
+	if _, _, err := readConfig(confPath); err != nil {
+		return confPath, err
+	}
+	expected, err := targetPath(params, confPath)
+	if err != nil {
+		// The virtual host is gone; the directive may still be loaded
+		// from wherever it ended up.
+		expected = confPath
+	}
+	target := locateDirective(params, expected, confPath)
+	if target != expected {
+		fmt.Fprintf(warnOut, "warning: %s pipe not in %s; removing it from %s, where it was moved\n", params.directive(), expected, target)
+		// The snippet left expected by hand, so its original is no longer
+		// the right content to restore.
+		if err := snapshot.Discard(expected); err != nil {
+			return target, err
 		}
 	}
 
-	return nil
+	res, err := snapshot.Revert(target, func(b []byte) ([]byte, bool) {
+		content, found := removeCustomLogDirective(params, string(b))
+		return []byte(content), found
+	})
+	if errors.Is(err, snapshot.ErrNotFound) {
+		return target, errors.New("apache-log snippet not found in configuration")
+	}
+	if err != nil {
+		return target, fmt.Errorf("revert apache configuration: %w", err)
+	}
+	if res.Drift != "" {
+		fmt.Fprintf(warnOut, "warning: %s changed since install; %s pipe removed surgically, remaining differences from the original:\n%s", target, params.directive(), res.Drift)
+	}
+	return target, nil
+}
+
+// stageConfig returns the current content and mode of confPath together with
+// the content after appending the rendered directive.
+func stageConfig(params ConfigParams, confPath string) ([]byte, []byte, os.FileMode, error) {
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	original, mode, err := readConfig(confPath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(original)
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(cfg)
+	if !strings.HasSuffix(cfg, "\n") {
+		buf.WriteByte('\n')
+	}
+	return original, buf.Bytes(), mode, nil
 }
 
 func readConfig(path string) ([]byte, os.FileMode, error) {
@@ -104,60 +206,81 @@ func readConfig(path string) ([]byte, os.FileMode, error) {
 	return data, info.Mode(), nil
 }
 
-func restartApache() error {
-	if _, err := lookPath("systemctl"); err != nil {
-		return fmt.Errorf("systemctl not available: %w", err)
-	}
-	cmd := execCommand("systemctl", "restart", serviceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("systemctl restart %s: %w: %s", serviceName, err, strings.TrimSpace(string(output)))
-	}
-	return nil
+func containsCustomLogDirective(content, directive string) bool {
+	ds, _ := ParseConfig("", []byte(content))
+	found := false
+	Walk(ds, func(d *Directive, _ []*Directive) bool {
+		found = found || d.Text == directive
+		return !found
+	})
+	return found
 }
 
-func containsCustomLogDirective(content, directive string) bool {
-	for _, line := range strings.Split(content, "\n") {
-		if strings.TrimSpace(line) == directive {
-			return true
+// findCustomLogDirective returns the first directive in ds that params
+// renders; see ConfigParams.matches.
+func findCustomLogDirective(params ConfigParams, ds []*Directive) (*Directive, bool) {
+	var found *Directive
+	Walk(ds, func(d *Directive, _ []*Directive) bool {
+		if found == nil && params.matches(d) {
+			found = d
 		}
-	}
-	return false
+		return found == nil
+	})
+	return found, found != nil
 }
 
-func isCustomLogDirective(line string) bool {
-	line = strings.TrimSpace(line)
-	if !strings.HasPrefix(line, `CustomLog "|`) {
-		return false
+// locateDirective returns the file holding the NixPersist log pipe:
+// expected when it is there, otherwise whichever file of the configuration
+// loaded with confPath an admin moved it to, such as a sites-enabled vhost.
+func locateDirective(params ConfigParams, expected, confPath string) string {
+	if data, err := os.ReadFile(expected); err == nil {
+		if ds, _ := ParseConfig(expected, data); ds != nil {
+			if _, ok := findCustomLogDirective(params, ds); ok {
+				return expected
+			}
+		}
+	}
+	cfg, err := LoadConfig("/", mainConfig(confPath))
+	if err != nil {
+		return expected
 	}
-	if !strings.HasSuffix(line, " "+logFormat) {
-		return false
+	if d, ok := findCustomLogDirective(params, cfg.Directives); ok {
+		return d.Pos.File
 	}
-	return true
+	return expected
 }
 
-func removeCustomLogDirective(content string) (string, bool) {
-	lines := strings.Split(content, "\n")
-	var result []string
-	removed := false
-
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		if !removed && isCustomLogDirective(line) {
-			removed = true
-			// Skip immediate blank line after the directive if present.
-			if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "" {
-				i++
-			}
-			continue
+// mainConfig returns the httpd main configuration that loads confPath: the
+// first of mainConfigs present on the host, trying the layout's own first,
+// or confPath itself.
+func mainConfig(confPath string) string {
+	candidates := mainConfigs
+	if own := layoutFor(confPath).ConfPath; slices.Contains(mainConfigs, own) {
+		candidates = append([]string{own}, mainConfigs...)
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
 		}
-		result = append(result, line)
 	}
+	return confPath
+}
 
-	if !removed {
+func removeCustomLogDirective(params ConfigParams, content string) (string, bool) {
+	ds, _ := ParseConfig("", []byte(content))
+	d, ok := findCustomLogDirective(params, ds)
+	if !ok {
 		return content, false
 	}
 
+	lines := strings.Split(content, "\n")
+	end := d.EndLine
+	// Skip immediate blank line after the directive if present.
+	if end < len(lines) && strings.TrimSpace(lines[end]) == "" {
+		end++
+	}
+	result := append(lines[:d.Pos.Line-1:d.Pos.Line-1], lines[end:]...)
+
 	// Trim trailing blank lines to keep file tidy.
 	for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" {
 		result = result[:len(result)-1]
@@ -1,13 +1,32 @@
 package apachelog
 
 import (
+	"bytes"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"nixpersist/internal/state"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "nixpersist-state")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(state.DirEnv, dir)
+	// Pin the detected layout to Debian whatever the test host runs.
+	osReleasePath = filepath.Join(dir, "os-release")
+	if err := os.WriteFile(osReleasePath, []byte("ID=debian\n"), 0644); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestInstallAndRemove(t *testing.T) {
 	dir := t.TempDir()
 	conf := filepath.Join(dir, "apache2.conf")
@@ -15,7 +34,7 @@ func TestInstallAndRemove(t *testing.T) {
 		t.Fatalf("write temp config: %v", err)
 	}
 
-	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, false); err != nil {
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
 		t.Fatalf("Install returned error: %v", err)
 	}
 
@@ -35,7 +54,7 @@ func TestInstallAndRemove(t *testing.T) {
 		t.Fatalf("expected CustomLog directive, got\n%s", content)
 	}
 
-	if err := Remove(conf, false); err != nil {
+	if _, err := Remove(ConfigParams{}, conf, ReloadNone); err != nil {
 		t.Fatalf("Remove returned error: %v", err)
 	}
 
@@ -55,11 +74,11 @@ func TestInstallDuplicateFails(t *testing.T) {
 		t.Fatalf("write temp config: %v", err)
 	}
 
-	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, false); err != nil {
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
 		t.Fatalf("Install returned error: %v", err)
 	}
 
-	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, false); err == nil {
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err == nil {
 		t.Fatalf("expected duplicate install to fail")
 	}
 }
@@ -86,16 +105,71 @@ func TestInstallRestartInvokesSystemctl(t *testing.T) {
 		execCommand = origExec
 	}()
 
-	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, true); err != nil {
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadRestart); err != nil {
 		t.Fatalf("Install returned error: %v", err)
 	}
 
-	if len(called) != 1 {
-		t.Fatalf("expected systemctl to be called once, got %v", called)
+	if len(called) != 2 {
+		t.Fatalf("expected restart and health check, got %v", called)
 	}
 	if called[0] != "systemctl restart apache2" {
 		t.Fatalf("unexpected command: %s", called[0])
 	}
+	if called[1] != "systemctl is-active apache2" {
+		t.Fatalf("unexpected health check: %s", called[1])
+	}
+}
+
+func TestInstallRollsBackOnRestartFailure(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "apache2.conf")
+	original := "ServerName localhost\n"
+	if err := os.WriteFile(conf, []byte(original), 0644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	var called []string
+	origLookPath := lookPath
+	origExec := execCommand
+	lookPath = func(string) (string, error) {
+		return "/bin/systemctl", nil
+	}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, name+" "+strings.Join(args, " "))
+		if len(called) == 1 {
+			return exec.Command("false")
+		}
+		return exec.Command("true")
+	}
+	defer func() {
+		lookPath = origLookPath
+		execCommand = origExec
+	}()
+
+	err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadRestart)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected rolled back install error, got %v", err)
+	}
+
+	final, _ := os.ReadFile(conf)
+	if string(final) != original {
+		t.Fatalf("expected original config after rollback, got %q", string(final))
+	}
+	if len(called) != 2 || called[1] != "systemctl restart apache2" {
+		t.Fatalf("expected apache2 to be restarted again after rollback, got %v", called)
+	}
+
+	// The rollback must not leave a stale snapshot behind.
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
+		t.Fatalf("Install after rollback returned error: %v", err)
+	}
+	if _, err := Remove(ConfigParams{}, conf, ReloadNone); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	final, _ = os.ReadFile(conf)
+	if string(final) != original {
+		t.Fatalf("unexpected final config: %q", string(final))
+	}
 }
 
 func TestRemoveRestartError(t *testing.T) {
@@ -105,7 +179,7 @@ func TestRemoveRestartError(t *testing.T) {
 		t.Fatalf("write temp config: %v", err)
 	}
 
-	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, false); err != nil {
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
 		t.Fatalf("Install returned error: %v", err)
 	}
 
@@ -122,7 +196,98 @@ func TestRemoveRestartError(t *testing.T) {
 		execCommand = origExec
 	}()
 
-	if err := Remove(conf, true); err == nil {
+	if _, err := Remove(ConfigParams{}, conf, ReloadRestart); err == nil {
 		t.Fatalf("expected restart failure to bubble up")
 	}
 }
+
+func TestRemoveRestoresOriginalBytes(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "apache2.conf")
+	original := "ServerName localhost\n\n\n"
+	if err := os.WriteFile(conf, []byte(original), 0640); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if _, err := Remove(ConfigParams{}, conf, ReloadNone); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+
+	final, err := os.ReadFile(conf)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if string(final) != original {
+		t.Fatalf("expected byte-identical restore, got %q", string(final))
+	}
+	info, err := os.Stat(conf)
+	if err != nil {
+		t.Fatalf("stat config: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("expected mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRemoveWarnsOnDrift(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "apache2.conf")
+	if err := os.WriteFile(conf, []byte("ServerName localhost\n"), 0644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadNone); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+
+	f, err := os.OpenFile(conf, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open config: %v", err)
+	}
+	f.WriteString("Listen 8080\n")
+	f.Close()
+
+	var warnings bytes.Buffer
+	origWarn := warnOut
+	warnOut = &warnings
+	defer func() { warnOut = origWarn }()
+
+	if _, err := Remove(ConfigParams{}, conf, ReloadNone); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	final, _ := os.ReadFile(conf)
+	if string(final) != "ServerName localhost\nListen 8080\n" {
+		t.Fatalf("unexpected final config: %q", string(final))
+	}
+	if !strings.Contains(warnings.String(), "+Listen 8080") {
+		t.Fatalf("expected drift warning with diff, got %q", warnings.String())
+	}
+}
+
+func TestRemoveKeepsAdminPipes(t *testing.T) {
+	original := "ServerName localhost\n" +
+		"ErrorLog \"|/usr/bin/rotatelogs /var/log/apache2/error.%Y%m%d 86400\"\n" +
+		"CustomLog \"|/usr/bin/rotatelogs /var/log/apache2/access.%Y%m%d 86400\" error\n"
+	for _, directive := range []string{"ErrorLog", "CustomLog"} {
+		dir := t.TempDir()
+		conf := filepath.Join(dir, "apache2.conf")
+		if err := os.WriteFile(conf, []byte(original), 0644); err != nil {
+			t.Fatalf("write temp config: %v", err)
+		}
+		params := ConfigParams{Payload: "/usr/bin/testsh", Directive: directive}
+		if err := Install(params, conf, ReloadNone); err != nil {
+			t.Fatalf("Install %s returned error: %v", directive, err)
+		}
+		if target := locateDirective(params, conf, conf); target != conf {
+			t.Fatalf("%s pipe located in %s", directive, target)
+		}
+		if _, err := Remove(params, conf, ReloadNone); err != nil {
+			t.Fatalf("Remove %s returned error: %v", directive, err)
+		}
+		if final, _ := os.ReadFile(conf); string(final) != original {
+			t.Fatalf("removing the %s pipe left %q", directive, final)
+		}
+	}
+}
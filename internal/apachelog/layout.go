@@ -0,0 +1,163 @@
+package apachelog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// osReleasePath identifies the distribution; a package variable so tests can
+// point it at a fixture.
+var osReleasePath = "/etc/os-release"
+
+// Layout is where a distribution's httpd package keeps its configuration and
+// how its service is managed.
+type Layout struct {
+	// Name is the distribution family: debian, rhel, suse or alpine.
+	Name string `json:"name"`
+	// ConfPath is the main configuration file httpd is started with.
+	ConfPath string `json:"conf_path"`
+	// ConfDir is where drop-in configurations are written.
+	ConfDir string `json:"conf_dir"`
+	// EnabledDir is where a2enconf links the drop-ins of ConfDir that httpd
+	// loads; empty when httpd includes ConfDir itself.
+	EnabledDir string `json:"enabled_dir,omitempty"`
+	Service    string `json:"service"`
+	// Validators are the programs that accept "-t -d <root> -f <file>", in
+	// order of preference.
+	Validators []string `json:"validators"`
+	// DetectedBy explains how the layout was chosen.
+	DetectedBy string `json:"detected_by"`
+}
+
+// layouts are the supported httpd packagings. The first entry is the fallback
+// when nothing identifies the host.
+var layouts = []Layout{
+	{Name: "debian", ConfPath: DefaultConfPath, ConfDir: "/etc/apache2/conf-available", EnabledDir: "/etc/apache2/conf-enabled", Service: "apache2", Validators: []string{"apache2ctl", "apachectl"}},
+	{Name: "rhel", ConfPath: "/etc/httpd/conf/httpd.conf", ConfDir: "/etc/httpd/conf.d", Service: "httpd", Validators: []string{"httpd", "apachectl"}},
+	{Name: "suse", ConfPath: "/etc/apache2/httpd.conf", ConfDir: "/etc/apache2/conf.d", Service: "apache2", Validators: []string{"apachectl", "apache2ctl", "httpd"}},
+	{Name: "alpine", ConfPath: "/etc/apache2/httpd.conf", ConfDir: "/etc/apache2/conf.d", Service: "apache2", Validators: []string{"httpd", "apachectl"}},
+}
+
+// osFamilies maps os-release ID and ID_LIKE values to a layout.
+var osFamilies = map[string]string{
+	"debian": "debian", "ubuntu": "debian", "raspbian": "debian", "linuxmint": "debian", "kali": "debian",
+	"rhel": "rhel", "fedora": "rhel", "centos": "rhel", "rocky": "rhel", "almalinux": "rhel", "ol": "rhel", "amzn": "rhel",
+	"suse": "suse", "opensuse": "suse", "sles": "suse", "opensuse-leap": "suse", "opensuse-tumbleweed": "suse",
+	"alpine": "alpine",
+}
+
+// layoutBinaries identify a layout by the programs its package installs, for
+// hosts whose os-release is missing or unknown.
+var layoutBinaries = []struct{ binary, layout string }{
+	{"apache2ctl", "debian"},
+	{"httpd", "rhel"},
+}
+
+// DetectLayout identifies the httpd layout of the host from /etc/os-release,
+// falling back to the Apache binaries on PATH and then to Debian.
+func DetectLayout() Layout {
+	if name, why, ok := osReleaseFamily(); ok {
+		l, _ := layoutNamed(name)
+		l.DetectedBy = why
+		return l
+	}
+	for _, b := range layoutBinaries {
+		if _, err := lookPath(b.binary); err == nil {
+			l, _ := layoutNamed(b.layout)
+			l.DetectedBy = b.binary + " found on PATH"
+			return l
+		}
+	}
+	l := layouts[0]
+	l.DetectedBy = "default; the distribution was not recognised"
+	return l
+}
+
+// layoutFor returns the layout to manage confPath with: the detected one,
+// unless confPath is the main configuration of another layout.
+func layoutFor(confPath string) Layout {
+	l := DetectLayout()
+	if confPath == "" || confPath == l.ConfPath {
+		return l
+	}
+	for _, other := range layouts {
+		if other.ConfPath == confPath {
+			other.DetectedBy = fmt.Sprintf("%s is the %s main configuration (host detected as %s: %s)", confPath, other.Name, l.Name, l.DetectedBy)
+			return other
+		}
+	}
+	return l
+}
+
+func layoutNamed(name string) (Layout, bool) {
+	for _, l := range layouts {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
+
+// osReleaseFamily matches ID, then each ID_LIKE entry, against osFamilies.
+func osReleaseFamily() (string, string, bool) {
+	data, err := os.ReadFile(osReleasePath)
+	if err != nil {
+		return "", "", false
+	}
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if ok {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	if family, ok := osFamilies[strings.ToLower(fields["ID"])]; ok {
+		return family, fmt.Sprintf("ID=%s in %s", fields["ID"], osReleasePath), true
+	}
+	for _, like := range strings.Fields(strings.ToLower(fields["ID_LIKE"])) {
+		if family, ok := osFamilies[like]; ok {
+			return family, fmt.Sprintf("ID_LIKE=%s in %s", fields["ID_LIKE"], osReleasePath), true
+		}
+	}
+	return "", "", false
+}
+
+// relocate maps dir, a directory of the layout, into the tree of confPath,
+// so a configuration under another root keeps the layout's shape.
+func (l Layout) relocate(confPath, dir string) string {
+	rel, err := filepath.Rel(filepath.Dir(l.ConfPath), dir)
+	if err != nil {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(confPath), rel)
+}
+
+// validator returns the first of the layout's validators on PATH.
+func (l Layout) validator() (string, bool) {
+	for _, name := range l.Validators {
+		if _, err := lookPath(name); err == nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// mainConfigs are the httpd main configuration files, whose includes decide
+// which other files are loaded.
+var mainConfigs = mainConfigPaths()
+
+func mainConfigPaths() []string {
+	var paths []string
+	for _, l := range layouts {
+		if !slices.Contains(paths, l.ConfPath) {
+			paths = append(paths, l.ConfPath)
+		}
+	}
+	return paths
+}
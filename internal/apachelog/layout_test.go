@@ -0,0 +1,112 @@
+package apachelog
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useOSRelease points layout detection at an os-release with content, or at
+// a missing file when content is empty.
+func useOSRelease(t *testing.T, content string) {
+	t.Helper()
+	orig := osReleasePath
+	osReleasePath = filepath.Join(t.TempDir(), "os-release")
+	if content != "" {
+		if err := os.WriteFile(osReleasePath, []byte(content), 0644); err != nil {
+			t.Fatalf("write os-release: %v", err)
+		}
+	}
+	t.Cleanup(func() { osReleasePath = orig })
+}
+
+func TestDetectLayout(t *testing.T) {
+	origLookPath := lookPath
+	defer func() { lookPath = origLookPath }()
+
+	cases := []struct {
+		osRelease string
+		binaries  []string
+		want      string
+		why       string
+	}{
+		{osRelease: "NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", want: "rhel", why: "ID=rocky"},
+		{osRelease: "ID=myrhelclone\nID_LIKE=\"centos rhel\"\n", want: "rhel", why: "ID_LIKE=centos rhel"},
+		{osRelease: "ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", want: "suse", why: "ID=opensuse-leap"},
+		{osRelease: "ID=alpine\n", want: "alpine", why: "ID=alpine"},
+		{osRelease: "ID=ubuntu\nID_LIKE=debian\n", want: "debian", why: "ID=ubuntu"},
+		{osRelease: "ID=unknown\n", binaries: []string{"httpd"}, want: "rhel", why: "httpd found on PATH"},
+		{binaries: []string{"apache2ctl", "httpd"}, want: "debian", why: "apache2ctl found on PATH"},
+		{want: "debian", why: "default"},
+	}
+	for _, c := range cases {
+		useOSRelease(t, c.osRelease)
+		lookPath = func(name string) (string, error) {
+			for _, b := range c.binaries {
+				if b == name {
+					return "/usr/sbin/" + name, nil
+				}
+			}
+			return "", os.ErrNotExist
+		}
+		l := DetectLayout()
+		if l.Name != c.want || !strings.Contains(l.DetectedBy, c.why) {
+			t.Fatalf("DetectLayout(%q, %v) = %s (%s), want %s (%s)", c.osRelease, c.binaries, l.Name, l.DetectedBy, c.want, c.why)
+		}
+	}
+}
+
+func TestLayoutForExplicitConf(t *testing.T) {
+	useOSRelease(t, "ID=debian\n")
+	if l := layoutFor("/etc/httpd/conf/httpd.conf"); l.Name != "rhel" || l.Service != "httpd" {
+		t.Fatalf("layoutFor(httpd.conf) = %+v", l)
+	}
+	if l := layoutFor("/srv/custom.conf"); l.Name != "debian" {
+		t.Fatalf("layoutFor(custom) = %+v", l)
+	}
+}
+
+func TestRHELLayoutValidatesAndRestartsHttpd(t *testing.T) {
+	useOSRelease(t, "ID=\"rocky\"\n")
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "httpd.conf")
+	if err := os.WriteFile(conf, []byte("ServerRoot \"/etc/httpd\"\n"), 0644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	var called []string
+	origLookPath := lookPath
+	origExec := execCommand
+	defer func() {
+		lookPath = origLookPath
+		execCommand = origExec
+	}()
+	lookPath = func(name string) (string, error) {
+		if name == "systemctl" || name == "httpd" {
+			return "/usr/sbin/" + name, nil
+		}
+		return "", os.ErrNotExist
+	}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, name+" "+args[0])
+		return exec.Command("true")
+	}
+
+	if err := ValidateConfig(ConfigParams{Payload: "/usr/bin/testsh"}, conf); err != nil {
+		t.Fatalf("ValidateConfig returned error: %v", err)
+	}
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadRestart); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	want := "httpd -t,systemctl restart,systemctl is-active"
+	if got := strings.Join(called, ","); got != want {
+		t.Fatalf("commands = %s, want %s", got, want)
+	}
+
+	res := Check(conf)
+	if res.Layout.Name != "rhel" || !res.ApacheCtlAvailable || !strings.Contains(res.Render(), "httpd service active") {
+		t.Fatalf("unexpected check result %+v\n%s", res, res.Render())
+	}
+}
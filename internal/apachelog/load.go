@@ -0,0 +1,330 @@
+package apachelog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// staticModules are compiled into the stock Debian and RHEL httpd binaries,
+// so <IfModule> tests for them hold without a LoadModule.
+var staticModules = []string{"core", "so", "http_core", "log_config", "logio", "version", "unixd", "watchdog"}
+
+// envvarsPath is the environment file apache2ctl sources before starting
+// httpd; its exports are visible as ${VAR} in the configuration.
+var envvarsPath = "/etc/apache2/envvars"
+
+var (
+	varRe      = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+	shellVarRe = regexp.MustCompile(`\$\{?[A-Za-z0-9_]+\}?`)
+)
+
+// Config is an Apache configuration as httpd evaluates it at startup:
+// includes resolved into the Body of their Include directive, ${VAR}
+// references substituted, and directives under a false <IfModule>,
+// <IfDefine> or <IfFile> marked Disabled.
+type Config struct {
+	// Files lists every file read, in load order, as paths on the host.
+	Files      []string
+	Directives []*Directive
+	// ServerRoot resolves relative Include paths.
+	ServerRoot string
+	Defines    map[string]string
+	// Modules holds the modules loaded, by "<name>_module" and "mod_<name>.c"
+	// identifiers as <IfModule> accepts either.
+	Modules map[string]bool
+	// Patterns are the include paths and globs in effect, as absolute host
+	// paths, so a file that does not exist yet can be checked against them.
+	Patterns []string
+	// Errors holds syntax errors and unresolvable includes.
+	Errors []error
+}
+
+// LoadConfig parses path, as seen on the host whose filesystem is mounted at
+// root, and every file it includes. ServerRoot defaults to the directory of
+// path. It fails only when path itself cannot be read.
+func LoadConfig(root, path string) (*Config, error) {
+	return loadConfig(root, path, nil)
+}
+
+// loadConfig is LoadConfig with the content of some files replaced, to
+// evaluate a configuration before writing it.
+func loadConfig(root, path string, overrides map[string][]byte) (*Config, error) {
+	l := &loader{
+		root:      root,
+		overrides: overrides,
+		env:       readEnvvars(root),
+		active:    make(map[string]bool),
+		cfg: &Config{
+			ServerRoot: filepath.Dir(path),
+			Defines:    make(map[string]string),
+			Modules:    make(map[string]bool),
+		},
+	}
+	for _, m := range staticModules {
+		l.cfg.Modules[m+"_module"] = true
+		l.cfg.Modules["mod_"+m+".c"] = true
+	}
+	ds, err := l.file(path)
+	if err != nil {
+		return nil, err
+	}
+	l.cfg.Directives = ds
+	return l.cfg, nil
+}
+
+// Walk walks every directive of the configuration; see Walk.
+func (c *Config) Walk(fn func(d *Directive, enclosing []*Directive) bool) {
+	Walk(c.Directives, fn)
+}
+
+// Includes reports whether httpd loads path: it was read while loading, or an
+// include pattern in effect matches it, as for a file about to be written.
+func (c *Config) Includes(path string) bool {
+	if slices.Contains(c.Files, path) {
+		return true
+	}
+	for _, pattern := range c.Patterns {
+		if matchesPattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern reports whether an Include of pattern, a glob or a
+// directory, loads path.
+func matchesPattern(pattern, path string) bool {
+	if ok, _ := filepath.Match(pattern, path); ok {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(pattern, "/")+"/") && !strings.ContainsAny(pattern, "*?[")
+}
+
+// readEnvvars returns the variables exported by the envvars file under root.
+func readEnvvars(root string) map[string]string {
+	vars := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(root, envvarsPath))
+	if err != nil {
+		return vars
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), "export ")
+		if !ok {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		// Shell expansions such as $SUFFIX are empty unless apache2ctl is
+		// run for a named instance.
+		value = strings.Trim(value, `"'`)
+		value = shellVarRe.ReplaceAllString(value, "")
+		vars[name] = value
+	}
+	return vars
+}
+
+type loader struct {
+	root      string
+	cfg       *Config
+	overrides map[string][]byte
+	env       map[string]string
+	// active holds the files being loaded, to stop include cycles.
+	active map[string]bool
+}
+
+func (l *loader) read(path string) ([]byte, error) {
+	if data, ok := l.overrides[path]; ok {
+		return data, nil
+	}
+	return os.ReadFile(filepath.Join(l.root, path))
+}
+
+func (l *loader) file(path string) ([]*Directive, error) {
+	if l.active[path] {
+		return nil, fmt.Errorf("include cycle through %s", path)
+	}
+	data, err := l.read(path)
+	if err != nil {
+		return nil, err
+	}
+	l.active[path] = true
+	defer delete(l.active, path)
+	if !slices.Contains(l.cfg.Files, path) {
+		l.cfg.Files = append(l.cfg.Files, path)
+	}
+
+	ds, err := ParseConfig(path, data)
+	if err != nil {
+		l.cfg.Errors = append(l.cfg.Errors, err)
+	}
+	l.eval(ds, "")
+	return ds, nil
+}
+
+// eval applies ds in order as httpd does while reading its configuration.
+// Directives under a false condition are marked with disabled.
+func (l *loader) eval(ds []*Directive, disabled string) {
+	for _, d := range ds {
+		if disabled != "" {
+			d.Disabled = disabled
+			l.eval(d.Body, disabled)
+			continue
+		}
+		for i, a := range d.Args {
+			d.Args[i] = l.expand(a)
+		}
+
+		switch strings.ToLower(d.Name) {
+		case "define":
+			if len(d.Args) > 0 {
+				value := ""
+				if len(d.Args) > 1 {
+					value = d.Args[1]
+				}
+				l.cfg.Defines[d.Args[0]] = value
+			}
+		case "undefine":
+			if len(d.Args) > 0 {
+				delete(l.cfg.Defines, d.Args[0])
+			}
+		case "serverroot":
+			if len(d.Args) > 0 {
+				l.cfg.ServerRoot = d.Args[0]
+			}
+		case "loadmodule":
+			if len(d.Args) > 0 {
+				name := strings.TrimSuffix(d.Args[0], "_module")
+				l.cfg.Modules[name+"_module"] = true
+				l.cfg.Modules["mod_"+name+".c"] = true
+			}
+		case "include", "includeoptional":
+			if len(d.Args) > 0 {
+				d.Body = l.include(d, d.Args[0], d.Is("IncludeOptional"))
+			}
+			continue
+		}
+
+		if d.Section {
+			l.eval(d.Body, l.condition(d))
+		}
+	}
+}
+
+// expand substitutes ${VAR} from Define and the environment files. Unknown
+// variables are left as is, as httpd does after warning.
+func (l *loader) expand(s string) string {
+	return varRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if v, ok := l.cfg.Defines[name]; ok {
+			return v
+		}
+		if v, ok := l.env[name]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// condition returns why the contents of section d are skipped, or "" when
+// they apply.
+func (l *loader) condition(d *Directive) string {
+	if len(d.Args) == 0 {
+		return ""
+	}
+	arg, negated := strings.CutPrefix(d.Args[0], "!")
+	var holds bool
+	switch {
+	case d.Is("IfModule"):
+		holds = l.cfg.Modules[arg]
+	case d.Is("IfDefine"):
+		_, holds = l.cfg.Defines[arg]
+	case d.Is("IfFile"):
+		p := arg
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(l.cfg.ServerRoot, p)
+		}
+		_, err := os.Stat(filepath.Join(l.root, p))
+		holds = err == nil
+	default:
+		return ""
+	}
+	if holds != negated {
+		return ""
+	}
+	return fmt.Sprintf("<%s %s> at %s is false", d.Name, d.Args[0], d.Pos)
+}
+
+// include loads the files matching pattern, relative to ServerRoot: a file,
+// a glob, or a directory whose files are all read, in name order.
+func (l *loader) include(d *Directive, pattern string, optional bool) []*Directive {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(l.cfg.ServerRoot, pattern)
+	}
+	l.cfg.Patterns = append(l.cfg.Patterns, pattern)
+
+	var paths []string
+	host := filepath.Join(l.root, pattern)
+	if info, err := os.Stat(host); err == nil && info.IsDir() {
+		_ = filepath.WalkDir(host, func(p string, e fs.DirEntry, err error) error {
+			// sites-enabled and conf-enabled hold symlinks to the files.
+			if err != nil || e.IsDir() {
+				return nil
+			}
+			if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, l.hostPath(p))
+			}
+			return nil
+		})
+	} else {
+		matches, _ := filepath.Glob(host)
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, l.hostPath(m))
+			}
+		}
+	}
+	// Files about to be written are loaded where the pattern would pick
+	// them up.
+	for p := range l.overrides {
+		if _, err := os.Stat(filepath.Join(l.root, p)); err == nil || slices.Contains(paths, p) {
+			continue
+		}
+		if matchesPattern(pattern, p) {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	if len(paths) == 0 && !optional && !strings.ContainsAny(path.Base(pattern), "*?[") {
+		l.cfg.Errors = append(l.cfg.Errors, fmt.Errorf("%s: Include %s: no such file", d.Pos, pattern))
+	}
+
+	var body []*Directive
+	for _, p := range paths {
+		ds, err := l.file(p)
+		if err != nil {
+			l.cfg.Errors = append(l.cfg.Errors, fmt.Errorf("%s: Include %s: %w", d.Pos, p, err))
+			continue
+		}
+		body = append(body, ds...)
+	}
+	return body
+}
+
+func (l *loader) hostPath(p string) string {
+	rel, err := filepath.Rel(l.root, p)
+	if err != nil {
+		return p
+	}
+	return "/" + filepath.ToSlash(rel)
+}
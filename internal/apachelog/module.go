@@ -0,0 +1,280 @@
+package apachelog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/module"
+	"nixpersist/internal/preflight"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+// placementProbe stands in for the payload when evaluating where the
+// directive would take effect without one.
+const placementProbe = "/bin/true"
+
+// Module exposes Apache piped logging as the "apache-log" subcommand.
+type Module struct {
+	payload    string
+	confPath   string
+	noRestart  bool
+	reload     string
+	directive  string
+	logFormat  string
+	placement  string
+	name       string
+	vhost      string
+	selinuxAVC bool
+}
+
+// NewModule returns the apache-log module.
+func NewModule() *Module { return &Module{} }
+
+func (m *Module) Name() string { return "apache-log" }
+
+func (m *Module) Describe() string { return "Autostart persistence via Apache Logging Pipes" }
+
+func (m *Module) Flags(fs *pflag.FlagSet) {
+	fs.StringVarP(&m.payload, "payload", "p", "", "path to executable payload invoked via CustomLog")
+	fs.StringVarP(&m.confPath, "conf", "c", "", "path to the main Apache config (default: the detected layout's, e.g. apache2.conf or httpd.conf)")
+	fs.BoolVar(&m.noRestart, "no-restart", false, "skip reloading the apache2/httpd service after changes (same as --reload none)")
+	fs.StringVar(&m.reload, "reload", string(ReloadRestart), "how to apply changes: graceful, restart or none (systemctl, else apachectl -k, else service)")
+	fs.StringVar(&m.directive, "directive", "CustomLog", "log directive that pipes to the payload: CustomLog or ErrorLog")
+	fs.StringVar(&m.logFormat, "log-format", "", "CustomLog format, a LogFormat nickname or format string (default \""+DefaultLogFormat+"\")")
+	fs.StringVar(&m.placement, "placement", PlacementMain, "where to write the directive: main (append to --conf), drop-in (conf-available + a2enconf, or conf.d) or vhost")
+	fs.StringVar(&m.name, "name", DefaultDropInName, "drop-in configuration name, without .conf (--placement drop-in)")
+	fs.StringVar(&m.vhost, "vhost", "", "<VirtualHost> to write into, by ServerName, ServerAlias or address (--placement vhost)")
+	fs.BoolVar(&m.selinuxAVC, "selinux-avc", false, "report SELinux AVC denials for httpd (--check: last 24 hours; --install: since the install)")
+}
+
+func (m *Module) params() ConfigParams {
+	return ConfigParams{
+		Payload:   m.payload,
+		Directive: m.directive,
+		LogFormat: m.logFormat,
+		Placement: m.placement,
+		Name:      m.name,
+		VHost:     m.vhost,
+	}
+}
+
+func (m *Module) validate(action string) error {
+	if m.noRestart && action != "install" && action != "remove" {
+		return errors.New("--no-restart requires --install or --remove")
+	}
+	if _, err := m.reloadStrategy(); err != nil {
+		return err
+	}
+	m.resolveConf()
+	return m.params().validateTarget()
+}
+
+// reloadStrategy returns --reload, with --no-restart forcing ReloadNone.
+func (m *Module) reloadStrategy() (Reload, error) {
+	reload, err := ParseReload(m.reload)
+	if err != nil {
+		return "", err
+	}
+	if m.noRestart {
+		if reload != ReloadRestart && reload != ReloadNone {
+			return "", fmt.Errorf("--no-restart conflicts with --reload %s", reload)
+		}
+		return ReloadNone, nil
+	}
+	return reload, nil
+}
+
+// resolveConf defaults --conf to the main configuration of the detected
+// layout.
+func (m *Module) resolveConf() {
+	if strings.TrimSpace(m.confPath) == "" {
+		m.confPath = DetectLayout().ConfPath
+	}
+}
+
+func (m *Module) Check() (module.Report, error) {
+	if err := m.validate("check"); err != nil {
+		return nil, err
+	}
+	res := Check(m.confPath)
+	params := m.params()
+	if strings.TrimSpace(m.payload) == "" {
+		res.Validation = "skipped (pass --payload to validate the rendered directive)"
+		// Where the directive lands does not depend on the payload.
+		params.Payload = placementProbe
+	} else {
+		res.Validation = preflight.Describe(ValidateConfig(params, m.confPath))
+	}
+	if res.ConfigExists {
+		effect, warnings, err := Effect(params, m.confPath)
+		if err != nil {
+			res.Notes = append(res.Notes, fmt.Sprintf("could not evaluate the loaded configuration: %v", err))
+		}
+		res.Effect, res.Warnings = effect, warnings
+	}
+	res.inspectSELinux(m.payload, m.selinuxAVC)
+	return res, nil
+}
+
+func (m *Module) Render() (string, error) {
+	if err := m.validate("render"); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(m.payload) == "" {
+		return "", errors.New("--payload is required to render")
+	}
+	return RenderConfig(m.params())
+}
+
+func (m *Module) Plan() (module.Plan, error) {
+	if err := m.validate("install"); err != nil {
+		return module.Plan{}, err
+	}
+	if strings.TrimSpace(m.payload) == "" {
+		return module.Plan{}, errors.New("--payload is required for --plan")
+	}
+	params := m.params()
+	layout := layoutFor(m.confPath)
+	st, err := stage(params, m.confPath)
+	if err != nil {
+		return module.Plan{}, err
+	}
+
+	plan := module.Plan{
+		Files:    []module.FileEdit{{Path: st.Path, Before: st.Before, After: st.After}},
+		Commands: []string{validationPlan(st, m.confPath)},
+	}
+	plan.Commands = append(plan.Commands, enableCommands(st, params.name(), m.confPath)...)
+	if st.Created {
+		plan.Notes = append(plan.Notes, "new drop-in "+st.Path+"; install recorded in the NixPersist ledger")
+	} else {
+		plan.Notes = append(plan.Notes, "original "+st.Path+" snapshotted for byte-exact restore; install recorded in the NixPersist ledger")
+	}
+	if _, warnings, err := Effect(params, m.confPath); err == nil {
+		plan.Notes = append(plan.Notes, warnings...)
+	}
+	if reload, _ := m.reloadStrategy(); reload == ReloadNone {
+		plan.Notes = append(plan.Notes, "--reload none: the pipe is spawned on the next natural Apache restart")
+	} else {
+		plan.Commands = append(plan.Commands, reloadPlan(layout, reload)...)
+	}
+	return plan, nil
+}
+
+func (m *Module) Install() (module.Outcome, error) {
+	if err := m.validate("install"); err != nil {
+		return module.Outcome{}, err
+	}
+	if strings.TrimSpace(m.payload) == "" {
+		return module.Outcome{}, errors.New("--payload is required for --install")
+	}
+
+	params := m.params()
+	if err := preflight.Gate(ValidateConfig(params, m.confPath), os.Stderr); err != nil {
+		return module.Outcome{}, err
+	}
+
+	reload, _ := m.reloadStrategy()
+	st, err := stage(params, m.confPath)
+	if err != nil {
+		return module.Outcome{}, fmt.Errorf("install failed: %w", err)
+	}
+	changes := []state.FileChange{state.Observe(st.Path)}
+	if st.LoadedAs != st.Path {
+		changes = append(changes, state.Observe(st.LoadedAs))
+	}
+	warnSELinux(m.payload)
+	start := time.Now()
+	if err := Install(params, m.confPath, reload); err != nil {
+		return module.Outcome{}, fmt.Errorf("install failed: %w", err)
+	}
+	msg := fmt.Sprintf("install complete: apache-log %s pipe written to %s", params.directive(), st.Path)
+	res := outcome(msg, layoutFor(m.confPath).Service, reload, changes...)
+	if reload != ReloadNone {
+		// The configuration is live either way; a payload that exits at
+		// once, or a virtual host that never logs, leaves no child to see.
+		if pid, err := VerifySpawn(m.payload); err != nil {
+			fmt.Fprintf(warnOut, "warning: %v\n", err)
+			res.Message += "; piped child not seen"
+		} else {
+			res.Message += fmt.Sprintf("; piped child running as pid %d", pid)
+		}
+	}
+	if m.selinuxAVC {
+		res.Message += selinuxDenials(start)
+	}
+	return res, nil
+}
+
+func (m *Module) Remove() (module.Outcome, error) {
+	if err := m.validate("remove"); err != nil {
+		return module.Outcome{}, err
+	}
+
+	reload, _ := m.reloadStrategy()
+	params := m.params()
+	var changes []state.FileChange
+	if params.placement() == PlacementDropIn {
+		path, loadedAs := dropInPaths(params, m.confPath)
+		changes = append(changes, state.ObserveDelete(path))
+		if loadedAs != path {
+			changes = append(changes, state.ObserveDelete(loadedAs))
+		}
+	} else {
+		expected, err := targetPath(params, m.confPath)
+		if err != nil {
+			expected = m.confPath
+		}
+		changes = append(changes, state.Observe(expected))
+		if moved := locateDirective(params, expected, m.confPath); moved != expected {
+			changes = append(changes, state.Observe(moved))
+		}
+	}
+	edited, err := Remove(params, m.confPath, reload)
+	if err != nil {
+		return module.Outcome{}, fmt.Errorf("remove failed: %w", err)
+	}
+	msg := fmt.Sprintf("remove complete: apache-log snippet removed from %s", edited)
+	return outcome(msg, layoutFor(m.confPath).Service, reload, changes...), nil
+}
+
+func (m *Module) Detections() ([]sigma.Rule, error) {
+	m.resolveConf()
+	if strings.TrimSpace(m.payload) == "" {
+		return nil, errors.New("--payload is required for detections")
+	}
+	return Detections(m.params(), m.confPath)
+}
+
+func (m *Module) AuditRules() ([]audit.Rule, error) {
+	m.resolveConf()
+	if strings.TrimSpace(m.payload) == "" {
+		return nil, errors.New("--payload is required for audit rules")
+	}
+	return AuditRules(m.params(), m.confPath)
+}
+
+func (m *Module) Hunt(root string) hunt.Report { return Hunt(root) }
+
+func outcome(msg, service string, reload Reload, changes ...state.FileChange) module.Outcome {
+	res := module.Outcome{Files: changes}
+	switch reload {
+	case ReloadNone:
+		res.Message = msg + "; reload skipped"
+	case ReloadGraceful:
+		res.Message = msg + "; " + service + " reloaded gracefully"
+		res.Services = []string{service}
+	default:
+		res.Message = msg + "; " + service + " restarted"
+		res.Services = []string{service}
+	}
+	return res
+}
@@ -0,0 +1,166 @@
+package apachelog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pos is a position in an Apache configuration file.
+type Pos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%s:%d", p.File, p.Line) }
+
+// Directive is a node of a parsed Apache configuration: a directive line or
+// a <Section>...</Section> block.
+type Directive struct {
+	Name string
+	// Args are the arguments with quotes removed. LoadConfig substitutes
+	// ${VAR} references in the directives httpd evaluates.
+	Args []string
+	Pos  Pos
+	// EndLine is the last line the directive occupies in Pos.File, counting
+	// continuation lines and, for sections, the closing tag.
+	EndLine int
+	// Text is the directive's source with continuation lines joined; for
+	// sections, the opening tag.
+	Text    string
+	Section bool
+	// Body holds the contents of a section, or the directives of the files
+	// an Include pulls in.
+	Body []*Directive
+	// Disabled explains why httpd ignores the directive, such as a false
+	// <IfModule>; empty when it is in effect.
+	Disabled string
+}
+
+// Is reports whether the directive has the given name, which httpd matches
+// case-insensitively.
+func (d *Directive) Is(name string) bool { return strings.EqualFold(d.Name, name) }
+
+// SyntaxError reports a line the parser could not read.
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string { return fmt.Sprintf("%s: %s", e.Pos, e.Msg) }
+
+// Walk calls fn for each directive in source order, descending into sections
+// and included files. enclosing lists the sections and includes containing d,
+// outermost first. When fn returns false the children of d are skipped.
+func Walk(ds []*Directive, fn func(d *Directive, enclosing []*Directive) bool) {
+	walk(ds, nil, fn)
+}
+
+func walk(ds, enclosing []*Directive, fn func(*Directive, []*Directive) bool) {
+	for _, d := range ds {
+		if !fn(d, enclosing) {
+			continue
+		}
+		walk(d.Body, append(enclosing[:len(enclosing):len(enclosing)], d), fn)
+	}
+}
+
+// ParseConfig parses a single Apache configuration file without resolving
+// includes or variables. It returns every directive it could read; err
+// joins one *SyntaxError per line it could not.
+func ParseConfig(name string, src []byte) ([]*Directive, error) {
+	var errs []error
+	fail := func(line int, format string, args ...any) {
+		errs = append(errs, &SyntaxError{Pos: Pos{File: name, Line: line}, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	root := &Directive{}
+	stack := []*Directive{root}
+	lines := strings.Split(string(src), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i
+		text := strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+		for strings.HasSuffix(text, `\`) && i+1 < len(lines) {
+			i++
+			text = strings.TrimSuffix(text, `\`) + " " + strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parent := stack[len(stack)-1]
+
+		if strings.HasPrefix(text, "</") {
+			name := strings.TrimSpace(strings.TrimSuffix(text[2:], ">"))
+			if len(stack) == 1 || !strings.EqualFold(name, parent.Name) {
+				fail(start+1, "unexpected </%s>", name)
+				continue
+			}
+			parent.EndLine = i + 1
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		d := &Directive{Pos: Pos{File: name, Line: start + 1}, EndLine: i + 1, Text: text}
+		if strings.HasPrefix(text, "<") {
+			if !strings.HasSuffix(text, ">") {
+				fail(start+1, "section %s is missing >", text)
+				continue
+			}
+			d.Section = true
+			text = strings.TrimSpace(text[1 : len(text)-1])
+		}
+		fields, err := splitArgs(text)
+		if err != nil {
+			fail(start+1, "%v", err)
+			continue
+		}
+		if len(fields) == 0 {
+			fail(start+1, "empty section")
+			continue
+		}
+		d.Name, d.Args = fields[0], fields[1:]
+		parent.Body = append(parent.Body, d)
+		if d.Section {
+			stack = append(stack, d)
+		}
+	}
+	for _, open := range stack[1:] {
+		fail(open.Pos.Line, "<%s> is never closed", open.Name)
+	}
+	return root.Body, errors.Join(errs...)
+}
+
+// splitArgs splits a directive into words as httpd does: on whitespace, with
+// single or double quotes grouping words and a backslash escaping a quote.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return args, nil
+		}
+		q := s[0]
+		if q != '"' && q != '\'' {
+			end := strings.IndexAny(s, " \t")
+			if end == -1 {
+				end = len(s)
+			}
+			args = append(args, s[:end])
+			s = s[end:]
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != q; i++ {
+			if s[i] == '\\' && i+1 < len(s) && (s[i+1] == q || s[i+1] == '\\') {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return args, fmt.Errorf("unterminated quote in %q", s)
+		}
+		args = append(args, b.String())
+		s = s[i+1:]
+	}
+}
@@ -0,0 +1,177 @@
+package apachelog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigDirectives(t *testing.T) {
+	src := "# comment\nServerName x\nLogFormat \"%h \\\"%r\\\"\" \\\n    combined\n<VirtualHost *:80>\n  <Directory /var/www>\n    Require all granted\n  </Directory>\n</virtualhost>\n"
+	ds, err := ParseConfig("a.conf", []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+	var got []string
+	Walk(ds, func(d *Directive, enclosing []*Directive) bool {
+		got = append(got, strings.Repeat(" ", len(enclosing))+d.Name+" "+strings.Join(d.Args, "|"))
+		return true
+	})
+	want := []string{"ServerName x", `LogFormat %h "%r"|combined`, "VirtualHost *:80", " Directory /var/www", "  Require all|granted"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("directives = %q, want %q", got, want)
+	}
+	if ds[1].Pos.Line != 3 || ds[1].EndLine != 4 || ds[2].EndLine != 9 {
+		t.Fatalf("unexpected spans %d-%d, vhost ends %d", ds[1].Pos.Line, ds[1].EndLine, ds[2].EndLine)
+	}
+
+	_, err = ParseConfig("b.conf", []byte("</Directory>\nCustomLog \"|x combined\n<IfModule x>\n"))
+	var syn *SyntaxError
+	if !errors.As(err, &syn) || syn.Pos.Line != 1 {
+		t.Fatalf("expected a syntax error on line 1, got %v", err)
+	}
+	for _, want := range []string{"b.conf:2: unterminated quote", "b.conf:3: <IfModule> is never closed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("errors %q do not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfigEvaluatesIncludesAndConditions(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/apache2/envvars": "export APACHE_LOG_DIR=/var/log/apache2$SUFFIX\n",
+		"etc/apache2/apache2.conf": "ServerRoot \"/etc/apache2\"\nDefine PIPE /usr/bin/a\n" +
+			"LoadModule ssl_module mods/ssl.so\nInclude mods-enabled/*.load\n" +
+			"IncludeOptional conf-enabled/*.conf\nInclude sites-enabled/\nInclude missing.conf\n" +
+			"<IfModule ssl_module>\n  CustomLog \"|${PIPE}\" combined\n</IfModule>\n" +
+			"<IfModule !mod_ssl.c>\n  CustomLog |/bin/never x\n</IfModule>\n" +
+			"<IfDefine NOPE>\n  ErrorLog |/bin/nope\n</IfDefine>\n" +
+			"ErrorLog ${APACHE_LOG_DIR}/error.log\n",
+		"etc/apache2/mods-enabled/x.load":     "LoadModule x_module mods/x.so\n",
+		"etc/apache2/sites-enabled/a.conf":    "<VirtualHost *:80>\n  ServerName a.example\n  CustomLog /var/log/a.log combined\n  Include /etc/apache2/apache2.conf\n</VirtualHost>\n",
+		"etc/apache2/sites-enabled/b.conf":    "<VirtualHost *:443>\n  ErrorLog |/bin/first\n  ErrorLog |/bin/second\n</VirtualHost>\n",
+		"etc/apache2/conf-available/off.conf": "CustomLog |/bin/off x\n",
+	})
+
+	cfg, err := LoadConfig(root, "/etc/apache2/apache2.conf")
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	wantFiles := []string{"/etc/apache2/apache2.conf", "/etc/apache2/mods-enabled/x.load", "/etc/apache2/sites-enabled/a.conf", "/etc/apache2/sites-enabled/b.conf"}
+	if !reflect.DeepEqual(cfg.Files, wantFiles) {
+		t.Fatalf("Files = %v, want %v", cfg.Files, wantFiles)
+	}
+	if !cfg.Modules["x_module"] || !cfg.Modules["mod_ssl.c"] {
+		t.Fatalf("modules not recorded: %v", cfg.Modules)
+	}
+
+	var got []string
+	for _, pl := range cfg.PipedLogs() {
+		line := pl.Directive.Pos.String() + " " + pl.Command + " in " + pl.Scope
+		if pl.Disabled != "" {
+			line += " disabled: " + pl.Disabled
+		}
+		for _, o := range pl.Overridden {
+			line += "; " + o
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		"/etc/apache2/sites-enabled/b.conf:2 /bin/first in <VirtualHost *:443> at /etc/apache2/sites-enabled/b.conf:1 disabled: replaced by ErrorLog at /etc/apache2/sites-enabled/b.conf:3",
+		"/etc/apache2/sites-enabled/b.conf:3 /bin/second in <VirtualHost *:443> at /etc/apache2/sites-enabled/b.conf:1",
+		"/etc/apache2/apache2.conf:9 /usr/bin/a in main server; <VirtualHost *:80> a.example at /etc/apache2/sites-enabled/a.conf:1 sets its own CustomLog",
+		"/etc/apache2/apache2.conf:12 /bin/never in main server disabled: <IfModule !mod_ssl.c> at /etc/apache2/apache2.conf:11 is false",
+		"/etc/apache2/apache2.conf:15 /bin/nope in main server disabled: <IfDefine NOPE> at /etc/apache2/apache2.conf:14 is false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("piped logs:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var errorLog *Directive
+	cfg.Walk(func(d *Directive, enclosing []*Directive) bool {
+		if d.Is("ErrorLog") && len(enclosing) == 0 {
+			errorLog = d
+		}
+		return true
+	})
+	if errorLog == nil || errorLog.Args[0] != "/var/log/apache2/error.log" {
+		t.Fatalf("envvars not substituted: %+v", errorLog)
+	}
+
+	errs := errors.Join(cfg.Errors...).Error()
+	for _, want := range []string{"include cycle through /etc/apache2/apache2.conf", "Include /etc/apache2/missing.conf: no such file"} {
+		if !strings.Contains(errs, want) {
+			t.Fatalf("errors %q do not mention %q", errs, want)
+		}
+	}
+	if !cfg.Includes("/etc/apache2/conf-enabled/new.conf") || !cfg.Includes("/etc/apache2/sites-enabled/c.conf") || cfg.Includes("/etc/apache2/conf-available/off.conf") {
+		t.Fatal("Includes does not follow the include patterns")
+	}
+}
+
+// useMainConfig points the main configuration lookup at path for the test.
+func useMainConfig(t *testing.T, path string) {
+	t.Helper()
+	orig := mainConfigs
+	mainConfigs = []string{path}
+	t.Cleanup(func() { mainConfigs = orig })
+}
+
+func TestEffectWarnsWhenNotLoadedOrOverridden(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	site := filepath.Join(dir, "sites-enabled", "a.conf")
+	stray := filepath.Join(dir, "stray.conf")
+	writeTree(t, dir, map[string]string{
+		"apache2.conf":         "Include sites-enabled/\n",
+		"sites-enabled/a.conf": "<VirtualHost *:80>\n  CustomLog /var/log/a.log combined\n</VirtualHost>\n",
+		"stray.conf":           "",
+	})
+	useMainConfig(t, main)
+
+	effect, warnings, err := Effect(ConfigParams{Payload: "/usr/bin/x"}, main)
+	if err != nil {
+		t.Fatalf("Effect returned error: %v", err)
+	}
+	if effect != "main server (loaded via "+main+")" || len(warnings) != 1 || !strings.Contains(warnings[0], "<VirtualHost *:80> at "+site+":1 sets its own CustomLog") {
+		t.Fatalf("Effect = %q, %q", effect, warnings)
+	}
+
+	effect, warnings, err = Effect(ConfigParams{Payload: "/usr/bin/x"}, stray)
+	if err != nil {
+		t.Fatalf("Effect returned error: %v", err)
+	}
+	if effect != "never loaded" || len(warnings) != 1 || !strings.Contains(warnings[0], stray+" is not loaded by "+main) {
+		t.Fatalf("Effect = %q, %q", effect, warnings)
+	}
+}
+
+func TestRemoveFindsDirectiveInIncludedFile(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	site := filepath.Join(dir, "sites-enabled", "a.conf")
+	writeTree(t, dir, map[string]string{
+		"apache2.conf":         "ServerName x\nIncludeOptional sites-enabled/*.conf\n",
+		"sites-enabled/a.conf": "<VirtualHost *:80>\n</VirtualHost>\n",
+	})
+	useMainConfig(t, main)
+
+	if err := Install(ConfigParams{Payload: "/usr/bin/x"}, site, ReloadNone); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	edited, err := Remove(ConfigParams{}, main, ReloadNone)
+	if err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if edited != site {
+		t.Fatalf("Remove edited %s, want %s", edited, site)
+	}
+	data, _ := os.ReadFile(site)
+	if string(data) != "<VirtualHost *:80>\n</VirtualHost>\n" {
+		t.Fatalf("unexpected site config after remove:\n%s", data)
+	}
+}
@@ -0,0 +1,123 @@
+package apachelog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PipedLog is a log directive whose target is a program, and where httpd
+// applies it.
+type PipedLog struct {
+	Directive *Directive
+	// Command is the program line without the "|", "||" or "|$" prefix.
+	Command string
+	// Scope is "main server" or the <VirtualHost> the directive belongs to.
+	Scope string
+	// Disabled explains why httpd never starts the program; empty when it
+	// does.
+	Disabled string
+	// Overridden lists the virtual hosts whose own log directives keep their
+	// requests from reaching a main server pipe. The program still starts.
+	Overridden []string
+}
+
+// pipedCommand returns the program of a CustomLog, ErrorLog, TransferLog or
+// GlobalLog directive that logs to a pipe.
+func pipedCommand(d *Directive) (string, bool) {
+	if d.Section || len(d.Args) == 0 || !isLogDirective(d) || !strings.HasPrefix(d.Args[0], "|") {
+		return "", false
+	}
+	command := strings.TrimPrefix(strings.TrimPrefix(d.Args[0], "|"), "|")
+	command = strings.TrimSpace(strings.TrimPrefix(command, "$"))
+	return command, command != ""
+}
+
+func isLogDirective(d *Directive) bool {
+	return d.Is("CustomLog") || d.Is("ErrorLog") || d.Is("TransferLog") || d.Is("GlobalLog")
+}
+
+// isAccessLog reports whether d configures request logging, which a virtual
+// host with its own such directive does not inherit from the main server.
+func isAccessLog(d *Directive) bool { return d.Is("CustomLog") || d.Is("TransferLog") }
+
+// PipedLogs returns every piped log directive in the configuration, in load
+// order, with where it takes effect.
+func (c *Config) PipedLogs() []PipedLog {
+	type scoped struct {
+		d     *Directive
+		vhost *Directive
+	}
+	var logs []scoped
+	var vhosts []*Directive
+	ownAccess := make(map[*Directive]bool)
+	ownError := make(map[*Directive]bool)
+	c.Walk(func(d *Directive, enclosing []*Directive) bool {
+		if d.Section && d.Is("VirtualHost") && d.Disabled == "" {
+			vhosts = append(vhosts, d)
+		}
+		if d.Section || !isLogDirective(d) {
+			return true
+		}
+		vhost := enclosingVirtualHost(enclosing)
+		logs = append(logs, scoped{d: d, vhost: vhost})
+		if vhost != nil && d.Disabled == "" {
+			ownAccess[vhost] = ownAccess[vhost] || isAccessLog(d)
+			ownError[vhost] = ownError[vhost] || d.Is("ErrorLog")
+		}
+		return true
+	})
+
+	var out []PipedLog
+	for i, s := range logs {
+		command, ok := pipedCommand(s.d)
+		if !ok {
+			continue
+		}
+		pl := PipedLog{Directive: s.d, Command: command, Scope: scopeName(s.vhost), Disabled: s.d.Disabled}
+		if pl.Disabled == "" && s.d.Is("ErrorLog") {
+			// A server has one error log: the last ErrorLog wins.
+			for _, later := range logs[i+1:] {
+				if later.vhost == s.vhost && later.d.Is("ErrorLog") && later.d.Disabled == "" {
+					pl.Disabled = fmt.Sprintf("replaced by ErrorLog at %s", later.d.Pos)
+				}
+			}
+		}
+		if pl.Disabled == "" && s.vhost == nil {
+			own := ownAccess
+			if s.d.Is("ErrorLog") {
+				own = ownError
+			}
+			for _, v := range vhosts {
+				if own[v] && !s.d.Is("GlobalLog") {
+					pl.Overridden = append(pl.Overridden, fmt.Sprintf("%s sets its own %s", scopeName(v), s.d.Name))
+				}
+			}
+		}
+		out = append(out, pl)
+	}
+	return out
+}
+
+func enclosingVirtualHost(enclosing []*Directive) *Directive {
+	for i := len(enclosing) - 1; i >= 0; i-- {
+		if d := enclosing[i]; d.Section && d.Is("VirtualHost") {
+			return d
+		}
+	}
+	return nil
+}
+
+// scopeName describes a virtual host by address, ServerName and position.
+func scopeName(vhost *Directive) string {
+	if vhost == nil {
+		return "main server"
+	}
+	name := fmt.Sprintf("<VirtualHost %s>", strings.Join(vhost.Args, " "))
+	for _, d := range vhost.Body {
+		if d.Is("ServerName") && len(d.Args) > 0 {
+			name += " " + d.Args[0]
+			break
+		}
+	}
+	return name + " at " + vhost.Pos.String()
+}
@@ -0,0 +1,256 @@
+package apachelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// staging is the write that installs the rendered directive.
+type staging struct {
+	// Path is the file written.
+	Path string
+	// LoadedAs is the path httpd reads Path through: Path itself, or the
+	// conf-enabled link of a Debian drop-in.
+	LoadedAs string
+	// Created is set when Path does not exist yet.
+	Created bool
+	Before  []byte
+	After   []byte
+	Mode    os.FileMode
+	// Line is the line of the directive in After.
+	Line int
+}
+
+// stage computes the write that installs the directive rendered from params
+// with confPath as the main configuration.
+func stage(params ConfigParams, confPath string) (*staging, error) {
+	directive, err := RenderConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	switch params.placement() {
+	case PlacementDropIn:
+		path, loadedAs := dropInPaths(params, confPath)
+		if _, err := os.Lstat(path); err == nil {
+			return nil, fmt.Errorf("drop-in %s already exists", path)
+		}
+		return &staging{Path: path, LoadedAs: loadedAs, Created: true, After: []byte(directive), Mode: 0644, Line: 1}, nil
+	case PlacementVHost:
+		return stageVHost(params, confPath, directive)
+	}
+	original, staged, mode, err := stageConfig(params, confPath)
+	if err != nil {
+		return nil, err
+	}
+	return &staging{Path: confPath, LoadedAs: confPath, Before: original, After: staged, Mode: mode, Line: bytes.Count(staged, []byte("\n"))}, nil
+}
+
+// targetPath returns the file the directive is written to, or was written to
+// by an install with the same params.
+func targetPath(params ConfigParams, confPath string) (string, error) {
+	switch params.placement() {
+	case PlacementDropIn:
+		path, _ := dropInPaths(params, confPath)
+		return path, nil
+	case PlacementVHost:
+		vhost, err := selectVHost(params.VHost, confPath)
+		if err != nil {
+			return "", err
+		}
+		return vhost.Pos.File, nil
+	}
+	return confPath, nil
+}
+
+// dropInPaths returns where the drop-in of params is written, and the path
+// httpd loads it from once enabled.
+func dropInPaths(params ConfigParams, confPath string) (string, string) {
+	layout := layoutFor(confPath)
+	file := params.name() + ".conf"
+	path := filepath.Join(layout.relocate(confPath, layout.ConfDir), file)
+	if layout.EnabledDir == "" {
+		return path, path
+	}
+	return path, filepath.Join(layout.relocate(confPath, layout.EnabledDir), file)
+}
+
+// stageVHost inserts directive before the closing tag of the selected
+// <VirtualHost>, indented like the directives inside it.
+func stageVHost(params ConfigParams, confPath, directive string) (*staging, error) {
+	vhost, err := selectVHost(params.VHost, confPath)
+	if err != nil {
+		return nil, err
+	}
+	path := vhost.Pos.File
+	original, mode, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(original), "\n")
+	if vhost.EndLine < 1 || vhost.EndLine > len(lines) {
+		return nil, fmt.Errorf("%s: cannot find the end of <VirtualHost>", vhost.Pos)
+	}
+	indent := leadingSpace(lines[vhost.Pos.Line-1]) + "    "
+	for _, d := range vhost.Body {
+		if d.Pos.File == path {
+			indent = leadingSpace(lines[d.Pos.Line-1])
+			break
+		}
+	}
+	at := vhost.EndLine - 1
+	staged := append(lines[:at:at], indent+strings.TrimSuffix(directive, "\n"))
+	staged = append(staged, lines[at:]...)
+	return &staging{Path: path, LoadedAs: path, Before: original, After: []byte(strings.Join(staged, "\n")), Mode: mode, Line: vhost.EndLine}, nil
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// selectVHost returns the single <VirtualHost> of the configuration loaded
+// from confPath's main configuration that selector names by ServerName,
+// ServerAlias or address.
+func selectVHost(selector, confPath string) (*Directive, error) {
+	main := mainConfig(confPath)
+	cfg, err := LoadConfig("/", main)
+	if err != nil {
+		return nil, err
+	}
+	var matched, all []string
+	var found *Directive
+	cfg.Walk(func(d *Directive, _ []*Directive) bool {
+		if !d.Section || !d.Is("VirtualHost") || d.Disabled != "" {
+			return true
+		}
+		all = append(all, scopeName(d))
+		if vhostMatches(d, selector) {
+			matched = append(matched, scopeName(d))
+			found = d
+		}
+		return false
+	})
+	switch len(matched) {
+	case 0:
+		if len(all) == 0 {
+			return nil, fmt.Errorf("no <VirtualHost> is loaded by %s", main)
+		}
+		return nil, fmt.Errorf("no <VirtualHost> matches %q; loaded: %s", selector, strings.Join(all, "; "))
+	case 1:
+		return found, nil
+	}
+	return nil, fmt.Errorf("%q matches several virtual hosts: %s", selector, strings.Join(matched, "; "))
+}
+
+func vhostMatches(vhost *Directive, selector string) bool {
+	for _, addr := range vhost.Args {
+		if strings.EqualFold(addr, selector) {
+			return true
+		}
+	}
+	for _, d := range vhost.Body {
+		if d.Is("ServerName") || d.Is("ServerAlias") {
+			for _, name := range d.Args {
+				if strings.EqualFold(name, selector) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// enableDropIn links the drop-in into conf-enabled: with a2enconf for the
+// live /etc/apache2 tree, otherwise with the relative symlink a2enconf
+// would create.
+func enableDropIn(st *staging, name, confPath string) error {
+	if st.LoadedAs == st.Path {
+		return nil
+	}
+	if useA2enconf(confPath) {
+		if out, err := execCommand("a2enconf", "-q", name).CombinedOutput(); err != nil {
+			return fmt.Errorf("a2enconf %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	rel, err := filepath.Rel(filepath.Dir(st.LoadedAs), st.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.LoadedAs), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(rel, st.LoadedAs)
+}
+
+// disableDropIn undoes enableDropIn.
+func disableDropIn(path, loadedAs, name, confPath string) error {
+	if loadedAs == path {
+		return nil
+	}
+	if useA2enconf(confPath) {
+		if out, err := execCommand("a2disconf", "-q", name).CombinedOutput(); err != nil {
+			return fmt.Errorf("a2disconf %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	if err := os.Remove(loadedAs); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// useA2enconf reports whether a2enconf manages the tree of confPath: it only
+// knows /etc/apache2.
+func useA2enconf(confPath string) bool {
+	if filepath.Dir(confPath) != filepath.Dir(DefaultConfPath) {
+		return false
+	}
+	_, err := lookPath("a2enconf")
+	return err == nil
+}
+
+// enableCommands describes how a drop-in is enabled, for --plan.
+func enableCommands(st *staging, name, confPath string) []string {
+	switch {
+	case st.LoadedAs == st.Path:
+		return nil
+	case useA2enconf(confPath):
+		return []string{"a2enconf -q " + name}
+	}
+	return []string{fmt.Sprintf("ln -s %s %s", st.Path, st.LoadedAs)}
+}
+
+// Effect evaluates the configuration as it would be after installing the
+// directive rendered from params. It returns where the directive takes
+// effect, and warnings when httpd would never load it or virtual hosts would
+// keep their requests from it.
+func Effect(params ConfigParams, confPath string) (string, []string, error) {
+	st, err := stage(params, confPath)
+	if err != nil {
+		return "", nil, err
+	}
+	main := mainConfig(confPath)
+	cfg, err := loadConfig("/", main, map[string][]byte{st.LoadedAs: st.After})
+	if err != nil {
+		return "", nil, err
+	}
+	name := params.directive()
+	for _, pl := range cfg.PipedLogs() {
+		if pl.Directive.Pos.File != st.LoadedAs || pl.Directive.Pos.Line != st.Line {
+			continue
+		}
+		if pl.Disabled != "" {
+			return "never loaded", []string{fmt.Sprintf("the %s would never be loaded: %s", name, pl.Disabled)}, nil
+		}
+		var warnings []string
+		for _, o := range pl.Overridden {
+			warnings = append(warnings, fmt.Sprintf("%s overridden: %s, so its requests never reach the pipe", name, o))
+		}
+		return fmt.Sprintf("%s (loaded via %s)", pl.Scope, main), warnings, nil
+	}
+	return "never loaded", []string{fmt.Sprintf("%s is not loaded by %s or its includes; the %s would never take effect", st.LoadedAs, main, name)}, nil
+}
@@ -0,0 +1,156 @@
+package apachelog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderConfigVariants(t *testing.T) {
+	cases := map[string]ConfigParams{
+		"ErrorLog \"|/usr/bin/x\"\n":                {Payload: "/usr/bin/x", Directive: "errorlog"},
+		"CustomLog \"|/usr/bin/x\" combined\n":      {Payload: "/usr/bin/x", LogFormat: "combined"},
+		"CustomLog \"|/usr/bin/x\" \"%h %>s %r\"\n": {Payload: "/usr/bin/x", LogFormat: "%h %>s %r"},
+	}
+	for want, p := range cases {
+		got, err := RenderConfig(p)
+		if err != nil || got != want {
+			t.Fatalf("RenderConfig(%+v) = %q, %v; want %q", p, got, err, want)
+		}
+		ds, err := ParseConfig("t.conf", []byte(got))
+		if err != nil || len(ds) != 1 || !p.matches(ds[0]) {
+			t.Fatalf("rendered %q does not match its params", got)
+		}
+	}
+
+	for _, p := range []ConfigParams{
+		{Payload: "/usr/bin/x", Directive: "TransferLog"},
+		{Payload: "/usr/bin/x", Directive: "ErrorLog", LogFormat: "combined"},
+		{Payload: "/usr/bin/x", LogFormat: `"x`},
+		{Payload: "/usr/bin/x", Placement: "sideways"},
+		{Payload: "/usr/bin/x", Placement: PlacementVHost},
+		{Payload: "/usr/bin/x", VHost: "a.example"},
+		{Payload: "/usr/bin/x", Placement: PlacementDropIn, Name: "../x"},
+		{Payload: "/usr/bin/x", Placement: PlacementDropIn, Name: "x.conf"},
+	} {
+		if _, err := RenderConfig(p); err == nil {
+			t.Fatalf("expected an error for %+v", p)
+		}
+	}
+}
+
+func TestDropInDebianIsLinkedIntoConfEnabled(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	writeTree(t, dir, map[string]string{"apache2.conf": "ServerName x\nIncludeOptional conf-enabled/*.conf\n"})
+	useMainConfig(t, main)
+	params := ConfigParams{Payload: "/usr/bin/x", Placement: PlacementDropIn, Name: "audit"}
+
+	effect, warnings, err := Effect(params, main)
+	if err != nil || !strings.HasPrefix(effect, "main server") || len(warnings) != 0 {
+		t.Fatalf("Effect = %q, %q, %v", effect, warnings, err)
+	}
+	if err := Install(params, main, ReloadNone); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	path := filepath.Join(dir, "conf-available", "audit.conf")
+	if data, _ := os.ReadFile(path); string(data) != "CustomLog \"|/usr/bin/x\" error\n" {
+		t.Fatalf("unexpected drop-in %q", data)
+	}
+	link, err := os.Readlink(filepath.Join(dir, "conf-enabled", "audit.conf"))
+	if err != nil || link != "../conf-available/audit.conf" {
+		t.Fatalf("conf-enabled link = %q, %v", link, err)
+	}
+	if data, _ := os.ReadFile(main); string(data) != "ServerName x\nIncludeOptional conf-enabled/*.conf\n" {
+		t.Fatalf("main config must not change, got %q", data)
+	}
+	if err := Install(params, main, ReloadNone); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected a second install to fail, got %v", err)
+	}
+
+	edited, err := Remove(params, main, ReloadNone)
+	if err != nil || edited != path {
+		t.Fatalf("Remove = %s, %v", edited, err)
+	}
+	for _, p := range []string{path, filepath.Join(dir, "conf-enabled", "audit.conf")} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s still present after remove", p)
+		}
+	}
+}
+
+func TestDropInRHELUsesConfD(t *testing.T) {
+	useOSRelease(t, "ID=rocky\n")
+	dir := t.TempDir()
+	main := filepath.Join(dir, "conf", "httpd.conf")
+	writeTree(t, dir, map[string]string{"conf/httpd.conf": "ServerRoot \"" + dir + "\"\nIncludeOptional conf.d/*.conf\n"})
+	useMainConfig(t, main)
+	params := ConfigParams{Payload: "/usr/bin/x", Placement: PlacementDropIn}
+
+	st, err := stage(params, main)
+	if err != nil || st.Path != filepath.Join(dir, "conf.d", "nixpersist.conf") || st.LoadedAs != st.Path {
+		t.Fatalf("stage = %+v, %v", st, err)
+	}
+	if effect, _, err := Effect(params, main); err != nil || !strings.HasPrefix(effect, "main server") {
+		t.Fatalf("Effect = %q, %v", effect, err)
+	}
+}
+
+func TestVHostPlacementInsertsInsideSelectedHost(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	site := filepath.Join(dir, "sites-enabled", "sites.conf")
+	original := "<VirtualHost *:80>\n  ServerName a.example\n  ErrorLog /var/log/a.log\n</VirtualHost>\n" +
+		"<VirtualHost *:80>\n\tServerName b.example\n\tServerAlias www.b.example\n</VirtualHost>\n"
+	writeTree(t, dir, map[string]string{
+		"apache2.conf":             "ServerName x\nIncludeOptional sites-enabled/*.conf\n",
+		"sites-enabled/sites.conf": original,
+	})
+	useMainConfig(t, main)
+
+	if _, err := stage(ConfigParams{Payload: "/usr/bin/x", Placement: PlacementVHost, VHost: "*:80"}, main); err == nil || !strings.Contains(err.Error(), "several virtual hosts") {
+		t.Fatalf("expected an ambiguous selector error, got %v", err)
+	}
+	if _, err := stage(ConfigParams{Payload: "/usr/bin/x", Placement: PlacementVHost, VHost: "c.example"}, main); err == nil || !strings.Contains(err.Error(), "b.example at "+site+":5") {
+		t.Fatalf("expected the loaded hosts to be listed, got %v", err)
+	}
+
+	params := ConfigParams{Payload: "/usr/bin/x", Placement: PlacementVHost, VHost: "www.b.example"}
+	effect, _, err := Effect(params, main)
+	if err != nil || !strings.HasPrefix(effect, "<VirtualHost *:80> b.example") {
+		t.Fatalf("Effect = %q, %v", effect, err)
+	}
+	if err := Install(params, main, ReloadNone); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	data, _ := os.ReadFile(site)
+	if !strings.HasSuffix(string(data), "\tServerAlias www.b.example\n\tCustomLog \"|/usr/bin/x\" error\n</VirtualHost>\n") {
+		t.Fatalf("directive not inserted in the virtual host:\n%s", data)
+	}
+
+	edited, err := Remove(ConfigParams{Placement: PlacementVHost, VHost: "www.b.example"}, main, ReloadNone)
+	if err != nil || edited != site {
+		t.Fatalf("Remove = %s, %v", edited, err)
+	}
+	if data, _ := os.ReadFile(site); string(data) != original {
+		t.Fatalf("virtual host not restored:\n%s", data)
+	}
+}
+
+func TestErrorLogEffectWarnsAboutHostsWithTheirOwn(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	writeTree(t, dir, map[string]string{
+		"apache2.conf": "ErrorLog /var/log/error.log\n<VirtualHost *:80>\n  ErrorLog /var/log/a.log\n  CustomLog /var/log/a_access.log combined\n</VirtualHost>\n",
+	})
+	useMainConfig(t, main)
+
+	effect, warnings, err := Effect(ConfigParams{Payload: "/usr/bin/x", Directive: "ErrorLog"}, main)
+	if err != nil || !strings.HasPrefix(effect, "main server") {
+		t.Fatalf("Effect = %q, %v", effect, err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "ErrorLog overridden: <VirtualHost *:80> at "+main+":2 sets its own ErrorLog") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
@@ -0,0 +1,242 @@
+package apachelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reload is how httpd is made to pick up a configuration change.
+type Reload string
+
+const (
+	// ReloadGraceful lets workers finish their requests; the parent rereads
+	// the configuration and respawns its piped loggers.
+	ReloadGraceful Reload = "graceful"
+	// ReloadRestart stops and starts the service.
+	ReloadRestart Reload = "restart"
+	// ReloadNone leaves httpd alone: the pipe is spawned on its next restart.
+	ReloadNone Reload = "none"
+)
+
+// ParseReload validates a --reload value.
+func ParseReload(s string) (Reload, error) {
+	switch r := Reload(strings.ToLower(s)); r {
+	case ReloadGraceful, ReloadRestart, ReloadNone:
+		return r, nil
+	}
+	return "", fmt.Errorf("reload must be %s, %s or %s, got %q", ReloadGraceful, ReloadRestart, ReloadNone, s)
+}
+
+var (
+	// procRoot is where processes are inspected to verify the piped child.
+	procRoot = "/proc"
+	// spawnTimeout bounds the wait for httpd to spawn the piped child.
+	spawnTimeout = 5 * time.Second
+	// healthDelay is how long to wait after a reload before looking for the
+	// httpd parent when systemd cannot be asked.
+	healthDelay = time.Second
+)
+
+// httpdNames are the process names of the httpd parent on each layout.
+var httpdNames = []string{"apache2", "httpd", "httpd2", "httpd-prefork", "httpd-worker"}
+
+// reloadCommand returns the command that applies strategy: systemctl when
+// present, else the layout's apachectl/httpd -k, else service.
+func reloadCommand(layout Layout, strategy Reload) ([]string, error) {
+	if strategy == ReloadNone {
+		return nil, nil
+	}
+	verb := "restart"
+	if strategy == ReloadGraceful {
+		verb = "reload"
+	}
+	if _, err := lookPath("systemctl"); err == nil {
+		return []string{"systemctl", verb, layout.Service}, nil
+	}
+	if ctl, ok := layout.validator(); ok {
+		return []string{ctl, "-k", string(strategy)}, nil
+	}
+	if _, err := lookPath("service"); err == nil {
+		return []string{"service", layout.Service, verb}, nil
+	}
+	return nil, fmt.Errorf("cannot %s %s: none of systemctl, %s or service is available", strategy, layout.Service, strings.Join(layout.Validators, ", "))
+}
+
+// reloadApache applies strategy to the layout's httpd.
+func reloadApache(layout Layout, strategy Reload) error {
+	command, err := reloadCommand(layout, strategy)
+	if err != nil || command == nil {
+		return err
+	}
+	output, err := execCommand(command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// reloadPlan describes the commands reloadApache and the health check run.
+func reloadPlan(layout Layout, strategy Reload) []string {
+	command, err := reloadCommand(layout, strategy)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if command == nil {
+		return nil
+	}
+	health := "httpd parent in " + procRoot
+	if command[0] == "systemctl" {
+		health = "systemctl is-active " + layout.Service
+	}
+	return []string{
+		strings.Join(command, " "),
+		health + " (health check)",
+		"piped child in " + procRoot + " (spawn check)",
+	}
+}
+
+// checkApacheHealthy confirms httpd is still running after a reload:
+// through systemctl when present, otherwise by its parent process.
+func checkApacheHealthy(layout Layout) error {
+	if _, err := lookPath("systemctl"); err == nil {
+		output, err := execCommand("systemctl", "is-active", layout.Service).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("health check: %s not active after reload: %s", layout.Service, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	time.Sleep(healthDelay)
+	procs := readProcs()
+	for _, p := range procs {
+		if p.isHTTPD() {
+			return nil
+		}
+	}
+	return fmt.Errorf("health check: no %s process after reload", strings.Join(httpdNames, "/"))
+}
+
+// proc is a process as read from procRoot.
+type proc struct {
+	pid, ppid int
+	comm      string
+	argv      []string
+}
+
+func (p proc) isHTTPD() bool {
+	for _, name := range httpdNames {
+		if p.comm == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readProcs lists the processes under procRoot, skipping those that exit
+// while being read.
+func readProcs() map[int]proc {
+	procs := make(map[int]proc)
+	entries, _ := os.ReadDir(procRoot)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(procRoot, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// comm is parenthesised and may hold spaces; the fields after the
+		// last ")" are state, then ppid.
+		open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		cmdline, _ := os.ReadFile(filepath.Join(procRoot, e.Name(), "cmdline"))
+		procs[pid] = proc{
+			pid:  pid,
+			ppid: ppid,
+			comm: string(stat[open+1 : end]),
+			argv: strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"),
+		}
+	}
+	return procs
+}
+
+// findPipedChild returns the process running program below an httpd
+// process. httpd starts "|" pipes through "sh -c", so the program may be a
+// grandchild, or only the shell's argument when the shell has not exec'd
+// it; the program itself is preferred over its shell.
+func findPipedChild(procs map[int]proc, program string) (proc, bool) {
+	pids := make([]int, 0, len(procs))
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+	for _, viaShell := range []bool{false, true} {
+		for _, pid := range pids {
+			p := procs[pid]
+			if runs(p, program, viaShell) && underHTTPD(procs, p) {
+				return p, true
+			}
+		}
+	}
+	return proc{}, false
+}
+
+func underHTTPD(procs map[int]proc, p proc) bool {
+	seen := map[int]bool{}
+	for parent, ok := procs[p.ppid]; ok && !seen[parent.pid]; parent, ok = procs[parent.ppid] {
+		if parent.isHTTPD() {
+			return true
+		}
+		seen[parent.pid] = true
+	}
+	return false
+}
+
+// runs reports whether p executes program, or with viaShell whether p is
+// "sh -c" running it.
+func runs(p proc, program string, viaShell bool) bool {
+	if len(p.argv) == 0 {
+		return false
+	}
+	if !viaShell {
+		return p.argv[0] == program
+	}
+	if len(p.argv) >= 3 && p.argv[1] == "-c" && filepath.Base(p.argv[0]) == "sh" {
+		fields := strings.Fields(p.argv[2])
+		return len(fields) > 0 && fields[0] == program
+	}
+	return false
+}
+
+// VerifySpawn waits up to spawnTimeout for httpd to run the program of
+// payload as a piped logger and returns its pid.
+func VerifySpawn(payload string) (int, error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return 0, errors.New("empty payload")
+	}
+	deadline := time.Now().Add(spawnTimeout)
+	for {
+		if p, ok := findPipedChild(readProcs(), fields[0]); ok {
+			return p.pid, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("piped child not found: no child of %s runs %s after %s", strings.Join(httpdNames, "/"), fields[0], spawnTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
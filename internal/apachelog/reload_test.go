@@ -0,0 +1,153 @@
+package apachelog
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeProc writes a /proc entry for pid under root.
+func fakeProc(t *testing.T, root string, pid, ppid int, comm string, argv ...string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	stat := strconv.Itoa(pid) + " (" + comm + ") S " + strconv.Itoa(ppid) + " 1 1 0 -1\n"
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatalf("write stat: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(argv, "\x00")+"\x00"), 0644); err != nil {
+		t.Fatalf("write cmdline: %v", err)
+	}
+}
+
+func useProcRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	origRoot, origTimeout, origDelay := procRoot, spawnTimeout, healthDelay
+	procRoot, spawnTimeout, healthDelay = root, 0, 0
+	t.Cleanup(func() { procRoot, spawnTimeout, healthDelay = origRoot, origTimeout, origDelay })
+	return root
+}
+
+func TestReloadCommandFallbacks(t *testing.T) {
+	origLookPath := lookPath
+	defer func() { lookPath = origLookPath }()
+	debian, _ := layoutNamed("debian")
+	rhel, _ := layoutNamed("rhel")
+
+	cases := []struct {
+		available []string
+		layout    Layout
+		strategy  Reload
+		want      string
+	}{
+		{[]string{"systemctl", "apache2ctl"}, debian, ReloadGraceful, "systemctl reload apache2"},
+		{[]string{"systemctl"}, rhel, ReloadRestart, "systemctl restart httpd"},
+		{[]string{"apachectl", "service"}, debian, ReloadGraceful, "apachectl -k graceful"},
+		{[]string{"httpd"}, rhel, ReloadRestart, "httpd -k restart"},
+		{[]string{"service"}, rhel, ReloadGraceful, "service httpd reload"},
+		{nil, debian, ReloadNone, ""},
+	}
+	for _, c := range cases {
+		lookPath = func(name string) (string, error) {
+			for _, a := range c.available {
+				if a == name {
+					return "/usr/sbin/" + name, nil
+				}
+			}
+			return "", os.ErrNotExist
+		}
+		command, err := reloadCommand(c.layout, c.strategy)
+		if err != nil || strings.Join(command, " ") != c.want {
+			t.Fatalf("reloadCommand(%v, %s) = %q, %v; want %q", c.available, c.strategy, command, err, c.want)
+		}
+	}
+	lookPath = func(string) (string, error) { return "", os.ErrNotExist }
+	if _, err := reloadCommand(debian, ReloadGraceful); err == nil {
+		t.Fatal("expected an error without any reload method")
+	}
+	if _, err := ParseReload("bounce"); err == nil {
+		t.Fatal("expected an invalid strategy to be rejected")
+	}
+}
+
+func TestGracefulInstallWithoutSystemd(t *testing.T) {
+	proc := useProcRoot(t)
+	fakeProc(t, proc, 100, 1, "apache2", "/usr/sbin/apache2", "-k", "start")
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "apache2.conf")
+	if err := os.WriteFile(conf, []byte("ServerName localhost\n"), 0644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+	var called []string
+	origLookPath := lookPath
+	origExec := execCommand
+	defer func() {
+		lookPath = origLookPath
+		execCommand = origExec
+	}()
+	lookPath = func(name string) (string, error) {
+		if name == "apache2ctl" {
+			return "/usr/sbin/apache2ctl", nil
+		}
+		return "", os.ErrNotExist
+	}
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, name+" "+strings.Join(args, " "))
+		return exec.Command("true")
+	}
+
+	if err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadGraceful); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	if len(called) != 1 || called[0] != "apache2ctl -k graceful" {
+		t.Fatalf("unexpected commands %v", called)
+	}
+
+	// Without an httpd process the health check fails and the install is
+	// rolled back.
+	if err := os.RemoveAll(filepath.Join(proc, "100")); err != nil {
+		t.Fatalf("remove fake proc: %v", err)
+	}
+	if _, err := Remove(ConfigParams{}, conf, ReloadNone); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	err := Install(ConfigParams{Payload: "/usr/bin/testsh"}, conf, ReloadGraceful)
+	if err == nil || !strings.Contains(err.Error(), "no apache2/httpd") {
+		t.Fatalf("expected a failed health check, got %v", err)
+	}
+	if data, _ := os.ReadFile(conf); string(data) != "ServerName localhost\n" {
+		t.Fatalf("expected rollback, got %q", data)
+	}
+}
+
+func TestVerifySpawnWalksProc(t *testing.T) {
+	proc := useProcRoot(t)
+	fakeProc(t, proc, 100, 1, "apache2", "/usr/sbin/apache2", "-k", "start")
+	fakeProc(t, proc, 120, 100, "sh", "/bin/sh", "-c", "/usr/bin/beacon --quiet")
+	fakeProc(t, proc, 121, 120, "beacon", "/usr/bin/beacon", "--quiet")
+	fakeProc(t, proc, 300, 1, "beacon", "/usr/bin/other")
+
+	pid, err := VerifySpawn("/usr/bin/beacon --quiet")
+	if err != nil || pid != 121 {
+		t.Fatalf("VerifySpawn = %d, %v; want 121", pid, err)
+	}
+
+	// A shell that has not exec'd the payload yet still counts.
+	if err := os.RemoveAll(filepath.Join(proc, "121")); err != nil {
+		t.Fatalf("remove fake proc: %v", err)
+	}
+	if pid, err := VerifySpawn("/usr/bin/beacon"); err != nil || pid != 120 {
+		t.Fatalf("VerifySpawn = %d, %v; want 120", pid, err)
+	}
+
+	if _, err := VerifySpawn("/usr/bin/other"); err == nil || !strings.Contains(err.Error(), "piped child not found") {
+		t.Fatalf("expected an unrelated process to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package apachelog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/preflight"
+)
+
+// ValidateConfig checks the configuration as it would be after installing
+// the rendered directive where params places it, using the layout's
+// validator ("apache2ctl -t", "httpd -t") against a staged copy so the live
+// configuration is never touched. Appended to confPath, the staged copy is
+// confPath with the directive; written to a drop-in or a virtual host, it is
+// the main configuration with every include, the new file among them,
+// inlined.
+func ValidateConfig(params ConfigParams, confPath string) error {
+	layout := layoutFor(confPath)
+	if strings.TrimSpace(confPath) == "" {
+		confPath = layout.ConfPath
+	}
+	ctl, ok := layout.validator()
+	if !ok {
+		return preflight.Unavailable("%s not found on PATH", strings.Join(layout.Validators, "/"))
+	}
+
+	if _, err := RenderConfig(params); err != nil {
+		return err
+	}
+	st, err := stage(params, confPath)
+	if err != nil {
+		return preflight.Unavailable("%v", err)
+	}
+	root, staged := filepath.Dir(confPath), st.After
+	if st.Path != confPath {
+		main := mainConfig(confPath)
+		cfg, err := loadConfig("/", main, map[string][]byte{st.LoadedAs: st.After})
+		if err != nil {
+			return preflight.Unavailable("%v", err)
+		}
+		var b bytes.Buffer
+		flatten(&b, cfg.Directives)
+		root, staged = filepath.Dir(main), b.Bytes()
+	}
+
+	f, err := os.CreateTemp("", "nixpersist-apache-*.conf")
+	if err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(staged); err != nil {
+		f.Close()
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+
+	// Relative Include paths resolve against ServerRoot, so point it at the
+	// directory of the real configuration.
+	args := []string{"-t", "-d", root, "-f", f.Name()}
+	out, err := execCommand(ctl, args...).CombinedOutput()
+	return preflight.FromCommand(fmt.Sprintf("%s %s", ctl, strings.Join(args, " ")), out, err)
+}
+
+// validationPlan describes what ValidateConfig stages for st, for --plan.
+func validationPlan(st *staging, confPath string) string {
+	validators := strings.Join(layoutFor(confPath).Validators, "/")
+	if st.Path == confPath {
+		return validators + " -t -f <staged copy of " + confPath + " with the directive appended> (pre-flight validation)"
+	}
+	return validators + " -t -f <staged copy of " + mainConfig(confPath) + " with its includes inlined, " + st.LoadedAs + " as it would be written> (pre-flight validation)"
+}
+
+// flatten writes ds back out as a single configuration file, replacing each
+// Include by the directives of the files it loaded. An Include that loaded
+// nothing is kept for httpd to resolve, and fail on, itself.
+func flatten(b *bytes.Buffer, ds []*Directive) {
+	for _, d := range ds {
+		switch {
+		case (d.Is("Include") || d.Is("IncludeOptional")) && len(d.Body) > 0:
+			flatten(b, d.Body)
+		case d.Section:
+			b.WriteString(d.Text + "\n")
+			flatten(b, d.Body)
+			fmt.Fprintf(b, "</%s>\n", d.Name)
+		default:
+			b.WriteString(d.Text + "\n")
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package apachelog
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/preflight"
+)
+
+func TestValidateConfigStagesCopy(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "apache2.conf")
+	original := "ServerName localhost\n"
+	if err := os.WriteFile(conf, []byte(original), 0644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	origLookPath := lookPath
+	origExec := execCommand
+	defer func() {
+		lookPath = origLookPath
+		execCommand = origExec
+	}()
+	lookPath = func(name string) (string, error) {
+		if name == "apachectl" {
+			return "/usr/sbin/apachectl", nil
+		}
+		return "", os.ErrNotExist
+	}
+	var staged string
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		if name != "apachectl" || args[0] != "-t" || args[2] != dir {
+			t.Fatalf("unexpected validator invocation: %s %v", name, args)
+		}
+		data, err := os.ReadFile(args[len(args)-1])
+		if err != nil {
+			t.Fatalf("read staged config: %v", err)
+		}
+		staged = string(data)
+		return exec.Command("sh", "-c", "echo 'Syntax error on line 2'; exit 1")
+	}
+
+	err := ValidateConfig(ConfigParams{Payload: "/usr/bin/testsh"}, conf)
+	var failed *preflight.FailedError
+	if !errors.As(err, &failed) || !strings.Contains(failed.Output, "Syntax error") {
+		t.Fatalf("expected validation failure, got %v", err)
+	}
+	if staged != original+"CustomLog \"|/usr/bin/testsh\" error\n" {
+		t.Fatalf("unexpected staged config: %q", staged)
+	}
+	live, _ := os.ReadFile(conf)
+	if string(live) != original {
+		t.Fatalf("live config must not be modified, got %q", live)
+	}
+}
+
+func TestValidateConfigUnavailable(t *testing.T) {
+	origLookPath := lookPath
+	defer func() { lookPath = origLookPath }()
+	lookPath = func(string) (string, error) { return "", os.ErrNotExist }
+
+	err := ValidateConfig(ConfigParams{Payload: "/usr/bin/testsh"}, "/nonexistent/apache2.conf")
+	if !errors.Is(err, preflight.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+// captureValidator makes ValidateConfig run a passing apachectl and returns
+// the staged configuration it was given.
+func captureValidator(t *testing.T) *string {
+	t.Helper()
+	origLookPath, origExec := lookPath, execCommand
+	t.Cleanup(func() { lookPath, execCommand = origLookPath, origExec })
+	lookPath = func(name string) (string, error) {
+		if name == "apachectl" {
+			return "/usr/sbin/apachectl", nil
+		}
+		return "", os.ErrNotExist
+	}
+	staged := new(string)
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		data, err := os.ReadFile(args[len(args)-1])
+		if err != nil {
+			t.Fatalf("read staged config: %v", err)
+		}
+		*staged = string(data)
+		return exec.Command("true")
+	}
+	return staged
+}
+
+func TestValidateConfigStagesVHostFile(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	writeTree(t, dir, map[string]string{
+		"apache2.conf":         "ServerName x\n<IfModule mod_ssl.c>\n  Listen 443\n</IfModule>\nIncludeOptional sites-enabled/*.conf\n",
+		"sites-enabled/b.conf": "<VirtualHost *:80>\n\tServerName b.example\n</VirtualHost>\n",
+	})
+	useMainConfig(t, main)
+	staged := captureValidator(t)
+
+	params := ConfigParams{Payload: "/usr/bin/x", Placement: PlacementVHost, VHost: "b.example"}
+	if err := ValidateConfig(params, main); err != nil {
+		t.Fatalf("ValidateConfig returned error: %v", err)
+	}
+	want := "ServerName x\n<IfModule mod_ssl.c>\nListen 443\n</IfModule>\n" +
+		"<VirtualHost *:80>\nServerName b.example\nCustomLog \"|/usr/bin/x\" error\n</VirtualHost>\n"
+	if *staged != want {
+		t.Fatalf("unexpected staged config:\n%s", *staged)
+	}
+}
+
+func TestValidateConfigStagesEnabledDropIn(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "apache2.conf")
+	writeTree(t, dir, map[string]string{
+		"apache2.conf":            "ServerName x\nIncludeOptional conf-enabled/*.conf\nInclude ports.conf\n",
+		"conf-enabled/other.conf": "ServerTokens Prod\n",
+		"ports.conf":              "Listen 80\n",
+	})
+	useMainConfig(t, main)
+	staged := captureValidator(t)
+
+	params := ConfigParams{Payload: "/usr/bin/x", Placement: PlacementDropIn, Name: "audit"}
+	if err := ValidateConfig(params, main); err != nil {
+		t.Fatalf("ValidateConfig returned error: %v", err)
+	}
+	want := "ServerName x\nCustomLog \"|/usr/bin/x\" error\nServerTokens Prod\nListen 80\n"
+	if *staged != want {
+		t.Fatalf("unexpected staged config:\n%s", *staged)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "conf-available", "audit.conf")); !os.IsNotExist(err) {
+		t.Fatalf("validation must not write the drop-in: %v", err)
+	}
+}
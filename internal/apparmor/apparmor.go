@@ -0,0 +1,193 @@
+// Package apparmor relaxes the AppArmor profile confining a daemon, in
+// complain mode or by disabling it, and restores exactly the state it found.
+// The prior state is recorded under the NixPersist state directory so that a
+// later --remove or cleanup can put it back.
+package apparmor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	execCommand = exec.Command
+	lookPath    = exec.LookPath
+
+	// profileDir holds the profile files and their disable/ links.
+	profileDir = "/etc/apparmor.d"
+	// securityFS lists the profiles loaded in the kernel and their modes.
+	securityFS = "/sys/kernel/security/apparmor"
+)
+
+// Mode is the state of a profile.
+type Mode string
+
+const (
+	Enforce  Mode = "enforce"
+	Complain Mode = "complain"
+	// Disabled is a profile file that is not loaded.
+	Disabled Mode = "disabled"
+	// Absent means there is no profile file at all.
+	Absent Mode = "absent"
+)
+
+// ParseMode validates an --apparmor-mode value: complain, or disable for
+// Disabled.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "complain":
+		return Complain, nil
+	case "disable", "disabled":
+		return Disabled, nil
+	}
+	return "", fmt.Errorf("AppArmor mode must be complain or disable, got %q", s)
+}
+
+// Profile is a profile file name under /etc/apparmor.d, such as
+// usr.sbin.rsyslogd.
+type Profile string
+
+// ProfileFor returns the conventional profile of binary: /usr/sbin/apache2
+// is confined by usr.sbin.apache2.
+func ProfileFor(binary string) Profile {
+	return Profile(strings.ReplaceAll(strings.TrimPrefix(binary, "/"), "/", "."))
+}
+
+// Path is the profile file.
+func (p Profile) Path() string { return filepath.Join(profileDir, string(p)) }
+
+// disableLink is the link apparmor.service skips the profile for.
+func (p Profile) disableLink() string { return filepath.Join(profileDir, "disable", string(p)) }
+
+// profileHeader matches the first profile declaration of a file, such as
+//
+//	/usr/sbin/rsyslogd flags=(attach_disconnected) {
+//	profile rsyslogd /usr/{,s}bin/rsyslogd {
+var profileHeader = regexp.MustCompile(`^(\s*(?:profile\s+("[^"]*"|\S+)(?:\s+[/@"]\S*)?|("[^"]*"|/\S+)))(\s+flags\s*=\s*\(([^)]*)\))?(\s*\{.*)$`)
+
+// header returns the line index and submatches of the profile declaration.
+func header(data []byte) (int, []string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for i := 0; sc.Scan(); i++ {
+		line := sc.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if m := profileHeader.FindStringSubmatch(line); m != nil {
+			return i, m, true
+		}
+	}
+	return 0, nil, false
+}
+
+// KernelName returns the name the profile is loaded under: its profile
+// name, or its attachment path for unnamed profiles.
+func (p Profile) KernelName() (string, error) {
+	data, err := os.ReadFile(p.Path())
+	if err != nil {
+		return "", err
+	}
+	_, m, ok := header(data)
+	if !ok {
+		return "", fmt.Errorf("%s: no profile declaration found", p.Path())
+	}
+	name := m[2]
+	if name == "" {
+		name = m[3]
+	}
+	return strings.Trim(name, `"`), nil
+}
+
+// Current returns the mode of the profile: the mode the kernel has it
+// loaded in, Disabled when its file exists but it is not loaded, or Absent.
+func (p Profile) Current() (Mode, error) {
+	if _, err := os.Stat(p.Path()); errors.Is(err, os.ErrNotExist) {
+		return Absent, nil
+	}
+	name, err := p.KernelName()
+	if err != nil {
+		return "", err
+	}
+	loaded, err := os.ReadFile(filepath.Join(securityFS, "profiles"))
+	if err != nil {
+		return "", fmt.Errorf("read loaded profiles: %w", err)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(loaded))
+	for sc.Scan() {
+		line := sc.Text()
+		open := strings.LastIndex(line, " (")
+		if open > 0 && line[:open] == name {
+			return Mode(strings.TrimSuffix(line[open+2:], ")")), nil
+		}
+	}
+	return Disabled, nil
+}
+
+// setComplainFlag adds complain to the flags of the profile declaration,
+// replacing enforce.
+func setComplainFlag(data []byte) ([]byte, error) {
+	i, m, ok := header(data)
+	if !ok {
+		return nil, errors.New("no profile declaration found")
+	}
+	flags := []string{"complain"}
+	for _, f := range strings.Split(m[5], ",") {
+		if f = strings.TrimSpace(f); f != "" && f != "complain" && f != "enforce" {
+			flags = append(flags, f)
+		}
+	}
+	return replaceLine(data, i, m[1]+" flags=("+strings.Join(flags, ",")+")"+m[6]), nil
+}
+
+// clearComplainFlag removes complain from the flags of the profile
+// declaration, dropping flags=() when nothing else is left. It reports
+// whether the flag was there.
+func clearComplainFlag(data []byte) ([]byte, bool) {
+	i, m, ok := header(data)
+	if !ok {
+		return data, false
+	}
+	var flags []string
+	found := false
+	for _, f := range strings.Split(m[5], ",") {
+		switch f = strings.TrimSpace(f); f {
+		case "":
+		case "complain":
+			found = true
+		default:
+			flags = append(flags, f)
+		}
+	}
+	if !found {
+		return data, false
+	}
+	line := m[1] + m[6]
+	if len(flags) > 0 {
+		line = m[1] + " flags=(" + strings.Join(flags, ",") + ")" + m[6]
+	}
+	return replaceLine(data, i, line), true
+}
+
+func replaceLine(data []byte, i int, line string) []byte {
+	lines := strings.Split(string(data), "\n")
+	lines[i] = line
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// parser runs apparmor_parser with args.
+func parser(args ...string) error {
+	if _, err := lookPath("apparmor_parser"); err != nil {
+		return errors.New("apparmor_parser not found; is AppArmor installed?")
+	}
+	if out, err := execCommand("apparmor_parser", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("apparmor_parser %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
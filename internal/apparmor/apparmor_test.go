@@ -0,0 +1,280 @@
+package apparmor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/state"
+)
+
+const rsyslogd = Profile("usr.sbin.rsyslogd")
+
+const rsyslogProfile = `# Last Modified: Sun Sep 25 08:58:35 2011
+#include <tunables/global>
+
+profile rsyslogd /usr/sbin/rsyslogd flags=(attach_disconnected) {
+  #include <abstractions/base>
+  /usr/sbin/rsyslogd mr,
+}
+`
+
+// fakeKernel stubs apparmor_parser with a kernel whose loaded profiles are
+// kept in securityFS/profiles, and returns the commands run.
+func fakeKernel(t *testing.T, loaded string) *[]string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv(state.DirEnv, filepath.Join(root, "state"))
+	origDir, origFS, origExec, origLookPath := profileDir, securityFS, execCommand, lookPath
+	profileDir, securityFS = filepath.Join(root, "apparmor.d"), filepath.Join(root, "securityfs")
+	t.Cleanup(func() { profileDir, securityFS, execCommand, lookPath = origDir, origFS, origExec, origLookPath })
+	for _, dir := range []string{profileDir, securityFS} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLoaded(t, loaded)
+
+	var called []string
+	lookPath = func(name string) (string, error) { return "/sbin/" + name, nil }
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, name+" "+strings.Join(args, " "))
+		path := args[len(args)-1]
+		p := Profile(filepath.Base(path))
+		kernelName, err := p.KernelName()
+		if err != nil {
+			return exec.Command("false")
+		}
+		var lines []string
+		for _, l := range strings.Split(readLoaded(t), "\n") {
+			if l != "" && !strings.HasPrefix(l, kernelName+" (") {
+				lines = append(lines, l)
+			}
+		}
+		if args[0] != "-R" {
+			data, _ := os.ReadFile(path)
+			mode := "enforce"
+			if _, flagged := clearComplainFlag(data); flagged || args[0] == "-C" {
+				mode = "complain"
+			}
+			lines = append(lines, kernelName+" ("+mode+")")
+		}
+		writeLoaded(t, strings.Join(lines, "\n"))
+		return exec.Command("true")
+	}
+	return &called
+}
+
+func writeLoaded(t *testing.T, loaded string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(securityFS, "profiles"), []byte(loaded+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLoaded(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(securityFS, "profiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeProfile(t *testing.T, p Profile, content string) {
+	t.Helper()
+	if err := os.WriteFile(p.Path(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileForAndKernelName(t *testing.T) {
+	fakeKernel(t, "")
+	if p := ProfileFor("/usr/sbin/apache2"); p != "usr.sbin.apache2" {
+		t.Fatalf("got %s", p)
+	}
+	writeProfile(t, rsyslogd, rsyslogProfile)
+	if name, err := rsyslogd.KernelName(); err != nil || name != "rsyslogd" {
+		t.Fatalf("got %q %v", name, err)
+	}
+	cron := Profile("usr.sbin.cron")
+	writeProfile(t, cron, "#include <tunables/global>\n/usr/sbin/cron {\n}\n")
+	if name, err := cron.KernelName(); err != nil || name != "/usr/sbin/cron" {
+		t.Fatalf("got %q %v", name, err)
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	fakeKernel(t, "/usr/sbin/cupsd (enforce)\nrsyslogd (complain)")
+	if mode, _ := rsyslogd.Current(); mode != Absent {
+		t.Fatalf("no profile file: got %s", mode)
+	}
+	writeProfile(t, rsyslogd, rsyslogProfile)
+	if mode, _ := rsyslogd.Current(); mode != Complain {
+		t.Fatalf("got %s, want complain", mode)
+	}
+	writeLoaded(t, "/usr/sbin/cupsd (enforce)")
+	if mode, _ := rsyslogd.Current(); mode != Disabled {
+		t.Fatalf("got %s, want disabled", mode)
+	}
+}
+
+func TestComplainFlags(t *testing.T) {
+	got, err := setComplainFlag([]byte(rsyslogProfile))
+	if err != nil || !strings.Contains(string(got), "profile rsyslogd /usr/sbin/rsyslogd flags=(complain,attach_disconnected) {") {
+		t.Fatalf("got %v:\n%s", err, got)
+	}
+	back, found := clearComplainFlag(got)
+	if !found || string(back) != rsyslogProfile {
+		t.Fatalf("clear did not round-trip:\n%s", back)
+	}
+	plain := "/usr/sbin/cron {\n}\n"
+	got, _ = setComplainFlag([]byte(plain))
+	if !strings.HasPrefix(string(got), "/usr/sbin/cron flags=(complain) {") {
+		t.Fatalf("got:\n%s", got)
+	}
+	if back, _ := clearComplainFlag(got); string(back) != plain {
+		t.Fatalf("clear did not drop empty flags:\n%s", back)
+	}
+}
+
+func TestRelaxComplainRestoresExactly(t *testing.T) {
+	called := fakeKernel(t, "rsyslogd (enforce)")
+	writeProfile(t, rsyslogd, rsyslogProfile)
+
+	rec, err := Relax(rsyslogd, Complain)
+	if err != nil {
+		t.Fatalf("Relax: %v", err)
+	}
+	if rec.Prior != Enforce || rec.Applied != Complain || rec.String() != "usr.sbin.rsyslogd enforce -> complain" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Complain {
+		t.Fatalf("after relax: %s", mode)
+	}
+	if _, err := os.Lstat(rsyslogd.disableLink()); err == nil {
+		t.Fatal("complain mode must not link into disable/")
+	}
+
+	rec, err = Restore(rsyslogd)
+	if err != nil || rec.Restored() != "usr.sbin.rsyslogd restored to enforce" {
+		t.Fatalf("Restore: %v %+v", err, rec)
+	}
+	data, _ := os.ReadFile(rsyslogd.Path())
+	if string(data) != rsyslogProfile {
+		t.Fatalf("profile not restored byte for byte:\n%s", data)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Enforce {
+		t.Fatalf("after restore: %s (%v)", mode, *called)
+	}
+	if _, err := load(rsyslogd); !os.IsNotExist(err) {
+		t.Fatalf("record not deleted: %v", err)
+	}
+}
+
+func TestRelaxDisableRestoresPriorComplain(t *testing.T) {
+	called := fakeKernel(t, "rsyslogd (complain)")
+	writeProfile(t, rsyslogd, rsyslogProfile)
+
+	if _, err := Relax(rsyslogd, Disabled); err != nil {
+		t.Fatalf("Relax: %v", err)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Disabled {
+		t.Fatalf("after relax: %s", mode)
+	}
+	if target, err := os.Readlink(rsyslogd.disableLink()); err != nil || target != rsyslogd.Path() {
+		t.Fatalf("disable link: %q %v", target, err)
+	}
+
+	if _, err := Restore(rsyslogd); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// The file has no complain flag, so complain mode must be forced.
+	if last := (*called)[len(*called)-1]; last != "apparmor_parser -C -r "+rsyslogd.Path() {
+		t.Fatalf("unexpected reload %q", last)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Complain {
+		t.Fatalf("after restore: %s", mode)
+	}
+	if _, err := os.Lstat(rsyslogd.disableLink()); err == nil {
+		t.Fatal("disable link left behind")
+	}
+}
+
+func TestRelaxLeavesDisabledProfileAlone(t *testing.T) {
+	called := fakeKernel(t, "")
+	writeProfile(t, rsyslogd, rsyslogProfile)
+	if err := os.MkdirAll(filepath.Dir(rsyslogd.disableLink()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(rsyslogd.Path(), rsyslogd.disableLink()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := Relax(rsyslogd, Complain)
+	if err != nil || rec.Applied != "" || rec.Prior != Disabled {
+		t.Fatalf("Relax: %v %+v", err, rec)
+	}
+	if _, err := Restore(rsyslogd); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if len(*called) != 0 {
+		t.Fatalf("expected no apparmor_parser calls, got %v", *called)
+	}
+	if _, err := os.Lstat(rsyslogd.disableLink()); err != nil {
+		t.Fatal("pre-existing disable link removed")
+	}
+}
+
+func TestRestoreUnrecordedLegacyDisable(t *testing.T) {
+	called := fakeKernel(t, "")
+	writeProfile(t, rsyslogd, rsyslogProfile)
+	if rec, err := Restore(rsyslogd); err != nil || rec.Applied != "" || len(*called) != 0 {
+		t.Fatalf("nothing to restore: %v %+v %v", err, rec, *called)
+	}
+	if err := os.MkdirAll(filepath.Dir(rsyslogd.disableLink()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(rsyslogd.Path(), rsyslogd.disableLink()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(rsyslogd); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Enforce {
+		t.Fatalf("legacy disable not undone: %s", mode)
+	}
+}
+
+func TestAbortKeepsProfileRelaxedForEarlierInstall(t *testing.T) {
+	fakeKernel(t, "rsyslogd (enforce)")
+	writeProfile(t, rsyslogd, rsyslogProfile)
+
+	first, err := Relax(rsyslogd, Complain)
+	if err != nil {
+		t.Fatalf("Relax: %v", err)
+	}
+	second, err := Relax(rsyslogd, Complain)
+	if err != nil {
+		t.Fatalf("second Relax: %v", err)
+	}
+	// The second install failed: the first still needs complain mode.
+	if err := second.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Complain {
+		t.Fatalf("after aborting the second install: %s", mode)
+	}
+	if _, err := load(rsyslogd); err != nil {
+		t.Fatalf("record of the first install deleted: %v", err)
+	}
+
+	if err := first.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if mode, _ := rsyslogd.Current(); mode != Enforce {
+		t.Fatalf("after aborting the first install: %s", mode)
+	}
+}
@@ -0,0 +1,266 @@
+package apparmor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"nixpersist/internal/snapshot"
+	"nixpersist/internal/state"
+)
+
+// warnOut receives non-fatal warnings such as profile drift.
+var warnOut io.Writer = os.Stderr
+
+// Record is the state of a profile before NixPersist relaxed it.
+type Record struct {
+	Profile Profile `json:"profile"`
+	// Prior is the mode the profile was in.
+	Prior Mode `json:"prior"`
+	// PriorLink is set when the profile was already linked into disable/.
+	PriorLink bool `json:"prior_link"`
+	// Applied is the mode NixPersist put the profile in; empty when it was
+	// already at least as permissive.
+	Applied Mode `json:"applied,omitempty"`
+
+	// created is set when Relax made the record rather than finding one
+	// from an earlier install.
+	created bool
+}
+
+// String describes the change, for the ledger and install messages.
+func (r Record) String() string {
+	if r.Applied == "" {
+		return fmt.Sprintf("%s left %s", r.Profile, r.Prior)
+	}
+	return fmt.Sprintf("%s %s -> %s", r.Profile, r.Prior, r.Applied)
+}
+
+// Restored describes what Restore did with the profile.
+func (r Record) Restored() string {
+	if r.Applied == "" {
+		return fmt.Sprintf("%s left %s", r.Profile, r.Prior)
+	}
+	return fmt.Sprintf("%s restored to %s", r.Profile, r.Prior)
+}
+
+// Dir returns where profile records are stored inside the state directory.
+func Dir() string { return filepath.Join(state.Dir(), "apparmor") }
+
+func recordPath(p Profile) string { return filepath.Join(Dir(), string(p)+".json") }
+
+// permissiveness orders modes from most to least confining.
+func permissiveness(m Mode) int {
+	switch m {
+	case Enforce:
+		return 0
+	case Complain:
+		return 1
+	case Disabled, Absent, "unconfined":
+		return 2
+	}
+	return 0
+}
+
+// Relax puts p in target, Complain or Disabled, and records its prior state.
+// A profile that is already as permissive is left alone. When a record from
+// an earlier install exists it is kept, as it holds the true original state.
+//
+// Complain follows aa-complain: flags=(complain) is added to the profile's
+// declaration, after snapshotting the file, and the profile is reloaded.
+// Disabled unloads the profile and links it into disable/.
+func Relax(p Profile, target Mode) (Record, error) {
+	if target != Complain && target != Disabled {
+		return Record{}, fmt.Errorf("cannot relax %s to %s", p, target)
+	}
+	if rec, err := load(p); err == nil {
+		return rec, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Record{}, err
+	}
+	prior, err := p.Current()
+	if err != nil {
+		return Record{}, err
+	}
+	rec := Record{Profile: p, Prior: prior, PriorLink: exists(p.disableLink())}
+	if permissiveness(prior) < permissiveness(target) {
+		rec.Applied = target
+	}
+	// Saved even when nothing changes, so that Restore leaves a profile
+	// that was disabled beforehand alone.
+	if err := save(rec); err != nil {
+		return Record{}, err
+	}
+	rec.created = true
+	if rec.Applied == "" {
+		return rec, nil
+	}
+	if err := apply(p, target); err != nil {
+		if _, rerr := Restore(p); rerr != nil {
+			err = fmt.Errorf("%w (restoring %s failed: %w)", err, p, rerr)
+		}
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func apply(p Profile, target Mode) error {
+	if target == Disabled {
+		if err := parser("-R", p.Path()); err != nil {
+			return err
+		}
+		if exists(p.disableLink()) {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(p.disableLink()), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(p.Path(), p.disableLink())
+	}
+	snap, err := snapshot.Take(p.Path())
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(p.Path())
+	if err != nil {
+		return err
+	}
+	updated, err := setComplainFlag(original)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Path(), err)
+	}
+	if err := snap.Save(updated); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.Path(), updated, snap.Mode); err != nil {
+		_ = snap.Abort()
+		return fmt.Errorf("write %s: %w", p.Path(), err)
+	}
+	return parser("-r", p.Path())
+}
+
+// Restore puts p back in the state recorded by Relax and deletes the
+// record. Without a record, a disable/ link left by older NixPersist
+// versions, which did not record the prior state, is undone.
+func Restore(p Profile) (Record, error) {
+	rec, err := load(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return restoreUnrecorded(p)
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	if rec.Applied == Complain {
+		res, err := snapshot.Revert(p.Path(), clearComplainFlag)
+		if err != nil && !errors.Is(err, snapshot.ErrNotFound) {
+			return rec, fmt.Errorf("restore %s: %w", p.Path(), err)
+		}
+		if res.Drift != "" {
+			fmt.Fprintf(warnOut, "warning: %s changed since install; complain flag removed surgically, remaining differences from the original:\n%s", p.Path(), res.Drift)
+		}
+	}
+	if rec.Applied != "" {
+		if !rec.PriorLink {
+			if err := os.Remove(p.disableLink()); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return rec, fmt.Errorf("remove %s: %w", p.disableLink(), err)
+			}
+		}
+		if err := reload(p, rec.Prior); err != nil {
+			return rec, err
+		}
+	}
+	if err := os.Remove(recordPath(p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// Abort undoes Relax after the install it prepared for failed. A record
+// kept from an earlier install is left alone: that install still relies on
+// the relaxed profile.
+func (r Record) Abort() error {
+	if !r.created {
+		return nil
+	}
+	_, err := Restore(r.Profile)
+	return err
+}
+
+// reload loads p in mode, forcing complain mode with -C when the profile
+// was in complain mode without the flag in its file.
+func reload(p Profile, mode Mode) error {
+	switch mode {
+	case Disabled, Absent:
+		// Not loaded before install: unload whatever the install loaded.
+		if current, err := p.Current(); err == nil && current != Disabled && current != Absent {
+			return parser("-R", p.Path())
+		}
+		return nil
+	case Complain:
+		data, err := os.ReadFile(p.Path())
+		if err != nil {
+			return err
+		}
+		if _, flagged := clearComplainFlag(data); flagged {
+			return parser("-r", p.Path())
+		}
+		return parser("-C", "-r", p.Path())
+	}
+	return parser("-r", p.Path())
+}
+
+func restoreUnrecorded(p Profile) (Record, error) {
+	rec := Record{Profile: p, Prior: Enforce, Applied: Disabled}
+	if !exists(p.disableLink()) {
+		return Record{Profile: p}, nil
+	}
+	if err := os.Remove(p.disableLink()); err != nil {
+		return rec, fmt.Errorf("remove %s: %w", p.disableLink(), err)
+	}
+	return rec, parser("-r", p.Path())
+}
+
+// RelaxPlan lists the commands Relax runs for target, for --plan.
+func RelaxPlan(p Profile, target Mode) []string {
+	if target == Complain {
+		return []string{
+			"add flags=(complain) to the declaration in " + p.Path() + " (aa-complain; original snapshotted)",
+			"apparmor_parser -r " + p.Path(),
+		}
+	}
+	return []string{
+		"apparmor_parser -R " + p.Path(),
+		"ln -s " + p.Path() + " " + filepath.Dir(p.disableLink()) + "/",
+	}
+}
+
+func load(p Profile) (Record, error) {
+	data, err := os.ReadFile(recordPath(p))
+	if err != nil {
+		return Record{}, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Record{}, fmt.Errorf("apparmor: parse record for %s: %w", p, err)
+	}
+	return rec, nil
+}
+
+func save(rec Record) error {
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return fmt.Errorf("apparmor: create %s: %w", Dir(), err)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recordPath(rec.Profile), data, 0600)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,191 @@
+// Package audit builds auditd rules covering the files and processes a
+// persistence technique touches, and loads or unloads them with auditctl.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	execCommand = exec.Command
+	lookPath    = exec.LookPath
+)
+
+// KeyPrefix starts every rule key so NixPersist telemetry can be found with
+// "ausearch -k nixpersist".
+const KeyPrefix = "nixpersist-"
+
+// Rule is a single auditctl rule in rules-file syntax, split into arguments.
+type Rule struct {
+	Args []string
+}
+
+// Watch returns a file watch: "-w path -p perms -k key".
+func Watch(path, perms, key string) Rule {
+	return Rule{Args: []string{"-w", path, "-p", perms, "-k", key}}
+}
+
+// Exec returns a syscall rule logging every execve of path. The SYSCALL
+// record carries ppid, so the parent of the process can be recovered.
+func Exec(path, key string) Rule {
+	return Rule{Args: []string{"-a", "always,exit", "-F", "arch=b64", "-S", "execve", "-F", "path=" + path, "-k", key}}
+}
+
+// Parse reads a rule from its String form.
+func Parse(line string) (Rule, error) {
+	args := strings.Fields(line)
+	if len(args) < 2 || (args[0] != "-w" && args[0] != "-a") {
+		return Rule{}, fmt.Errorf("unsupported audit rule %q", line)
+	}
+	return Rule{Args: args}, nil
+}
+
+func (r Rule) String() string { return strings.Join(r.Args, " ") }
+
+// path returns the filesystem path the kernel resolves when the rule is
+// added, or "" if it has none.
+func (r Rule) path() string {
+	for i, a := range r.Args {
+		switch {
+		case a == "-w" && i+1 < len(r.Args):
+			return r.Args[i+1]
+		case strings.HasPrefix(a, "path="):
+			return strings.TrimPrefix(a, "path=")
+		}
+	}
+	return ""
+}
+
+// deleteArgs returns the auditctl arguments removing the rule.
+func (r Rule) deleteArgs() []string {
+	args := append([]string(nil), r.Args...)
+	switch args[0] {
+	case "-w":
+		args[0] = "-W"
+	case "-a":
+		args[0] = "-d"
+	}
+	return args
+}
+
+// Render returns the rules as a file suitable for /etc/audit/rules.d/.
+func Render(module string, rules []Rule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## NixPersist auditd rules for %s\n", module)
+	b.WriteString("## Load with: auditctl -R <file>, or copy to /etc/audit/rules.d/ and run augenrules --load\n")
+	for _, r := range rules {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Plan returns the auditctl commands Load would run.
+func Plan(rules []Rule) []string {
+	cmds := make([]string, 0, len(rules))
+	for _, r := range rules {
+		cmds = append(cmds, "auditctl "+r.String())
+	}
+	return cmds
+}
+
+// Load adds rules with auditctl. Rules whose path does not exist yet (e.g.
+// a directory the install is about to create) cannot be added and are
+// returned as deferred; call Load again with them after the install. Rules
+// that already exist in the kernel are left alone and not reported as
+// loaded, so unloading never removes rules NixPersist did not add. On error
+// the rules added so far are unloaded again.
+func Load(rules []Rule) (loaded, deferred []Rule, err error) {
+	if len(rules) == 0 {
+		return nil, nil, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, nil, errors.New("audit: root privileges required to load rules (run with sudo)")
+	}
+	if _, err := lookPath("auditctl"); err != nil {
+		return nil, nil, fmt.Errorf("audit: auditctl not found: %w", err)
+	}
+	for _, r := range rules {
+		if p := r.path(); p != "" {
+			if _, statErr := os.Stat(p); statErr != nil {
+				deferred = append(deferred, r)
+				continue
+			}
+		}
+		out, runErr := execCommand("auditctl", r.Args...).CombinedOutput()
+		if runErr != nil {
+			if strings.Contains(strings.ToLower(string(out)), "rule exists") {
+				continue
+			}
+			cause := fmt.Errorf("audit: auditctl %s: %v: %s", r, runErr, strings.TrimSpace(string(out)))
+			if undoErr := Unload(loaded); undoErr != nil {
+				return nil, nil, fmt.Errorf("%w (unloading added rules failed: %w)", cause, undoErr)
+			}
+			return nil, nil, cause
+		}
+		loaded = append(loaded, r)
+	}
+	return loaded, deferred, nil
+}
+
+// Unload deletes rules with auditctl, attempting every rule even if some
+// fail.
+func Unload(rules []Rule) error {
+	var errs []error
+	for _, r := range rules {
+		out, err := execCommand("auditctl", r.deleteArgs()...).CombinedOutput()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("auditctl %s: %v: %s", strings.Join(r.deleteArgs(), " "), err, strings.TrimSpace(string(out))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Strings returns the String form of each rule, as recorded in the ledger.
+func Strings(rules []Rule) []string {
+	lines := make([]string, 0, len(rules))
+	for _, r := range rules {
+		lines = append(lines, r.String())
+	}
+	return lines
+}
+
+// ParseAll parses rules recorded with Strings.
+func ParseAll(lines []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(lines))
+	for _, line := range lines {
+		r, err := Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// FirstExisting returns the first of paths that exists, or the first path
+// when none does, so rules follow distributions that install a binary in
+// /sbin rather than /usr/sbin.
+func FirstExisting(paths ...string) string {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return paths[0]
+}
+
+// AppArmorRules covers unloading, disabling and relaxing AppArmor profiles
+// ("apparmor_parser -R", links in /etc/apparmor.d/disable, complain flags
+// written into profile files).
+func AppArmorRules() []Rule {
+	key := KeyPrefix + "apparmor"
+	return []Rule{
+		Exec(FirstExisting("/usr/sbin/apparmor_parser", "/sbin/apparmor_parser"), key),
+		Watch("/etc/apparmor.d", "wa", key),
+	}
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, r := range []Rule{Watch("/etc/rsyslog.conf", "wa", "k"), Exec("/usr/bin/id", "k")} {
+		got, err := Parse(r.String())
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", r, err)
+		}
+		if got.String() != r.String() {
+			t.Fatalf("round trip = %q, want %q", got, r)
+		}
+	}
+	if _, err := Parse("-D"); err == nil {
+		t.Fatal("expected delete-all rule to be rejected")
+	}
+}
+
+func TestDeleteArgs(t *testing.T) {
+	if got := strings.Join(Watch("/x", "wa", "k").deleteArgs(), " "); got != "-W /x -p wa -k k" {
+		t.Fatalf("watch delete = %q", got)
+	}
+	if got := strings.Join(Exec("/x", "k").deleteArgs(), " "); !strings.HasPrefix(got, "-d always,exit ") {
+		t.Fatalf("exec delete = %q", got)
+	}
+}
+
+func TestLoadDefersMissingPathsAndSkipsExisting(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Load requires root")
+	}
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "later")
+
+	var called []string
+	origExec, origLookPath := execCommand, lookPath
+	lookPath = func(string) (string, error) { return "/sbin/auditctl", nil }
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, name+" "+strings.Join(args, " "))
+		if args[len(args)-1] == "dup" {
+			return exec.Command("sh", "-c", "echo 'Error sending add rule data request (Rule exists)' >&2; exit 1")
+		}
+		return exec.Command("true")
+	}
+	t.Cleanup(func() { execCommand, lookPath = origExec, origLookPath })
+
+	loaded, deferred, err := Load([]Rule{Watch(dir, "wa", "k"), Watch(missing, "wa", "k"), Watch(dir, "r", "dup")})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0].path() != dir {
+		t.Fatalf("loaded = %v, want only the existing watch", loaded)
+	}
+	if len(deferred) != 1 || deferred[0].path() != missing {
+		t.Fatalf("deferred = %v, want the missing path", deferred)
+	}
+	if len(called) != 2 {
+		t.Fatalf("expected two auditctl calls, got %v", called)
+	}
+}
+
+func TestLoadUnloadsOnFailure(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Load requires root")
+	}
+	dir := t.TempDir()
+
+	var called []string
+	origExec, origLookPath := execCommand, lookPath
+	lookPath = func(string) (string, error) { return "/sbin/auditctl", nil }
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		called = append(called, strings.Join(args, " "))
+		if args[len(args)-1] == "bad" {
+			return exec.Command("false")
+		}
+		return exec.Command("true")
+	}
+	t.Cleanup(func() { execCommand, lookPath = origExec, origLookPath })
+
+	if _, _, err := Load([]Rule{Watch(dir, "wa", "ok"), Watch(dir, "wa", "bad")}); err == nil {
+		t.Fatal("expected Load to fail")
+	}
+	want := []string{"-w " + dir + " -p wa -k ok", "-w " + dir + " -p wa -k bad", "-W " + dir + " -p wa -k ok"}
+	if strings.Join(called, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("auditctl calls = %q, want %q", called, want)
+	}
+}
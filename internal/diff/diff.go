@@ -0,0 +1,168 @@
+// Package diff renders line-based unified diffs for configuration files.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	line string
+	// aLine and bLine are the zero-based line numbers in each input.
+	aLine, bLine int
+}
+
+// Unified returns a unified diff turning a into b, labelled with the given
+// names. It returns "" when the inputs are identical.
+func Unified(aName, bName string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	ops := compute(splitLines(string(a)), splitLines(string(b)))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks(ops) {
+		writeHunk(&out, h)
+	}
+	return out.String()
+}
+
+// splitLines splits s into lines, keeping a marker for a missing trailing
+// newline so that such changes still show up in the diff.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	last := lines[len(lines)-1]
+	if !strings.HasSuffix(last, "\n") {
+		lines[len(lines)-1] = last + "\n\\ No newline at end of file\n"
+	}
+	return lines
+}
+
+// compute returns the edit script between a and b. Common prefix and suffix
+// are stripped first so the quadratic LCS only runs over the changed region,
+// which keeps typical config edits cheap.
+func compute(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []op
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{opEqual, a[i], i, i})
+	}
+
+	am := a[prefix : len(a)-suffix]
+	bm := b[prefix : len(b)-suffix]
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		switch {
+		case i < len(am) && j < len(bm) && am[i] == bm[j]:
+			ops = append(ops, op{opEqual, am[i], prefix + i, prefix + j})
+			i++
+			j++
+		case j < len(bm) && (i == len(am) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{opInsert, bm[j], prefix + i, prefix + j})
+			j++
+		default:
+			ops = append(ops, op{opDelete, am[i], prefix + i, prefix + j})
+			i++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, op{opEqual, a[len(a)-suffix+k], len(a) - suffix + k, len(b) - suffix + k})
+	}
+	return ops
+}
+
+// hunks groups ops into change regions padded with context lines.
+func hunks(ops []op) [][]op {
+	var out [][]op
+	start, end := -1, -1
+	for i, o := range ops {
+		if o.kind == opEqual {
+			continue
+		}
+		lo := max(i-context, 0)
+		hi := min(i+context+1, len(ops))
+		if start >= 0 && lo <= end {
+			end = hi
+			continue
+		}
+		if start >= 0 {
+			out = append(out, ops[start:end])
+		}
+		start, end = lo, hi
+	}
+	if start >= 0 {
+		out = append(out, ops[start:end])
+	}
+	return out
+}
+
+func writeHunk(out *strings.Builder, h []op) {
+	aStart, bStart := h[0].aLine, h[0].bLine
+	aCount, bCount := 0, 0
+	for _, o := range h {
+		if o.kind != opInsert {
+			aCount++
+		}
+		if o.kind != opDelete {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, o := range h {
+		out.WriteByte(byte(o.kind))
+		out.WriteString(o.line)
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
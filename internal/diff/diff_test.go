@@ -0,0 +1,45 @@
+package diff
+
+import "testing"
+
+func TestUnifiedAppend(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\n"
+	b := a + "CustomLog \"|/bin/x\" error\n"
+	got := Unified("a/apache2.conf", "b/apache2.conf", []byte(a), []byte(b))
+	want := "--- a/apache2.conf\n+++ b/apache2.conf\n" +
+		"@@ -3,3 +3,4 @@\n three\n four\n five\n+CustomLog \"|/bin/x\" error\n"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedCreateAndIdentical(t *testing.T) {
+	if got := Unified("a", "b", []byte("same\n"), []byte("same\n")); got != "" {
+		t.Fatalf("expected empty diff, got %q", got)
+	}
+	got := Unified("/dev/null", "b/new.conf", nil, []byte("x\ny\n"))
+	want := "--- /dev/null\n+++ b/new.conf\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+}
+
+func TestUnifiedSeparateHunks(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\nX\n3\n4\n5\n6\n7\n8\n9\n10\nY\n12\n"
+	got := Unified("a", "b", []byte(a), []byte(b))
+	want := "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n" +
+		"@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+Y\n 12\n"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedMissingNewline(t *testing.T) {
+	got := Unified("a", "b", []byte("x"), []byte("x\n"))
+	want := "--- a\n+++ b\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%q", got)
+	}
+}
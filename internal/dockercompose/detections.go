@@ -0,0 +1,88 @@
+package dockercompose
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/sigma"
+)
+
+var composeTags = []string{"attack.persistence", "attack.privilege-escalation", "attack.t1610", "attack.t1611"}
+
+// Detections returns Sigma rules for the compose file rendered from p and
+// written to outputDir.
+func Detections(p ConfigParams, outputDir string) ([]sigma.Rule, error) {
+	if _, err := RenderConfig(p); err != nil {
+		return nil, err
+	}
+	composeFile := filepath.Join(outputDir, DefaultComposeName)
+	return []sigma.Rule{
+		{
+			Title:       "Docker Compose File Written",
+			Description: fmt.Sprintf("Detects creation of %s, the compose file NixPersist deploys.", composeFile),
+			Tags:        composeTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_event"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{{Name: "selection", Fields: []sigma.Field{{Name: "TargetFilename", Values: []string{composeFile}}}}},
+				Condition:  "selection",
+			},
+			FalsePositives: []string{"Administrators deploying compose projects to the same path"},
+			Level:          "medium",
+		},
+		{
+			Title:       "Privileged Restart-Always Container Mounting Host Root",
+			Description: fmt.Sprintf("Detects compose files defining a privileged container with the host root mounted and restart: always. The simulation defines service %s from image %s.", p.ServiceName, p.Image),
+			Tags:        composeTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_content"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_file", Fields: []sigma.Field{{Name: "TargetFilename", Modifiers: []string{"endswith"}, Values: []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"}}}},
+					{Name: "selection_privileged", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains", "all"}, Values: []string{"privileged: true", "/:/mnt", "restart: \"always\""}}}},
+					{Name: "selection_service", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains"}, Values: []string{"container_name: " + p.ServiceName}}}},
+				},
+				Condition: "selection_file and (selection_privileged or selection_service)",
+			},
+			FalsePositives: []string{"Privileged infrastructure containers such as monitoring agents"},
+			Level:          "high",
+		},
+		{
+			Title:       "Container Chroot Into Host Root",
+			Description: fmt.Sprintf("Detects the container escaping into the host filesystem via chroot to run %s, and the compose deployment that starts it.", p.PayloadCommand),
+			Tags:        append([]string{"attack.execution"}, composeTags...),
+			LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_chroot", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains"}, Values: []string{"chroot /mnt " + p.PayloadCommand}}}},
+					{Name: "selection_up", Fields: []sigma.Field{
+						{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"compose", "-f " + DefaultComposeName, "up"}},
+						{Name: "CurrentDirectory", Values: []string{outputDir}},
+					}},
+				},
+				Condition: "1 of selection_*",
+			},
+			FalsePositives: []string{"Debug containers that chroot into the host on purpose"},
+			Level:          "high",
+		},
+	}, nil
+}
+
+// AuditRules returns auditd rules watching the compose directory and the
+// docker socket, and logging execve of the docker CLIs and the payload the
+// container runs on the host root.
+func AuditRules(p ConfigParams, outputDir string) ([]audit.Rule, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	key := audit.KeyPrefix + "docker-compose"
+	rules := []audit.Rule{
+		audit.Watch(outputDir, "wa", key),
+		audit.Watch(audit.FirstExisting("/var/run/docker.sock", "/run/docker.sock"), "rwxa", key),
+		audit.Exec(audit.FirstExisting("/usr/bin/docker", "/usr/local/bin/docker"), key+"-exec"),
+		audit.Exec(audit.FirstExisting("/usr/bin/docker-compose", "/usr/local/bin/docker-compose"), key+"-exec"),
+	}
+	if image := sigma.Image(p.PayloadCommand); filepath.IsAbs(image) {
+		rules = append(rules, audit.Exec(image, key+"-exec"))
+	}
+	return rules, nil
+}
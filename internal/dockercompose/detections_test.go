@@ -0,0 +1,37 @@
+package dockercompose
+
+import (
+	"strings"
+	"testing"
+
+	"nixpersist/internal/sigma"
+)
+
+func TestDetectionsMatchComposeFile(t *testing.T) {
+	params := ConfigParams{ServiceName: "svc", Image: "alpine:latest", PayloadCommand: "/bin/beacon"}
+	rules, err := Detections(params, "/opt/svc")
+	if err != nil {
+		t.Fatalf("Detections returned error: %v", err)
+	}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	// Every content indicator must occur in the file the module writes.
+	for _, v := range rules[1].Detection.Selections[1].Fields[0].Values {
+		if !strings.Contains(cfg, v) {
+			t.Fatalf("indicator %q not present in rendered compose file:\n%s", v, cfg)
+		}
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{
+		"TargetFilename: '/opt/svc/docker-compose.yml'",
+		"Content|contains: 'container_name: svc'",
+		"CommandLine|contains: chroot /mnt /bin/beacon",
+		"CurrentDirectory: '/opt/svc'",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -11,14 +11,17 @@ import (
 
 // Result captures discovery data about the local Docker installation.
 type Result struct {
-	DockerAvailable   bool
-	ComposeAvailable  bool
-	UserIsRoot        bool
-	UserInDockerGroup bool
-	DockerPsSucceeded bool
-	Images            []string
-	Containers        []string
-	Notes             []string
+	DockerAvailable   bool     `json:"docker_available"`
+	ComposeAvailable  bool     `json:"compose_available"`
+	UserIsRoot        bool     `json:"user_is_root"`
+	UserInDockerGroup bool     `json:"user_in_docker_group"`
+	DockerPsSucceeded bool     `json:"docker_ps_succeeded"`
+	Images            []string `json:"images"`
+	Containers        []string `json:"containers"`
+	// Validation is the outcome of docker compose config on the rendered
+	// file; empty when no file was validated.
+	Validation string   `json:"validation,omitempty"`
+	Notes      []string `json:"notes"`
 }
 
 // HasAccess reports whether the current user is likely able to interact with Docker.
@@ -39,6 +42,9 @@ func (r Result) Render() string {
 	writeLine("docker binary present", r.DockerAvailable)
 	writeLine("docker compose available", r.ComposeAvailable)
 	writeLine("user has docker access", r.HasAccess())
+	if r.Validation != "" {
+		fmt.Fprintf(b, "- config validation (docker compose config): %s\n", r.Validation)
+	}
 
 	if len(r.Images) > 0 {
 		b.WriteString("\nImages:\n")
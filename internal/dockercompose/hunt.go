@@ -0,0 +1,244 @@
+package dockercompose
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// composeDirs are searched for compose files defining services.
+var composeDirs = []string{"/opt", "/srv", "/root", "/home", "/etc", "/usr/local"}
+
+var composeNames = map[string]bool{
+	"docker-compose.yml":  true,
+	"docker-compose.yaml": true,
+	"compose.yml":         true,
+	"compose.yaml":        true,
+}
+
+// service is what the hunt extracts from one compose service definition.
+type service struct {
+	name       string
+	line       int
+	privileged bool
+	hostPID    bool
+	rootMount  bool
+	restart    bool
+	evidence   []string
+}
+
+// risky reports whether the service grants host-level access.
+func (s service) risky() bool { return s.privileged || s.hostPID || s.rootMount }
+
+func (s service) severity() hunt.Severity {
+	if (s.privileged || s.rootMount) && s.restart {
+		return hunt.High
+	}
+	return hunt.Medium
+}
+
+func (s service) indicators() string {
+	var parts []string
+	if s.privileged {
+		parts = append(parts, "privileged")
+	}
+	if s.hostPID {
+		parts = append(parts, "host PID namespace")
+	}
+	if s.rootMount {
+		parts = append(parts, "host / mounted")
+	}
+	if s.restart {
+		parts = append(parts, "restarts automatically")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Hunt reports compose services, defined in compose files under root or
+// running on this host, that are privileged, share the host PID namespace or
+// mount the host root, rating those that also restart automatically highest.
+func Hunt(root string) hunt.Report {
+	var r hunt.Report
+	for _, dir := range composeDirs {
+		for _, file := range hunt.Walk(root, dir, 4, func(name string) bool { return composeNames[name] }) {
+			lines, err := hunt.ReadLines(root, file)
+			if err != nil {
+				r.Notes = append(r.Notes, fmt.Sprintf("read %s: %v", file, err))
+				continue
+			}
+			for _, s := range parseServices(lines) {
+				if !s.risky() {
+					continue
+				}
+				r.Findings = append(r.Findings, hunt.Finding{
+					Technique: "docker-compose",
+					Severity:  s.severity(),
+					Path:      file,
+					Line:      s.line,
+					Summary:   fmt.Sprintf("compose service %s: %s", s.name, s.indicators()),
+					Evidence:  strings.Join(s.evidence, "; "),
+				})
+			}
+		}
+	}
+	if root != "/" {
+		r.Notes = append(r.Notes, "running containers not inspected when scanning an alternate --root")
+		return r
+	}
+	r.Merge(huntContainers())
+	return r
+}
+
+// parseServices extracts the services of a compose file. It understands the
+// subset of YAML compose files use for the keys the hunt inspects.
+func parseServices(lines []string) []service {
+	var services []service
+	inServices := false
+	serviceIndent := -1
+	for i, raw := range lines {
+		line := stripComment(raw)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		trimmed := strings.TrimSpace(line)
+		item, isItem := strings.CutPrefix(trimmed, "- ")
+		key, value := splitKey(item)
+
+		if indent == 0 {
+			inServices = key == "services" && value == ""
+			serviceIndent = -1
+			continue
+		}
+		if !inServices {
+			continue
+		}
+		if serviceIndent == -1 {
+			serviceIndent = indent
+		}
+		if indent == serviceIndent {
+			services = append(services, service{name: key, line: i + 1})
+			continue
+		}
+		if len(services) == 0 {
+			continue
+		}
+		s := &services[len(services)-1]
+		note := func() { s.evidence = append(s.evidence, fmt.Sprintf("line %d: %s", i+1, trimmed)) }
+		switch {
+		case key == "privileged" && value == "true":
+			s.privileged = true
+			note()
+		case key == "pid" && value == "host":
+			s.hostPID = true
+			note()
+		case key == "restart" && (value == "always" || value == "unless-stopped"):
+			s.restart = true
+			note()
+		case key == "source" && value == "/":
+			s.rootMount = true
+			note()
+		case isItem && strings.HasPrefix(unquote(item), "/:"):
+			s.rootMount = true
+			note()
+		}
+	}
+	return services
+}
+
+func stripComment(line string) string {
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = line[:i]
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	return strings.TrimRight(line, " \t\r")
+}
+
+// splitKey splits "key: value" and unquotes both parts.
+func splitKey(line string) (string, string) {
+	key, value, found := strings.Cut(line, ":")
+	if !found {
+		return "", ""
+	}
+	return unquote(strings.TrimSpace(key)), unquote(strings.TrimSpace(value))
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// containerInfo is the subset of "docker inspect" output the hunt reads.
+type containerInfo struct {
+	Name   string
+	Config struct {
+		Labels map[string]string
+	}
+	HostConfig struct {
+		Privileged    bool
+		PidMode       string
+		RestartPolicy struct{ Name string }
+	}
+	Mounts []struct {
+		Source      string
+		Destination string
+	}
+}
+
+// huntContainers inspects running containers via the docker CLI.
+func huntContainers() hunt.Report {
+	var r hunt.Report
+	out, err := commandRunner("docker", "ps", "-q").Output()
+	if err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("running containers not inspected: docker ps: %v", err))
+		return r
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return r
+	}
+	out, err = commandRunner("docker", append([]string{"inspect"}, ids...)...).Output()
+	if err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("running containers not inspected: docker inspect: %v", err))
+		return r
+	}
+	var infos []containerInfo
+	if err := json.Unmarshal(out, &infos); err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("running containers not inspected: parse docker inspect: %v", err))
+		return r
+	}
+	for _, c := range infos {
+		s := service{
+			name:       strings.TrimPrefix(c.Name, "/"),
+			privileged: c.HostConfig.Privileged,
+			hostPID:    c.HostConfig.PidMode == "host",
+			restart:    c.HostConfig.RestartPolicy.Name == "always" || c.HostConfig.RestartPolicy.Name == "unless-stopped",
+		}
+		for _, m := range c.Mounts {
+			if m.Source == "/" {
+				s.rootMount = true
+			}
+		}
+		if !s.risky() {
+			continue
+		}
+		evidence := "restart policy " + c.HostConfig.RestartPolicy.Name
+		if files := c.Config.Labels["com.docker.compose.project.config_files"]; files != "" {
+			evidence += "; compose file " + files
+		}
+		r.Findings = append(r.Findings, hunt.Finding{
+			Technique: "docker-compose",
+			Severity:  s.severity(),
+			Path:      "container:" + s.name,
+			Summary:   fmt.Sprintf("running container %s: %s", s.name, s.indicators()),
+			Evidence:  evidence,
+		})
+	}
+	return r
+}
@@ -0,0 +1,77 @@
+package dockercompose
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/hunt"
+)
+
+func TestParseServices(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{ServiceName: "svc", Image: "alpine", PayloadCommand: "/bin/x"})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	cfg += `  web:
+    image: nginx # front end
+    restart: always
+  debug:
+    image: busybox
+    pid: 'host'
+    volumes:
+      - type: bind
+        source: /
+        target: /host
+`
+	services := parseServices(strings.Split(cfg, "\n"))
+	if len(services) != 3 {
+		t.Fatalf("expected three services, got %+v", services)
+	}
+	svc := services[0]
+	if svc.name != "svc" || svc.line != 3 || !svc.privileged || !svc.hostPID || !svc.rootMount || !svc.restart {
+		t.Fatalf("unexpected NixPersist service %+v", svc)
+	}
+	if svc.severity() != hunt.High {
+		t.Fatalf("expected high severity, got %s", svc.severity())
+	}
+	if services[1].risky() {
+		t.Fatalf("web service should not be risky: %+v", services[1])
+	}
+	if dbg := services[2]; !dbg.hostPID || !dbg.rootMount || dbg.severity() != hunt.Medium {
+		t.Fatalf("unexpected debug service %+v", dbg)
+	}
+}
+
+func TestHuntFindsComposeFilesAndContainers(t *testing.T) {
+	root := t.TempDir()
+	cfg, _ := RenderConfig(ConfigParams{ServiceName: "svc", Image: "alpine", PayloadCommand: "/bin/x"})
+	path := filepath.Join(root, "opt", "compose-nixpersist", DefaultComposeName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	r := Hunt(root)
+	if len(r.Findings) != 1 || r.Findings[0].Location() != "/opt/compose-nixpersist/docker-compose.yml:3" {
+		t.Fatalf("unexpected findings %+v", r.Findings)
+	}
+
+	origRunner := commandRunner
+	commandRunner = func(name string, args ...string) *exec.Cmd {
+		if args[0] == "ps" {
+			return exec.Command("echo", "abc123")
+		}
+		return exec.Command("echo", `[{"Name":"/svc","HostConfig":{"Privileged":true,"PidMode":"host","RestartPolicy":{"Name":"always"}},"Mounts":[{"Source":"/","Destination":"/mnt"}]}]`)
+	}
+	t.Cleanup(func() { commandRunner = origRunner })
+
+	c := huntContainers()
+	if len(c.Findings) != 1 || c.Findings[0].Path != "container:svc" || c.Findings[0].Severity != hunt.High {
+		t.Fatalf("unexpected container findings %+v", c)
+	}
+}
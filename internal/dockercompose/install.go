@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+
+	"nixpersist/internal/txn"
 )
 
 // DefaultComposeName is the filename written to the target directory.
@@ -17,6 +19,10 @@ var commandRunner = exec.Command
 
 // Install writes the rendered docker-compose configuration to outputDir and
 // invokes "docker compose up -d" (or "docker-compose") to start the container.
+// If the deployment fails to start, any partially created containers are
+// taken down and the compose file (and directory, if created) removed again.
+// An existing compose file in outputDir belongs to another deployment and is
+// never overwritten.
 func Install(cfg string, outputDir string) (string, error) {
 	if cfg == "" {
 		return "", errors.New("install: configuration content is empty")
@@ -25,22 +31,54 @@ func Install(cfg string, outputDir string) (string, error) {
 		return "", errors.New("install: output directory is required")
 	}
 
+	dest := filepath.Join(outputDir, DefaultComposeName)
+	if _, err := os.Lstat(dest); err == nil {
+		return "", fmt.Errorf("install: compose file %s already exists; choose another output directory", dest)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("install: stat compose file: %w", err)
+	}
+
+	var tx txn.Txn
+	if _, err := os.Stat(outputDir); errors.Is(err, os.ErrNotExist) {
+		tx.OnRollback(func() error {
+			if err := os.Remove(outputDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove %s: %w", outputDir, err)
+			}
+			return nil
+		})
+	}
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return "", fmt.Errorf("install: create output directory %s: %w", outputDir, err)
 	}
 
-	dest := filepath.Join(outputDir, DefaultComposeName)
-	if err := os.WriteFile(dest, []byte(cfg), 0644); err != nil {
-		return "", fmt.Errorf("install: write compose file: %w", err)
+	if err := tx.WriteFile(dest, []byte(cfg), 0644); err != nil {
+		return "", tx.Rollback(fmt.Errorf("install: write compose file: %w", err))
 	}
 
 	if err := runCompose(dest, "up", "-d"); err != nil {
-		return "", fmt.Errorf("install: docker compose up failed: %w", err)
+		// Take down whatever was partially created while the file still exists.
+		tx.OnRollback(func() error {
+			if err := runCompose(dest, "down"); err != nil {
+				return fmt.Errorf("docker compose down: %w", err)
+			}
+			return nil
+		})
+		return "", tx.Rollback(fmt.Errorf("install: docker compose up failed: %w", err))
 	}
 
 	return dest, nil
 }
 
+// installPlan describes the commands Install runs for a compose file in
+// outputDir.
+func installPlan(outputDir string) []string {
+	var cmds []string
+	if _, err := os.Stat(outputDir); errors.Is(err, os.ErrNotExist) {
+		cmds = append(cmds, "mkdir -p "+outputDir)
+	}
+	return append(cmds, fmt.Sprintf("docker compose -f %s up -d (in %s; falls back to docker-compose)", DefaultComposeName, outputDir))
+}
+
 // Remove stops the deployment via "docker compose down" and deletes the compose file.
 func Remove(outputDir string) error {
 	if strings.TrimSpace(outputDir) == "" {
@@ -0,0 +1,83 @@
+package dockercompose
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallRollsBackOnComposeFailure(t *testing.T) {
+	origRunner := commandRunner
+	commandRunner = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("false")
+	}
+	defer func() { commandRunner = origRunner }()
+
+	outputDir := filepath.Join(t.TempDir(), "compose-nixpersist")
+	cfg, err := RenderConfig(ConfigParams{ServiceName: "svc", Image: "alpine:latest", PayloadCommand: "/bin/true"})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+
+	if _, err := Install(cfg, outputDir); err == nil {
+		t.Fatal("expected install to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, DefaultComposeName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected compose file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(outputDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected created output directory to be removed, got %v", err)
+	}
+}
+
+func TestInstallRollbackKeepsExistingDirectory(t *testing.T) {
+	origRunner := commandRunner
+	commandRunner = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("false")
+	}
+	defer func() { commandRunner = origRunner }()
+
+	outputDir := t.TempDir()
+	if _, err := Install("services: {}\n", outputDir); err == nil {
+		t.Fatal("expected install to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, DefaultComposeName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected compose file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(outputDir); err != nil {
+		t.Fatalf("expected pre-existing directory to remain, got %v", err)
+	}
+}
+
+func TestInstallKeepsExistingComposeFile(t *testing.T) {
+	ran := false
+	origRunner := commandRunner
+	commandRunner = func(name string, args ...string) *exec.Cmd {
+		ran = true
+		return exec.Command("true")
+	}
+	defer func() { commandRunner = origRunner }()
+
+	outputDir := t.TempDir()
+	dest := filepath.Join(outputDir, DefaultComposeName)
+	original := "services:\n  web:\n    image: nginx:latest\n"
+	if err := os.WriteFile(dest, []byte(original), 0640); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+	cfg, err := RenderConfig(ConfigParams{ServiceName: "svc", Image: "alpine:latest", PayloadCommand: "/bin/true"})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+
+	if _, err := Install(cfg, outputDir); err == nil {
+		t.Fatal("expected install to refuse the existing compose file")
+	}
+	if ran {
+		t.Fatal("docker compose ran against the existing compose file")
+	}
+	if data, _ := os.ReadFile(dest); string(data) != original {
+		t.Fatalf("existing compose file changed: %q", data)
+	}
+}
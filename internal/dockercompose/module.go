@@ -0,0 +1,174 @@
+package dockercompose
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/module"
+	"nixpersist/internal/preflight"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+// Module exposes the privileged restart-always compose service as the
+// "docker-compose" subcommand.
+type Module struct {
+	payload string
+	image   string
+	name    string
+	output  string
+}
+
+// NewModule returns the docker-compose module.
+func NewModule() *Module { return &Module{} }
+
+func (m *Module) Name() string { return "docker-compose" }
+
+func (m *Module) Describe() string { return "Autostart persistence via docker-compose file" }
+
+func (m *Module) Flags(fs *pflag.FlagSet) {
+	fs.StringVarP(&m.payload, "payload", "p", "", "path to payload on HOST filesystem")
+	fs.StringVarP(&m.image, "image", "i", "alpine:latest", "container image to launch, will download if required")
+	fs.StringVarP(&m.name, "name", "n", "compose-nixpersist", "service/container name for docker-compose")
+	fs.StringVarP(&m.output, "output", "o", "/opt/compose-nixpersist", "directory to place docker-compose.yml")
+}
+
+func (m *Module) Check() (module.Report, error) {
+	res := Check()
+	if strings.TrimSpace(m.payload) == "" {
+		res.Validation = "skipped (pass --payload to validate the rendered compose file)"
+	} else if cfg, err := m.Render(); err != nil {
+		res.Validation = "skipped (" + err.Error() + ")"
+	} else {
+		res.Validation = preflight.Describe(ValidateConfig(cfg))
+	}
+	return res, nil
+}
+
+func (m *Module) params() (ConfigParams, error) {
+	if strings.TrimSpace(m.payload) == "" {
+		return ConfigParams{}, errors.New("--payload is required")
+	}
+	if strings.TrimSpace(m.image) == "" {
+		return ConfigParams{}, errors.New("--image is required")
+	}
+	if strings.TrimSpace(m.name) == "" {
+		return ConfigParams{}, errors.New("--name is required")
+	}
+	return ConfigParams{
+		ServiceName:    m.name,
+		Image:          m.image,
+		PayloadCommand: m.payload,
+	}, nil
+}
+
+func (m *Module) Render() (string, error) {
+	params, err := m.params()
+	if err != nil {
+		return "", err
+	}
+	return RenderConfig(params)
+}
+
+func (m *Module) Plan() (module.Plan, error) {
+	cfg, err := m.Render()
+	if err != nil {
+		return module.Plan{}, err
+	}
+	if strings.TrimSpace(m.output) == "" {
+		return module.Plan{}, errors.New("--output directory is required for --plan")
+	}
+	dest := filepath.Join(m.output, DefaultComposeName)
+	before, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return module.Plan{}, fmt.Errorf("read %s: %w", dest, err)
+	}
+	cmds := append([]string{"docker compose config --quiet on a staged copy (pre-flight validation)"}, installPlan(m.output)...)
+	plan := module.Plan{
+		Files:    []module.FileEdit{{Path: dest, Before: before, After: []byte(cfg)}},
+		Commands: cmds,
+		Notes:    []string{"image " + m.image + " is pulled if not present locally; install recorded in the NixPersist ledger"},
+	}
+	if before != nil {
+		plan.Notes = append(plan.Notes, dest+" already exists: --install would fail (choose another --output)")
+	}
+	return plan, nil
+}
+
+func (m *Module) Install() (module.Outcome, error) {
+	params, err := m.params()
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	if strings.TrimSpace(m.output) == "" {
+		return module.Outcome{}, errors.New("--output directory is required for --install")
+	}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+
+	if err := preflight.Gate(ValidateConfig(cfg), os.Stderr); err != nil {
+		return module.Outcome{}, err
+	}
+
+	if !Check().HasAccess() {
+		fmt.Fprintln(os.Stderr, "warning: docker commands may fail (insufficient permissions or daemon unavailable)")
+	}
+
+	change := state.Observe(filepath.Join(m.output, DefaultComposeName))
+	path, err := Install(cfg, m.output)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	return module.Outcome{
+		Message:  fmt.Sprintf("install complete: %s written and docker compose up started (service %s)", path, m.name),
+		Files:    []state.FileChange{change},
+		Services: []string{"compose:" + m.name},
+	}, nil
+}
+
+func (m *Module) Remove() (module.Outcome, error) {
+	if strings.TrimSpace(m.output) == "" {
+		return module.Outcome{}, errors.New("--output directory is required for --remove")
+	}
+	change := state.ObserveDelete(filepath.Join(m.output, DefaultComposeName))
+	if err := Remove(m.output); err != nil {
+		return module.Outcome{}, err
+	}
+	return module.Outcome{
+		Message: fmt.Sprintf("remove complete: docker compose down and %s removed", DefaultComposeName),
+		Files:   []state.FileChange{change},
+	}, nil
+}
+
+func (m *Module) Detections() ([]sigma.Rule, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(m.output) == "" {
+		return nil, errors.New("--output directory is required for detections")
+	}
+	return Detections(params, m.output)
+}
+
+func (m *Module) AuditRules() ([]audit.Rule, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(m.output) == "" {
+		return nil, errors.New("--output directory is required for audit rules")
+	}
+	return AuditRules(params, m.output)
+}
+
+func (m *Module) Hunt(root string) hunt.Report { return Hunt(root) }
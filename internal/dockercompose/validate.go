@@ -0,0 +1,39 @@
+package dockercompose
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"nixpersist/internal/preflight"
+)
+
+// ValidateConfig checks a rendered compose file with "docker compose config"
+// (or "docker-compose config") against a temporary copy.
+func ValidateConfig(cfg string) error {
+	var binary string
+	var args []string
+	if _, err := exec.LookPath("docker"); err == nil && hasCompose() {
+		binary, args = "docker", []string{"compose"}
+	} else if _, err := exec.LookPath("docker-compose"); err == nil {
+		binary = "docker-compose"
+	} else {
+		return preflight.Unavailable("docker compose command not found (tried 'docker compose' and 'docker-compose')")
+	}
+
+	dir, err := os.MkdirTemp("", "nixpersist-compose-")
+	if err != nil {
+		return preflight.Unavailable("stage compose file: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	staged := filepath.Join(dir, DefaultComposeName)
+	if err := os.WriteFile(staged, []byte(cfg), 0644); err != nil {
+		return preflight.Unavailable("stage compose file: %v", err)
+	}
+
+	args = append(args, "-f", staged, "config", "--quiet")
+	cmd := commandRunner(binary, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return preflight.FromCommand(binary+" config", out, err)
+}
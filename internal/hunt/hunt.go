@@ -0,0 +1,200 @@
+// Package hunt holds the findings produced when scanning a host for the
+// persistence techniques NixPersist implements, whoever planted them.
+package hunt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Severity ranks a finding. Higher values are more suspicious.
+type Severity int
+
+const (
+	Info Severity = iota
+	Low
+	Medium
+	High
+)
+
+var severityNames = []string{"info", "low", "medium", "high"}
+
+func (s Severity) String() string {
+	if s < Info || s > High {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalJSON encodes the severity by name.
+func (s Severity) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// ParseSeverity parses a severity name such as "medium".
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return Severity(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown severity %q (want %s)", name, strings.Join(severityNames, ", "))
+}
+
+// Finding is one suspicious artefact.
+type Finding struct {
+	// Technique names the module whose technique class matched.
+	Technique string   `json:"technique"`
+	Severity  Severity `json:"severity"`
+	// Path is the file as seen on the scanned host, or a pseudo path such
+	// as "container:<name>" for runtime state.
+	Path string `json:"path"`
+	// Line is 1-based; 0 when the finding is not tied to a line.
+	Line    int    `json:"line,omitempty"`
+	Summary string `json:"summary"`
+	// Evidence is the matching configuration text.
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// Location returns "path:line", or just the path when Line is 0.
+func (f Finding) Location() string {
+	if f.Line == 0 {
+		return f.Path
+	}
+	return fmt.Sprintf("%s:%d", f.Path, f.Line)
+}
+
+// Report collects findings and notes about what could not be scanned.
+type Report struct {
+	Findings []Finding `json:"findings"`
+	Notes    []string  `json:"notes,omitempty"`
+}
+
+// Merge appends the findings and notes of o. Notes r already holds, such as
+// an unreadable file scanned by two modules, are not repeated.
+func (r *Report) Merge(o Report) {
+	r.Findings = append(r.Findings, o.Findings...)
+	for _, n := range o.Notes {
+		if !slices.Contains(r.Notes, n) {
+			r.Notes = append(r.Notes, n)
+		}
+	}
+}
+
+// Filter drops findings below min.
+func (r *Report) Filter(min Severity) {
+	kept := r.Findings[:0]
+	for _, f := range r.Findings {
+		if f.Severity >= min {
+			kept = append(kept, f)
+		}
+	}
+	r.Findings = kept
+}
+
+// Sort orders findings by descending severity, then location.
+func (r *Report) Sort() {
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		a, b := r.Findings[i], r.Findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+}
+
+// Render returns the findings grouped as one block per finding.
+func (r Report) Render() string {
+	b := &strings.Builder{}
+	if len(r.Findings) == 0 {
+		b.WriteString("no findings\n")
+	}
+	for _, f := range r.Findings {
+		fmt.Fprintf(b, "[%s] %s: %s (%s)\n", strings.ToUpper(f.Severity.String()), f.Location(), f.Summary, f.Technique)
+		if f.Evidence != "" {
+			fmt.Fprintf(b, "    %s\n", f.Evidence)
+		}
+	}
+	if len(r.Notes) > 0 {
+		b.WriteString("\nNotes:\n")
+		for _, n := range r.Notes {
+			fmt.Fprintf(b, "- %s\n", n)
+		}
+	}
+	return b.String()
+}
+
+// HostPath maps a path on the scanned host to the local filesystem under root.
+func HostPath(root, path string) string {
+	return filepath.Join(root, path)
+}
+
+// Glob expands patterns (host paths) under root and returns the matches as
+// host paths, sorted and without duplicates.
+func Glob(root string, patterns ...string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(HostPath(root, p))
+		for _, m := range matches {
+			host := strip(root, m)
+			if !seen[host] {
+				seen[host] = true
+				out = append(out, host)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Walk returns the regular files below the host directory dir, at most depth
+// levels deep, whose base name satisfies match. Symlinks are not followed.
+func Walk(root, dir string, depth int, match func(name string) bool) []string {
+	base := HostPath(root, dir)
+	var out []string
+	_ = filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(base, p)
+		if d.IsDir() {
+			if rel != "." && strings.Count(rel, string(filepath.Separator)) >= depth-1 {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && match(d.Name()) {
+			out = append(out, strip(root, p))
+		}
+		return nil
+	})
+	return out
+}
+
+// ReadLines reads the host file path under root and splits it into lines.
+func ReadLines(root, path string) ([]string, error) {
+	data, err := os.ReadFile(HostPath(root, path))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+func strip(root, p string) string {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return p
+	}
+	return "/" + filepath.ToSlash(rel)
+}
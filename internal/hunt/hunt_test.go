@@ -0,0 +1,51 @@
+package hunt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobAndWalkReturnHostPaths(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"etc/a.conf", "etc/sub/b.conf", "etc/sub/deep/c.conf", "etc/sub/deep/er/d.conf"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if got := Glob(root, "/etc/*.conf", "/etc/a.conf"); !reflect.DeepEqual(got, []string{"/etc/a.conf"}) {
+		t.Fatalf("Glob = %v", got)
+	}
+	got := Walk(root, "/etc", 3, func(string) bool { return true })
+	want := []string{"/etc/a.conf", "/etc/sub/b.conf", "/etc/sub/deep/c.conf"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Walk = %v, want %v", got, want)
+	}
+}
+
+func TestReportFilterAndSort(t *testing.T) {
+	r := Report{Findings: []Finding{
+		{Severity: Low, Path: "/b"},
+		{Severity: High, Path: "/z", Line: 2},
+		{Severity: Info, Path: "/a"},
+		{Severity: High, Path: "/z", Line: 1},
+	}}
+	r.Filter(Low)
+	r.Sort()
+	var got []string
+	for _, f := range r.Findings {
+		got = append(got, f.Location())
+	}
+	if want := []string{"/z:1", "/z:2", "/b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("findings = %v, want %v", got, want)
+	}
+	if _, err := ParseSeverity("critical"); err == nil {
+		t.Fatal("expected unknown severity to be rejected")
+	}
+}
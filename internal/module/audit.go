@@ -0,0 +1,63 @@
+package module
+
+import (
+	"fmt"
+	"os"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/state"
+)
+
+// installWithAudit runs m.Install, first loading the module's auditd rules
+// when enabled so the install itself is captured. Rules for paths the install
+// creates are loaded once it has finished. The loaded rules are returned in
+// Outcome.Audit and recorded in the ledger so remove and cleanup can unload
+// them.
+func installWithAudit(m Module, enabled bool) (Outcome, error) {
+	if !enabled {
+		return m.Install()
+	}
+	rules, err := m.AuditRules()
+	if err != nil {
+		return Outcome{}, err
+	}
+	loaded, deferred, err := audit.Load(rules)
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	res, err := m.Install()
+	if err != nil {
+		if uerr := audit.Unload(loaded); uerr != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to unload audit rules: %v\n", uerr)
+		}
+		return Outcome{}, err
+	}
+
+	more, missing, err := audit.Load(deferred)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	for _, r := range missing {
+		fmt.Fprintf(os.Stderr, "warning: audit rule not loaded, path does not exist: %s\n", r)
+	}
+	loaded = append(loaded, more...)
+	res.Audit = audit.Strings(loaded)
+	res.Message += fmt.Sprintf("; %d auditd rules loaded", len(loaded))
+	return res, nil
+}
+
+// unloadAudit deletes the auditd rules recorded for e and returns them.
+func unloadAudit(e state.Entry) ([]string, error) {
+	if len(e.Audit) == 0 {
+		return nil, nil
+	}
+	rules, err := audit.ParseAll(e.Audit)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.Unload(rules); err != nil {
+		return nil, err
+	}
+	return e.Audit, nil
+}
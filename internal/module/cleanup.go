@@ -0,0 +1,104 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/state"
+)
+
+// CleanupResult reports how reverting a single ledger entry went.
+type CleanupResult struct {
+	Entry   state.Entry
+	Outcome Outcome
+	Err     error
+}
+
+// MarshalJSON reports the entry, its outcome and any error as a string.
+func (r CleanupResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Entry   state.Entry `json:"entry"`
+		OK      bool        `json:"ok"`
+		Error   string      `json:"error,omitempty"`
+		Outcome *Outcome    `json:"outcome,omitempty"`
+	}{Entry: r.Entry, OK: r.Err == nil}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	} else {
+		out.Outcome = &r.Outcome
+	}
+	return json.Marshal(out)
+}
+
+// Render returns a one-line summary followed by the entry's artefacts.
+func (r CleanupResult) Render() string {
+	status := "reverted"
+	if r.Err != nil {
+		status = "FAILED: " + r.Err.Error()
+	}
+	s := fmt.Sprintf("- [%d] %s: %s\n", r.Entry.ID, r.Entry.Module, status)
+	for _, f := range r.Entry.Files {
+		s += fmt.Sprintf("    file: %s\n", f.Path)
+	}
+	for _, a := range r.Entry.AppArmor {
+		s += fmt.Sprintf("    apparmor: %s\n", a)
+	}
+	for _, a := range r.Entry.Audit {
+		s += fmt.Sprintf("    audit: %s\n", a)
+	}
+	return s
+}
+
+// Cleanup reverts entries newest first by replaying each module's Remove with
+// the parameters recorded at install time. Successful entries are marked
+// removed in ledger; the caller is responsible for saving it.
+func Cleanup(reg *Registry, ledger *state.Ledger, entries []state.Entry) []CleanupResult {
+	results := make([]CleanupResult, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		res := CleanupResult{Entry: e}
+		m, ok := reg.Lookup(e.Module)
+		if !ok {
+			res.Err = fmt.Errorf("unknown module %q", e.Module)
+			results = append(results, res)
+			continue
+		}
+		if err := bindParams(m, e.Params); err != nil {
+			res.Err = err
+			results = append(results, res)
+			continue
+		}
+		res.Outcome, res.Err = m.Remove()
+		if res.Err == nil {
+			ledger.MarkRemoved(e.ID, time.Now())
+			unloaded, err := unloadAudit(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: [%d] %s: failed to unload audit rules: %v\n", e.ID, e.Module, err)
+			}
+			res.Outcome.Audit = unloaded
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// bindParams registers m's flags on a fresh flag set and restores the values
+// recorded in the ledger, which marks them set; the others keep their
+// defaults.
+func bindParams(m Module, params map[string]string) error {
+	fs := pflag.NewFlagSet("nixpersist "+m.Name(), pflag.ContinueOnError)
+	m.Flags(fs)
+	for name, value := range params {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("recorded parameter --%s is not supported by %s", name, m.Name())
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("restore --%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package module
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/state"
+)
+
+type failingModule struct{ fakeModule }
+
+func (f *failingModule) Flags(fs *pflag.FlagSet) {}
+func (f *failingModule) Remove() (Outcome, error) {
+	return Outcome{}, errors.New("boom")
+}
+
+func TestCleanupReplaysParams(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+
+	fake := &fakeModule{name: "fake"}
+	reg := NewRegistry()
+	reg.Register(fake)
+	reg.Register(&failingModule{fakeModule{name: "broken"}})
+
+	ledger, err := state.Load()
+	if err != nil {
+		t.Fatalf("load ledger: %v", err)
+	}
+	ledger.Add(state.Entry{Module: "fake", Params: map[string]string{"payload": "/opt/recorded"}})
+	ledger.Add(state.Entry{Module: "broken"})
+	ledger.Add(state.Entry{Module: "gone"})
+
+	results := Cleanup(reg, ledger, ledger.Active())
+	if len(results) != 3 {
+		t.Fatalf("expected three results, got %d", len(results))
+	}
+	// Newest entries are reverted first.
+	if results[0].Entry.Module != "gone" || results[0].Err == nil {
+		t.Fatalf("expected unknown module failure first, got %+v", results[0])
+	}
+	if results[1].Entry.Module != "broken" || results[1].Err == nil {
+		t.Fatalf("expected broken module failure, got %+v", results[1])
+	}
+	if results[2].Err != nil {
+		t.Fatalf("expected fake module cleanup to succeed: %v", results[2].Err)
+	}
+	if fake.payload != "/opt/recorded" {
+		t.Fatalf("recorded params not restored, payload = %q", fake.payload)
+	}
+	if !strings.Contains(results[1].Render(), "FAILED: boom") {
+		t.Fatalf("unexpected render: %s", results[1].Render())
+	}
+
+	active := ledger.Active()
+	if len(active) != 2 {
+		t.Fatalf("expected only successful entry removed, got %+v", active)
+	}
+}
+
+// trackingModule records which of its flags Remove sees as set.
+type trackingModule struct {
+	fakeModule
+	form    string
+	fs      *pflag.FlagSet
+	changed []string
+}
+
+func (m *trackingModule) Flags(fs *pflag.FlagSet) {
+	m.fakeModule.Flags(fs)
+	fs.StringVar(&m.form, "form", "auto", "form")
+	m.fs = fs
+}
+
+func (m *trackingModule) Remove() (Outcome, error) {
+	m.changed = nil
+	m.fs.Visit(func(f *pflag.Flag) { m.changed = append(m.changed, f.Name) })
+	return Outcome{}, nil
+}
+
+func TestCleanupReplaysOnlySetFlags(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+
+	m := &trackingModule{fakeModule: fakeModule{name: "tracked"}}
+	reg := NewRegistry()
+	reg.Register(m)
+	if err := Run(m, []string{"--install", "-p", "/bin/x"}, &bytes.Buffer{}, FormatText); err != nil {
+		t.Fatalf("install returned error: %v", err)
+	}
+	ledger, err := state.Load()
+	if err != nil {
+		t.Fatalf("load ledger: %v", err)
+	}
+	if params := ledger.Active()[0].Params; len(params) != 1 || params["payload"] != "/bin/x" {
+		t.Fatalf("recorded params %v, want only payload", params)
+	}
+
+	results := Cleanup(reg, ledger, ledger.Active())
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("cleanup: %+v", results)
+	}
+	if got := strings.Join(m.changed, ","); got != "payload" || m.form != "auto" {
+		t.Fatalf("remove saw %q set and form %q, want only payload and the default form", got, m.form)
+	}
+}
+
+func TestCleanupRejectsUnknownParam(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&fakeModule{name: "fake"})
+	ledger := &state.Ledger{}
+	ledger.Add(state.Entry{Module: "fake", Params: map[string]string{"removed-flag": "x"}})
+
+	results := Cleanup(reg, ledger, ledger.Active())
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected unknown parameter error, got %+v", results)
+	}
+}
@@ -0,0 +1,114 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/sigma"
+)
+
+// detectionFormats lists the rule formats accepted by --format.
+var detectionFormats = []string{"sigma", "auditd"}
+
+// now is replaced in tests to pin rule dates.
+var now = time.Now
+
+// RunDetections implements "nixpersist detections": it binds the selected
+// module's own flags so the rules describe exactly the artefacts that
+// "--install" with the same flags would plant. --format selects Sigma rules
+// or an auditd rules file.
+func RunDetections(reg *Registry, args []string, out io.Writer, format Format) error {
+	// The module is needed before its flags can be registered, so look for
+	// --module first and ignore everything else.
+	var scanned string
+	scan := pflag.NewFlagSet("nixpersist detections", pflag.ContinueOnError)
+	scan.SetOutput(io.Discard)
+	scan.ParseErrorsWhitelist.UnknownFlags = true
+	scan.StringVarP(&scanned, "module", "m", "", "")
+	scan.BoolP("help", "h", false, "")
+	_ = scan.Parse(args)
+
+	fs := pflag.NewFlagSet("nixpersist detections", pflag.ContinueOnError)
+	fs.SortFlags = false
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nixpersist detections --module <name> [--format sigma|auditd] [module flags]")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Modules:")
+		for _, m := range reg.Modules() {
+			fmt.Fprintf(fs.Output(), "  %s\n", m.Name())
+		}
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Flags:")
+		fs.PrintDefaults()
+	}
+	var name string
+	fs.StringVarP(&name, "module", "m", "", "module whose artefacts the rules should match")
+	ruleFormat := fs.String("format", "sigma", "rule format: "+strings.Join(detectionFormats, ", "))
+
+	m, ok := reg.Lookup(scanned)
+	if ok {
+		m.Flags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return reportDetections(out, format, name, nil, &UsageError{Err: err})
+	}
+	switch {
+	case name == "":
+		if format == FormatText {
+			fs.Usage()
+		}
+		return reportDetections(out, format, name, nil, &UsageError{Err: errors.New("--module is required")})
+	case !ok:
+		return reportDetections(out, format, name, nil, &UsageError{Err: fmt.Errorf("unknown module %q", name)})
+	case fs.NArg() > 0:
+		return reportDetections(out, format, name, nil, &UsageError{Err: fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), ", "))})
+	case *ruleFormat != "sigma" && *ruleFormat != "auditd":
+		return reportDetections(out, format, name, nil, &UsageError{Err: fmt.Errorf("unsupported rule format %q (want %s)", *ruleFormat, strings.Join(detectionFormats, ", "))})
+	}
+
+	if *ruleFormat == "auditd" {
+		rules, err := m.AuditRules()
+		if err != nil {
+			return reportDetections(out, format, name, nil, err)
+		}
+		if format == FormatJSON {
+			return reportDetections(out, format, name, audit.Strings(rules), nil)
+		}
+		fmt.Fprint(out, audit.Render(name, rules))
+		return nil
+	}
+
+	rules, err := m.Detections()
+	if err != nil {
+		return reportDetections(out, format, name, nil, err)
+	}
+	date := now().Format("2006-01-02")
+	for i := range rules {
+		rules[i] = rules[i].Normalize(date)
+	}
+	if format == FormatJSON {
+		return reportDetections(out, format, name, rules, nil)
+	}
+	fmt.Fprint(out, sigma.Render(rules))
+	return nil
+}
+
+func reportDetections(out io.Writer, format Format, name string, result any, err error) error {
+	if format != FormatJSON {
+		return err
+	}
+	if werr := WriteJSON(out, name, "detections", result, err); werr != nil && err == nil {
+		return werr
+	}
+	return err
+}
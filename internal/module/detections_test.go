@@ -0,0 +1,62 @@
+package module
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunDetectionsBindsModuleFlags(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now })
+
+	fake := &fakeModule{name: "fake"}
+	reg := NewRegistry()
+	reg.Register(fake)
+
+	var out bytes.Buffer
+	if err := RunDetections(reg, []string{"-p", "/opt/payload", "--module", "fake", "--format", "sigma"}, &out, FormatText); err != nil {
+		t.Fatalf("RunDetections returned error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"title: Fake payload executed\n", "Image: '/opt/payload'\n", "date: '2024-01-02'\n", "author: NixPersist\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRunDetectionsUsageErrors(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&fakeModule{name: "fake"})
+
+	for _, args := range [][]string{
+		nil,
+		{"--module", "missing"},
+		{"--module", "fake", "-p", "/x", "--format", "yara"},
+	} {
+		err := RunDetections(reg, args, &bytes.Buffer{}, FormatText)
+		if ExitCode(err) != ExitUsage {
+			t.Fatalf("RunDetections(%v) = %v, want usage error", args, err)
+		}
+	}
+}
+
+func TestRunHuntSortsAndFilters(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&fakeModule{name: "fake"})
+
+	var out bytes.Buffer
+	if err := RunHunt(reg, []string{"--min-severity", "medium"}, &out, FormatText); err != nil {
+		t.Fatalf("RunHunt returned error: %v", err)
+	}
+	if got, want := out.String(), "[HIGH] /etc/fake.conf:1: fake high (fake)\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	err := RunHunt(reg, []string{"--module", "missing"}, &bytes.Buffer{}, FormatText)
+	if ExitCode(err) != ExitUsage {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
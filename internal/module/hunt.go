@@ -0,0 +1,81 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/hunt"
+)
+
+// RunHunt implements "nixpersist hunt": every module (or those selected with
+// --module) scans the host for its technique class and the findings are
+// reported together, most severe first.
+func RunHunt(reg *Registry, args []string, out io.Writer, format Format) error {
+	fs := pflag.NewFlagSet("nixpersist hunt", pflag.ContinueOnError)
+	fs.SortFlags = false
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nixpersist hunt [--module name]... [--root dir] [--min-severity level]")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Flags:")
+		fs.PrintDefaults()
+	}
+	names := fs.StringSliceP("module", "m", nil, "only run the scanners of these modules (default all)")
+	root := fs.String("root", "/", "scan a host filesystem mounted at this directory")
+	minLevel := fs.String("min-severity", "low", "hide findings below this severity: info, low, medium or high")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return reportHunt(out, format, nil, &UsageError{Err: err})
+	}
+	if fs.NArg() > 0 {
+		return reportHunt(out, format, nil, &UsageError{Err: fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), ", "))})
+	}
+	min, err := hunt.ParseSeverity(*minLevel)
+	if err != nil {
+		return reportHunt(out, format, nil, &UsageError{Err: err})
+	}
+
+	modules := reg.Modules()
+	if len(*names) > 0 {
+		modules = modules[:0:0]
+		for _, name := range *names {
+			m, ok := reg.Lookup(name)
+			if !ok {
+				return reportHunt(out, format, nil, &UsageError{Err: fmt.Errorf("unknown module %q", name)})
+			}
+			modules = append(modules, m)
+		}
+	}
+
+	var report hunt.Report
+	for _, m := range modules {
+		report.Merge(m.Hunt(*root))
+	}
+	report.Filter(min)
+	report.Sort()
+	if report.Findings == nil {
+		report.Findings = []hunt.Finding{}
+	}
+
+	if format == FormatJSON {
+		return reportHunt(out, format, report, nil)
+	}
+	fmt.Fprint(out, report.Render())
+	return nil
+}
+
+func reportHunt(out io.Writer, format Format, result any, err error) error {
+	if format != FormatJSON {
+		return err
+	}
+	if werr := WriteJSON(out, "", "hunt", result, err); werr != nil && err == nil {
+		return werr
+	}
+	return err
+}
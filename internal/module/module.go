@@ -0,0 +1,73 @@
+// Package module defines the contract shared by every NixPersist persistence
+// technique and the generic command-line runner that drives them.
+package module
+
+import (
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+// Report is the diagnostic output produced by a module's Check.
+type Report interface {
+	Render() string
+}
+
+// Module is a persistence technique exposed as a nixpersist subcommand.
+// Implementations bind their flags in Flags and read the parsed values back
+// when one of the action methods is invoked.
+type Module interface {
+	// Name is the subcommand used on the command line (e.g. "rsyslog").
+	Name() string
+	// Describe returns a one-line summary for the main menu.
+	Describe() string
+	// Flags registers the module-specific flags on fs.
+	Flags(fs *pflag.FlagSet)
+	// Check inspects the host for prerequisites.
+	Check() (Report, error)
+	// Render returns the output printed when no action flag is given,
+	// typically the rendered configuration.
+	Render() (string, error)
+	// Plan reports what Install would change without touching the host.
+	Plan() (Plan, error)
+	// Install plants the persistence artefact.
+	Install() (Outcome, error)
+	// Remove reverts Install.
+	Remove() (Outcome, error)
+	// Detections returns Sigma rules matching the artefacts Install would
+	// plant with the current flags.
+	Detections() ([]sigma.Rule, error)
+	// AuditRules returns auditd rules covering the files and processes the
+	// technique touches with the current flags.
+	AuditRules() ([]audit.Rule, error)
+	// Hunt scans the host filesystem mounted at root for instances of the
+	// technique, whoever planted them. It ignores the module's flags.
+	Hunt(root string) hunt.Report
+}
+
+// Outcome describes what an Install or Remove changed on the host. The runner
+// prints Message and records the rest in the install ledger.
+type Outcome struct {
+	Message  string             `json:"message"`
+	Files    []state.FileChange `json:"files,omitempty"`
+	Services []string           `json:"services,omitempty"`
+	AppArmor []string           `json:"apparmor,omitempty"`
+	Audit    []string           `json:"audit,omitempty"`
+	// Instance names the install among several of the same module; the
+	// ledger matches a remove to its install by it.
+	Instance string `json:"instance,omitempty"`
+	// Verification is set when Install fired the trigger to prove it works.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Paths returns the paths of every file in the outcome.
+func (o Outcome) Paths() []string {
+	paths := make([]string, 0, len(o.Files))
+	for _, f := range o.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
@@ -0,0 +1,86 @@
+package module
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"nixpersist/internal/preflight"
+)
+
+// Format selects how command results are written to stdout.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat validates a --output value.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatText, FormatJSON:
+		return Format(s), nil
+	}
+	return "", &UsageError{Err: fmt.Errorf("unsupported output format %q (want text or json)", s)}
+}
+
+// Process exit codes, also reported as exit_code in JSON output.
+const (
+	ExitOK         = 0
+	ExitFailure    = 1
+	ExitUsage      = 2
+	ExitValidation = 3
+)
+
+// UsageError marks errors caused by invalid command-line usage.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// ExitCode maps an error returned by a command to a process exit code.
+func ExitCode(err error) int {
+	var usage *UsageError
+	var failed *preflight.FailedError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.As(err, &failed):
+		return ExitValidation
+	default:
+		return ExitFailure
+	}
+}
+
+// Envelope is the JSON document written for every command in JSON mode.
+type Envelope struct {
+	Module   string `json:"module,omitempty"`
+	Action   string `json:"action"`
+	OK       bool   `json:"ok"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	Result   any    `json:"result,omitempty"`
+}
+
+// WriteJSON writes a single envelope for the given command outcome.
+func WriteJSON(out io.Writer, module, action string, result any, err error) error {
+	env := Envelope{
+		Module:   module,
+		Action:   action,
+		OK:       err == nil,
+		ExitCode: ExitCode(err),
+		Result:   result,
+	}
+	if err != nil {
+		env.Error = err.Error()
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(env)
+}
@@ -0,0 +1,76 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nixpersist/internal/diff"
+)
+
+// FileEdit is a file Install would create or modify.
+type FileEdit struct {
+	Path string
+	// Before is the current content; nil when the file does not exist.
+	Before []byte
+	After  []byte
+}
+
+// MarshalJSON reports the edit as a unified diff plus the resulting content.
+func (f FileEdit) MarshalJSON() ([]byte, error) {
+	from := "a" + f.Path
+	if f.Before == nil {
+		from = "/dev/null"
+	}
+	return json.Marshal(struct {
+		Path    string `json:"path"`
+		Exists  bool   `json:"exists"`
+		Diff    string `json:"diff"`
+		Content string `json:"content"`
+	}{f.Path, f.Before != nil, diff.Unified(from, "b"+f.Path, f.Before, f.After), string(f.After)})
+}
+
+// Plan describes what Install would do without touching the host.
+type Plan struct {
+	Files []FileEdit `json:"files"`
+	// Commands are the external commands Install would execute, in order.
+	Commands []string `json:"commands"`
+	Notes    []string `json:"notes,omitempty"`
+}
+
+// Render returns unified diffs for every file followed by the command list.
+func (p Plan) Render() string {
+	b := &strings.Builder{}
+	b.WriteString("Files:\n")
+	if len(p.Files) == 0 {
+		b.WriteString("- none\n")
+	}
+	for _, f := range p.Files {
+		from := "a" + f.Path
+		if f.Before == nil {
+			from = "/dev/null"
+		}
+		d := diff.Unified(from, "b"+f.Path, f.Before, f.After)
+		if d == "" {
+			fmt.Fprintf(b, "- %s: unchanged\n", f.Path)
+			continue
+		}
+		b.WriteString(d)
+	}
+
+	b.WriteString("\nCommands:\n")
+	if len(p.Commands) == 0 {
+		b.WriteString("- none\n")
+	}
+	for i, c := range p.Commands {
+		fmt.Fprintf(b, "%d. %s\n", i+1, c)
+	}
+
+	if len(p.Notes) > 0 {
+		b.WriteString("\nNotes:\n")
+		for _, n := range p.Notes {
+			fmt.Fprintf(b, "- %s\n", n)
+		}
+	}
+	return b.String()
+}
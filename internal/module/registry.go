@@ -0,0 +1,42 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Registry holds the modules available to the CLI, keyed by name.
+type Registry struct {
+	byName map[string]Module
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]Module)}
+}
+
+// Register adds m to the registry. It panics if a module with the same name
+// is already registered, as that is a programming error.
+func (r *Registry) Register(m Module) {
+	name := m.Name()
+	if _, dup := r.byName[name]; dup {
+		panic(fmt.Sprintf("module %q registered twice", name))
+	}
+	r.byName[name] = m
+}
+
+// Lookup returns the module registered under name.
+func (r *Registry) Lookup(name string) (Module, bool) {
+	m, ok := r.byName[name]
+	return m, ok
+}
+
+// Modules returns every registered module sorted by name.
+func (r *Registry) Modules() []Module {
+	out := make([]Module, 0, len(r.byName))
+	for _, m := range r.byName {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
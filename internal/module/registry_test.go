@@ -0,0 +1,259 @@
+package module
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+type fakeReport string
+
+func (r fakeReport) Render() string { return string(r) }
+
+type fakeModule struct {
+	name    string
+	payload string
+	calls   []string
+}
+
+func (f *fakeModule) Name() string     { return f.name }
+func (f *fakeModule) Describe() string { return "fake " + f.name }
+func (f *fakeModule) Flags(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.payload, "payload", "p", "", "payload")
+}
+func (f *fakeModule) Check() (Report, error) {
+	f.calls = append(f.calls, "check")
+	return fakeReport("checked\n"), nil
+}
+func (f *fakeModule) Render() (string, error) {
+	f.calls = append(f.calls, "render")
+	return "rendered " + f.payload + "\n", nil
+}
+func (f *fakeModule) Plan() (Plan, error) {
+	f.calls = append(f.calls, "plan")
+	return Plan{
+		Files:    []FileEdit{{Path: "/etc/fake.conf", After: []byte(f.payload + "\n")}},
+		Commands: []string{"systemctl reload fake"},
+	}, nil
+}
+func (f *fakeModule) Install() (Outcome, error) {
+	f.calls = append(f.calls, "install")
+	if f.payload == "" {
+		return Outcome{}, errors.New("payload required")
+	}
+	return Outcome{
+		Message: "installed " + f.payload,
+		Files:   []state.FileChange{{Path: "/etc/fake.conf", Action: state.FileCreated}},
+	}, nil
+}
+func (f *fakeModule) Remove() (Outcome, error) {
+	f.calls = append(f.calls, "remove")
+	return Outcome{
+		Message: "removed",
+		Files:   []state.FileChange{{Path: "/etc/fake.conf", Action: state.FileDeleted}},
+	}, nil
+}
+func (f *fakeModule) Detections() ([]sigma.Rule, error) {
+	f.calls = append(f.calls, "detections")
+	if f.payload == "" {
+		return nil, errors.New("payload required")
+	}
+	return []sigma.Rule{{
+		Title:     "Fake payload executed",
+		LogSource: sigma.LogSource{Product: "linux", Category: "process_creation"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{{Name: "selection", Fields: []sigma.Field{{Name: "Image", Values: []string{f.payload}}}}},
+			Condition:  "selection",
+		},
+		Level: "high",
+	}}, nil
+}
+
+func (f *fakeModule) AuditRules() ([]audit.Rule, error) {
+	f.calls = append(f.calls, "audit")
+	return []audit.Rule{audit.Watch("/etc/fake.conf", "wa", audit.KeyPrefix+f.name)}, nil
+}
+
+func (f *fakeModule) Hunt(root string) hunt.Report {
+	f.calls = append(f.calls, "hunt")
+	return hunt.Report{Findings: []hunt.Finding{
+		{Technique: f.name, Severity: hunt.Low, Path: "/etc/fake.conf", Line: 3, Summary: "fake low"},
+		{Technique: f.name, Severity: hunt.High, Path: "/etc/fake.conf", Line: 1, Summary: "fake high"},
+	}}
+}
+
+func TestRegistryModulesSorted(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&fakeModule{name: "zeta"})
+	reg.Register(&fakeModule{name: "alpha"})
+
+	mods := reg.Modules()
+	if len(mods) != 2 || mods[0].Name() != "alpha" || mods[1].Name() != "zeta" {
+		t.Fatalf("unexpected module order: %v", mods)
+	}
+	if _, ok := reg.Lookup("alpha"); !ok {
+		t.Fatal("expected alpha to be registered")
+	}
+	if _, ok := reg.Lookup("missing"); ok {
+		t.Fatal("expected missing module lookup to fail")
+	}
+}
+
+func TestRegistryDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	reg := NewRegistry()
+	reg.Register(&fakeModule{name: "dup"})
+	reg.Register(&fakeModule{name: "dup"})
+}
+
+func TestRunDispatch(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	tests := []struct {
+		args []string
+		call string
+		want string
+	}{
+		{[]string{"--check"}, "check", "checked\n"},
+		{[]string{"-p", "/bin/x"}, "render", "rendered /bin/x\n"},
+		{[]string{"--install", "-p", "/bin/x"}, "install", "installed /bin/x\n"},
+		{[]string{"--remove"}, "remove", "removed\n"},
+		{[]string{"--plan", "-p", "/bin/x"}, "plan", "Files:\n--- /dev/null\n+++ b/etc/fake.conf\n@@ -0,0 +1 @@\n+/bin/x\n\nCommands:\n1. systemctl reload fake\n"},
+		{[]string{"--dry-run", "-p", "/bin/x"}, "plan", "Files:\n--- /dev/null\n+++ b/etc/fake.conf\n@@ -0,0 +1 @@\n+/bin/x\n\nCommands:\n1. systemctl reload fake\n"},
+	}
+	for _, tc := range tests {
+		m := &fakeModule{name: "fake"}
+		var out bytes.Buffer
+		if err := Run(m, tc.args, &out, FormatText); err != nil {
+			t.Fatalf("Run(%v) returned error: %v", tc.args, err)
+		}
+		if len(m.calls) != 1 || m.calls[0] != tc.call {
+			t.Fatalf("Run(%v) calls = %v, want [%s]", tc.args, m.calls, tc.call)
+		}
+		if out.String() != tc.want {
+			t.Fatalf("Run(%v) output = %q, want %q", tc.args, out.String(), tc.want)
+		}
+	}
+}
+
+func TestRunRejectsMultipleActions(t *testing.T) {
+	m := &fakeModule{name: "fake"}
+	err := Run(m, []string{"--install", "--remove"}, &bytes.Buffer{}, FormatText)
+	if err == nil || !strings.Contains(err.Error(), "at most one") {
+		t.Fatalf("expected multiple-action error, got %v", err)
+	}
+	if len(m.calls) != 0 {
+		t.Fatalf("expected no actions to run, got %v", m.calls)
+	}
+}
+
+func TestRunNoFlagsPrintsUsage(t *testing.T) {
+	m := &fakeModule{name: "fake"}
+	var out bytes.Buffer
+	if err := Run(m, nil, &out, FormatText); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Usage: nixpersist fake") {
+		t.Fatalf("expected usage output, got %q", out.String())
+	}
+	if len(m.calls) != 0 {
+		t.Fatalf("expected no actions to run, got %v", m.calls)
+	}
+}
+
+func TestRunRecordsLedger(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+
+	m := &fakeModule{name: "fake"}
+	if err := Run(m, []string{"--install", "-p", "/bin/x"}, &bytes.Buffer{}, FormatText); err != nil {
+		t.Fatalf("install returned error: %v", err)
+	}
+	ledger, err := state.Load()
+	if err != nil {
+		t.Fatalf("load ledger: %v", err)
+	}
+	active := ledger.Active()
+	if len(active) != 1 {
+		t.Fatalf("expected one active entry, got %+v", ledger.Entries)
+	}
+	if active[0].Module != "fake" || active[0].Params["payload"] != "/bin/x" {
+		t.Fatalf("unexpected entry: %+v", active[0])
+	}
+	if _, ok := active[0].Params["install"]; ok {
+		t.Fatalf("action flags must not be recorded: %+v", active[0].Params)
+	}
+
+	if err := Run(m, []string{"--remove"}, &bytes.Buffer{}, FormatText); err != nil {
+		t.Fatalf("remove returned error: %v", err)
+	}
+	ledger, err = state.Load()
+	if err != nil {
+		t.Fatalf("load ledger: %v", err)
+	}
+	if len(ledger.Active()) != 0 {
+		t.Fatalf("expected entry to be marked removed, got %+v", ledger.Entries)
+	}
+}
+
+func TestRunJSONEnvelope(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+
+	m := &fakeModule{name: "fake"}
+	var out bytes.Buffer
+	if err := Run(m, []string{"--install", "-p", "/bin/x"}, &out, FormatJSON); err != nil {
+		t.Fatalf("install returned error: %v", err)
+	}
+	var env struct {
+		Module   string
+		Action   string
+		OK       bool
+		ExitCode int `json:"exit_code"`
+		Result   Outcome
+	}
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	if env.Module != "fake" || env.Action != "install" || !env.OK || env.ExitCode != ExitOK {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+	if len(env.Result.Files) != 1 || env.Result.Files[0].Path != "/etc/fake.conf" {
+		t.Fatalf("unexpected result: %+v", env.Result)
+	}
+
+	out.Reset()
+	err := Run(m, []string{"--bogus"}, &out, FormatJSON)
+	if ExitCode(err) != ExitUsage {
+		t.Fatalf("ExitCode(%v) = %d, want %d", err, ExitCode(err), ExitUsage)
+	}
+	if !strings.Contains(out.String(), `"exit_code": 2`) {
+		t.Fatalf("expected usage error envelope, got %q", out.String())
+	}
+}
+
+func TestRunInstallAuditRequiresInstall(t *testing.T) {
+	m := &fakeModule{name: "fake"}
+	err := Run(m, []string{"--check", "--install-audit"}, &bytes.Buffer{}, FormatText)
+	if ExitCode(err) != ExitUsage {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run(m, []string{"--plan", "--install-audit", "-p", "/bin/x"}, &out, FormatText); err != nil {
+		t.Fatalf("plan returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), "1. auditctl -w /etc/fake.conf -p wa -k nixpersist-fake\n2. systemctl reload fake\n") {
+		t.Fatalf("expected auditctl commands before install commands, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,189 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/audit"
+	"nixpersist/internal/state"
+)
+
+// actionFlags are the runner-owned flags excluded from recorded parameters.
+var actionFlags = map[string]bool{"check": true, "plan": true, "dry-run": true, "install": true, "install-audit": true, "remove": true}
+
+// Run parses args for m, dispatches to the selected action, and writes the
+// result to out in the requested format. Help output and usage also go to out.
+// In JSON mode the result envelope is written even when the action fails.
+func Run(m Module, args []string, out io.Writer, format Format) error {
+	action, result, text, err := run(m, args, out)
+	if format == FormatJSON && action != "" {
+		if werr := WriteJSON(out, m.Name(), action, result, err); werr != nil && err == nil {
+			err = werr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, text)
+	return nil
+}
+
+// run executes the action selected by args. It returns the action name (empty
+// when only usage was printed), the structured result, and its text form.
+func run(m Module, args []string, out io.Writer) (string, any, string, error) {
+	fs := pflag.NewFlagSet("nixpersist "+m.Name(), pflag.ContinueOnError)
+	fs.SortFlags = false
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: nixpersist %s [--check|--plan|--install|--remove] [flags]\n", m.Name())
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Flags:")
+		fs.PrintDefaults()
+	}
+
+	doCheck := fs.Bool("check", false, "check system feasibility and exit")
+	var doPlan bool
+	fs.BoolVar(&doPlan, "plan", false, "show the diffs and commands --install would apply, without changing anything")
+	fs.BoolVar(&doPlan, "dry-run", false, "alias for --plan")
+	doInstall := fs.Bool("install", false, "install the persistence artefact")
+	doRemove := fs.Bool("remove", false, "remove the persistence artefact")
+	installAudit := fs.Bool("install-audit", false, "load auditd rules for this technique via auditctl before --install; unloaded by --remove and cleanup")
+	m.Flags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return "", nil, "", nil
+		}
+		return "parse", nil, "", &UsageError{Err: err}
+	}
+
+	if fs.NArg() > 0 {
+		return "parse", nil, "", &UsageError{Err: fmt.Errorf("unexpected arguments for %s module: %s", m.Name(), strings.Join(fs.Args(), ", "))}
+	}
+
+	if fs.NFlag() == 0 {
+		fs.Usage()
+		return "", nil, "", nil
+	}
+
+	actions := 0
+	for _, set := range []bool{*doCheck, doPlan, *doInstall, *doRemove} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return "parse", nil, "", &UsageError{Err: errors.New("choose at most one of --check, --plan, --install, or --remove")}
+	}
+	if *installAudit && !*doInstall && !doPlan {
+		return "parse", nil, "", &UsageError{Err: errors.New("--install-audit requires --install or --plan")}
+	}
+
+	switch {
+	case *doCheck:
+		res, err := m.Check()
+		if err != nil {
+			return "check", nil, "", err
+		}
+		return "check", res, res.Render(), nil
+	case doPlan:
+		plan, err := m.Plan()
+		if err != nil {
+			return "plan", nil, "", err
+		}
+		if *installAudit {
+			rules, err := m.AuditRules()
+			if err != nil {
+				return "plan", nil, "", err
+			}
+			plan.Commands = append(audit.Plan(rules), plan.Commands...)
+			plan.Notes = append(plan.Notes, "--install-audit: rules for paths the install creates are loaded after it completes")
+		}
+		return "plan", plan, plan.Render(), nil
+	case *doInstall:
+		res, err := installWithAudit(m, *installAudit)
+		if err != nil {
+			return "install", nil, "", err
+		}
+		if err := recordInstall(m.Name(), fs, res); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to record install in ledger: %v\n", err)
+		}
+		return "install", res, res.Message + "\n", nil
+	case *doRemove:
+		res, err := m.Remove()
+		if err != nil {
+			return "remove", nil, "", err
+		}
+		entry, err := recordRemove(m.Name(), res)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to update ledger: %v\n", err)
+		}
+		res.Audit, err = unloadAudit(entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to unload audit rules: %v\n", err)
+		}
+		if len(res.Audit) > 0 {
+			res.Message += fmt.Sprintf("; %d auditd rules unloaded", len(res.Audit))
+		}
+		return "remove", res, res.Message + "\n", nil
+	default:
+		rendered, err := m.Render()
+		if err != nil {
+			return "render", nil, "", err
+		}
+		return "render", map[string]string{"config": rendered}, rendered, nil
+	}
+}
+
+// Params returns the values of the module flags set on the command line,
+// keyed by flag name. Flags left at their defaults are not recorded, so a
+// replayed remove resolves them as a hand-run one would.
+func Params(fs *pflag.FlagSet) map[string]string {
+	params := make(map[string]string)
+	fs.Visit(func(f *pflag.Flag) {
+		if actionFlags[f.Name] {
+			return
+		}
+		params[f.Name] = f.Value.String()
+	})
+	return params
+}
+
+func recordInstall(name string, fs *pflag.FlagSet, res Outcome) error {
+	ledger, err := state.Load()
+	if err != nil {
+		return err
+	}
+	ledger.Add(state.Entry{
+		Module:   name,
+		Instance: res.Instance,
+		Params:   Params(fs),
+		Files:    res.Files,
+		Services: res.Services,
+		AppArmor: res.AppArmor,
+		Audit:    res.Audit,
+	})
+	return ledger.Save()
+}
+
+// recordRemove marks the matching ledger entry removed and returns it, or a
+// zero Entry when none matches.
+func recordRemove(name string, res Outcome) (state.Entry, error) {
+	ledger, err := state.Load()
+	if err != nil {
+		return state.Entry{}, err
+	}
+	entry, ok := ledger.FindActive(name, res.Instance, res.Paths())
+	if !ok {
+		return state.Entry{}, nil
+	}
+	ledger.MarkRemoved(entry.ID, time.Now())
+	return entry, ledger.Save()
+}
@@ -0,0 +1,39 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Verification is the evidence that an installed trigger executed its
+// payload, gathered by firing the trigger after Install.
+type Verification struct {
+	// Fired describes how the trigger was fired.
+	Fired    string `json:"fired"`
+	Executed bool   `json:"executed"`
+	// Latency is the time from firing the trigger to the payload starting.
+	Latency time.Duration `json:"latency_ns,omitempty"`
+	// ExitStatus is nil when the payload had not exited by the timeout.
+	ExitStatus *int `json:"exit_status,omitempty"`
+	// Lineage is the process ancestry of the payload, nearest first.
+	Lineage []string `json:"lineage,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Summary returns a one-line account for the install message.
+func (v Verification) Summary() string {
+	if !v.Executed {
+		return "trigger verification FAILED: " + v.Error
+	}
+	s := fmt.Sprintf("trigger verified: payload started %s after %s", v.Latency.Round(time.Millisecond), v.Fired)
+	if v.ExitStatus != nil {
+		s += fmt.Sprintf(", exit status %d", *v.ExitStatus)
+	} else {
+		s += ", still running at the timeout"
+	}
+	if len(v.Lineage) > 0 {
+		s += ", lineage " + strings.Join(v.Lineage, " <- ")
+	}
+	return s
+}
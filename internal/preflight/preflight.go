@@ -0,0 +1,73 @@
+// Package preflight classifies the results of running a daemon's own config
+// validator (rsyslogd -N1, apachectl -t, docker compose config) so that
+// modules can report them in --check and gate --install on them.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ErrUnavailable reports that the validator could not be run at all, for
+// example because the binary is missing.
+var ErrUnavailable = errors.New("validator unavailable")
+
+// FailedError is returned when the validator ran and rejected the config.
+type FailedError struct {
+	Tool   string
+	Output string
+}
+
+func (e *FailedError) Error() string {
+	if e.Output == "" {
+		return e.Tool + " rejected the configuration"
+	}
+	return fmt.Sprintf("%s rejected the configuration: %s", e.Tool, e.Output)
+}
+
+// Unavailable returns an error wrapping ErrUnavailable with a reason.
+func Unavailable(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrUnavailable, fmt.Sprintf(format, args...))
+}
+
+// FromCommand interprets the combined output and error of a validator run.
+// A non-zero exit is a validation failure; any other error means the
+// validator could not run.
+func FromCommand(tool string, output []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return Unavailable("%s: %v", tool, err)
+	}
+	return &FailedError{Tool: tool, Output: strings.TrimSpace(string(output))}
+}
+
+// Describe returns the status line shown in --check output.
+func Describe(err error) string {
+	switch {
+	case err == nil:
+		return "passed"
+	case errors.Is(err, ErrUnavailable):
+		return "not run (" + err.Error() + ")"
+	default:
+		return "FAILED: " + err.Error()
+	}
+}
+
+// Gate decides whether an install may proceed after validation. A rejected
+// config blocks the install; an unavailable validator only warns on warn.
+func Gate(err error, warn io.Writer) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrUnavailable) {
+		fmt.Fprintf(warn, "warning: config validation could not run (%v); installing without pre-flight validation\n", err)
+		return nil
+	}
+	return fmt.Errorf("pre-flight validation failed: %w", err)
+}
@@ -0,0 +1,55 @@
+package preflight
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestFromCommand(t *testing.T) {
+	if err := FromCommand("tool", nil, nil); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	out, err := exec.Command("sh", "-c", "echo 'syntax error line 3'; exit 1").CombinedOutput()
+	failed := FromCommand("rsyslogd", out, err)
+	var fe *FailedError
+	if !errors.As(failed, &fe) || !strings.Contains(fe.Output, "syntax error line 3") {
+		t.Fatalf("expected FailedError with output, got %v", failed)
+	}
+
+	_, err = exec.Command("/nonexistent/validator").CombinedOutput()
+	if unavailable := FromCommand("validator", nil, err); !errors.Is(unavailable, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", unavailable)
+	}
+}
+
+func TestGate(t *testing.T) {
+	var warn bytes.Buffer
+	if err := Gate(nil, &warn); err != nil || warn.Len() != 0 {
+		t.Fatalf("expected pass without warning, got %v %q", err, warn.String())
+	}
+	if err := Gate(Unavailable("rsyslogd not found"), &warn); err != nil {
+		t.Fatalf("unavailable validator must not block install: %v", err)
+	}
+	if !strings.Contains(warn.String(), "rsyslogd not found") {
+		t.Fatalf("expected warning, got %q", warn.String())
+	}
+	if err := Gate(&FailedError{Tool: "apachectl", Output: "bad"}, &warn); err == nil {
+		t.Fatal("expected failed validation to block install")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	if got := Describe(nil); got != "passed" {
+		t.Fatalf("Describe(nil) = %q", got)
+	}
+	if got := Describe(Unavailable("missing")); !strings.HasPrefix(got, "not run") {
+		t.Fatalf("Describe(unavailable) = %q", got)
+	}
+	if got := Describe(&FailedError{Tool: "x"}); !strings.HasPrefix(got, "FAILED") {
+		t.Fatalf("Describe(failed) = %q", got)
+	}
+}
@@ -1,45 +1,22 @@
 package rsyslog
 
-import (
-	"errors"
-	"fmt"
-	"os"
-	"os/exec"
-)
+import "nixpersist/internal/apparmor"
 
-// DisableRsyslogProfile disables AppArmor's rsyslog profile (Ubuntu/Debian paths)
-// to permit omprog execution. Requires root and is destructive until reboot or
-// re-enabling; callers should present a clear confirmation gate and provide a
-// corresponding re-enable path.
-//
-// Equivalent shell (from PoC.md):
-//
-//	apparmor_parser -R /etc/apparmor.d/usr.sbin.rsyslogd
-//	ln -sf /etc/apparmor.d/usr.sbin.rsyslogd /etc/apparmor.d/disable/
-func DisableRsyslogProfile() error {
-	if _, err := exec.LookPath("apparmor_parser"); err != nil {
-		return errors.New("apparmor_parser not found; is AppArmor installed?")
-	}
-	if err := exec.Command("apparmor_parser", "-R", "/etc/apparmor.d/usr.sbin.rsyslogd").Run(); err != nil {
-		return fmt.Errorf("failed to remove rsyslog AppArmor profile: %w", err)
-	}
-	if err := exec.Command("ln", "-sf", "/etc/apparmor.d/usr.sbin.rsyslogd", "/etc/apparmor.d/disable/").Run(); err != nil {
-		return fmt.Errorf("failed to place profile in disable/: %w", err)
-	}
-	return nil
+// rsyslogProfileName is the AppArmor profile confining rsyslogd on
+// Ubuntu/Debian.
+const rsyslogProfileName = "usr.sbin.rsyslogd"
+
+const rsyslogProfile = apparmor.Profile(rsyslogProfileName)
+
+// RelaxRsyslogProfile puts the rsyslog profile in mode, complain or disabled,
+// to permit omprog and shell execution, after recording its current state.
+// Requires root; RestoreRsyslogProfile undoes it.
+func RelaxRsyslogProfile(mode apparmor.Mode) (apparmor.Record, error) {
+	return apparmor.Relax(rsyslogProfile, mode)
 }
 
-// EnableRsyslogProfile restores the rsyslog AppArmor profile after a disable.
-// Requires root privileges.
-func EnableRsyslogProfile() error {
-	if _, err := exec.LookPath("apparmor_parser"); err != nil {
-		return errors.New("apparmor_parser not found; is AppArmor installed?")
-	}
-	if err := os.Remove("/etc/apparmor.d/disable/usr.sbin.rsyslogd"); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to remove disable symlink: %w", err)
-	}
-	if err := exec.Command("apparmor_parser", "-r", "/etc/apparmor.d/usr.sbin.rsyslogd").Run(); err != nil {
-		return fmt.Errorf("failed to re-load rsyslog AppArmor profile: %w", err)
-	}
-	return nil
+// RestoreRsyslogProfile returns the rsyslog profile to the state recorded by
+// RelaxRsyslogProfile. Requires root privileges.
+func RestoreRsyslogProfile() (apparmor.Record, error) {
+	return apparmor.Restore(rsyslogProfile)
 }
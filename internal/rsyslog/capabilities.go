@@ -0,0 +1,169 @@
+package rsyslog
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nixpersist/internal/preflight"
+)
+
+// Form is how the shell module plants its trigger.
+type Form string
+
+const (
+	// FormAuto picks FormConf or FormOmprog from the rsyslog build.
+	FormAuto Form = "auto"
+	// FormConf appends a legacy ^ (shell execute) line to rsyslog.conf.
+	FormConf Form = "conf"
+	// FormDropIn writes the ^ line to its own file under rsyslog.d.
+	FormDropIn Form = "dropin"
+	// FormOmprog writes the RainerScript equivalent, an omprog action, to
+	// its own file under rsyslog.d.
+	FormOmprog Form = "omprog"
+)
+
+// ParseForm validates a --form value.
+func ParseForm(s string) (Form, error) {
+	switch f := Form(strings.ToLower(s)); f {
+	case FormAuto, FormConf, FormDropIn, FormOmprog:
+		return f, nil
+	}
+	return "", fmt.Errorf("form must be auto, conf, dropin or omprog, got %q", s)
+}
+
+// DropIn reports whether the form owns a whole file under rsyslog.d.
+func (f Form) DropIn() bool { return f == FormDropIn || f == FormOmprog }
+
+// Support is whether the rsyslog build provides an execution primitive.
+type Support string
+
+const (
+	Supported   Support = "yes"
+	Unsupported Support = "no"
+	Unknown     Support = "unknown"
+)
+
+// Capabilities are the execution primitives of the local rsyslog build.
+type Capabilities struct {
+	// Version is the rsyslogd version, such as 8.2112.0; empty when
+	// rsyslogd -v could not be run.
+	Version string `json:"version,omitempty"`
+	Major   int    `json:"-"`
+	// ShellExecute is whether rsyslogd -N1 accepts a ^ action, and
+	// ShellExecuteDetail how that was decided.
+	ShellExecute       Support `json:"shell_execute"`
+	ShellExecuteDetail string  `json:"shell_execute_detail,omitempty"`
+	Omprog             Support `json:"omprog"`
+	// Improg runs a program when rsyslog starts and logs its output; it
+	// cannot be triggered by a message.
+	Improg Support `json:"improg"`
+}
+
+// moduleDirs are the globs of the directories rsyslog loads modules from.
+var moduleDirs = []string{"/usr/lib/rsyslog", "/usr/lib64/rsyslog", "/usr/lib/*-linux-gnu*/rsyslog"}
+
+// shellProbe is validated with rsyslogd -N1 to find out whether the build
+// accepts the ^ action.
+const shellProbe = ":msg, contains, \"nixpersist-probe\" ^/bin/true\n"
+
+var versionPattern = regexp.MustCompile(`rsyslogd:?\s+v?(\d+)\.(\d+)\.(\d+)`)
+
+// parseVersion returns the version and major number from rsyslogd -v.
+func parseVersion(out string) (string, int, bool) {
+	m := versionPattern.FindStringSubmatch(out)
+	if m == nil {
+		return "", 0, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	return m[1] + "." + m[2] + "." + m[3], major, true
+}
+
+// DetectCapabilities runs rsyslogd -v, validates a ^ probe with
+// rsyslogd -N1 and looks for the omprog and improg modules.
+func DetectCapabilities() Capabilities {
+	c := Capabilities{Omprog: moduleSupport("omprog"), Improg: moduleSupport("improg")}
+	if bin, err := exec.LookPath("rsyslogd"); err == nil {
+		if out, err := exec.Command(bin, "-v").CombinedOutput(); err == nil {
+			c.Version, c.Major, _ = parseVersion(string(out))
+		}
+	}
+	c.ShellExecute, c.ShellExecuteDetail = shellSupport(validateStaged([]byte(shellProbe)), c.Major)
+	return c
+}
+
+// shellSupport interprets the validation of shellProbe, falling back on
+// the major version when rsyslogd could not be run.
+func shellSupport(probe error, major int) (Support, string) {
+	var failed *preflight.FailedError
+	switch {
+	case probe == nil:
+		return Supported, "rsyslogd -N1 accepts a ^ action"
+	case errors.As(probe, &failed):
+		return Unsupported, "rsyslogd -N1 rejects a ^ action: " + failed.Output
+	case major > 0 && major < 8:
+		return Supported, fmt.Sprintf("assumed for rsyslog %d (%v)", major, probe)
+	case major >= 8:
+		return Unknown, fmt.Sprintf("rsyslog %d restricts or drops ^ depending on the build (%v)", major, probe)
+	}
+	return Unknown, probe.Error()
+}
+
+// moduleSupport looks for name.so in moduleDirs; Unknown when none of the
+// directories exists.
+func moduleSupport(name string) Support {
+	found := false
+	for _, pattern := range moduleDirs {
+		dirs, _ := filepath.Glob(pattern)
+		for _, dir := range dirs {
+			found = true
+			if exists(filepath.Join(dir, name+".so")) {
+				return Supported
+			}
+		}
+	}
+	if !found {
+		return Unknown
+	}
+	return Unsupported
+}
+
+// ChooseForm picks the form FormAuto resolves to: the ^ line in
+// rsyslog.conf where the build accepts it, otherwise the omprog equivalent.
+func (c Capabilities) ChooseForm() (Form, string, error) {
+	switch c.ShellExecute {
+	case Supported:
+		return FormConf, "rsyslog accepts ^ (shell execute)", nil
+	case Unsupported:
+		if c.Omprog == Unsupported {
+			return "", "", errors.New("rsyslog rejects ^ and the omprog module is not installed")
+		}
+		return FormOmprog, "rsyslog rejects ^ (shell execute); using omprog", nil
+	}
+	if c.Major >= 8 && c.Omprog == Supported {
+		return FormOmprog, fmt.Sprintf("^ support unknown on rsyslog %s; using omprog", c.Version), nil
+	}
+	return FormConf, "^ support unknown; using the legacy form", nil
+}
+
+// Lines describes the capabilities for --check.
+func (c Capabilities) Lines() []string {
+	version := c.Version
+	if version == "" {
+		version = "unknown (rsyslogd -v not run)"
+	}
+	shell := string(c.ShellExecute)
+	if c.ShellExecuteDetail != "" {
+		shell += " (" + c.ShellExecuteDetail + ")"
+	}
+	return []string{
+		"- rsyslog version: " + version,
+		"- execution primitive ^ (shell execute): " + shell,
+		"- execution primitive omprog (action, triggerable): " + string(c.Omprog),
+		"- execution primitive improg (input, runs at startup only): " + string(c.Improg),
+	}
+}
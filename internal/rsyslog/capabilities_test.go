@@ -0,0 +1,163 @@
+package rsyslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/preflight"
+	"nixpersist/internal/sigma"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]string{
+		"rsyslogd  8.2112.0 (aka 2021.12) compiled with:\n\tPLATFORM: x86_64-pc-linux-gnu\n": "8.2112.0",
+		"rsyslogd 7.4.7, compiled with:\n\tFEATURE_REGEXP: Yes\n":                            "7.4.7",
+		"rsyslogd: unknown option": "",
+	}
+	for out, want := range cases {
+		if got, _, _ := parseVersion(out); got != want {
+			t.Errorf("parseVersion(%q) = %q, want %q", out, got, want)
+		}
+	}
+	if _, major, ok := parseVersion("rsyslogd 8.2302.0"); !ok || major != 8 {
+		t.Fatalf("major = %d", major)
+	}
+}
+
+func TestShellSupport(t *testing.T) {
+	rejected := &preflight.FailedError{Tool: "rsyslogd -N1", Output: "error during parsing"}
+	cases := []struct {
+		probe error
+		major int
+		want  Support
+	}{
+		{nil, 8, Supported},
+		{rejected, 8, Unsupported},
+		{preflight.Unavailable("rsyslogd not found in PATH"), 7, Supported},
+		{preflight.Unavailable("rsyslogd not found in PATH"), 8, Unknown},
+		{preflight.Unavailable("rsyslogd not found in PATH"), 0, Unknown},
+	}
+	for _, c := range cases {
+		if got, detail := shellSupport(c.probe, c.major); got != c.want {
+			t.Errorf("shellSupport(%v, %d) = %s (%s), want %s", c.probe, c.major, got, detail, c.want)
+		}
+	}
+	if _, detail := shellSupport(rejected, 8); !strings.Contains(detail, "error during parsing") {
+		t.Fatalf("detail %q lacks the validator output", detail)
+	}
+}
+
+func TestChooseForm(t *testing.T) {
+	cases := []struct {
+		caps Capabilities
+		want Form
+	}{
+		{Capabilities{ShellExecute: Supported, Omprog: Supported}, FormConf},
+		{Capabilities{ShellExecute: Unsupported, Omprog: Supported}, FormOmprog},
+		{Capabilities{ShellExecute: Unsupported, Omprog: Unknown}, FormOmprog},
+		{Capabilities{ShellExecute: Unknown, Major: 8, Version: "8.2112.0", Omprog: Supported}, FormOmprog},
+		{Capabilities{ShellExecute: Unknown, Omprog: Unknown}, FormConf},
+	}
+	for _, c := range cases {
+		if got, reason, err := c.caps.ChooseForm(); err != nil || got != c.want || reason == "" {
+			t.Errorf("%+v: got %s %q %v, want %s", c.caps, got, reason, err, c.want)
+		}
+	}
+	if _, _, err := (Capabilities{ShellExecute: Unsupported, Omprog: Unsupported}).ChooseForm(); err == nil {
+		t.Fatal("expected an error without ^ or omprog")
+	}
+}
+
+func TestModuleSupport(t *testing.T) {
+	root := t.TempDir()
+	orig := moduleDirs
+	moduleDirs = []string{filepath.Join(root, "missing"), filepath.Join(root, "*-linux-gnu", "rsyslog")}
+	t.Cleanup(func() { moduleDirs = orig })
+	if got := moduleSupport("omprog"); got != Unknown {
+		t.Fatalf("no module directory: got %s", got)
+	}
+	dir := filepath.Join(root, "x86_64-linux-gnu", "rsyslog")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "omprog.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := moduleSupport("omprog"); got != Supported {
+		t.Fatalf("omprog: got %s", got)
+	}
+	if got := moduleSupport("improg"); got != Unsupported {
+		t.Fatalf("improg: got %s", got)
+	}
+}
+
+func TestRenderShellConfigOmprogForm(t *testing.T) {
+	cfg, err := RenderShellConfig(ShellConfigParams{Trigger: `say "hi"`, Payload: "/usr/bin/touch /tmp/x", Form: FormOmprog})
+	if err != nil {
+		t.Fatalf("RenderShellConfig: %v", err)
+	}
+	want := "module(load=\"omprog\")\n\nif $msg contains \"say \\\"hi\\\"\" then {\n    action(type=\"omprog\" binary=\"/usr/bin/touch /tmp/x\")\n}\n"
+	if cfg != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", cfg, want)
+	}
+	stmts, err := ParseConfig(ShellDropInName, []byte(cfg))
+	if err != nil {
+		t.Fatalf("rendered drop-in does not parse: %v", err)
+	}
+	if _, ok := findShellRule(stmts, ""); ok {
+		t.Fatal("omprog form must not contain a ^ rule")
+	}
+	var binary string
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if s.Kind == KindObject && s.Name == "action" {
+			binary, _ = s.Param("binary")
+		}
+		return true
+	})
+	if binary != "/usr/bin/touch /tmp/x" {
+		t.Fatalf("binary = %q", binary)
+	}
+
+	rules, err := ShellDetections(ShellConfigParams{Trigger: "hacker", Payload: "/opt/beacon", Form: FormOmprog}, filepath.Join(DefaultConfigDir, ShellDropInName))
+	if err != nil {
+		t.Fatalf("ShellDetections: %v", err)
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{"TargetFilename: '/etc/rsyslog.d/99-nixpersist-shell.conf'", `Content|contains: 'binary="/opt/beacon"'`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestShellModuleForms(t *testing.T) {
+	m := NewShellModule()
+	m.trigger, m.payload = "hacker", "/bin/true"
+	for form, wantDest := range map[string]string{
+		"conf":   DefaultShellConfigPath,
+		"dropin": filepath.Join(DefaultConfigDir, ShellDropInName),
+		"omprog": filepath.Join(DefaultConfigDir, ShellDropInName),
+	} {
+		m.form = form
+		got, reason, err := m.resolveForm()
+		if err != nil || string(got) != form || reason != "" {
+			t.Fatalf("%s: got %s %q %v", form, got, reason, err)
+		}
+		if dest := m.dest(got); dest != wantDest {
+			t.Fatalf("%s: dest %s, want %s", form, dest, wantDest)
+		}
+	}
+
+	m.form = "auto"
+	m.caps = &Capabilities{ShellExecute: Unsupported, Omprog: Supported}
+	out, err := m.Render()
+	if err != nil || !strings.HasPrefix(out, "module(load=\"omprog\")") {
+		t.Fatalf("auto on a build without ^: %q %v", out, err)
+	}
+	m.form = "shell"
+	if _, err := m.Render(); err == nil {
+		t.Fatal("expected an invalid --form error")
+	}
+}
@@ -4,13 +4,33 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 )
 
 // ConfigParams captures the inputs for rendering an rsyslog configuration
-// that tails a file via imfile and triggers a program via omprog.
+// that takes messages from an input source, by default a file tailed via
+// imfile, and triggers a program via omprog.
 type ConfigParams struct {
-	// InputFile is the path to the log file to monitor.
+	// Source is the input module messages come from; empty means SourceFile.
+	Source Source
+	// SourceLoaded is set when the host configuration already loads the
+	// Source module, which is then not loaded again. It is ignored for
+	// SourceFile.
+	SourceLoaded bool
+	// SharedModules is set when the modules drop-in loads the modules
+	// Modules lists, which the snippet then leaves out.
+	SharedModules bool
+	// Port is the localhost port SourceTCP and SourceUDP listen on.
+	Port int
+	// Units optionally restricts SourceJournal messages to these systemd
+	// units (_SYSTEMD_UNIT).
+	Units []string
+	// Identifiers optionally restricts messages to these program names,
+	// the journal's SYSLOG_IDENTIFIER.
+	Identifiers []string
+
+	// InputFile is the path to the log file to monitor (SourceFile).
 	InputFile string
 	// Tag is the tag assigned to messages from InputFile.
 	Tag string
@@ -34,6 +54,9 @@ type ConfigParams struct {
 	// FilterRegex when set, triggers if $msg matches this regex.
 	FilterRegex string
 
+	// Filter when set, triggers on messages the expression matches.
+	Filter Expr
+
 	// FilterByTag when true, include "$syslogtag contains Tag" in the condition.
 	FilterByTag bool
 
@@ -41,29 +64,203 @@ type ConfigParams struct {
 	ProgramPath string
 	// ProgramArgs optional arguments for the payload.
 	ProgramArgs string
+
+	// ConfirmMessages makes omprog wait for the payload to print OK for each
+	// message before sending the next one.
+	ConfirmMessages bool
+	// Output optionally captures the payload's stdout and stderr in a file.
+	Output string
+	// UseTransactions sends messages in BEGIN/COMMIT batches; it requires
+	// ConfirmMessages.
+	UseTransactions bool
+	// ForceSingleInstance runs a single payload process for all workers.
+	ForceSingleInstance bool
+	// KillUnresponsive is on or off to set whether omprog kills a payload
+	// that outlives its close timeout; empty keeps rsyslog's default.
+	KillUnresponsive string
+	// SignalOnClose sends SIGTERM to the payload when the action closes.
+	SignalOnClose bool
+	// Template formats the messages written to the payload's stdin: a
+	// string template containing properties such as %msg%, rendered as a
+	// template object, or the name of an existing template. Its backslash
+	// escapes, such as \n, are RainerScript's.
+	Template string
 }
 
 // Validate checks required fields.
 func (p ConfigParams) Validate() error {
-	if p.InputFile == "" {
+	src := p.source()
+	if !slices.Contains(Sources, src) {
+		return fmt.Errorf("unknown Source %q", p.Source)
+	}
+	if src == SourceFile && p.InputFile == "" {
 		return errors.New("InputFile is required")
 	}
 	if p.ProgramPath == "" {
 		return errors.New("ProgramPath is required")
 	}
-	if p.Tag == "" {
+	if src == SourceFile && p.Tag == "" {
 		return errors.New("tag is required")
 	}
+	if (src == SourceTCP || src == SourceUDP) && (p.Port < 1 || p.Port > 65535) {
+		return fmt.Errorf("Port must be between 1 and 65535, got %d", p.Port)
+	}
+	if len(p.Units) > 0 && src != SourceJournal {
+		return errors.New("Units requires SourceJournal")
+	}
 	// RulesetName is only required if UseRuleset is true
 	if p.UseRuleset && p.RulesetName == "" {
 		return errors.New("RulesetName is required when UseRuleset is true")
 	}
-	if p.FilterContains == "" && p.FilterRegex == "" {
-		return errors.New("at least one of FilterContains or FilterRegex must be set")
+	if p.FilterContains == "" && p.FilterRegex == "" && p.Filter == nil {
+		return errors.New("at least one of FilterContains, FilterRegex or Filter must be set")
+	}
+	if err := p.Condition().validate(); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	if p.PollingInterval < 0 {
+		return errors.New("PollingInterval must not be negative")
+	}
+	if p.UseTransactions && !p.ConfirmMessages {
+		return errors.New("UseTransactions requires ConfirmMessages")
+	}
+	switch p.KillUnresponsive {
+	case "", "on", "off":
+	default:
+		return fmt.Errorf("KillUnresponsive must be on or off, got %q", p.KillUnresponsive)
 	}
 	return nil
 }
 
+// Condition returns the expression of the if statement: the message must
+// match any of FilterContains, FilterRegex and Filter, carry Tag when
+// FilterByTag is set, and come from one of Units and Identifiers when they
+// are given. Without a ruleset, messages of a source that cannot bind one
+// are also told apart by $inputname.
+func (p ConfigParams) Condition() Expr {
+	var match Or
+	if p.FilterContains != "" {
+		match = append(match, Comparison{Property: PropMsg, Op: OpContains, Value: p.FilterContains})
+	}
+	if p.FilterRegex != "" {
+		match = append(match, Comparison{Property: PropMsg, Op: OpReMatch, Value: p.FilterRegex})
+	}
+	if p.Filter != nil {
+		match = append(match, p.Filter)
+	}
+	var cond Expr = match
+	if len(match) == 1 {
+		cond = match[0]
+	}
+	var guards And
+	if !p.UseRuleset && !p.source().Bindable() {
+		guards = append(guards, p.inputGuard())
+	}
+	if p.FilterByTag {
+		guards = append(guards, Comparison{Property: PropSyslogTag, Op: OpContains, Value: p.Tag})
+	}
+	if units := anyOf(JSONField("_SYSTEMD_UNIT"), p.Units); units != nil {
+		guards = append(guards, units)
+	}
+	if ids := anyOf(PropProgramName, p.Identifiers); ids != nil {
+		guards = append(guards, ids)
+	}
+	if len(guards) == 0 {
+		return cond
+	}
+	return append(guards, cond)
+}
+
+// inputGuard matches the messages of p's source.
+func (p ConfigParams) inputGuard() Comparison {
+	return Comparison{Property: PropInputName, Op: OpIsEqual, Value: string(p.source())}
+}
+
+// anyOf matches prop against any of values; it is nil without values.
+func anyOf(prop Property, values []string) Expr {
+	var or Or
+	for _, v := range values {
+		or = append(or, Comparison{Property: prop, Op: OpIsEqual, Value: v})
+	}
+	switch len(or) {
+	case 0:
+		return nil
+	case 1:
+		return or[0]
+	}
+	return or
+}
+
+// templateName is the name of the template object rendered for a string
+// Template, or the existing template Template names.
+func (p ConfigParams) templateName() string {
+	if !strings.Contains(p.Template, "%") {
+		return p.Template
+	}
+	if p.UseRuleset {
+		return p.RulesetName + "_fmt"
+	}
+	return "nixpersist_fmt"
+}
+
+// actionOptions returns the omprog parameters beyond the binary, in the
+// order rsyslog documents them.
+func (p ConfigParams) actionOptions() []string {
+	var opts []string
+	if p.Template != "" {
+		opts = append(opts, fmt.Sprintf("template=\"%s\"", escapeQuotes(p.templateName())))
+	}
+	if p.ConfirmMessages {
+		opts = append(opts, "confirmMessages=\"on\"")
+	}
+	if p.UseTransactions {
+		opts = append(opts, "useTransactions=\"on\"")
+	}
+	if p.Output != "" {
+		opts = append(opts, fmt.Sprintf("output=\"%s\"", escapeQuotes(p.Output)))
+	}
+	if p.SignalOnClose {
+		opts = append(opts, "signalOnClose=\"on\"")
+	}
+	if p.KillUnresponsive != "" {
+		opts = append(opts, fmt.Sprintf("killUnresponsive=\"%s\"", p.KillUnresponsive))
+	}
+	if p.ForceSingleInstance {
+		opts = append(opts, "forceSingleInstance=\"on\"")
+	}
+	return opts
+}
+
+// Modules returns the module() statements the snippet needs, in the order
+// RenderConfig writes them.
+func (p ConfigParams) Modules() []string {
+	var loads []string
+	switch src := p.source(); {
+	case src == SourceFile && p.PollingInterval > 0:
+		loads = append(loads, fmt.Sprintf("module(load=\"imfile\" PollingInterval=\"%d\")", p.PollingInterval))
+	case src == SourceFile:
+		loads = append(loads, "module(load=\"imfile\")")
+	case p.SourceLoaded:
+	case src == SourceJournal:
+		// Without a state file, IgnorePreviousMessages keeps imjournal from
+		// replaying the whole journal.
+		loads = append(loads, "module(load=\"imjournal\" IgnorePreviousMessages=\"on\")")
+	default:
+		loads = append(loads, fmt.Sprintf("module(load=\"%s\")", src))
+	}
+	loads = append(loads, "module(load=\"omprog\")")
+	if p.parseJSON() {
+		loads = append(loads, "module(load=\"mmjsonparse\")")
+	}
+	return loads
+}
+
+// parseJSON reports whether messages go through mmjsonparse: imjournal fills
+// the JSON fields itself, and mmjsonparse would replace them.
+func (p ConfigParams) parseJSON() bool {
+	return usesJSON(p.Condition()) && p.source() != SourceJournal
+}
+
 // RenderConfig produces a RainerScript snippet for rsyslog based on the params.
 // Note: omprog arguments handling varies by rsyslog version. This renderer uses
 // the common binary + arguments properties; adjust if your target differs.
@@ -72,89 +269,123 @@ func RenderConfig(p ConfigParams) (string, error) {
 		return "", err
 	}
 
+	src := p.source()
+	cond := p.Condition()
+
 	var b bytes.Buffer
-	// Ensure modules
-	if p.PollingInterval > 0 {
-		fmt.Fprintf(&b, "module(load=\"imfile\" PollingInterval=\"%d\")\n", p.PollingInterval)
-	} else {
-		b.WriteString("module(load=\"imfile\")\n")
+	if !p.SharedModules {
+		for _, load := range p.Modules() {
+			b.WriteString(load + "\n")
+		}
+		b.WriteString("\n")
 	}
-	b.WriteString("module(load=\"omprog\")\n\n")
 
-	// imfile input
-	b.WriteString("input(\n")
-	fmt.Fprintf(&b, "\ttype=\"imfile\"\n")
-	fmt.Fprintf(&b, "\tFile=\"%s\"\n", p.InputFile)
-	fmt.Fprintf(&b, "\tTag=\"%s\"\n", p.Tag)
-	if p.Severity != "" {
-		fmt.Fprintf(&b, "\tSeverity=\"%s\"\n", p.Severity)
-	}
-	if p.Facility != "" {
-		fmt.Fprintf(&b, "\tFacility=\"%s\"\n", p.Facility)
-	}
-	if p.AddMetadata {
-		fmt.Fprintf(&b, "\taddMetadata=\"on\"\n")
-	}
-	// reopenOnTruncate keeps tailing rotated logs so triggers remain armed.
-	fmt.Fprintf(&b, "\treopenOnTruncate=\"on\"\n")
-	if p.StateFile != "" {
-		fmt.Fprintf(&b, "\tStateFile=\"%s\"\n", p.StateFile)
+	if strings.Contains(p.Template, "%") {
+		fmt.Fprintf(&b, "template(name=\"%s\" type=\"string\" string=\"%s\")\n\n", p.templateName(), escapeTemplate(p.Template))
 	}
-	if p.UseRuleset {
-		fmt.Fprintf(&b, "\truleset=\"%s\"\n", p.RulesetName)
+
+	switch src {
+	case SourceFile:
+		p.renderFileInput(&b)
+	case SourceTCP, SourceUDP:
+		b.WriteString("input(\n")
+		fmt.Fprintf(&b, "\ttype=\"%s\"\n", src)
+		// Bound to loopback so the listener is not reachable remotely.
+		b.WriteString("\taddress=\"127.0.0.1\"\n")
+		fmt.Fprintf(&b, "\tport=\"%d\"\n", p.Port)
+		if p.UseRuleset {
+			fmt.Fprintf(&b, "\truleset=\"%s\"\n", p.RulesetName)
+		}
+		b.WriteString(")\n\n")
 	}
-	b.WriteString(")\n\n")
 
 	// filter + action
+	indent := ""
 	if p.UseRuleset {
 		fmt.Fprintf(&b, "ruleset(name=\"%s\") {\n", p.RulesetName)
-		b.WriteString("    if (")
-	} else {
-		b.WriteString("if (")
-	}
-	wrote := false
-	if p.FilterByTag {
-		fmt.Fprintf(&b, "$syslogtag contains '%s')", escapeQuotes(p.Tag))
-		wrote = true
-	}
-	if p.FilterContains != "" {
-		if wrote {
-			b.WriteString(" and ")
-		}
-		fmt.Fprintf(&b, "($msg contains '%s')", escapeQuotes(p.FilterContains))
-		wrote = true
-	}
-	if p.FilterRegex != "" {
-		if wrote {
-			b.WriteString(" or ")
-		}
-		fmt.Fprintf(&b, "re_match($msg, \"%s\")", escapeQuotes(p.FilterRegex))
-		wrote = true
+		indent = "    "
 	}
-	if !wrote {
-		return "", errors.New("no filter expression constructed")
+	if p.parseJSON() {
+		// JSON fields ($!) are only set once the message is parsed.
+		fmt.Fprintf(&b, "%saction(type=\"mmjsonparse\" cookie=\"\")\n", indent)
 	}
-	b.WriteString(" then {\n")
+	fmt.Fprintf(&b, "%sif %s then {\n", indent, cond.RainerScript())
+	binary := p.ProgramPath
 	if p.ProgramArgs != "" {
-		fmt.Fprintf(&b, "        action(type=\"omprog\" binary=\"%s %s\")\n", escapeQuotes(p.ProgramPath), escapeQuotes(p.ProgramArgs))
-	} else {
-		fmt.Fprintf(&b, "        action(type=\"omprog\" binary=\"%s\")\n", escapeQuotes(p.ProgramPath))
+		binary += " " + p.ProgramArgs
 	}
+	action := fmt.Sprintf("action(type=\"omprog\" binary=\"%s\"", escapeQuotes(binary))
+	for _, opt := range p.actionOptions() {
+		action += " " + opt
+	}
+	fmt.Fprintf(&b, "        %s)\n", action)
 	if p.UseRuleset {
 		b.WriteString("    }\n")
 		b.WriteString("}\n")
 	} else {
 		b.WriteString("}\n")
 	}
+	if p.UseRuleset && !src.Bindable() {
+		// imjournal and imuxsock feed the default ruleset, which hands
+		// their messages on.
+		fmt.Fprintf(&b, "\nif %s then {\n    call %s\n}\n", p.inputGuard().RainerScript(), p.RulesetName)
+	}
 
 	return b.String(), nil
 }
 
+// renderFileInput writes the imfile input of p.
+func (p ConfigParams) renderFileInput(b *bytes.Buffer) {
+	b.WriteString("input(\n")
+	fmt.Fprintf(b, "\ttype=\"imfile\"\n")
+	fmt.Fprintf(b, "\tFile=\"%s\"\n", p.InputFile)
+	fmt.Fprintf(b, "\tTag=\"%s\"\n", p.Tag)
+	if p.Severity != "" {
+		fmt.Fprintf(b, "\tSeverity=\"%s\"\n", p.Severity)
+	}
+	if p.Facility != "" {
+		fmt.Fprintf(b, "\tFacility=\"%s\"\n", p.Facility)
+	}
+	if p.AddMetadata {
+		fmt.Fprintf(b, "\taddMetadata=\"on\"\n")
+	}
+	// reopenOnTruncate keeps tailing rotated logs so triggers remain armed.
+	fmt.Fprintf(b, "\treopenOnTruncate=\"on\"\n")
+	if p.StateFile != "" {
+		fmt.Fprintf(b, "\tStateFile=\"%s\"\n", p.StateFile)
+	}
+	if p.UseRuleset {
+		fmt.Fprintf(b, "\truleset=\"%s\"\n", p.RulesetName)
+	}
+	b.WriteString(")\n\n")
+}
+
+// escapeQuotes escapes backslashes and double quotes for embedding s in a
+// double-quoted RainerScript string.
 func escapeQuotes(s string) string {
-	// Escape backslashes first, then quotes, for safe embedding in RainerScript strings.
-	s = strings.ReplaceAll(s, `\\`, `\\\\`)
-	s = strings.ReplaceAll(s, `"`, `\\"`)
-	return s
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// escapeTemplate escapes the double quotes of a string template for
+// embedding it in a double-quoted RainerScript string. Backslash escapes
+// such as \n are the template's own and are kept; a trailing backslash,
+// which would escape the closing quote, is doubled.
+func escapeTemplate(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case s[i] == '\\':
+			b.WriteString(`\\`)
+		case s[i] == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
 }
 
 // Prepare is a placeholder for future privileged operations.
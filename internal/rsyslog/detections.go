@@ -0,0 +1,200 @@
+package rsyslog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/apparmor"
+	"nixpersist/internal/audit"
+	"nixpersist/internal/sigma"
+)
+
+var rsyslogTags = []string{"attack.persistence", "attack.privilege-escalation", "attack.t1546"}
+
+// ShellDetections returns Sigma rules for the shell-execute snippet rendered
+// from p and appended to dest.
+func ShellDetections(p ShellConfigParams, dest string) ([]sigma.Rule, error) {
+	cfg, err := RenderShellConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.TrimSpace(p.Payload)
+	if p.Form == FormOmprog {
+		return []sigma.Rule{
+			configWriteRule(dest),
+			omprogActionRule(fmt.Sprintf("The simulation writes %s, which runs %q when a message contains %q.", dest, payload, p.Trigger), payload),
+			childProcessRule(payload),
+			triggerRule([]string{p.Trigger}, "any syslog message"),
+		}, nil
+	}
+	return []sigma.Rule{
+		configWriteRule(dest),
+		{
+			Title:       "Rsyslog Shell Execute Action In Configuration",
+			Description: fmt.Sprintf("Detects a legacy rsyslog ^ (shell execute) action in %s. The simulation runs %q when a message contains %q.", dest, payload, p.Trigger),
+			Tags:        rsyslogTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_content"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_file", Fields: []sigma.Field{{Name: "TargetFilename", Modifiers: []string{"startswith"}, Values: []string{"/etc/rsyslog"}}}},
+					{Name: "selection_exact", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains"}, Values: []string{strings.TrimSpace(cfg)}}}},
+					{Name: "selection_generic", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"re"}, Values: []string{`(?m)^\s*:\w+,\s*!?[\w-]+,\s*".*"\s*\^`}}}},
+				},
+				Condition: "selection_file and (selection_exact or selection_generic)",
+			},
+			FalsePositives: []string{"Legacy rsyslog configurations that intentionally execute programs"},
+			Level:          "high",
+		},
+		childProcessRule(payload),
+		triggerRule([]string{p.Trigger}, "any syslog message"),
+	}, nil
+}
+
+// Detections returns Sigma rules for the imfile + omprog drop-in rendered
+// from p and written to dest.
+func Detections(p ConfigParams, dest string) ([]sigma.Rule, error) {
+	if _, err := RenderConfig(p); err != nil {
+		return nil, err
+	}
+	program := p.ProgramPath
+	if p.ProgramArgs != "" {
+		program += " " + p.ProgramArgs
+	}
+	rules := []sigma.Rule{
+		configWriteRule(dest),
+		omprogActionRule(fmt.Sprintf("The simulation %s and runs %q.", p.sourceVerb(), program), program),
+		childProcessRule(p.ProgramPath),
+	}
+	// A filter that only matches regular expressions has no keyword.
+	if keywords := messageLiterals(p.Condition()); len(keywords) > 0 {
+		rules = append(rules, triggerRule(keywords, p.sourceDescription()))
+	}
+	return rules, nil
+}
+
+// omprogActionRule matches an omprog action running program; simulation
+// describes the simulated setup.
+func omprogActionRule(simulation, program string) sigma.Rule {
+	return sigma.Rule{
+		Title:       "Rsyslog Omprog Action In Configuration",
+		Description: "Detects an rsyslog omprog action that executes a program for matching messages. " + simulation,
+		Tags:        rsyslogTags,
+		LogSource:   sigma.LogSource{Product: "linux", Category: "file_content"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{
+				{Name: "selection_file", Fields: []sigma.Field{{Name: "TargetFilename", Modifiers: []string{"startswith"}, Values: []string{"/etc/rsyslog"}}}},
+				{Name: "selection_exact", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains"}, Values: []string{fmt.Sprintf(`binary="%s"`, escapeQuotes(program))}}}},
+				{Name: "selection_generic", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains", "all"}, Values: []string{"omprog", "binary="}}}},
+			},
+			Condition: "selection_file and (selection_exact or selection_generic)",
+		},
+		FalsePositives: []string{"Log shipping setups that use omprog to forward messages"},
+		Level:          "high",
+	}
+}
+
+// AppArmorDetection returns a rule for the rsyslog profile being relaxed as
+// --apparmor-mode says: unloaded and disabled, or reloaded in complain mode.
+func AppArmorDetection(mode apparmor.Mode) sigma.Rule {
+	if mode == apparmor.Complain {
+		return sigma.Rule{
+			Title:       "Rsyslog AppArmor Profile Set To Complain Mode",
+			Description: "Detects the rsyslogd AppArmor profile being switched to complain mode, which logs but no longer blocks programs rsyslog executes.",
+			Tags:        []string{"attack.defense-evasion", "attack.t1562.001"},
+			LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_utils", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"aa-complain", "rsyslogd"}}}},
+					{Name: "selection_parser", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"apparmor_parser", "-r", rsyslogProfileName}}}},
+				},
+				Condition: "1 of selection_*",
+			},
+			FalsePositives: []string{"rsyslog package upgrades reloading the profile", "Administrators troubleshooting rsyslog confinement"},
+			Level:          "medium",
+		}
+	}
+	return sigma.Rule{
+		Title:       "Rsyslog AppArmor Profile Disabled",
+		Description: "Detects the rsyslogd AppArmor profile being unloaded or disabled, which lifts confinement on programs rsyslog executes.",
+		Tags:        []string{"attack.defense-evasion", "attack.t1562.001"},
+		LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{
+				{Name: "selection_parser", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"apparmor_parser", "-R", rsyslogProfileName}}}},
+				{Name: "selection_disable", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"/etc/apparmor.d/disable", rsyslogProfileName}}}},
+			},
+			Condition: "1 of selection_*",
+		},
+		FalsePositives: []string{"Administrators troubleshooting rsyslog confinement"},
+		Level:          "high",
+	}
+}
+
+func configWriteRule(dest string) sigma.Rule {
+	return sigma.Rule{
+		Title:       "Rsyslog Configuration Modified",
+		Description: fmt.Sprintf("Detects writes to %s, where NixPersist plants its rsyslog trigger.", dest),
+		Tags:        rsyslogTags,
+		LogSource:   sigma.LogSource{Product: "linux", Category: "file_event"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{{Name: "selection", Fields: []sigma.Field{{Name: "TargetFilename", Values: []string{dest}}}}},
+			Condition:  "selection",
+		},
+		FalsePositives: []string{"Package upgrades and configuration management touching rsyslog"},
+		Level:          "medium",
+	}
+}
+
+func childProcessRule(payload string) sigma.Rule {
+	image := sigma.Image(payload)
+	return sigma.Rule{
+		Title:       "Rsyslogd Spawning Payload Process",
+		Description: fmt.Sprintf("Detects rsyslogd executing %s, the program run when the trigger matches.", image),
+		Tags:        append([]string{"attack.execution"}, rsyslogTags...),
+		LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{
+				{Name: "selection_parent", Fields: []sigma.Field{{Name: "ParentImage", Modifiers: []string{"endswith"}, Values: []string{"/rsyslogd"}}}},
+				{Name: "selection_payload", Fields: []sigma.Field{{Name: "Image", Values: []string{image}}}},
+			},
+			Condition: "all of selection_*",
+		},
+		FalsePositives: []string{"Legitimate omprog consumers run by rsyslog"},
+		Level:          "high",
+	}
+}
+
+func triggerRule(keywords []string, source string) sigma.Rule {
+	return sigma.Rule{
+		Title:       "Rsyslog Persistence Trigger String Logged",
+		Description: fmt.Sprintf("Detects the trigger string that fires the rsyslog payload appearing in %s.", source),
+		Tags:        rsyslogTags,
+		LogSource:   sigma.LogSource{Product: "linux", Service: "syslog"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{{Name: "keywords", Keywords: keywords}},
+			Condition:  "keywords",
+		},
+		FalsePositives: []string{"Benign messages that happen to contain the trigger string"},
+		Level:          "low",
+	}
+}
+
+// AuditRules returns auditd rules for a module that writes dest and has
+// rsyslogd execute payload: watches on the rsyslog configuration, execve of
+// the payload and rsyslogd, and AppArmor profile changes.
+func AuditRules(module, dest, payload string) []audit.Rule {
+	key := audit.KeyPrefix + module
+	rules := []audit.Rule{
+		audit.Watch(DefaultShellConfigPath, "wa", key),
+		audit.Watch(DefaultConfigDir, "wa", key),
+	}
+	if dest != DefaultShellConfigPath && filepath.Dir(dest) != DefaultConfigDir {
+		rules = append(rules, audit.Watch(dest, "wa", key))
+	}
+	if image := sigma.Image(payload); filepath.IsAbs(image) {
+		rules = append(rules, audit.Exec(image, key+"-exec"))
+	}
+	rules = append(rules, audit.Exec(audit.FirstExisting("/usr/sbin/rsyslogd", "/sbin/rsyslogd"), key+"-exec"))
+	return append(rules, audit.AppArmorRules()...)
+}
@@ -0,0 +1,67 @@
+package rsyslog
+
+import (
+	"strings"
+	"testing"
+
+	"nixpersist/internal/sigma"
+)
+
+func TestShellDetectionsMatchRenderedSnippet(t *testing.T) {
+	rules, err := ShellDetections(ShellConfigParams{Trigger: "hacker", Payload: "/usr/bin/touch /tmp/x"}, DefaultShellConfigPath)
+	if err != nil {
+		t.Fatalf("ShellDetections returned error: %v", err)
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{
+		"TargetFilename: '/etc/rsyslog.conf'",
+		`Content|contains: ':msg, contains, "hacker" ^/usr/bin/touch /tmp/x'`,
+		"ParentImage|endswith: '/rsyslogd'",
+		"Image: '/usr/bin/touch'",
+		"    keywords:\n        - hacker\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestOmprogDetectionsUseDropInPath(t *testing.T) {
+	m := NewOmprogModule()
+	m.in, m.payload, m.trigger = "/var/log/auth.log", "/opt/beacon", "uhtavi0"
+	rules, err := m.Detections()
+	if err != nil {
+		t.Fatalf("Detections returned error: %v", err)
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{
+		"TargetFilename: '/etc/rsyslog.d/99-nixpersist.conf'",
+		`Content|contains: 'binary="/opt/beacon"'`,
+		"Image: '/opt/beacon'",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "AppArmor") {
+		t.Fatalf("AppArmor rule emitted without --apparmor:\n%s", out)
+	}
+}
+
+func TestAuditRulesCoverConfigAndPayload(t *testing.T) {
+	var lines []string
+	for _, r := range AuditRules("rsyslog", "/etc/custom/rsyslog.conf", "/usr/bin/touch /tmp/x") {
+		lines = append(lines, r.String())
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"-w /etc/rsyslog.d -p wa -k nixpersist-rsyslog",
+		"-w /etc/custom/rsyslog.conf -p wa -k nixpersist-rsyslog",
+		"-S execve -F path=/usr/bin/touch -k nixpersist-rsyslog-exec",
+		"-k nixpersist-apparmor",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("rules missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -7,16 +7,42 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"nixpersist/internal/selinux"
 )
 
+// rsyslogNames are the process names of the rsyslog daemon and
+// rsyslogDomain the SELinux domain the targeted policy runs it in.
+var rsyslogNames = []string{"rsyslogd"}
+
+const rsyslogDomain = "syslogd_t"
+
 // Result captures feasibility checks for using NixPersist on a host.
 type Result struct {
-	RsyslogInstalled         bool
-	RsyslogRunning           bool
-	AppArmorInstalled        bool
-	RsyslogAppArmorProtected bool
+	RsyslogInstalled         bool `json:"rsyslog_installed"`
+	RsyslogRunning           bool `json:"rsyslog_running"`
+	AppArmorInstalled        bool `json:"apparmor_installed"`
+	RsyslogAppArmorProtected bool `json:"rsyslog_apparmor_protected"`
+	// ProfileMode is the mode of the usr.sbin.rsyslogd profile: enforce,
+	// complain, disabled or absent; empty when it could not be read.
+	ProfileMode string `json:"profile_mode,omitempty"`
+	// Validation is the outcome of checking the rendered config with
+	// rsyslogd -N1; empty when no config was validated.
+	Validation string `json:"validation,omitempty"`
+	// Capabilities are the version and execution primitives of the build.
+	Capabilities Capabilities `json:"capabilities"`
+	// ShellForm is the form the shell module would install, and why.
+	ShellForm string `json:"shell_form,omitempty"`
+	// SELinux is the mode, rsyslogd's domain and whether it may execute
+	// the payload.
+	SELinux selinux.Report `json:"selinux"`
+	// Sources are the input sources a trigger can be read from, and
+	// LogFiles the log files imfile could watch; set by rsyslog-omprog.
+	Sources  []SourceStatus `json:"sources,omitempty"`
+	LogFiles []LogFile      `json:"log_files,omitempty"`
 
-	Notes []string
+	Notes []string `json:"notes"`
 }
 
 // Check performs environment checks and returns a Result.
@@ -25,14 +51,61 @@ func Check() Result {
 
 	r.RsyslogInstalled = checkRsyslogInstalled(&r)
 	r.RsyslogRunning = checkRsyslogRunning(&r)
+	r.Capabilities = DetectCapabilities()
 	r.AppArmorInstalled = checkAppArmorInstalled(&r)
 	if r.AppArmorInstalled && r.RsyslogRunning {
 		r.RsyslogAppArmorProtected = checkRsyslogAppArmorProtected(&r)
 	}
+	if r.AppArmorInstalled {
+		if mode, err := rsyslogProfile.Current(); err != nil {
+			r.Notes = append(r.Notes, fmt.Sprintf("cannot read the mode of %s: %v", rsyslogProfileName, err))
+		} else {
+			r.ProfileMode = string(mode)
+		}
+	}
 
 	return r
 }
 
+// inspectSELinux fills r.SELinux for payload; with avc it also lists the
+// denials of the last selinux.RecentWindow.
+func (r *Result) inspectSELinux(payload string, avc bool) {
+	var notes []string
+	r.SELinux, notes = selinux.Inspect(rsyslogNames, rsyslogDomain, payload)
+	r.Notes = append(r.Notes, notes...)
+	if avc {
+		if err := r.SELinux.LoadDenials(time.Now().Add(-selinux.RecentWindow)); err != nil {
+			r.Notes = append(r.Notes, err.Error())
+		}
+	}
+}
+
+// warnSELinux warns when SELinux is predicted to keep rsyslogd from
+// executing payload, which would make the trigger fail silently.
+func warnSELinux(payload string) {
+	r, _ := selinux.Inspect(rsyslogNames, rsyslogDomain, payload)
+	if r.Prediction != nil && r.Prediction.Verdict == selinux.Denied {
+		fmt.Fprintf(warnOut, "warning: SELinux would deny %s executing %s: %s\n", r.Domain, r.Program, r.Prediction.Reason)
+	}
+}
+
+// selinuxDenials prints the AVC denials logged for rsyslogd's domain since
+// since and returns a summary for the install message.
+func selinuxDenials(since time.Time) string {
+	r, _ := selinux.Inspect(rsyslogNames, rsyslogDomain, "")
+	if r.Mode == selinux.Disabled {
+		return "; SELinux disabled"
+	}
+	if err := r.LoadDenials(since); err != nil {
+		fmt.Fprintf(warnOut, "warning: %v\n", err)
+		return "; AVC denials not read"
+	}
+	for _, d := range r.Denials {
+		fmt.Fprintf(warnOut, "AVC denial: %s\n", d)
+	}
+	return fmt.Sprintf("; %d AVC denial(s) for %s since install", len(r.Denials), r.Domain)
+}
+
 func checkRsyslogInstalled(r *Result) bool {
 	if _, err := exec.LookPath("rsyslogd"); err == nil {
 		r.Notes = append(r.Notes, "found rsyslogd in PATH")
@@ -164,6 +237,35 @@ func (r Result) Render() string {
 	writeLine("rsyslog running", r.RsyslogRunning)
 	writeLine("AppArmor installed", r.AppArmorInstalled)
 	writeLine("AppArmor enforced for rsyslog", r.RsyslogAppArmorProtected)
+	if r.ProfileMode != "" {
+		fmt.Fprintf(b, "- AppArmor profile %s: %s\n", rsyslogProfileName, r.ProfileMode)
+	}
+	for _, line := range r.Capabilities.Lines() {
+		b.WriteString(line + "\n")
+	}
+	if r.ShellForm != "" {
+		fmt.Fprintf(b, "- shell module form: %s\n", r.ShellForm)
+	}
+	if r.Validation != "" {
+		fmt.Fprintf(b, "- config validation (rsyslogd -N1): %s\n", r.Validation)
+	}
+	for _, s := range r.Sources {
+		fmt.Fprintf(b, "- input source %s: %s", s.Source, s.Available)
+		if s.Detail != "" {
+			fmt.Fprintf(b, " (%s)", s.Detail)
+		}
+		b.WriteString("\n")
+	}
+	for _, f := range r.LogFiles {
+		status := "missing"
+		if f.Exists {
+			status = "present"
+		}
+		fmt.Fprintf(b, "- log file %s: %s\n", f.Path, status)
+	}
+	for _, line := range r.SELinux.Lines() {
+		b.WriteString(line + "\n")
+	}
 
 	if len(r.Notes) > 0 {
 		b.WriteString("\nNotes:\n")
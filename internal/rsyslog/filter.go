@@ -0,0 +1,492 @@
+package rsyslog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Property is a message property a filter compares, named as in
+// RainerScript without the leading $.
+type Property string
+
+const (
+	PropMsg         Property = "msg"
+	PropProgramName Property = "programname"
+	PropHostname    Property = "hostname"
+	PropFromHostIP  Property = "fromhost-ip"
+	PropSyslogTag   Property = "syslogtag"
+	PropSeverity    Property = "syslogseverity-text"
+	PropFacility    Property = "syslogfacility-text"
+	// PropInputName is the input module that received the message, such
+	// as imjournal.
+	PropInputName Property = "inputname"
+)
+
+// JSONField returns the property of the JSON field at path, such as
+// "user!name"; an empty path is the whole JSON tree. RenderConfig parses
+// the message with mmjsonparse when a filter uses one.
+func JSONField(path string) Property { return Property("!" + path) }
+
+// IsJSON reports whether p is a JSON field.
+func (p Property) IsJSON() bool { return strings.HasPrefix(string(p), "!") }
+
+// whenAliases are the --when spellings of properties whose RainerScript
+// names are long.
+var whenAliases = map[string]Property{
+	"severity": PropSeverity,
+	"facility": PropFacility,
+	"tag":      PropSyslogTag,
+	"program":  PropProgramName,
+	"host":     PropHostname,
+}
+
+// ParseProperty accepts a property name with or without $, a --when alias
+// such as severity, or $!path for a JSON field.
+func ParseProperty(s string) (Property, error) {
+	name := strings.TrimPrefix(s, "$")
+	if p, ok := whenAliases[name]; ok {
+		return p, nil
+	}
+	p := Property(name)
+	if p.IsJSON() {
+		if (strings.HasSuffix(name, "!") && name != "!") || strings.Contains(name, "!!") {
+			return "", fmt.Errorf("invalid JSON field %q", s)
+		}
+		return p, nil
+	}
+	switch p {
+	case PropMsg, PropProgramName, PropHostname, PropFromHostIP, PropSyslogTag, PropSeverity, PropFacility, PropInputName:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown property %q (want msg, programname, hostname, fromhost-ip, syslogtag, severity, facility, inputname or $!field)", s)
+}
+
+// when returns the --when spelling of p.
+func (p Property) when() string {
+	switch {
+	case p == PropSeverity:
+		return "severity"
+	case p == PropFacility:
+		return "facility"
+	case p.IsJSON():
+		return "$" + string(p)
+	}
+	return string(p)
+}
+
+// Op is a comparison of a property with a string.
+type Op string
+
+const (
+	OpContains   Op = "contains"
+	OpStartsWith Op = "startswith"
+	OpIsEqual    Op = "isequal"
+	// OpReMatch and OpEreRegex both match a POSIX ERE; ereregex is the
+	// property-filter spelling, and in RainerScript both are re_match().
+	OpReMatch  Op = "re_match"
+	OpEreRegex Op = "ereregex"
+)
+
+// whenOps maps the --when spellings of operators to Ops; the first
+// spelling of each is the one String uses.
+var whenOps = []struct {
+	token string
+	op    Op
+}{
+	{"==", OpIsEqual},
+	{"~", OpReMatch},
+	{"contains", OpContains},
+	{"startswith", OpStartsWith},
+	{"ereregex", OpEreRegex},
+	{"isequal", OpIsEqual},
+	{"re_match", OpReMatch},
+	{"*=", OpContains},
+	{"^=", OpStartsWith},
+}
+
+// Expr is a filter condition: a Comparison, or And, Or and Not over other
+// expressions.
+type Expr interface {
+	// RainerScript renders the expression for an if statement.
+	RainerScript() string
+	// String renders the expression in --when syntax; ParseWhen reverses it.
+	String() string
+	validate() error
+}
+
+// Comparison compares Property with Value.
+type Comparison struct {
+	Property Property
+	Op       Op
+	Value    string
+}
+
+// And matches when all of its expressions match.
+type And []Expr
+
+// Or matches when any of its expressions matches.
+type Or []Expr
+
+// Not matches when X does not.
+type Not struct{ X Expr }
+
+func (c Comparison) RainerScript() string {
+	prop, value := "$"+string(c.Property), quote(c.Value)
+	switch c.Op {
+	case OpIsEqual:
+		return prop + " == " + value
+	case OpReMatch, OpEreRegex:
+		return "re_match(" + prop + ", " + value + ")"
+	}
+	return prop + " " + string(c.Op) + " " + value
+}
+
+func (c Comparison) String() string {
+	token := string(c.Op)
+	for _, w := range whenOps {
+		if w.op == c.Op {
+			token = w.token
+			break
+		}
+	}
+	if token == "==" || token == "~" {
+		return c.Property.when() + token + quote(c.Value)
+	}
+	return c.Property.when() + " " + token + " " + quote(c.Value)
+}
+
+func (c Comparison) validate() error {
+	if _, err := ParseProperty("$" + string(c.Property)); err != nil {
+		return err
+	}
+	switch c.Op {
+	case OpContains, OpStartsWith:
+		if c.Value == "" {
+			return fmt.Errorf("%s %s needs a non-empty value", c.Property, c.Op)
+		}
+	case OpIsEqual:
+	case OpReMatch, OpEreRegex:
+		if _, err := regexp.CompilePOSIX(c.Value); err != nil {
+			return fmt.Errorf("%s %s: %w", c.Property, c.Op, err)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", c.Op)
+	}
+	return nil
+}
+
+func (a And) RainerScript() string { return joinExprs(a, " and ", Expr.RainerScript) }
+func (a And) String() string       { return joinExprs(a, " && ", Expr.String) }
+func (a And) validate() error      { return validateAll("and", a) }
+
+func (o Or) RainerScript() string { return joinExprs(o, " or ", Expr.RainerScript) }
+func (o Or) String() string       { return joinExprs(o, " || ", Expr.String) }
+func (o Or) validate() error      { return validateAll("or", o) }
+
+// RainerScript parenthesises X: not binds tighter than comparisons.
+func (n Not) RainerScript() string { return "not (" + n.X.RainerScript() + ")" }
+func (n Not) String() string       { return "!(" + n.X.String() + ")" }
+
+func (n Not) validate() error {
+	if n.X == nil {
+		return errors.New("not without an expression")
+	}
+	return n.X.validate()
+}
+
+// joinExprs renders exprs with sep, parenthesising nested and/or lists:
+// RainerScript gives and and or the same precedence.
+func joinExprs[T ~[]Expr](exprs T, sep string, render func(Expr) string) string {
+	if len(exprs) == 1 {
+		return render(exprs[0])
+	}
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = render(e)
+		switch e.(type) {
+		case And, Or:
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func validateAll(kind string, exprs []Expr) error {
+	if len(exprs) == 0 {
+		return fmt.Errorf("empty %s", kind)
+	}
+	for _, e := range exprs {
+		if e == nil {
+			return fmt.Errorf("nil expression in %s", kind)
+		}
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quote renders s as a double-quoted string with backslash escapes, the
+// syntax of both RainerScript and --when.
+func quote(s string) string {
+	return `"` + escapeQuotes(s) + `"`
+}
+
+// usesJSON reports whether e compares a JSON field.
+func usesJSON(e Expr) bool {
+	switch e := e.(type) {
+	case Comparison:
+		return e.Property.IsJSON()
+	case And:
+		for _, x := range e {
+			if usesJSON(x) {
+				return true
+			}
+		}
+	case Or:
+		for _, x := range e {
+			if usesJSON(x) {
+				return true
+			}
+		}
+	case Not:
+		return usesJSON(e.X)
+	}
+	return false
+}
+
+// messageLiterals returns the strings a message must contain for e to
+// match through a positive $msg comparison, for detection keywords.
+func messageLiterals(e Expr) []string {
+	var out []string
+	switch e := e.(type) {
+	case Comparison:
+		if e.Property == PropMsg && e.Value != "" && (e.Op == OpContains || e.Op == OpStartsWith || e.Op == OpIsEqual) {
+			out = append(out, e.Value)
+		}
+	case And:
+		for _, x := range e {
+			out = append(out, messageLiterals(x)...)
+		}
+	case Or:
+		for _, x := range e {
+			out = append(out, messageLiterals(x)...)
+		}
+	}
+	return out
+}
+
+// ParseWhen parses a --when expression such as
+//
+//	programname==sshd && msg~"Invalid user"
+//
+// Comparisons are property, operator and value: == (isequal), != (not
+// isequal), ~ (re_match), contains or *=, startswith or ^=, and ereregex.
+// Values are double- or single-quoted with backslash escapes, or a bare
+// word. They combine with && (and), || (or), ! (not) and parentheses; &&
+// binds tighter than ||.
+func ParseWhen(s string) (Expr, error) {
+	p := &whenParser{src: s}
+	e, err := p.or()
+	if err == nil && !p.eof() {
+		err = p.errorf("unexpected %q", p.src[p.off:])
+	}
+	if err == nil {
+		err = e.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--when: %w", err)
+	}
+	return e, nil
+}
+
+type whenParser struct {
+	src string
+	off int
+}
+
+func (p *whenParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", p.off, fmt.Sprintf(format, args...))
+}
+
+func (p *whenParser) skipSpace() {
+	for p.off < len(p.src) && unicode.IsSpace(rune(p.src[p.off])) {
+		p.off++
+	}
+}
+
+func (p *whenParser) eof() bool {
+	p.skipSpace()
+	return p.off >= len(p.src)
+}
+
+// accept consumes one of tokens; words only match whole words.
+func (p *whenParser) accept(tokens ...string) bool {
+	p.skipSpace()
+	for _, tok := range tokens {
+		if !strings.HasPrefix(p.src[p.off:], tok) {
+			continue
+		}
+		end := p.off + len(tok)
+		if isWordByte(tok[0]) && end < len(p.src) && isWordByte(p.src[end]) {
+			continue
+		}
+		p.off = end
+		return true
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *whenParser) or() (Expr, error) {
+	var list Or
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.accept("||", "or") {
+			break
+		}
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return list, nil
+}
+
+func (p *whenParser) and() (Expr, error) {
+	var list And
+	for {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.accept("&&", "and") {
+			break
+		}
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return list, nil
+}
+
+func (p *whenParser) unary() (Expr, error) {
+	if p.accept("not") || p.acceptBang() {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{X: e}, nil
+	}
+	if p.accept("(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+		return e, nil
+	}
+	return p.comparison()
+}
+
+// acceptBang consumes a ! that negates, as opposed to the ! of != or of a
+// $! JSON field.
+func (p *whenParser) acceptBang() bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.off:], "!") && !strings.HasPrefix(p.src[p.off:], "!=") {
+		p.off++
+		return true
+	}
+	return false
+}
+
+func (p *whenParser) comparison() (Expr, error) {
+	p.skipSpace()
+	start := p.off
+	if strings.HasPrefix(p.src[p.off:], "$") {
+		p.off++
+	}
+	for p.off < len(p.src) {
+		c := p.src[p.off]
+		if c == '!' && !strings.HasPrefix(p.src[p.off:], "!=") || isWordByte(c) {
+			p.off++
+			continue
+		}
+		break
+	}
+	if p.off == start {
+		return nil, p.errorf("expected a property")
+	}
+	prop, err := ParseProperty(p.src[start:p.off])
+	if err != nil {
+		return nil, err
+	}
+	negate := p.accept("!=")
+	op := OpIsEqual
+	if !negate {
+		found := false
+		for _, w := range whenOps {
+			if p.accept(w.token) {
+				op, found = w.op, true
+				break
+			}
+		}
+		if !found {
+			return nil, p.errorf("expected an operator after %s", prop.when())
+		}
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	var e Expr = Comparison{Property: prop, Op: op, Value: value}
+	if negate {
+		e = Not{X: e}
+	}
+	return e, nil
+}
+
+func (p *whenParser) value() (string, error) {
+	p.skipSpace()
+	if p.off >= len(p.src) {
+		return "", p.errorf("expected a value")
+	}
+	if q := p.src[p.off]; q == '"' || q == '\'' {
+		var b strings.Builder
+		for i := p.off + 1; i < len(p.src); i++ {
+			switch c := p.src[i]; {
+			case c == '\\' && i+1 < len(p.src) && (p.src[i+1] == '"' || p.src[i+1] == '\\' || p.src[i+1] == q):
+				// Other backslashes are kept: they escape regex
+				// metacharacters.
+				i++
+				b.WriteByte(p.src[i])
+			case c == q:
+				p.off = i + 1
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", p.errorf("unterminated string")
+	}
+	start := p.off
+	for p.off < len(p.src) && !unicode.IsSpace(rune(p.src[p.off])) && !strings.ContainsRune("()&|", rune(p.src[p.off])) {
+		p.off++
+	}
+	if p.off == start {
+		return "", p.errorf("expected a value")
+	}
+	return p.src[start:p.off], nil
+}
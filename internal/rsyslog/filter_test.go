@@ -0,0 +1,186 @@
+package rsyslog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWhen(t *testing.T) {
+	e, err := ParseWhen(`programname==sshd && msg~"Invalid user"`)
+	if err != nil {
+		t.Fatalf("ParseWhen: %v", err)
+	}
+	want := And{
+		Comparison{Property: PropProgramName, Op: OpIsEqual, Value: "sshd"},
+		Comparison{Property: PropMsg, Op: OpReMatch, Value: "Invalid user"},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("got %#v", e)
+	}
+	if got := e.RainerScript(); got != `$programname == "sshd" and re_match($msg, "Invalid user")` {
+		t.Fatalf("RainerScript: %s", got)
+	}
+}
+
+func TestParseWhenPrecedenceAndNegation(t *testing.T) {
+	e, err := ParseWhen(`$hostname startswith web- || not (severity == debug) and $fromhost-ip != '127.0.0.1'`)
+	if err != nil {
+		t.Fatalf("ParseWhen: %v", err)
+	}
+	want := Or{
+		Comparison{Property: PropHostname, Op: OpStartsWith, Value: "web-"},
+		And{
+			Not{X: Comparison{Property: PropSeverity, Op: OpIsEqual, Value: "debug"}},
+			Not{X: Comparison{Property: PropFromHostIP, Op: OpIsEqual, Value: "127.0.0.1"}},
+		},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("got %#v", e)
+	}
+	// and and or share a precedence in RainerScript, so the and is grouped.
+	want2 := `$hostname startswith "web-" or (not ($syslogseverity-text == "debug") and not ($fromhost-ip == "127.0.0.1"))`
+	if got := e.RainerScript(); got != want2 {
+		t.Fatalf("RainerScript:\n got %s\nwant %s", got, want2)
+	}
+}
+
+func TestWhenRoundTrip(t *testing.T) {
+	exprs := []Expr{
+		Comparison{Property: PropMsg, Op: OpContains, Value: `say "hi" \o/`},
+		Comparison{Property: PropSyslogTag, Op: OpStartsWith, Value: "sshd["},
+		Comparison{Property: PropFacility, Op: OpIsEqual, Value: "authpriv"},
+		Comparison{Property: JSONField("user!name"), Op: OpEreRegex, Value: `^adm(in)?$`},
+		Comparison{Property: JSONField(""), Op: OpContains, Value: "token"},
+		Not{X: Or{
+			Comparison{Property: PropProgramName, Op: OpIsEqual, Value: "cron"},
+			Comparison{Property: PropMsg, Op: OpReMatch, Value: `session (opened|closed)`},
+		}},
+		And{
+			Or{Comparison{Property: PropHostname, Op: OpIsEqual, Value: "a"}, Comparison{Property: PropHostname, Op: OpIsEqual, Value: "b"}},
+			And{Comparison{Property: PropMsg, Op: OpContains, Value: "x"}, Comparison{Property: PropMsg, Op: OpContains, Value: "y"}},
+			Comparison{Property: PropMsg, Op: OpIsEqual, Value: ""},
+		},
+	}
+	for _, e := range exprs {
+		back, err := ParseWhen(e.String())
+		if err != nil {
+			t.Fatalf("ParseWhen(%s): %v", e, err)
+		}
+		if !reflect.DeepEqual(back, e) {
+			t.Fatalf("round trip of %s:\n got %#v\nwant %#v", e, back, e)
+		}
+	}
+}
+
+func TestParseWhenKeepsRegexEscapes(t *testing.T) {
+	for when, want := range map[string]string{
+		`msg ~ "a\.b"`:          `a\.b`,
+		`msg ~ 'a\.b\'c'`:       `a\.b'c`,
+		`msg ~ "\"q\" \\."`:     `"q" \.`,
+		`msg ereregex "^\[x\]"`: `^\[x\]`,
+	} {
+		e, err := ParseWhen(when)
+		if err != nil {
+			t.Fatalf("ParseWhen(%s): %v", when, err)
+		}
+		c, ok := e.(Comparison)
+		if !ok || c.Value != want {
+			t.Fatalf("ParseWhen(%s) = %#v, want value %q", when, e, want)
+		}
+		back, err := ParseWhen(e.String())
+		if err != nil || !reflect.DeepEqual(back, e) {
+			t.Fatalf("round trip of %s: %#v, %v", e, back, err)
+		}
+		if got := e.RainerScript(); !strings.Contains(got, quote(want)) {
+			t.Fatalf("%s rendered as %s", when, got)
+		}
+	}
+}
+
+func TestWhenRenderedConfigRoundTrip(t *testing.T) {
+	for _, when := range []string{
+		`programname==sshd && msg~"Invalid user"`,
+		`msg contains "then" || !(msg ^= '(')`,
+		`$!event!type == login && $!user ereregex "^r..t$"`,
+	} {
+		filter, err := ParseWhen(when)
+		if err != nil {
+			t.Fatalf("ParseWhen(%s): %v", when, err)
+		}
+		for _, useRuleset := range []bool{true, false} {
+			params := ConfigParams{
+				InputFile: "/var/log/auth.log", Tag: "sshd", Filter: filter,
+				ProgramPath: "/bin/cat", UseRuleset: useRuleset, RulesetName: "event_router",
+			}
+			cfg, err := RenderConfig(params)
+			if err != nil {
+				t.Fatalf("RenderConfig(%s): %v", when, err)
+			}
+			stmts, err := ParseConfig("99.conf", []byte(cfg))
+			if err != nil {
+				t.Fatalf("rendered config does not parse: %v\n%s", err, cfg)
+			}
+			var cond string
+			Walk(stmts, func(s *Statement, _ []*Statement) bool {
+				if s.Kind == KindIf {
+					cond = s.Filter
+				}
+				return true
+			})
+			if cond != filter.RainerScript() {
+				t.Fatalf("condition %q, want %q", cond, filter.RainerScript())
+			}
+			if json := strings.Contains(cfg, `action(type="mmjsonparse"`); json != strings.Contains(when, "$!") {
+				t.Fatalf("mmjsonparse action present=%v for %s:\n%s", json, when, cfg)
+			}
+		}
+	}
+}
+
+func TestConditionCombinesLegacyFilters(t *testing.T) {
+	p := ConfigParams{Tag: "access", FilterByTag: true, FilterContains: "a", FilterRegex: "b+"}
+	want := `$syslogtag contains "access" and ($msg contains "a" or re_match($msg, "b+"))`
+	if got := p.Condition().RainerScript(); got != want {
+		t.Fatalf("got %s", got)
+	}
+	p.FilterByTag, p.FilterRegex = false, ""
+	if got := p.Condition().RainerScript(); got != `$msg contains "a"` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	for _, when := range []string{
+		"",
+		"uid == 0",
+		"msg contains",
+		`msg ~ "("`,
+		"(msg == a",
+		"msg == a) ",
+		"msg == a &&",
+		`msg contains "open`,
+		"msg startswith ''",
+		"$!user! == x",
+		"msg => a",
+	} {
+		if e, err := ParseWhen(when); err == nil {
+			t.Errorf("%q parsed as %s", when, e)
+		}
+	}
+}
+
+func TestOmprogModuleWhenReplacesDefaultTrigger(t *testing.T) {
+	m := NewOmprogModule()
+	m.in, m.payload, m.trigger, m.when = "/var/log/auth.log", "/bin/cat", "uhtavi0", `msg~"Invalid user"`
+	cfg, err := m.render(m.params)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	mustContain(t, cfg, `if $syslogtag contains "access" and re_match($msg, "Invalid user") then {`)
+
+	m.when = "msg ~"
+	if _, err := m.render(m.params); err == nil || !strings.Contains(err.Error(), "--when") {
+		t.Fatalf("expected a --when error, got %v", err)
+	}
+}
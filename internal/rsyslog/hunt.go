@@ -0,0 +1,134 @@
+package rsyslog
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// huntMatch inspects one statement; legacyBinary is the most recent
+// $ActionOMProgBinary seen in load order. It returns the program the
+// statement runs and a summary prefix.
+type huntMatch func(s *Statement, legacyBinary string) (program, summary string, ok bool)
+
+// huntConfig reports the statements match accepts in the effective
+// configuration loaded from rsyslog.conf under root, rated by rate. Matches in rulesets that
+// never receive messages, and in rsyslog.d drop-ins rsyslog.conf does not
+// load, are rated low.
+func huntConfig(root, technique string, match huntMatch, rate func(program string) hunt.Severity) hunt.Report {
+	var r hunt.Report
+	cfg, err := LoadConfig(root, DefaultShellConfigPath)
+	dormant := true
+	if err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("%s: %v; drop-ins in %s scanned as if loaded", DefaultShellConfigPath, err, DefaultConfigDir))
+		cfg = &Config{}
+		dormant = false
+	}
+	for _, e := range cfg.Errors {
+		r.Notes = append(r.Notes, e.Error())
+	}
+
+	bound := cfg.BoundRulesets()
+	report := func(stmts []*Statement, why string) {
+		legacyBinary := ""
+		Walk(stmts, func(s *Statement, enclosing []*Statement) bool {
+			if s.Kind == KindDirective && strings.EqualFold(s.Name, "ActionOMProgBinary") {
+				legacyBinary = s.Value
+			}
+			program, summary, ok := match(s, legacyBinary)
+			if !ok {
+				return true
+			}
+			sev := rate(program)
+			note := why
+			if rs := enclosingRuleset(enclosing); note == "" && rs != "" && !bound[rs] {
+				note = fmt.Sprintf("ruleset %s is never bound or called", rs)
+			}
+			if note != "" {
+				sev = hunt.Low
+				summary += " (" + note + ")"
+			}
+			evidence := s.Text
+			if n := len(enclosing); n > 0 && enclosing[n-1].Kind == KindRule {
+				evidence = enclosing[n-1].Text
+			}
+			r.Findings = append(r.Findings, hunt.Finding{
+				Technique: technique,
+				Severity:  sev,
+				Path:      s.Pos.File,
+				Line:      s.Pos.Line,
+				Summary:   summary,
+				Evidence:  evidence,
+			})
+			return true
+		})
+	}
+	report(cfg.Statements, "")
+
+	why := ""
+	if dormant {
+		why = "not loaded by " + DefaultShellConfigPath
+	}
+	for _, path := range hunt.Glob(root, DefaultConfigDir+"/*.conf") {
+		if slices.Contains(cfg.Files, path) {
+			continue
+		}
+		data, err := os.ReadFile(hunt.HostPath(root, path))
+		if err != nil {
+			r.Notes = append(r.Notes, fmt.Sprintf("read %s: %v", path, err))
+			continue
+		}
+		stmts, err := ParseConfig(path, data)
+		if err != nil {
+			r.Notes = append(r.Notes, err.Error())
+		}
+		report(stmts, why)
+	}
+	return r
+}
+
+// HuntShell reports every legacy "^program" shell execute action in the
+// rsyslog configuration under root.
+func HuntShell(root string) hunt.Report {
+	return huntConfig(root, "rsyslog", func(s *Statement, _ string) (string, string, bool) {
+		program, ok := shellProgram(s)
+		return program, "rsyslog shell execute action runs " + program, ok
+	}, func(string) hunt.Severity { return hunt.High })
+}
+
+// HuntOmprog reports every omprog action, RainerScript or legacy
+// ":omprog:", in the rsyslog configuration under root.
+func HuntOmprog(root string) hunt.Report {
+	return huntConfig(root, "rsyslog-omprog", func(s *Statement, legacyBinary string) (string, string, bool) {
+		var binary string
+		switch {
+		case s.Kind == KindObject && s.Name == "action":
+			if typ, _ := s.Param("type"); !strings.EqualFold(typ, "omprog") {
+				return "", "", false
+			}
+			binary, _ = s.Param("binary")
+		case s.Kind == KindAction && strings.HasPrefix(s.Value, ":omprog:"):
+			binary = legacyBinary
+		default:
+			return "", "", false
+		}
+		if binary == "" {
+			return "", "rsyslog omprog action executes a program", true
+		}
+		return binary, "rsyslog omprog action executes " + binary, true
+	}, programSeverity)
+}
+
+// programSeverity rates an executed program: high when it lives somewhere
+// writable by unprivileged users, medium otherwise.
+func programSeverity(program string) hunt.Severity {
+	for _, dir := range []string{"/tmp/", "/var/tmp/", "/dev/shm/", "/home/", "/run/user/"} {
+		if strings.HasPrefix(program, dir) {
+			return hunt.High
+		}
+	}
+	return hunt.Medium
+}
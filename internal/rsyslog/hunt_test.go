@@ -0,0 +1,116 @@
+package rsyslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/hunt"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestShellProgramVariants(t *testing.T) {
+	src := `:msg, contains, "hacker" ^/usr/bin/x
+:programname, !isequal, "a" ^/opt/p;t1
+*.* ^/tmp/run
+if $msg contains "x" then ^/bin/y
+# *.* ^/bin/commented
+*.* /var/log/messages
+:msg, contains, "^x" /var/log/x
+`
+	stmts, err := ParseConfig("t.conf", []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+	var got []string
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if program, ok := shellProgram(s); ok {
+			got = append(got, fmt.Sprintf("%d:%s", s.Pos.Line, program))
+		}
+		return true
+	})
+	want := []string{"1:/usr/bin/x", "2:/opt/p", "3:/tmp/run", "4:/bin/y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("shell programs = %v, want %v", got, want)
+	}
+}
+
+func TestHuntFindsShellAndOmprogAcrossIncludes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/rsyslog.conf":          "$IncludeConfig /etc/rsyslog.d/*.conf\n$IncludeConfig /etc/rsyslog.custom/*.conf\n:msg, contains, \"hacker\" ^/usr/bin/touch\n",
+		"etc/rsyslog.d/10-a.conf":   "module(load=\"omprog\")\naction(\n  type=\"omprog\"\n  binary=\"/tmp/implant --flag\"\n)\n",
+		"etc/rsyslog.custom/x.conf": "$ActionOMProgBinary /usr/local/bin/ship\n*.* :omprog:\n*.* ^/opt/evil;fmt\n",
+	})
+
+	shell := HuntShell(root)
+	if len(shell.Findings) != 2 {
+		t.Fatalf("expected two shell findings, got %+v", shell.Findings)
+	}
+	// Findings follow load order: included files where they are included.
+	if f := shell.Findings[0]; f.Path != "/etc/rsyslog.custom/x.conf" || f.Line != 3 || f.Severity != hunt.High {
+		t.Fatalf("unexpected included finding %+v", f)
+	}
+	if got := shell.Findings[1].Location(); got != "/etc/rsyslog.conf:3" {
+		t.Fatalf("unexpected location %s", got)
+	}
+
+	omprog := HuntOmprog(root)
+	if len(omprog.Findings) != 2 {
+		t.Fatalf("expected two omprog findings, got %+v", omprog.Findings)
+	}
+	for _, f := range omprog.Findings {
+		switch f.Path {
+		case "/etc/rsyslog.d/10-a.conf":
+			if f.Line != 2 || f.Severity != hunt.High || !strings.Contains(f.Summary, "/tmp/implant --flag") {
+				t.Fatalf("unexpected RainerScript finding %+v", f)
+			}
+		case "/etc/rsyslog.custom/x.conf":
+			if f.Line != 2 || f.Severity != hunt.Medium || !strings.Contains(f.Summary, "/usr/local/bin/ship") {
+				t.Fatalf("unexpected legacy finding %+v", f)
+			}
+		default:
+			t.Fatalf("unexpected finding %+v", f)
+		}
+	}
+}
+
+func TestHuntRatesDormantConfigurationLow(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/rsyslog.conf": "ruleset(name=\"unused\") {\n  action(type=\"omprog\" binary=\"/tmp/a\")\n}\n" +
+			"ruleset(name=\"remote\") {\n  action(type=\"omprog\" binary=\"/tmp/b\")\n}\n" +
+			"input(type=\"imtcp\" port=\"514\" ruleset=\"remote\")\n",
+		"etc/rsyslog.d/50-x.conf": "*.* ^/tmp/c\n",
+	})
+
+	omprog := HuntOmprog(root)
+	got := map[string]hunt.Severity{}
+	for _, f := range omprog.Findings {
+		got[f.Location()] = f.Severity
+	}
+	want := map[string]hunt.Severity{"/etc/rsyslog.conf:2": hunt.Low, "/etc/rsyslog.conf:5": hunt.High}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("omprog findings = %+v", omprog.Findings)
+	}
+
+	shell := HuntShell(root)
+	if len(shell.Findings) != 1 || shell.Findings[0].Severity != hunt.Low || !strings.Contains(shell.Findings[0].Summary, "not loaded by /etc/rsyslog.conf") {
+		t.Fatalf("unexpected dormant drop-in finding %+v", shell.Findings)
+	}
+}
@@ -0,0 +1,197 @@
+package rsyslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Config is an rsyslog configuration with its includes resolved: the
+// statements of an include are the Body of the $IncludeConfig or include()
+// statement that pulls them in.
+type Config struct {
+	// Files lists every file read, in load order, as paths on the host.
+	Files      []string
+	Statements []*Statement
+	// Patterns are the include paths and globs as written, so a file that
+	// does not exist yet can be checked against them.
+	Patterns []string
+	// Errors holds syntax errors and unresolvable includes. rsyslog skips
+	// or rejects the affected statements, so they are not fatal here.
+	Errors []error
+}
+
+// LoadConfig parses path, as seen on the host whose filesystem is mounted at
+// root, and every file it includes. It fails only when path itself cannot be
+// read.
+func LoadConfig(root, path string) (*Config, error) {
+	l := &loader{root: root, cfg: &Config{}, active: make(map[string]bool)}
+	stmts, err := l.file(path)
+	if err != nil {
+		return nil, err
+	}
+	l.cfg.Statements = stmts
+	return l.cfg, nil
+}
+
+// Walk walks every statement of the configuration; see Walk.
+func (c *Config) Walk(fn func(s *Statement, enclosing []*Statement) bool) {
+	Walk(c.Statements, fn)
+}
+
+// Includes reports whether rsyslog loads path: it was read while loading, or
+// an include pattern matches it, as for a drop-in about to be written.
+func (c *Config) Includes(path string) bool {
+	if slices.Contains(c.Files, path) {
+		return true
+	}
+	for _, pattern := range c.Patterns {
+		// A pattern naming a directory includes every file in it.
+		if filepath.Dir(path) == filepath.Clean(pattern) {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundRulesets returns the names of rulesets that receive messages: those
+// bound to an input or module, called with "call", or bound by a legacy
+// "$...BindRuleset" directive. The default ruleset is always included.
+func (c *Config) BoundRulesets() map[string]bool {
+	bound := map[string]bool{"RSYSLOG_DefaultRuleset": true}
+	c.Walk(func(s *Statement, _ []*Statement) bool {
+		switch s.Kind {
+		case KindObject:
+			if s.Name != "ruleset" {
+				if name, ok := s.Param("ruleset"); ok {
+					bound[name] = true
+				}
+			}
+		case KindStatement:
+			if s.Name == "call" {
+				bound[s.Value] = true
+			}
+		case KindDirective:
+			if strings.HasSuffix(strings.ToLower(s.Name), "bindruleset") {
+				bound[s.Value] = true
+			}
+		}
+		return true
+	})
+	return bound
+}
+
+// enclosingRuleset returns the name of the innermost ruleset() among
+// enclosing, or "" for the default ruleset.
+func enclosingRuleset(enclosing []*Statement) string {
+	for i := len(enclosing) - 1; i >= 0; i-- {
+		if s := enclosing[i]; s.Kind == KindObject && s.Name == "ruleset" {
+			name, _ := s.Param("name")
+			return name
+		}
+	}
+	return ""
+}
+
+type loader struct {
+	root string
+	cfg  *Config
+	// active holds the files being loaded, to stop include cycles.
+	active map[string]bool
+}
+
+func (l *loader) file(path string) ([]*Statement, error) {
+	if l.active[path] {
+		return nil, fmt.Errorf("include cycle through %s", path)
+	}
+	data, err := os.ReadFile(filepath.Join(l.root, path))
+	if err != nil {
+		return nil, err
+	}
+	l.active[path] = true
+	defer delete(l.active, path)
+	if !slices.Contains(l.cfg.Files, path) {
+		l.cfg.Files = append(l.cfg.Files, path)
+	}
+
+	stmts, err := ParseConfig(path, data)
+	if err != nil {
+		l.cfg.Errors = append(l.cfg.Errors, err)
+	}
+	l.resolve(stmts)
+	return stmts, nil
+}
+
+// resolve loads the includes among stmts into their Body.
+func (l *loader) resolve(stmts []*Statement) {
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		switch {
+		case s.Kind == KindDirective && strings.EqualFold(s.Name, "IncludeConfig"):
+			s.Body = l.include(s, s.Value, false)
+			return false
+		case s.Kind == KindObject && s.Name == "include":
+			if text, ok := s.Param("text"); ok {
+				body, err := parseConfig(s.Pos.File, text, s.Pos.Line-1)
+				if err != nil {
+					l.cfg.Errors = append(l.cfg.Errors, err)
+				}
+				l.resolve(body)
+				s.Body = body
+				return false
+			}
+			file, _ := s.Param("file")
+			mode, _ := s.Param("mode")
+			s.Body = l.include(s, file, strings.EqualFold(mode, "optional"))
+			return false
+		}
+		return true
+	})
+}
+
+// include loads the files matching pattern, a path, glob or directory
+// (trailing slash), in name order.
+func (l *loader) include(s *Statement, pattern string, optional bool) []*Statement {
+	if pattern == "" {
+		l.cfg.Errors = append(l.cfg.Errors, fmt.Errorf("%s: include without a file", s.Pos))
+		return nil
+	}
+	l.cfg.Patterns = append(l.cfg.Patterns, pattern)
+
+	var paths []string
+	if info, err := os.Stat(filepath.Join(l.root, pattern)); err == nil && info.IsDir() {
+		entries, _ := os.ReadDir(filepath.Join(l.root, pattern))
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				paths = append(paths, filepath.Join(pattern, e.Name()))
+			}
+		}
+	} else {
+		matches, _ := filepath.Glob(filepath.Join(l.root, pattern))
+		for _, m := range matches {
+			rel, err := filepath.Rel(l.root, m)
+			if err != nil {
+				continue
+			}
+			paths = append(paths, "/"+filepath.ToSlash(rel))
+		}
+	}
+	if len(paths) == 0 && !optional && !strings.ContainsAny(pattern, "*?[") {
+		l.cfg.Errors = append(l.cfg.Errors, fmt.Errorf("%s: include %s: no such file", s.Pos, pattern))
+	}
+
+	var body []*Statement
+	for _, path := range paths {
+		stmts, err := l.file(path)
+		if err != nil {
+			l.cfg.Errors = append(l.cfg.Errors, fmt.Errorf("%s: include %s: %w", s.Pos, path, err))
+			continue
+		}
+		body = append(body, stmts...)
+	}
+	return body
+}
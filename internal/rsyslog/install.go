@@ -1,11 +1,15 @@
 package rsyslog
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
+
+	"nixpersist/internal/txn"
 )
 
 const (
@@ -14,10 +18,26 @@ const (
 	DefaultConfigName = "99-nixpersist.conf"
 )
 
+// configRoot is where the live rsyslog configuration is read from when
+// checking which files rsyslog loads.
+var configRoot = "/"
+
+// healthDelay is how long to wait after a reload before confirming rsyslogd
+// is still running; a fatal config error makes it exit shortly after start.
+var healthDelay = time.Second
+
 // Install writes the provided rsyslog configuration to /etc/rsyslog.d and
-// reloads (or restarts) rsyslog.
+// reloads (or restarts) rsyslog. If the reload or the follow-up health check
+// fails, the previous drop-in is restored and rsyslog is reloaded again.
 // Requires root privileges.
 func Install(cfg string) error {
+	return InstallDropIn(cfg, filepath.Join(DefaultConfigDir, DefaultConfigName))
+}
+
+// InstallDropIn is Install for the drop-in dest. The module() statements in
+// loads are added to the modules drop-in next to dest, unless it or the host
+// configuration loads them already; cfg is then rendered without them.
+func InstallDropIn(cfg, dest string, loads ...string) error {
 	if os.Geteuid() != 0 {
 		return errors.New("installer: root privileges required (run with sudo)")
 	}
@@ -26,17 +46,37 @@ func Install(cfg string) error {
 	}
 
 	// Ensure target directory exists
-	if err := os.MkdirAll(DefaultConfigDir, 0755); err != nil {
-		return fmt.Errorf("create %s: %w", DefaultConfigDir, err)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(dest), err)
 	}
 
-	dest := filepath.Join(DefaultConfigDir, DefaultConfigName)
-	if err := os.WriteFile(dest, []byte(cfg), 0644); err != nil {
-		return fmt.Errorf("write config to %s: %w", dest, err)
+	warnNotLoaded(dest)
+	var tx txn.Txn
+	// Registered first so it runs last, once the file has been restored.
+	tx.OnRollback(reloadRsyslog)
+	if len(loads) > 0 {
+		before, after, notes, err := stageModules(modulesFile(dest), loads)
+		if err != nil {
+			return err
+		}
+		for _, note := range notes {
+			fmt.Fprintf(warnOut, "warning: %s\n", note)
+		}
+		if !bytes.Equal(before, after) {
+			if err := tx.WriteFile(modulesFile(dest), after, 0644); err != nil {
+				return fmt.Errorf("write modules: %w", err)
+			}
+		}
+	}
+	if err := tx.WriteFile(dest, []byte(cfg), 0644); err != nil {
+		return tx.Rollback(fmt.Errorf("write config: %w", err))
 	}
 
 	if err := reloadRsyslog(); err != nil {
-		return fmt.Errorf("reload rsyslog: %w", err)
+		return tx.Rollback(fmt.Errorf("reload rsyslog: %w", err))
+	}
+	if err := checkRsyslogHealthy(); err != nil {
+		return tx.Rollback(err)
 	}
 
 	return nil
@@ -45,11 +85,16 @@ func Install(cfg string) error {
 // Remove deletes the NixPersist drop-in configuration and reloads rsyslog.
 // Requires root privileges.
 func Remove() error {
+	return RemoveDropIn(filepath.Join(DefaultConfigDir, DefaultConfigName))
+}
+
+// RemoveDropIn is Remove for the drop-in dest. The modules drop-in goes
+// with the last NixPersist drop-in that used it.
+func RemoveDropIn(dest string) error {
 	if os.Geteuid() != 0 {
 		return errors.New("remove: root privileges required (run with sudo)")
 	}
 
-	dest := filepath.Join(DefaultConfigDir, DefaultConfigName)
 	if _, err := os.Stat(dest); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return fmt.Errorf("remove: %s not present", dest)
@@ -57,9 +102,15 @@ func Remove() error {
 		return fmt.Errorf("remove: stat %s: %w", dest, err)
 	}
 
+	modules, orphaned := orphanedModules(dest)
 	if err := os.Remove(dest); err != nil {
 		return fmt.Errorf("remove: delete %s: %w", dest, err)
 	}
+	if orphaned {
+		if err := os.Remove(modules); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove: delete %s: %w", modules, err)
+		}
+	}
 
 	if err := reloadRsyslog(); err != nil {
 		return fmt.Errorf("reload rsyslog after removal: %w", err)
@@ -68,6 +119,22 @@ func Remove() error {
 	return nil
 }
 
+// loadNote explains that rsyslog would ignore path, or returns "" when
+// rsyslog.conf or one of its includes loads it, or cannot be read.
+func loadNote(path string) string {
+	cfg, err := LoadConfig(configRoot, DefaultShellConfigPath)
+	if err != nil || cfg.Includes(path) {
+		return ""
+	}
+	return fmt.Sprintf("%s is not loaded by %s or its includes; rsyslog would ignore it", path, DefaultShellConfigPath)
+}
+
+func warnNotLoaded(path string) {
+	if note := loadNote(path); note != "" {
+		fmt.Fprintf(warnOut, "warning: %s\n", note)
+	}
+}
+
 func reloadRsyslog() error {
 	// Reload or restart rsyslog to apply changes
 	if hasSystemctl() {
@@ -93,3 +160,24 @@ func reloadRsyslog() error {
 
 	return errors.New("could not find a method to reload rsyslog (systemctl or service not available)")
 }
+
+// checkRsyslogHealthy confirms rsyslog is still running after a reload.
+func checkRsyslogHealthy() error {
+	time.Sleep(healthDelay)
+	var r Result
+	if !checkRsyslogRunning(&r) {
+		return errors.New("health check: rsyslog is not running after reload")
+	}
+	return nil
+}
+
+// reloadPlan describes the commands reloadRsyslog would run on this host.
+func reloadPlan() []string {
+	if hasSystemctl() {
+		return []string{"systemctl reload rsyslog (falls back to systemctl restart rsyslog)"}
+	}
+	if _, err := exec.LookPath("service"); err == nil {
+		return []string{"service rsyslog reload (falls back to service rsyslog restart)"}
+	}
+	return []string{"reload rsyslog: no systemctl or service found, install would fail here"}
+}
@@ -0,0 +1,182 @@
+package rsyslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// DefaultInstance is the instance installed without --instance. It keeps
+// the file names, and the unmarked rsyslog.conf line, of earlier releases.
+const DefaultInstance = "default"
+
+var instancePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// ValidateInstance checks an --instance name.
+func ValidateInstance(name string) error {
+	if !instancePattern.MatchString(name) {
+		return fmt.Errorf("instance must be 1-32 letters, digits, - or _ starting with a letter or digit, got %q", name)
+	}
+	return nil
+}
+
+// instanceFile returns the drop-in file name of instance: base for the
+// default instance, base with "@name" before its extension otherwise.
+func instanceFile(base, instance string) string {
+	if instance == "" || instance == DefaultInstance {
+		return base
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "@" + instance + ext
+}
+
+// instanceOf returns the instance whose drop-in named after base is file.
+func instanceOf(base, file string) (string, bool) {
+	if file == base {
+		return DefaultInstance, true
+	}
+	ext := filepath.Ext(base)
+	name, ok := strings.CutPrefix(file, strings.TrimSuffix(base, ext)+"@")
+	if !ok {
+		return "", false
+	}
+	name, ok = strings.CutSuffix(name, ext)
+	return name, ok && instancePattern.MatchString(name)
+}
+
+// instanceMarker is the comment above a named instance's ^ line in
+// rsyslog.conf.
+func instanceMarker(name string) string { return "# nixpersist instance " + name }
+
+// Instance is a trigger NixPersist planted in the rsyslog configuration.
+type Instance struct {
+	Name string `json:"name"`
+	Form Form   `json:"form"`
+	Pos  Pos    `json:"pos"`
+	// Filter is the condition the trigger fires on.
+	Filter  string `json:"filter"`
+	Program string `json:"program"`
+}
+
+func (i Instance) String() string {
+	return fmt.Sprintf("%s (%s) %s: %s runs %s", i.Name, i.Form, i.Pos, i.Filter, i.Program)
+}
+
+// RenderInstances lists instances for --list.
+func RenderInstances(instances []Instance) string {
+	if len(instances) == 0 {
+		return "no NixPersist instances installed\n"
+	}
+	b := &strings.Builder{}
+	for _, i := range instances {
+		fmt.Fprintf(b, "- %s\n", i)
+	}
+	return b.String()
+}
+
+// OmprogInstances returns the rsyslog-omprog drop-ins in DefaultConfigDir.
+func OmprogInstances() ([]Instance, error) {
+	return dropInInstances(DefaultConfigName)
+}
+
+// ShellInstances returns the shell module's drop-ins in DefaultConfigDir and
+// its lines in conf.
+func ShellInstances(conf string) ([]Instance, error) {
+	instances, err := dropInInstances(ShellDropInName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(hunt.HostPath(configRoot, conf))
+	if errors.Is(err, os.ErrNotExist) {
+		return instances, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", conf, err)
+	}
+	stmts, _ := ParseConfig(conf, data)
+	lines := strings.Split(string(data), "\n")
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if isShellRule(s) {
+			program, _ := shellProgram(s.Body[0])
+			instances = append(instances, Instance{Name: ruleInstance(lines, s), Form: FormConf, Pos: s.Pos, Filter: s.Filter, Program: program})
+		}
+		return true
+	})
+	return instances, nil
+}
+
+// dropInInstances returns the instances whose drop-ins are named after
+// base, with the ^ rule or omprog action each holds.
+func dropInInstances(base string) ([]Instance, error) {
+	var instances []Instance
+	for _, path := range hunt.Glob(configRoot, DefaultConfigDir+"/*.conf") {
+		name, ok := instanceOf(base, filepath.Base(path))
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(hunt.HostPath(configRoot, path))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		stmts, _ := ParseConfig(path, data)
+		found := false
+		Walk(stmts, func(s *Statement, enclosing []*Statement) bool {
+			if found {
+				return false
+			}
+			inst := Instance{Name: name, Pos: s.Pos}
+			switch {
+			case isShellRule(s):
+				inst.Form, inst.Filter = FormDropIn, s.Filter
+				inst.Program, _ = shellProgram(s.Body[0])
+			case s.Kind == KindObject && s.Name == "action":
+				if typ, _ := s.Param("type"); !strings.EqualFold(typ, "omprog") {
+					return true
+				}
+				inst.Form = FormOmprog
+				inst.Program, _ = s.Param("binary")
+				for _, e := range enclosing {
+					if e.Kind == KindIf {
+						inst.Filter = e.Filter
+					}
+				}
+			default:
+				return true
+			}
+			instances = append(instances, inst)
+			found = true
+			return false
+		})
+		if !found {
+			instances = append(instances, Instance{Name: name, Form: FormDropIn, Pos: Pos{File: path, Line: 1}, Filter: "?", Program: "?"})
+		}
+	}
+	return instances, nil
+}
+
+// ledgerInstance is the instance recorded in the ledger: empty for the
+// default one, as for installs made before instances existed.
+func ledgerInstance(name string) string {
+	if name == DefaultInstance {
+		return ""
+	}
+	return name
+}
+
+// othersInstalled reports whether NixPersist triggers other than instance at
+// dest remain, in drop-ins of either module or in conf.
+func othersInstalled(conf, dest, instance string) bool {
+	shell, _ := ShellInstances(conf)
+	omprog, _ := OmprogInstances()
+	for _, i := range append(shell, omprog...) {
+		if i.Pos.File != dest || i.Name != instance {
+			return true
+		}
+	}
+	return false
+}
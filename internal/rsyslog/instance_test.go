@@ -0,0 +1,221 @@
+package rsyslog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/snapshot"
+	"nixpersist/internal/state"
+)
+
+func TestInstanceFile(t *testing.T) {
+	cases := map[string]string{
+		DefaultInstance: "99-nixpersist.conf",
+		"web":           "99-nixpersist@web.conf",
+		"auth-2":        "99-nixpersist@auth-2.conf",
+	}
+	for name, want := range cases {
+		file := instanceFile(DefaultConfigName, name)
+		if file != want {
+			t.Fatalf("instanceFile(%s) = %s, want %s", name, file, want)
+		}
+		if back, ok := instanceOf(DefaultConfigName, file); !ok || back != name {
+			t.Fatalf("instanceOf(%s) = %s %v", file, back, ok)
+		}
+	}
+	for _, file := range []string{"99-nixpersist-shell.conf", "99-nixpersist-shell@web.conf", "99-nixpersist@.conf", "50-default.conf"} {
+		if name, ok := instanceOf(DefaultConfigName, file); ok {
+			t.Fatalf("%s taken for omprog instance %q", file, name)
+		}
+	}
+	for _, name := range []string{"", "-x", "a/b", "a.b", strings.Repeat("x", 33)} {
+		if ValidateInstance(name) == nil {
+			t.Fatalf("instance %q accepted", name)
+		}
+	}
+}
+
+func TestShellInstancesInOneFile(t *testing.T) {
+	render := func(instance, trigger string) string {
+		cfg, err := RenderShellConfig(ShellConfigParams{Trigger: trigger, Payload: "/bin/" + trigger, Instance: instance})
+		if err != nil {
+			t.Fatalf("RenderShellConfig: %v", err)
+		}
+		return cfg
+	}
+	a, b := render("a", "alpha"), render("b", "beta")
+	if a != "# nixpersist instance a\n:msg, contains, \"alpha\" ^/bin/alpha\n" {
+		t.Fatalf("named instance rendered as %q", a)
+	}
+	content := "*.* /var/log/syslog\n" + render(DefaultInstance, "zero") + a + b
+
+	if got := shellConflict([]byte(content), render("b", "other")); got != "rsyslog shell snippet of instance b" {
+		t.Fatalf("conflict %q", got)
+	}
+	if got := shellConflict([]byte(content), render("c", "other")); got != "" {
+		t.Fatalf("conflict %q", got)
+	}
+
+	out, ok := removeShellDirective([]byte(content), "a", "")
+	if !ok || string(out) != "*.* /var/log/syslog\n:msg, contains, \"zero\" ^/bin/zero\n"+b {
+		t.Fatalf("removing a: %q", out)
+	}
+	out, ok = removeShellDirective(out, DefaultInstance, "")
+	if !ok || string(out) != "*.* /var/log/syslog\n"+b {
+		t.Fatalf("removing the default: %q", out)
+	}
+	if _, ok := removeShellDirective(out, DefaultInstance, ""); ok {
+		t.Fatal("the default instance must not match a named one")
+	}
+	out, ok = removeAnyShellDirective(out)
+	if !ok || string(out) != "*.* /var/log/syslog\n" {
+		t.Fatalf("removing b: %q", out)
+	}
+}
+
+func TestRemovingMissingInstanceKeepsOthers(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "rsyslog.conf")
+	original := "*.* /var/log/syslog\n"
+	cfg, err := RenderShellConfig(ShellConfigParams{Trigger: "alpha", Payload: "/bin/alpha", Instance: "a"})
+	if err != nil {
+		t.Fatalf("RenderShellConfig: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snap, err := snapshot.Take(path)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	installed := []byte(original + cfg)
+	if err := snap.Save(installed); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := os.WriteFile(path, installed, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	removeB := func(content []byte) ([]byte, bool) { return removeShellDirective(content, "b", "") }
+	if _, err := snapshot.RevertOne(path, removeB, removeAnyShellDirective); !errors.Is(err, snapshot.ErrNotFound) {
+		t.Fatalf("removing absent instance b: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(installed) {
+		t.Fatalf("instance a removed along with b: %q", got)
+	}
+}
+
+func TestRemovingDefaultInstanceKeepsAdminRules(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "rsyslog.conf")
+	original := "*.* /var/log/syslog\n:msg, contains, \"error\" ^/usr/local/bin/alert\n"
+	named, err := RenderShellConfig(ShellConfigParams{Trigger: "hacker", Payload: "/bin/true", Instance: "a"})
+	if err != nil {
+		t.Fatalf("RenderShellConfig: %v", err)
+	}
+	p := ShellConfigParams{Trigger: "hacker", Payload: "/bin/true"}
+	cfg, err := RenderShellConfig(p)
+	if err != nil {
+		t.Fatalf("RenderShellConfig: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snap, err := snapshot.Take(path)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	installed := []byte(original + cfg)
+	if err := snap.Save(installed); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// Instance a, with the same rule, is installed ahead of the default.
+	edited := original + named + cfg
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	rule := strings.TrimSpace(cfg)
+	remove := func(content []byte) ([]byte, bool) { return removeShellDirective(content, DefaultInstance, rule) }
+	res, err := snapshot.RevertOne(path, remove, removeNamedShellDirective)
+	if err != nil {
+		t.Fatalf("removing the default instance: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != original+named {
+		t.Fatalf("after removing the default instance: %q", got)
+	}
+	if res.Drift != "" {
+		t.Fatalf("admin rule reported as drift:\n%s", res.Drift)
+	}
+
+	other := func(content []byte) ([]byte, bool) {
+		return removeShellDirective(content, DefaultInstance, ":msg, contains, \"other\" ^/bin/true")
+	}
+	if _, err := snapshot.RevertOne(path, other, removeNamedShellDirective); !errors.Is(err, snapshot.ErrNotFound) {
+		t.Fatalf("removing a default instance with another trigger: %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	root := t.TempDir()
+	orig := configRoot
+	configRoot = root
+	t.Cleanup(func() { configRoot = orig })
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewOmprogModule()
+	m.in, m.payload, m.trigger, m.instance = "/var/log/nginx/access.log", "/opt/web", "GET /x", "web"
+	p, err := m.params()
+	if err != nil {
+		t.Fatalf("params: %v", err)
+	}
+	if p.RulesetName != "event_router_web" || m.dest() != "/etc/rsyslog.d/99-nixpersist@web.conf" {
+		t.Fatalf("instance web: ruleset %s, dest %s", p.RulesetName, m.dest())
+	}
+	cfg, _ := RenderConfig(p)
+	write(m.dest(), cfg)
+	shellCfg, _ := RenderShellConfig(ShellConfigParams{Trigger: "h@x", Payload: "/opt/shell", Form: FormOmprog})
+	write(filepath.Join(DefaultConfigDir, ShellDropInName), shellCfg)
+	conf, _ := RenderShellConfig(ShellConfigParams{Trigger: "ssh", Payload: "/opt/ssh", Instance: "ssh"})
+	write(DefaultShellConfigPath, "*.* /var/log/syslog\n"+conf)
+
+	omprog, err := OmprogInstances()
+	if err != nil || len(omprog) != 1 {
+		t.Fatalf("OmprogInstances = %v, %v", omprog, err)
+	}
+	if got := omprog[0]; got.Name != "web" || got.Program != "/opt/web" || got.Filter != `$syslogtag contains "access" and $msg contains "GET /x"` {
+		t.Fatalf("omprog instance %+v", got)
+	}
+	shell, err := ShellInstances(DefaultShellConfigPath)
+	if err != nil {
+		t.Fatalf("ShellInstances: %v", err)
+	}
+	want := "- default (omprog) /etc/rsyslog.d/99-nixpersist-shell.conf:4: $msg contains \"h@x\" runs /opt/shell\n" +
+		"- ssh (conf) /etc/rsyslog.conf:3: :msg, contains, \"ssh\" runs /opt/ssh\n"
+	if got := RenderInstances(shell); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if !othersInstalled(DefaultShellConfigPath, m.dest(), "web") {
+		t.Fatal("shell instances remain besides web")
+	}
+	if err := os.Remove(filepath.Join(root, DefaultConfigDir, ShellDropInName)); err != nil {
+		t.Fatal(err)
+	}
+	write(DefaultShellConfigPath, "*.* /var/log/syslog\n")
+	if othersInstalled(DefaultShellConfigPath, m.dest(), "web") {
+		t.Fatal("web is the last instance")
+	}
+}
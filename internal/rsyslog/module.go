@@ -0,0 +1,916 @@
+package rsyslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/apparmor"
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/module"
+	"nixpersist/internal/preflight"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+// rsyslogService is the service name recorded in the ledger on reload.
+const rsyslogService = "rsyslog"
+
+// OmprogModule exposes the omprog drop-in, fed by imfile or another input
+// source, as the "rsyslog-omprog" subcommand.
+type OmprogModule struct {
+	manageAppArmor bool
+	appArmorMode   string
+	source         string
+	in             string
+	port           int
+	units          string
+	identifiers    string
+	out            string
+	payload        string
+	payloadArgs    string
+	trigger        string
+	selinuxAVC     bool
+	verify         bool
+	verifyPayload  string
+	verifyTimeout  time.Duration
+
+	tag                 string
+	severity            string
+	facility            string
+	pollingInterval     int
+	ruleset             string
+	filterRegex         string
+	stateFile           string
+	confirmMessages     bool
+	output              string
+	useTransactions     bool
+	forceSingleInstance bool
+	killUnresponsive    string
+	signalOnClose       bool
+	template            string
+	when                string
+	instance            string
+	list                bool
+	flags               *pflag.FlagSet
+}
+
+// The PoC's imfile input settings, the defaults of their flags.
+const (
+	defaultTag             = "access"
+	defaultSeverity        = "info"
+	defaultFacility        = "local6"
+	defaultPollingInterval = 10
+	defaultRuleset         = "event_router"
+)
+
+// NewOmprogModule returns the rsyslog-omprog module.
+func NewOmprogModule() *OmprogModule {
+	return &OmprogModule{
+		tag:             defaultTag,
+		severity:        defaultSeverity,
+		facility:        defaultFacility,
+		pollingInterval: defaultPollingInterval,
+		ruleset:         defaultRuleset,
+		instance:        DefaultInstance,
+		source:          string(SourceFile),
+		port:            DefaultPort,
+	}
+}
+
+func (m *OmprogModule) Name() string { return "rsyslog-omprog" }
+
+func (m *OmprogModule) Describe() string {
+	return "Triggerable rsyslog filter using imfile + omprog drop-in"
+}
+
+func (m *OmprogModule) Flags(fs *pflag.FlagSet) {
+	fs.BoolVar(&m.manageAppArmor, "apparmor", false, "relax the rsyslog AppArmor profile on install and restore its prior mode on remove")
+	fs.StringVar(&m.appArmorMode, "apparmor-mode", "disable", "how --apparmor relaxes the profile: disable (unload, link into disable/) or complain (aa-complain)")
+	fs.StringVar(&m.source, "source", string(SourceFile), "input the trigger is read from: imfile (-l), imjournal (systemd journal), imuxsock (local syslog socket), imtcp or imudp (127.0.0.1:--port)")
+	fs.StringVarP(&m.in, "log-file-in", "l", "/var/log/auth.log", "log file to monitor (imfile)")
+	fs.IntVar(&m.port, "port", DefaultPort, "localhost port of the imtcp and imudp sources")
+	fs.StringVar(&m.units, "unit", "", "comma-separated systemd units whose journal messages may fire the trigger (imjournal; .service is implied)")
+	fs.StringVar(&m.identifiers, "identifier", "", "comma-separated program names (syslog identifiers) whose messages may fire the trigger")
+	fs.StringVarP(&m.out, "outfile", "o", "", "write rendered config to this file (default stdout)")
+	fs.StringVarP(&m.payload, "payload", "p", "/usr/bin/touch /tmp/nixpersist", "payload binary to execute (omprog)")
+	fs.StringVar(&m.payloadArgs, "payload-args", "", "optional arguments for payload binary")
+	fs.StringVarP(&m.trigger, "trigger", "t", "uhtavi0", "message substring to trigger on")
+	fs.StringVar(&m.instance, "instance", DefaultInstance, "name of this trigger, so that several can be installed side by side and removed individually; named instances get their own drop-in and ruleset")
+	fs.BoolVar(&m.list, "list", false, "list the triggers installed on this host instead of rendering")
+	fs.BoolVar(&m.selinuxAVC, "selinux-avc", false, "report SELinux AVC denials for rsyslogd (--check: last 24 hours; --install: since the install)")
+	fs.StringVar(&m.tag, "tag", defaultTag, "syslog tag of the monitored file's messages (imfile Tag)")
+	fs.StringVar(&m.severity, "severity", defaultSeverity, "syslog severity of the monitored file's messages (imfile Severity)")
+	fs.StringVar(&m.facility, "facility", defaultFacility, "syslog facility of the monitored file's messages (imfile Facility)")
+	fs.IntVar(&m.pollingInterval, "polling-interval", defaultPollingInterval, "seconds between imfile polls (imfile PollingInterval; 0 for rsyslog's default)")
+	fs.StringVar(&m.ruleset, "ruleset", defaultRuleset, "name of the ruleset the input is bound to")
+	fs.StringVar(&m.filterRegex, "filter-regex", "", "also trigger when the message matches this POSIX ERE (re_match)")
+	fs.StringVar(&m.when, "when", "", `trigger on a filter expression such as 'programname==sshd && msg~"Invalid user"', replacing -t unless it is given (for imfile, programname is --tag)`)
+	fs.StringVar(&m.stateFile, "state-file", "", "imfile StateFile name (default: rsyslog's generated name)")
+	fs.BoolVar(&m.confirmMessages, "confirm-messages", false, "omprog confirmMessages: the payload must print OK for each message")
+	fs.StringVar(&m.output, "omprog-output", "", "omprog output: file capturing the payload's stdout and stderr")
+	fs.BoolVar(&m.useTransactions, "use-transactions", false, "omprog useTransactions: send messages in BEGIN/COMMIT batches (requires --confirm-messages)")
+	fs.BoolVar(&m.forceSingleInstance, "force-single-instance", false, "omprog forceSingleInstance: one payload process for all workers")
+	fs.StringVar(&m.killUnresponsive, "kill-unresponsive", "", "omprog killUnresponsive: on or off (default: rsyslog's default)")
+	fs.BoolVar(&m.signalOnClose, "signal-on-close", false, "omprog signalOnClose: send SIGTERM to the payload when the action closes")
+	fs.StringVar(&m.template, "template", "", "format of the message on the payload's stdin: a string template such as '%hostname% %msg%\\n', or an existing template's name")
+	fs.BoolVar(&m.verify, "verify", false, "with --install, first prove the trigger fires: plant it with --verify-payload behind a sentinel that records its lineage and exit status, append the trigger to --log-file-in, wait for the sentinel and take the trigger out again")
+	fs.StringVar(&m.verifyPayload, "verify-payload", DefaultVerifyPayload, "harmless command --verify runs in place of the payload")
+	fs.DurationVar(&m.verifyTimeout, "verify-timeout", DefaultVerifyTimeout, "how long --verify waits for the verification payload to run")
+	m.flags = fs
+}
+
+func (m *OmprogModule) Check() (module.Report, error) {
+	res := Check()
+	res.Sources = DetectSources()
+	res.LogFiles = CheckLogFiles(m.in)
+	if cfg, err := m.render(m.params); err != nil {
+		res.Validation = "skipped (" + err.Error() + ")"
+	} else {
+		res.Validation = preflight.Describe(ValidateConfig(cfg))
+	}
+	res.inspectSELinux(m.payload, m.selinuxAVC)
+	return res, nil
+}
+
+// dest returns the instance's drop-in.
+func (m *OmprogModule) dest() string {
+	return filepath.Join(DefaultConfigDir, instanceFile(DefaultConfigName, m.instance))
+}
+
+func (m *OmprogModule) params() (ConfigParams, error) {
+	if err := ValidateInstance(m.instance); err != nil {
+		return ConfigParams{}, err
+	}
+	// Rulesets are global, so each named instance gets its own.
+	ruleset := m.ruleset
+	if ruleset == defaultRuleset && m.instance != DefaultInstance {
+		ruleset += "_" + m.instance
+	}
+	src, err := ParseSource(m.source)
+	if err != nil {
+		return ConfigParams{}, err
+	}
+	trigger := m.trigger
+	var filter Expr
+	if m.when != "" {
+		var err error
+		if filter, err = ParseWhen(m.when); err != nil {
+			return ConfigParams{}, err
+		}
+		if m.flags == nil || !m.flags.Changed("trigger") {
+			// The --verify test message carries only the trigger.
+			if m.verify {
+				return ConfigParams{}, errors.New("--verify with --when requires -t: the test message contains the trigger, which the filter must also match")
+			}
+			trigger = ""
+		}
+	}
+	var units []string
+	for _, unit := range splitList(m.units) {
+		if !strings.Contains(unit, ".") {
+			unit += ".service"
+		}
+		units = append(units, unit)
+	}
+	return ConfigParams{
+		Source:          src,
+		SourceLoaded:    src != SourceFile && hostModules()[string(src)],
+		Port:            m.port,
+		Units:           units,
+		Identifiers:     splitList(m.identifiers),
+		InputFile:       m.in,
+		Tag:             m.tag,
+		Severity:        m.severity,
+		Facility:        m.facility,
+		AddMetadata:     true,
+		PollingInterval: m.pollingInterval,
+		StateFile:       m.stateFile,
+		// Only imfile sets the tag; other sources carry the sender's.
+		FilterByTag:    src == SourceFile,
+		FilterContains: trigger,
+		FilterRegex:    m.filterRegex,
+		Filter:         filter,
+		ProgramPath:    m.payload,
+		ProgramArgs:    m.payloadArgs,
+		// Default ruleset is required for isolation and future expansion.
+		UseRuleset:  true,
+		RulesetName: ruleset,
+
+		ConfirmMessages:     m.confirmMessages,
+		Output:              m.output,
+		UseTransactions:     m.useTransactions,
+		ForceSingleInstance: m.forceSingleInstance,
+		KillUnresponsive:    m.killUnresponsive,
+		SignalOnClose:       m.signalOnClose,
+		Template:            m.template,
+	}, nil
+}
+
+// render renders the parameters params returns.
+func (m *OmprogModule) render(params func() (ConfigParams, error)) (string, error) {
+	p, err := params()
+	if err != nil {
+		return "", err
+	}
+	return RenderConfig(p)
+}
+
+func (m *OmprogModule) Render() (string, error) {
+	if m.manageAppArmor {
+		return "", errors.New("--apparmor requires --install or --remove")
+	}
+	if m.list {
+		instances, err := OmprogInstances()
+		if err != nil {
+			return "", err
+		}
+		return RenderInstances(instances), nil
+	}
+	if (m.in == "" && m.inputSource() == SourceFile) || m.payload == "" || (m.trigger == "" && m.filterRegex == "" && m.when == "") {
+		return "", errors.New("rsyslog-omprog render requires -l/--log-file-in (for imfile), -p/--payload, and -t/--trigger, --filter-regex or --when")
+	}
+	cfg, err := m.render(m.params)
+	if err != nil {
+		return "", err
+	}
+	if m.out == "" {
+		return cfg, nil
+	}
+	if err := os.WriteFile(m.out, []byte(cfg), 0644); err != nil {
+		return "", fmt.Errorf("write failed: %w", err)
+	}
+	return "", nil
+}
+
+func (m *OmprogModule) Plan() (module.Plan, error) {
+	p, err := m.params()
+	if err != nil {
+		return module.Plan{}, err
+	}
+	_, cfg, err := renderShared(p)
+	if err != nil {
+		return module.Plan{}, err
+	}
+	dest := m.dest()
+	before, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return module.Plan{}, fmt.Errorf("read %s: %w", dest, err)
+	}
+	plan := module.Plan{
+		Files:    []module.FileEdit{{Path: dest, Before: before, After: []byte(cfg)}},
+		Commands: planCommands(m.manageAppArmor, m.appArmorMode),
+		Notes:    []string{"install recorded in the NixPersist ledger"},
+	}
+	if before != nil {
+		plan.Notes = append(plan.Notes, dest+" already exists: --install would fail (choose another --instance)")
+	}
+	if err := planModules(&plan, dest, p.Modules()); err != nil {
+		return module.Plan{}, err
+	}
+	if note := loadNote(dest); note != "" {
+		plan.Notes = append(plan.Notes, note)
+	}
+	if m.verify {
+		planVerify(&plan, NewSentinel(instanceFile(m.Name(), m.instance)), m.verifyPayload, m.fireDescription(), m.verifyTimeout)
+	}
+	return plan, nil
+}
+
+func (m *OmprogModule) Install() (module.Outcome, error) {
+	p, err := m.params()
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	standalone, cfg, err := renderShared(p)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	dest := m.dest()
+	if exists(dest) {
+		return module.Outcome{}, fmt.Errorf("%s already exists: remove instance %s first or choose another --instance", dest, m.instance)
+	}
+	files, err := moduleChanges(dest, p.Modules())
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	files = append(files, state.Observe(dest))
+	if err := preflight.Gate(ValidateConfig(standalone), os.Stderr); err != nil {
+		return module.Outcome{}, err
+	}
+	rec, err := prepareAppArmor(m.manageAppArmor, m.appArmorMode)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	warnSELinux(m.payload)
+	start := time.Now()
+	var v module.Verification
+	if m.verify {
+		if v, err = m.verifyTrigger(p); err != nil {
+			return module.Outcome{}, fmt.Errorf("verify failed: %w", restoreAppArmor(m.manageAppArmor, rec, err))
+		}
+	}
+	if err := InstallDropIn(cfg, dest, p.Modules()...); err != nil {
+		return module.Outcome{}, fmt.Errorf("install failed: %w", restoreAppArmor(m.manageAppArmor, rec, err))
+	}
+	res := module.Outcome{
+		Message:  fmt.Sprintf("install complete: %s applied and rsyslog reloaded", dest),
+		Files:    files,
+		Services: []string{rsyslogService},
+		Instance: ledgerInstance(m.instance),
+	}
+	if m.manageAppArmor {
+		res.Message += "; AppArmor profile " + rec.String()
+		res.AppArmor = []string{rec.String()}
+	}
+	if m.verify {
+		res.Verification = &v
+		res.Message += "; " + v.Summary()
+	}
+	if m.selinuxAVC {
+		res.Message += selinuxDenials(start)
+	}
+	return res, nil
+}
+
+// verifyTrigger plants the trigger of p with --verify-payload behind the
+// sentinel, fires it and takes it out again, so the payload itself does not
+// run for the check.
+func (m *OmprogModule) verifyTrigger(p ConfigParams) (module.Verification, error) {
+	sentinel := NewSentinel(instanceFile(m.Name(), m.instance))
+	p.ProgramPath, p.ProgramArgs = sentinel.Script, ""
+	// The verification payload neither answers OK nor writes output.
+	p.ConfirmMessages, p.UseTransactions, p.Output = false, false, ""
+	_, cfg, err := renderShared(p)
+	if err != nil {
+		return module.Verification{}, err
+	}
+	// Written first: omprog checks that its binary exists.
+	if err := sentinel.Write(m.verifyPayload); err != nil {
+		return module.Verification{}, err
+	}
+	defer sentinel.Remove()
+	dest := m.dest()
+	if err := InstallDropIn(cfg, dest, p.Modules()...); err != nil {
+		return module.Verification{}, fmt.Errorf("plant verification trigger: %w", err)
+	}
+	v := sentinel.Verify(m.fire, m.verifyTimeout)
+	if err := RemoveDropIn(dest); err != nil {
+		return v, fmt.Errorf("take out verification trigger: %w", err)
+	}
+	return v, nil
+}
+
+// inputSource returns the --source, or "" when it is invalid.
+func (m *OmprogModule) inputSource() Source {
+	src, _ := ParseSource(m.source)
+	return src
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fire sends the trigger through the configured source, for --verify.
+func (m *OmprogModule) fire() (string, error) {
+	tag := verifyTag
+	if ids := splitList(m.identifiers); len(ids) > 0 {
+		tag = ids[0]
+	}
+	switch m.inputSource() {
+	case SourceJournal, SourceSocket:
+		if m.units != "" {
+			fmt.Fprintln(warnOut, "warning: --verify cannot send from a --unit; the test message only fires the trigger if journald attributes it to one")
+		}
+		return fireSyslogAs(tag, m.trigger)
+	case SourceTCP:
+		return fireNet("tcp", m.port, tag, m.trigger)
+	case SourceUDP:
+		return fireNet("udp", m.port, tag, m.trigger)
+	}
+	return fireFile(m.in, m.trigger)
+}
+
+// fireDescription describes fire for --plan.
+func (m *OmprogModule) fireDescription() string {
+	switch src := m.inputSource(); src {
+	case SourceJournal, SourceSocket:
+		return "log a message containing the trigger to " + syslogSocket
+	case SourceTCP, SourceUDP:
+		return fmt.Sprintf("send a message containing the trigger to %s 127.0.0.1:%d", strings.TrimPrefix(string(src), "im"), m.port)
+	}
+	return "append a line containing the trigger to " + m.in
+}
+
+func (m *OmprogModule) Remove() (module.Outcome, error) {
+	var res module.Outcome
+	if err := ValidateInstance(m.instance); err != nil {
+		return res, err
+	}
+	dest := m.dest()
+	var appArmorNote string
+	if m.manageAppArmor {
+		var err error
+		if res.AppArmor, appArmorNote, err = restoreProfile(DefaultShellConfigPath, dest, m.instance); err != nil {
+			return res, err
+		}
+	}
+	files := removeChanges(dest)
+	if err := RemoveDropIn(dest); err != nil {
+		return res, fmt.Errorf("remove failed: %w", err)
+	}
+	res.Message = fmt.Sprintf("remove complete: %s removed and rsyslog reloaded", dest) + appArmorNote
+	res.Files = files
+	res.Services = []string{rsyslogService}
+	res.Instance = ledgerInstance(m.instance)
+	return res, nil
+}
+
+// Detections returns rules for the drop-in the module would install.
+func (m *OmprogModule) Detections() ([]sigma.Rule, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := Detections(params, m.dest())
+	if err != nil {
+		return nil, err
+	}
+	if m.manageAppArmor {
+		mode, err := apparmor.ParseMode(m.appArmorMode)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, AppArmorDetection(mode))
+	}
+	return rules, nil
+}
+
+// AuditRules returns auditd rules for the drop-in and its payload.
+func (m *OmprogModule) AuditRules() ([]audit.Rule, error) {
+	if _, err := m.render(m.params); err != nil {
+		return nil, err
+	}
+	return AuditRules(m.Name(), m.dest(), m.payload), nil
+}
+
+// Hunt reports omprog actions anywhere in the rsyslog configuration.
+func (m *OmprogModule) Hunt(root string) hunt.Report { return HuntOmprog(root) }
+
+// ShellModule exposes the legacy shell-execute filter as the "rsyslog"
+// subcommand.
+type ShellModule struct {
+	manageAppArmor bool
+	appArmorMode   string
+	trigger        string
+	payload        string
+	output         string
+	form           string
+	instance       string
+	list           bool
+	selinuxAVC     bool
+	verify         bool
+	verifyPayload  string
+	verifyTimeout  time.Duration
+	flags          *pflag.FlagSet
+
+	// caps caches DetectCapabilities for --form auto.
+	caps *Capabilities
+}
+
+// NewShellModule returns the rsyslog shell-execute module.
+func NewShellModule() *ShellModule {
+	return &ShellModule{form: string(FormAuto), instance: DefaultInstance}
+}
+
+func (m *ShellModule) Name() string { return "rsyslog" }
+
+func (m *ShellModule) Describe() string {
+	return "Triggerable rsyslog filter (shell execute)"
+}
+
+func (m *ShellModule) Flags(fs *pflag.FlagSet) {
+	fs.BoolVar(&m.manageAppArmor, "apparmor", false, "relax the rsyslog AppArmor profile on install and restore its prior mode on remove")
+	fs.StringVar(&m.appArmorMode, "apparmor-mode", "disable", "how --apparmor relaxes the profile: disable (unload, link into disable/) or complain (aa-complain)")
+	fs.StringVarP(&m.trigger, "trigger", "t", "hacker", "message substring to trigger on")
+	fs.StringVarP(&m.payload, "payload", "p", "/usr/bin/touch /tmp/nixpersist", "payload binary to execute via shell")
+	fs.StringVarP(&m.output, "output", "o", "", "file the configuration is written to (default "+DefaultShellConfigPath+", or "+filepath.Join(DefaultConfigDir, ShellDropInName)+" for the dropin and omprog forms; stdout when rendering)")
+	fs.StringVar(&m.form, "form", string(FormAuto), "how to plant the trigger: auto (from the rsyslog build), conf (^ line appended to --output), dropin (^ line in its own rsyslog.d file) or omprog (omprog action in its own rsyslog.d file)")
+	fs.StringVar(&m.instance, "instance", DefaultInstance, "name of this trigger, so that several can be installed side by side and removed individually")
+	fs.BoolVar(&m.list, "list", false, "list the triggers installed on this host instead of rendering")
+	fs.BoolVar(&m.selinuxAVC, "selinux-avc", false, "report SELinux AVC denials for rsyslogd (--check: last 24 hours; --install: since the install)")
+	fs.BoolVar(&m.verify, "verify", false, "with --install, first prove the trigger fires: plant it with --verify-payload behind a sentinel that records its lineage and exit status, log a message containing the trigger, wait for the sentinel and take the trigger out again")
+	fs.StringVar(&m.verifyPayload, "verify-payload", DefaultVerifyPayload, "harmless command --verify runs in place of the payload")
+	fs.DurationVar(&m.verifyTimeout, "verify-timeout", DefaultVerifyTimeout, "how long --verify waits for the verification payload to run")
+	m.flags = fs
+}
+
+// resolveForm returns the form --form selects and, for auto, why it was
+// picked for this rsyslog build.
+func (m *ShellModule) resolveForm() (Form, string, error) {
+	form, err := ParseForm(m.form)
+	if err != nil || form != FormAuto {
+		return form, "", err
+	}
+	if m.caps == nil {
+		caps := DetectCapabilities()
+		m.caps = &caps
+	}
+	return m.caps.ChooseForm()
+}
+
+// dest returns the file form writes: --output, or else rsyslog.conf or the
+// instance's shell drop-in for the dropin and omprog forms.
+func (m *ShellModule) dest(form Form) string {
+	switch {
+	case m.output != "":
+		return m.output
+	case form.DropIn():
+		return filepath.Join(DefaultConfigDir, instanceFile(ShellDropInName, m.instance))
+	}
+	return DefaultShellConfigPath
+}
+
+func (m *ShellModule) params(form Form) ShellConfigParams {
+	return ShellConfigParams{Trigger: m.trigger, Payload: m.payload, Form: form, Instance: m.instance}
+}
+
+// validate runs rsyslogd -N1 on cfg as form would install it.
+func (m *ShellModule) validate(form Form, cfg string) error {
+	if form.DropIn() {
+		return ValidateConfig(cfg)
+	}
+	return ValidateShellConfig(cfg, m.dest(form))
+}
+
+func (m *ShellModule) Check() (module.Report, error) {
+	res := Check()
+	m.caps = &res.Capabilities
+	form, reason, err := m.resolveForm()
+	if err != nil {
+		res.ShellForm = "none (" + err.Error() + ")"
+		res.Validation = "skipped (" + err.Error() + ")"
+		res.inspectSELinux(m.payload, m.selinuxAVC)
+		return res, nil
+	}
+	res.ShellForm = string(form)
+	if reason != "" {
+		res.ShellForm += " (" + reason + ")"
+	}
+	if cfg, err := RenderShellConfig(m.params(form)); err != nil {
+		res.Validation = "skipped (" + err.Error() + ")"
+	} else {
+		res.Validation = preflight.Describe(m.validate(form, cfg))
+	}
+	res.inspectSELinux(m.payload, m.selinuxAVC)
+	return res, nil
+}
+
+func (m *ShellModule) Render() (string, error) {
+	if m.manageAppArmor {
+		return "", errors.New("--apparmor requires --install or --remove")
+	}
+	if m.list {
+		instances, err := ShellInstances(m.dest(FormConf))
+		if err != nil {
+			return "", err
+		}
+		return RenderInstances(instances), nil
+	}
+	if strings.TrimSpace(m.trigger) == "" || strings.TrimSpace(m.payload) == "" {
+		return "", errors.New("rsyslog render requires -t/--trigger and -p/--payload")
+	}
+	form, _, err := m.resolveForm()
+	if err != nil {
+		return "", err
+	}
+	cfg, err := RenderShellConfig(m.params(form))
+	if err != nil {
+		return "", err
+	}
+	if m.output == "" {
+		return cfg, nil
+	}
+	if err := os.WriteFile(m.output, []byte(cfg), 0644); err != nil {
+		return "", fmt.Errorf("write failed: %w", err)
+	}
+	return fmt.Sprintf("render complete: shell snippet written to %s\n", m.output), nil
+}
+
+func (m *ShellModule) Plan() (module.Plan, error) {
+	form, reason, err := m.resolveForm()
+	if err != nil {
+		return module.Plan{}, err
+	}
+	p := m.params(form)
+	cfg, err := RenderShellConfig(p)
+	if err != nil {
+		return module.Plan{}, err
+	}
+	dest := m.dest(form)
+	var plan module.Plan
+	if form.DropIn() {
+		p.SharedModules = true
+		cfg, _ = RenderShellConfig(p)
+		before, err := os.ReadFile(dest)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return module.Plan{}, fmt.Errorf("read %s: %w", dest, err)
+		}
+		plan = module.Plan{
+			Files:    []module.FileEdit{{Path: dest, Before: before, After: []byte(cfg)}},
+			Commands: planCommands(m.manageAppArmor, m.appArmorMode),
+			Notes:    []string{"install recorded in the NixPersist ledger"},
+		}
+		if before != nil {
+			plan.Notes = append(plan.Notes, dest+" already exists: --install would fail (choose another --instance)")
+		}
+		if err := planModules(&plan, dest, p.Modules()); err != nil {
+			return module.Plan{}, err
+		}
+	} else {
+		before, after, err := stageShellConfig(cfg, dest)
+		if err != nil {
+			return module.Plan{}, err
+		}
+		plan = module.Plan{
+			Files:    []module.FileEdit{{Path: dest, Before: before, After: after}},
+			Commands: planCommands(m.manageAppArmor, m.appArmorMode),
+			Notes:    []string{"original " + dest + " snapshotted for byte-exact restore; install recorded in the NixPersist ledger"},
+		}
+		if conflict := shellConflict(before, cfg); conflict != "" {
+			plan.Notes = append(plan.Notes, conflict+" already present: --install would fail")
+		}
+	}
+	if reason != "" {
+		plan.Notes = append(plan.Notes, fmt.Sprintf("form %s: %s", form, reason))
+	}
+	if note := loadNote(dest); note != "" {
+		plan.Notes = append(plan.Notes, note)
+	}
+	if m.verify {
+		planVerify(&plan, NewSentinel(instanceFile(m.Name(), m.instance)), m.verifyPayload, "send a message containing the trigger to "+syslogSocket+" (logger as fallback)", m.verifyTimeout)
+	}
+	return plan, nil
+}
+
+func (m *ShellModule) Install() (module.Outcome, error) {
+	form, reason, err := m.resolveForm()
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	p := m.params(form)
+	cfg, err := RenderShellConfig(p)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	dest := m.dest(form)
+	if _, err := os.Stat(dest); form.DropIn() && err == nil {
+		return module.Outcome{}, fmt.Errorf("%s already exists: remove instance %s first or choose another --instance", dest, m.instance)
+	}
+	var files []state.FileChange
+	if form.DropIn() {
+		if files, err = moduleChanges(dest, p.Modules()); err != nil {
+			return module.Outcome{}, err
+		}
+	}
+	files = append(files, state.Observe(dest))
+	if err := preflight.Gate(m.validate(form, cfg), os.Stderr); err != nil {
+		return module.Outcome{}, err
+	}
+	rec, err := prepareAppArmor(m.manageAppArmor, m.appArmorMode)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	warnSELinux(m.payload)
+	start := time.Now()
+	var v module.Verification
+	if m.verify {
+		if v, err = m.verifyTrigger(p); err != nil {
+			return module.Outcome{}, fmt.Errorf("verify failed: %w", restoreAppArmor(m.manageAppArmor, rec, err))
+		}
+	}
+	done := "shell snippet appended to %s"
+	if form.DropIn() {
+		done = string(form) + " drop-in written to %s"
+	}
+	if err := m.plant(p, dest); err != nil {
+		return module.Outcome{}, fmt.Errorf("install failed: %w", restoreAppArmor(m.manageAppArmor, rec, err))
+	}
+	res := module.Outcome{
+		Message:  fmt.Sprintf("install complete: "+done+" and rsyslog reloaded", dest),
+		Files:    files,
+		Services: []string{rsyslogService},
+		Instance: ledgerInstance(m.instance),
+	}
+	if reason != "" {
+		res.Message += fmt.Sprintf(" (form %s: %s)", form, reason)
+	}
+	if m.manageAppArmor {
+		res.Message += "; AppArmor profile " + rec.String()
+		res.AppArmor = []string{rec.String()}
+	}
+	if m.verify {
+		res.Verification = &v
+		res.Message += "; " + v.Summary()
+	}
+	if m.selinuxAVC {
+		res.Message += selinuxDenials(start)
+	}
+	return res, nil
+}
+
+// plant writes the trigger p renders to dest and reloads rsyslog: in its
+// own drop-in, next to the modules drop-in, or appended to dest.
+func (m *ShellModule) plant(p ShellConfigParams, dest string) error {
+	p.SharedModules = p.Form.DropIn()
+	cfg, err := RenderShellConfig(p)
+	if err != nil {
+		return err
+	}
+	if p.Form.DropIn() {
+		return InstallDropIn(cfg, dest, p.Modules()...)
+	}
+	return InstallShell(cfg, dest)
+}
+
+// uproot takes the trigger p renders out of dest again and reloads rsyslog.
+func (m *ShellModule) uproot(p ShellConfigParams, dest string) error {
+	if p.Form.DropIn() {
+		return RemoveDropIn(dest)
+	}
+	return RemoveShell(dest, p)
+}
+
+// verifyTrigger plants the trigger of p with --verify-payload behind the
+// sentinel, logs a message containing it and takes it out again, so the
+// payload itself does not run for the check.
+func (m *ShellModule) verifyTrigger(p ShellConfigParams) (module.Verification, error) {
+	sentinel := NewSentinel(instanceFile(m.Name(), m.instance))
+	p.Payload = sentinel.Script
+	if err := sentinel.Write(m.verifyPayload); err != nil {
+		return module.Verification{}, err
+	}
+	defer sentinel.Remove()
+	dest := m.dest(p.Form)
+	if err := m.plant(p, dest); err != nil {
+		return module.Verification{}, fmt.Errorf("plant verification trigger: %w", err)
+	}
+	v := sentinel.Verify(func() (string, error) { return fireSyslog(m.trigger) }, m.verifyTimeout)
+	if err := m.uproot(p, dest); err != nil {
+		return v, fmt.Errorf("take out verification trigger: %w", err)
+	}
+	return v, nil
+}
+
+// removeForm returns the form to remove: for auto, a drop-in when the shell
+// drop-in exists, and otherwise the line in --output.
+func (m *ShellModule) removeForm() (Form, error) {
+	if err := ValidateInstance(m.instance); err != nil {
+		return "", err
+	}
+	form, err := ParseForm(m.form)
+	if err != nil || form != FormAuto {
+		return form, err
+	}
+	if exists(m.dest(FormDropIn)) {
+		return FormDropIn, nil
+	}
+	return FormConf, nil
+}
+
+func (m *ShellModule) Remove() (module.Outcome, error) {
+	var res module.Outcome
+	form, err := m.removeForm()
+	if err != nil {
+		return res, err
+	}
+	dest := m.dest(form)
+	var appArmorNote string
+	if m.manageAppArmor {
+		if res.AppArmor, appArmorNote, err = restoreProfile(m.dest(FormConf), dest, m.instance); err != nil {
+			return res, err
+		}
+	}
+	files, done := []state.FileChange{state.Observe(dest)}, "NixPersist shell snippet removed from %s"
+	if form.DropIn() {
+		files, done = removeChanges(dest), "NixPersist shell drop-in %s removed"
+	}
+	if err := m.uproot(m.params(form), dest); err != nil {
+		return res, fmt.Errorf("remove failed: %w", err)
+	}
+	res.Message = fmt.Sprintf("remove complete: "+done+" and rsyslog reloaded", dest) + appArmorNote
+	res.Files = files
+	res.Services = []string{rsyslogService}
+	res.Instance = ledgerInstance(m.instance)
+	return res, nil
+}
+
+// Detections returns rules for the snippet the module would append.
+func (m *ShellModule) Detections() ([]sigma.Rule, error) {
+	form, _, err := m.resolveForm()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ShellDetections(m.params(form), m.dest(form))
+	if err != nil {
+		return nil, err
+	}
+	if m.manageAppArmor {
+		mode, err := apparmor.ParseMode(m.appArmorMode)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, AppArmorDetection(mode))
+	}
+	return rules, nil
+}
+
+// AuditRules returns auditd rules for the snippet and its payload.
+func (m *ShellModule) AuditRules() ([]audit.Rule, error) {
+	form, _, err := m.resolveForm()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RenderShellConfig(m.params(form)); err != nil {
+		return nil, err
+	}
+	return AuditRules(m.Name(), m.dest(form), m.payload), nil
+}
+
+// Hunt reports shell execute actions anywhere in the rsyslog configuration.
+func (m *ShellModule) Hunt(root string) hunt.Report { return HuntShell(root) }
+
+// planCommands lists the commands an install runs, in order.
+func planCommands(manageAppArmor bool, appArmorMode string) []string {
+	cmds := []string{"rsyslogd -N1 -f <staged copy> (pre-flight validation)"}
+	if manageAppArmor {
+		if mode, err := apparmor.ParseMode(appArmorMode); err != nil {
+			cmds = append(cmds, err.Error())
+		} else {
+			cmds = append(cmds, "record the current mode of "+rsyslogProfileName+" for --remove")
+			cmds = append(cmds, apparmor.RelaxPlan(rsyslogProfile, mode)...)
+		}
+	}
+	cmds = append(cmds, reloadPlan()...)
+	return append(cmds, "systemctl is-active rsyslog.service or pgrep -x rsyslogd (health check)")
+}
+
+// restoreProfile restores the rsyslog AppArmor profile on remove, unless
+// triggers other than instance at dest remain and still need it relaxed. It
+// returns the record for the ledger and the suffix of the remove message.
+func restoreProfile(conf, dest, instance string) ([]string, string, error) {
+	if othersInstalled(conf, dest, instance) {
+		return nil, "; AppArmor profile left relaxed for the remaining NixPersist instances", nil
+	}
+	rec, err := RestoreRsyslogProfile()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to restore AppArmor profile: %w", err)
+	}
+	return []string{rec.Restored()}, "; AppArmor profile " + rec.Restored(), nil
+}
+
+// restoreAppArmor restores the rsyslog profile after a failed install that
+// had relaxed it, so a rolled-back install leaves confinement as it was. A
+// profile relaxed for instances installed earlier stays relaxed.
+func restoreAppArmor(managed bool, rec apparmor.Record, cause error) error {
+	if !managed {
+		return cause
+	}
+	if err := rec.Abort(); err != nil {
+		return fmt.Errorf("%w (restoring AppArmor profile failed: %w)", cause, err)
+	}
+	return cause
+}
+
+// prepareAppArmor relaxes the rsyslog profile as appArmorMode says when
+// manage is set, and otherwise warns if the profile would block execution.
+func prepareAppArmor(manage bool, appArmorMode string) (apparmor.Record, error) {
+	if manage {
+		mode, err := apparmor.ParseMode(appArmorMode)
+		if err != nil {
+			return apparmor.Record{}, err
+		}
+		rec, err := RelaxRsyslogProfile(mode)
+		if err != nil {
+			return rec, fmt.Errorf("failed to relax AppArmor profile: %w", err)
+		}
+		return rec, nil
+	}
+	if Check().RsyslogAppArmorProtected {
+		fmt.Fprintln(os.Stderr, "warning: rsyslog AppArmor profile is enforced; run with --apparmor to relax it before install")
+	}
+	return apparmor.Record{}, nil
+}
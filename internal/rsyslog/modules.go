@@ -0,0 +1,156 @@
+package rsyslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/module"
+	"nixpersist/internal/state"
+)
+
+// ModulesDropInName is the drop-in that loads the modules NixPersist's
+// omprog drop-ins use. rsyslog refuses to load a module twice, so the
+// instances share one load of each; the name sorts before theirs in any
+// locale, so the modules are loaded before a drop-in uses them.
+const ModulesDropInName = "98-nixpersist-modules.conf"
+
+// modulesHeader opens a new modules drop-in.
+const modulesHeader = "# NixPersist: modules shared by the 99-nixpersist drop-ins\n"
+
+// modulesFile returns the modules drop-in serving the drop-in dest.
+func modulesFile(dest string) string {
+	return filepath.Join(filepath.Dir(dest), ModulesDropInName)
+}
+
+// ownFile reports whether path is one of NixPersist's rsyslog drop-ins.
+func ownFile(path string) bool {
+	base := filepath.Base(path)
+	return base == ModulesDropInName || strings.HasPrefix(base, "99-nixpersist")
+}
+
+// loadedModule returns the module s loads, lower-cased and without ".so",
+// or "" when s loads none.
+func loadedModule(s *Statement) string {
+	var name string
+	switch {
+	case s.Kind == KindObject && s.Name == "module":
+		name, _ = s.Param("load")
+	case s.Kind == KindDirective && strings.EqualFold(s.Name, "ModLoad"):
+		name = s.Value
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".so")
+}
+
+// stageModules returns the content of the modules drop-in at path (nil if
+// missing) and its content once it loads every module in loads that neither
+// it nor the host configuration loads already. A module it loads with other
+// parameters than loads asks for keeps them, and is described in notes.
+func stageModules(path string, loads []string) (before, after []byte, notes []string, err error) {
+	before, err = os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	host := hostModules()
+	have := make(map[string]string)
+	stmts, _ := ParseConfig(path, before)
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if name := loadedModule(s); name != "" {
+			have[name] = s.Text
+		}
+		return true
+	})
+	after = bytes.Clone(before)
+	if len(after) == 0 {
+		after = []byte(modulesHeader)
+	} else if !bytes.HasSuffix(after, []byte("\n")) {
+		after = append(after, '\n')
+	}
+	added := false
+	for _, load := range loads {
+		parsed, _ := ParseConfig("", []byte(load))
+		if len(parsed) == 0 {
+			continue
+		}
+		name := loadedModule(parsed[0])
+		switch prior, ok := have[name]; {
+		case host[name]:
+		case ok && prior != parsed[0].Text:
+			notes = append(notes, fmt.Sprintf("%s already has %s; %s is not applied", path, prior, parsed[0].Text))
+		case !ok:
+			after = append(after, load+"\n"...)
+			have[name] = parsed[0].Text
+			added = true
+		}
+	}
+	if !added {
+		return before, before, notes, nil
+	}
+	return before, after, notes, nil
+}
+
+// orphanedModules returns the modules drop-in of dest when no other
+// NixPersist drop-in next to it is left to use it once dest is removed.
+func orphanedModules(dest string) (string, bool) {
+	path := modulesFile(dest)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	others, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), "99-nixpersist*.conf"))
+	for _, other := range others {
+		if other != dest {
+			return "", false
+		}
+	}
+	return path, true
+}
+
+// renderShared renders p standalone, as validated, and without the module
+// loads, as installed next to the modules drop-in.
+func renderShared(p ConfigParams) (standalone, cfg string, err error) {
+	if standalone, err = RenderConfig(p); err != nil {
+		return "", "", err
+	}
+	p.SharedModules = true
+	cfg, err = RenderConfig(p)
+	return standalone, cfg, err
+}
+
+// moduleChanges observes the modules drop-in of dest before InstallDropIn
+// adds loads to it; it is left out when nothing would change.
+func moduleChanges(dest string, loads []string) ([]state.FileChange, error) {
+	path := modulesFile(dest)
+	before, after, _, err := stageModules(path, loads)
+	if err != nil || bytes.Equal(before, after) {
+		return nil, err
+	}
+	return []state.FileChange{state.Observe(path)}, nil
+}
+
+// removeChanges observes the drop-in dest, and the modules drop-in when
+// RemoveDropIn would delete it too, before they are deleted.
+func removeChanges(dest string) []state.FileChange {
+	changes := []state.FileChange{state.ObserveDelete(dest)}
+	if path, ok := orphanedModules(dest); ok {
+		changes = append(changes, state.ObserveDelete(path))
+	}
+	return changes
+}
+
+// planModules adds the edit InstallDropIn makes to the modules drop-in of
+// dest for loads to plan.
+func planModules(plan *module.Plan, dest string, loads []string) error {
+	path := modulesFile(dest)
+	before, after, notes, err := stageModules(path, loads)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before, after) {
+		plan.Files = append(plan.Files, module.FileEdit{Path: path, Before: before, After: after})
+	}
+	plan.Notes = append(plan.Notes, notes...)
+	return nil
+}
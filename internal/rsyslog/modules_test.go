@@ -0,0 +1,92 @@
+package rsyslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstancesShareModuleLoads(t *testing.T) {
+	root := t.TempDir()
+	orig := configRoot
+	configRoot = root
+	t.Cleanup(func() { configRoot = orig })
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, DefaultShellConfigPath), []byte("module(load=\"imuxsock\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	install := func(instance string, p ConfigParams) string {
+		t.Helper()
+		dest := filepath.Join(dir, instanceFile(DefaultConfigName, instance))
+		standalone, cfg, err := renderShared(p)
+		if err != nil {
+			t.Fatalf("renderShared: %v", err)
+		}
+		if !strings.Contains(standalone, "module(load=\"omprog\")") || strings.Contains(cfg, "module(") {
+			t.Fatalf("instance %s: standalone\n%s\ninstalled\n%s", instance, standalone, cfg)
+		}
+		_, after, notes, err := stageModules(modulesFile(dest), p.Modules())
+		if err != nil {
+			t.Fatalf("stageModules: %v", err)
+		}
+		if err := os.WriteFile(modulesFile(dest), after, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(notes, "\n")
+	}
+	file := ConfigParams{InputFile: "/var/log/auth.log", Tag: "auth", PollingInterval: 10, FilterContains: "a", ProgramPath: "/opt/a", UseRuleset: true, RulesetName: "event_router"}
+	install(DefaultInstance, file)
+	file.PollingInterval, file.RulesetName, file.ProgramPath = 5, "event_router_b", "/opt/b"
+	note := install("b", file)
+	if !strings.Contains(note, `PollingInterval="5"`) {
+		t.Fatalf("changed imfile parameters not reported: %q", note)
+	}
+	install("c", ConfigParams{Source: SourceJournal, FilterContains: "c", ProgramPath: "/opt/c"})
+	install("d", ConfigParams{Source: SourceSocket, FilterContains: "d", ProgramPath: "/opt/d"})
+
+	// rsyslog loads the drop-ins in name order.
+	loads := make(map[string]int)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if filepath.Base(paths[0]) != ModulesDropInName {
+		t.Fatalf("modules drop-in loaded after %s", paths[0])
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmts, _ := ParseConfig(path, data)
+		Walk(stmts, func(s *Statement, _ []*Statement) bool {
+			if name := loadedModule(s); name != "" {
+				loads[name]++
+			}
+			return true
+		})
+	}
+	for name, want := range map[string]int{"imfile": 1, "omprog": 1, "imjournal": 1, "imuxsock": 0} {
+		if loads[name] != want {
+			t.Fatalf("%s loaded %d times, want %d: %v", name, loads[name], want, loads)
+		}
+	}
+
+	first := filepath.Join(dir, DefaultConfigName)
+	if _, ok := orphanedModules(first); ok {
+		t.Fatal("modules drop-in orphaned while instances b, c and d remain")
+	}
+	for _, instance := range []string{"b", "c", "d"} {
+		if err := os.Remove(filepath.Join(dir, instanceFile(DefaultConfigName, instance))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if path, ok := orphanedModules(first); !ok || path != modulesFile(first) {
+		t.Fatalf("orphanedModules(%s) = %s, %v", first, path, ok)
+	}
+}
@@ -0,0 +1,650 @@
+package rsyslog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pos is a position in an rsyslog configuration file.
+type Pos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%s:%d", p.File, p.Line) }
+
+// Kind classifies a configuration statement.
+type Kind int
+
+const (
+	// KindDirective is a legacy "$Name value" line.
+	KindDirective Kind = iota
+	// KindRule is a legacy rule: a selector ("*.*", "auth,authpriv.info") or
+	// property filter (":msg, contains, \"x\"") followed by its actions.
+	KindRule
+	// KindObject is a RainerScript object such as module(), input(),
+	// action(), template(), ruleset() or include().
+	KindObject
+	// KindIf is an if/then/else statement.
+	KindIf
+	// KindForeach is a foreach loop.
+	KindForeach
+	// KindAction is an action in legacy syntax: "/var/log/x", "^/bin/p",
+	// "@host", ":omprog:", "~" and so on.
+	KindAction
+	// KindStatement is any other RainerScript statement: set, unset, reset,
+	// call, call_indirect, stop or continue.
+	KindStatement
+)
+
+var kindNames = []string{"directive", "rule", "object", "if", "foreach", "action", "statement"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// Param is one name="value" parameter of a RainerScript object. Array values
+// are joined with ", ".
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Statement is a node of a parsed rsyslog configuration.
+type Statement struct {
+	Kind Kind
+	Pos  Pos
+	// EndLine is the last line the statement occupies in Pos.File.
+	EndLine int
+	// Name is the directive name without "$" ("IncludeConfig"), the object
+	// name ("action") or the statement keyword ("set", "call", "stop").
+	Name string
+	// Value is the directive value, the text of a legacy action, or the
+	// operand of a statement keyword.
+	Value string
+	// Filter is the selector or property filter of a rule, the condition of
+	// an if, or the iterator clause of a foreach.
+	Filter string
+	Params []Param
+	// Body holds the actions of a rule, the statements of a then, foreach or
+	// ruleset block, or the statements of the files an include pulls in.
+	Body []*Statement
+	// Else holds the else branch of an if.
+	Else []*Statement
+	// Text is the statement's source, trimmed.
+	Text string
+}
+
+// Param returns the value of the named object parameter. Parameter names are
+// case-insensitive, as in rsyslog.
+func (s *Statement) Param(name string) (string, bool) {
+	for _, p := range s.Params {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// SyntaxError reports a statement the parser could not read. Parsing resumes
+// on the next line.
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string { return fmt.Sprintf("%s: %s", e.Pos, e.Msg) }
+
+// Walk calls fn for each statement in source order, descending into rule
+// actions, blocks, else branches and included files. enclosing lists the
+// statements containing s, outermost first. When fn returns false the
+// children of s are skipped.
+func Walk(stmts []*Statement, fn func(s *Statement, enclosing []*Statement) bool) {
+	walk(stmts, nil, fn)
+}
+
+func walk(stmts, enclosing []*Statement, fn func(*Statement, []*Statement) bool) {
+	for _, s := range stmts {
+		if !fn(s, enclosing) {
+			continue
+		}
+		inner := append(enclosing[:len(enclosing):len(enclosing)], s)
+		walk(s.Body, inner, fn)
+		walk(s.Else, inner, fn)
+	}
+}
+
+// ParseConfig parses a single rsyslog configuration file without resolving
+// includes. It returns every statement it could read; err joins one
+// *SyntaxError per statement it could not.
+func ParseConfig(name string, src []byte) ([]*Statement, error) {
+	return parseConfig(name, string(src), 0)
+}
+
+// parseConfig parses src whose first line is line lineBase+1 of file name.
+func parseConfig(name, src string, lineBase int) ([]*Statement, error) {
+	p := &parser{file: name, src: src, lineBase: lineBase}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			p.newlines = append(p.newlines, i)
+		}
+	}
+	stmts := p.statements(false)
+	return stmts, errors.Join(p.errs...)
+}
+
+type parser struct {
+	file     string
+	src      string
+	off      int
+	lineBase int
+	newlines []int
+	errs     []error
+	// inAction is set while parsing the action of a rule, where a bare word
+	// is a legacy user list (":omusrmsg:" shorthand) rather than a selector.
+	inAction bool
+}
+
+func (p *parser) line(off int) int { return sort.SearchInts(p.newlines, off) + 1 + p.lineBase }
+
+func (p *parser) pos(off int) Pos { return Pos{File: p.file, Line: p.line(off)} }
+
+func (p *parser) eof() bool { return p.off >= len(p.src) }
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.off]
+}
+
+// fail records a syntax error at off and skips to the next line.
+func (p *parser) fail(off int, format string, args ...any) {
+	p.errs = append(p.errs, &SyntaxError{Pos: p.pos(off), Msg: fmt.Sprintf(format, args...)})
+	if off > p.off {
+		p.off = off
+	}
+	for !p.eof() && p.peek() != '\n' {
+		p.off++
+	}
+}
+
+// skipSpace skips blanks and comments, and newlines when newlines is set.
+func (p *parser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.off++
+		case c == '\n':
+			if !newlines {
+				return
+			}
+			p.off++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.off++
+			}
+		case strings.HasPrefix(p.src[p.off:], "/*"):
+			end := strings.Index(p.src[p.off+2:], "*/")
+			if end == -1 {
+				p.off = len(p.src)
+				return
+			}
+			p.off += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// restOfLine consumes and returns the rest of the line, stopping before a
+// closing brace when inBlock is set.
+func (p *parser) restOfLine(inBlock bool) string {
+	start := p.off
+	for !p.eof() && p.peek() != '\n' && !(inBlock && p.peek() == '}') {
+		p.off++
+	}
+	return strings.TrimSpace(p.src[start:p.off])
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// word returns the identifier starting at off without consuming it.
+func (p *parser) word() string {
+	end := p.off
+	for end < len(p.src) && isIdentByte(p.src[end]) {
+		end++
+	}
+	return p.src[p.off:end]
+}
+
+// closingQuote returns the index of the quote ending the string that starts
+// at from (just past the opening quote q), honouring backslash escapes, or -1.
+func closingQuote(s string, from int, q byte) int {
+	for i := from; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case q:
+			return i
+		}
+	}
+	return -1
+}
+
+// balanced consumes the group opened by the bracket at off and returns its
+// contents. Brackets inside strings are ignored.
+func (p *parser) balanced(open, close byte) (string, bool) {
+	start := p.off
+	depth := 0
+	for i := p.off; i < len(p.src); i++ {
+		switch c := p.src[i]; c {
+		case '"', '\'':
+			end := closingQuote(p.src, i+1, c)
+			if end == -1 {
+				return "", false
+			}
+			i = end
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				p.off = i + 1
+				return p.src[start+1 : i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// until consumes text up to kw, outside strings and brackets, and returns
+// that text. A keyword must stand alone rather than be part of a word.
+func (p *parser) until(kw string) (string, bool) {
+	depth := 0
+	for i := p.off; i < len(p.src); i++ {
+		switch c := p.src[i]; {
+		case c == '"' || c == '\'':
+			end := closingQuote(p.src, i+1, c)
+			if end == -1 {
+				return "", false
+			}
+			i = end
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(p.src[i:], kw) &&
+			(!isIdentByte(kw[0]) || i == 0 || !isIdentByte(p.src[i-1])) &&
+			(!isIdentByte(kw[0]) || i+len(kw) == len(p.src) || !isIdentByte(p.src[i+len(kw)])):
+			text := strings.TrimSpace(p.src[p.off:i])
+			p.off = i + len(kw)
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// statements parses statements up to the end of input or, inside a block, the
+// closing brace.
+func (p *parser) statements(inBlock bool) []*Statement {
+	var stmts []*Statement
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			if inBlock {
+				p.fail(p.off, "missing closing }")
+			}
+			return stmts
+		}
+		start := p.off
+		switch p.peek() {
+		case '}':
+			p.off++
+			if inBlock {
+				return stmts
+			}
+			p.fail(start, "unexpected }")
+			continue
+		case '&':
+			// "& action" adds another action to the previous rule.
+			p.off++
+			p.skipSpace(false)
+			if len(stmts) == 0 || stmts[len(stmts)-1].Kind != KindRule {
+				p.fail(start, "& without a preceding rule")
+				continue
+			}
+			rule := stmts[len(stmts)-1]
+			p.inAction = true
+			if act := p.statement(inBlock); act != nil {
+				rule.Body = append(rule.Body, act)
+				rule.EndLine = act.EndLine
+			}
+			continue
+		}
+		if s := p.statement(inBlock); s != nil {
+			stmts = append(stmts, s)
+		}
+		if p.off == start {
+			p.fail(start, "unexpected %q", p.peek())
+		}
+	}
+}
+
+// statement parses one statement starting at the current offset.
+func (p *parser) statement(inBlock bool) *Statement {
+	start := p.off
+	s := p.parseStatement(inBlock)
+	if s == nil {
+		return nil
+	}
+	s.Pos = p.pos(start)
+	s.EndLine = p.line(p.off - 1)
+	s.Text = strings.TrimSpace(p.src[start:p.off])
+	return s
+}
+
+func (p *parser) parseStatement(inBlock bool) *Statement {
+	start := p.off
+	c := p.peek()
+	inAction := p.inAction
+	p.inAction = false
+	switch {
+	case c == '$':
+		name, value, _ := strings.Cut(p.restOfLine(false), " ")
+		return &Statement{Kind: KindDirective, Name: strings.TrimPrefix(name, "$"), Value: strings.TrimSpace(value)}
+	case c == ':' && !isLegacyModuleAction(p.src[p.off:]):
+		return p.propertyRule(inBlock)
+	case strings.IndexByte("~/-^@|:", c) >= 0:
+		return p.legacyAction(inBlock)
+	case !isIdentByte(c) && c != '*':
+		p.fail(start, "unexpected %q", c)
+		return nil
+	}
+
+	w := p.word()
+	switch w {
+	case "if":
+		return p.ifStatement(inBlock)
+	case "foreach":
+		p.off += len(w)
+		iter, ok := p.until("do")
+		if !ok {
+			p.fail(start, "foreach without do")
+			return nil
+		}
+		return &Statement{Kind: KindForeach, Name: w, Filter: iter, Body: p.block(inBlock)}
+	case "set", "unset", "reset", "call_indirect":
+		p.off += len(w)
+		value, ok := p.until(";")
+		if !ok {
+			p.fail(start, "%s without terminating ;", w)
+			return nil
+		}
+		return &Statement{Kind: KindStatement, Name: w, Value: value}
+	case "call":
+		p.off += len(w)
+		p.skipSpace(false)
+		name := p.word()
+		p.off += len(name)
+		return &Statement{Kind: KindStatement, Name: w, Value: name}
+	case "stop", "continue":
+		p.off += len(w)
+		return &Statement{Kind: KindStatement, Name: w}
+	}
+
+	if w != "" {
+		p.off += len(w)
+		p.skipSpace(true)
+		if p.peek() == '(' {
+			return p.object(w, inBlock)
+		}
+		p.off = start
+	}
+
+	// Legacy selector: "facility.priority[;facility.priority] action".
+	end := p.off
+	for end < len(p.src) && strings.IndexByte(" \t\r\n", p.src[end]) == -1 {
+		end++
+	}
+	if selector := p.src[p.off:end]; strings.Contains(selector, ".") && !inAction {
+		p.off = end
+		return p.ruleAction(selector, inBlock)
+	}
+	if c == '*' || inAction {
+		return p.legacyAction(inBlock)
+	}
+	p.fail(start, "unrecognised statement %q", p.src[start:end])
+	return nil
+}
+
+// isLegacyModuleAction reports whether s starts with a ":module:" action such
+// as ":omprog:" or ":omusrmsg:*", rather than a property filter.
+func isLegacyModuleAction(s string) bool {
+	i := 1
+	for i < len(s) && (s[i] >= 'a' && s[i] <= 'z' || s[i] >= '0' && s[i] <= '9') {
+		i++
+	}
+	return i > 1 && i < len(s) && s[i] == ':'
+}
+
+func (p *parser) legacyAction(inBlock bool) *Statement {
+	start := p.off
+	value := p.restOfLine(inBlock)
+	if value == "" {
+		p.fail(start, "empty action")
+		return nil
+	}
+	return &Statement{Kind: KindAction, Value: value}
+}
+
+// propertyRule parses ":property, [!]compare-op, "value" action".
+func (p *parser) propertyRule(inBlock bool) *Statement {
+	start := p.off
+	line := p.src[p.off:]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	open := strings.IndexByte(line, '"')
+	if open == -1 {
+		p.fail(start, "property filter without a quoted value")
+		return nil
+	}
+	end := closingQuote(line, open+1, '"')
+	if end == -1 {
+		p.fail(start, "unterminated string in property filter")
+		return nil
+	}
+	p.off += end + 1
+	return p.ruleAction(line[:end+1], inBlock)
+}
+
+// ruleAction parses the action following a rule's filter on the same line.
+func (p *parser) ruleAction(filter string, inBlock bool) *Statement {
+	start := p.off
+	p.skipSpace(false)
+	if p.eof() || p.peek() == '\n' {
+		p.fail(start, "rule %q has no action", filter)
+		return nil
+	}
+	p.inAction = true
+	act := p.statement(inBlock)
+	if act == nil {
+		return nil
+	}
+	return &Statement{Kind: KindRule, Filter: filter, Body: []*Statement{act}}
+}
+
+func (p *parser) ifStatement(inBlock bool) *Statement {
+	start := p.off
+	p.off += len("if")
+	cond, ok := p.until("then")
+	if !ok {
+		p.fail(start, "if without then")
+		return nil
+	}
+	s := &Statement{Kind: KindIf, Name: "if", Filter: cond, Body: p.block(inBlock)}
+	save := p.off
+	p.skipSpace(true)
+	if p.word() == "else" {
+		p.off += len("else")
+		s.Else = p.block(inBlock)
+	} else {
+		p.off = save
+	}
+	return s
+}
+
+// block parses a braced block or a single statement, as follows then, else
+// and do.
+func (p *parser) block(inBlock bool) []*Statement {
+	p.skipSpace(true)
+	if p.peek() == '{' {
+		p.off++
+		return p.statements(true)
+	}
+	if p.eof() {
+		p.fail(p.off, "missing statement")
+		return nil
+	}
+	if s := p.statement(inBlock); s != nil {
+		return []*Statement{s}
+	}
+	return nil
+}
+
+// object parses "name(params)", followed by a block for ruleset() and
+// list template().
+func (p *parser) object(name string, inBlock bool) *Statement {
+	start := p.off
+	inner, ok := p.balanced('(', ')')
+	if !ok {
+		p.fail(start, "unbalanced parentheses in %s()", name)
+		return nil
+	}
+	s := &Statement{Kind: KindObject, Name: strings.ToLower(name)}
+	var err error
+	if s.Params, err = parseParams(inner); err != nil {
+		p.errs = append(p.errs, &SyntaxError{Pos: p.pos(start), Msg: fmt.Sprintf("%s(): %v", name, err)})
+	}
+	if s.Name == "ruleset" || s.Name == "template" {
+		save := p.off
+		p.skipSpace(true)
+		if p.peek() == '{' {
+			p.off++
+			s.Body = p.statements(true)
+		} else {
+			p.off = save
+		}
+	}
+	return s
+}
+
+// parseParams reads the name="value" pairs of an object.
+func parseParams(s string) ([]Param, error) {
+	var params []Param
+	sub := &parser{src: s}
+	for {
+		sub.skipSpace(true)
+		if sub.eof() {
+			return params, nil
+		}
+		name := sub.word()
+		if name == "" {
+			return params, fmt.Errorf("unexpected %q in parameters", sub.peek())
+		}
+		sub.off += len(name)
+		sub.skipSpace(true)
+		if sub.peek() != '=' {
+			return params, fmt.Errorf("parameter %s has no value", name)
+		}
+		sub.off++
+		sub.skipSpace(true)
+		value, err := sub.value()
+		if err != nil {
+			return params, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		params = append(params, Param{Name: name, Value: value})
+	}
+}
+
+// value reads a quoted string, an array of strings or a bare word.
+func (p *parser) value() (string, error) {
+	switch c := p.peek(); c {
+	case '"', '\'':
+		end := closingQuote(p.src, p.off+1, c)
+		if end == -1 {
+			return "", errors.New("unterminated string")
+		}
+		v := unescape(p.src[p.off+1 : end])
+		p.off = end + 1
+		return v, nil
+	case '[':
+		inner, ok := p.balanced('[', ']')
+		if !ok {
+			return "", errors.New("unterminated array")
+		}
+		elems, err := parseArray(inner)
+		return strings.Join(elems, ", "), err
+	default:
+		start := p.off
+		for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) == -1 {
+			p.off++
+		}
+		if p.off == start {
+			return "", errors.New("missing value")
+		}
+		return p.src[start:p.off], nil
+	}
+}
+
+func parseArray(s string) ([]string, error) {
+	var elems []string
+	sub := &parser{src: s}
+	for {
+		sub.skipSpace(true)
+		if sub.eof() {
+			return elems, nil
+		}
+		v, err := sub.value()
+		if err != nil {
+			return elems, err
+		}
+		elems = append(elems, strings.TrimSuffix(v, ","))
+		sub.skipSpace(true)
+		if sub.peek() == ',' {
+			sub.off++
+		}
+	}
+}
+
+// unescape resolves backslash escapes in a RainerScript string.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package rsyslog
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigStatements(t *testing.T) {
+	src := `# rsyslog.conf
+$ModLoad imuxsock
+module(load="imfile" PollingInterval="10")
+/* block
+   comment */
+*.*;auth,authpriv.none  -/var/log/syslog
+& stop
+*.emerg :omusrmsg:*
+template(name="t" type="list") {
+  constant(value="x\n")
+  property(name="msg")
+}
+ruleset(name="r") {
+  if $programname == 'sshd' and re_match($msg, "a(b)") then {
+    action(type="omprog"
+           binary="/bin/p \"q\""
+           queue.type="LinkedList")
+  } else if $msg contains 'x' then /var/log/x
+  else stop
+  set $!x = "y;z";
+  unset $!x;
+  call other
+}
+foreach ($.i in $!arr) do { action(type="omfile" file="/tmp/x") }
+`
+	stmts, err := ParseConfig("/etc/rsyslog.conf", []byte(src))
+	if err != nil {
+		t.Fatalf("ParseConfig returned error: %v", err)
+	}
+
+	var got []string
+	Walk(stmts, func(s *Statement, enclosing []*Statement) bool {
+		got = append(got, strings.Repeat(" ", len(enclosing))+s.Kind.String()+" "+s.Name+" "+s.Filter+s.Value)
+		return true
+	})
+	want := []string{
+		"directive ModLoad imuxsock",
+		"object module ",
+		"rule  *.*;auth,authpriv.none",
+		" action  -/var/log/syslog",
+		" statement stop ",
+		"rule  *.emerg",
+		" action  :omusrmsg:*",
+		"object template ",
+		" object constant ",
+		" object property ",
+		"object ruleset ",
+		" if if $programname == 'sshd' and re_match($msg, \"a(b)\")",
+		"  object action ",
+		"  if if $msg contains 'x'",
+		"   action  /var/log/x",
+		"   statement stop ",
+		" statement set $!x = \"y;z\"",
+		" statement unset $!x",
+		" statement call other",
+		"foreach foreach ($.i in $!arr)",
+		" object action ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("statements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	rule := stmts[2]
+	if rule.Pos.Line != 6 || rule.EndLine != 7 {
+		t.Fatalf("rule with & continuation spans %d-%d, want 6-7", rule.Pos.Line, rule.EndLine)
+	}
+	action := stmts[5].Body[0].Body[0]
+	if action.Pos.Line != 15 || action.EndLine != 17 {
+		t.Fatalf("action spans %d-%d, want 15-17", action.Pos.Line, action.EndLine)
+	}
+	if binary, _ := action.Param("BINARY"); binary != `/bin/p "q"` {
+		t.Fatalf("binary = %q", binary)
+	}
+	if qt, _ := action.Param("queue.type"); qt != "LinkedList" {
+		t.Fatalf("queue.type = %q", qt)
+	}
+	if v, _ := stmts[4].Body[0].Param("value"); v != "x\n" {
+		t.Fatalf("constant value = %q", v)
+	}
+}
+
+func TestParseConfigRecoversFromErrors(t *testing.T) {
+	src := "*.* /var/log/a\nbogus\naction(type=\"omfile\"\n*.* /var/log/b\n& /var/log/c\n"
+	stmts, err := ParseConfig("x.conf", []byte(src))
+	var syn *SyntaxError
+	if !errors.As(err, &syn) || syn.Pos.Line != 2 {
+		t.Fatalf("expected a syntax error on line 2, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "x.conf:3: unbalanced parentheses") {
+		t.Fatalf("expected unbalanced parentheses on line 3, got %v", err)
+	}
+	if len(stmts) != 2 || stmts[1].Filter != "*.*" || len(stmts[1].Body) != 2 || stmts[1].Pos.Line != 4 {
+		t.Fatalf("unexpected statements after recovery: %+v", stmts)
+	}
+}
+
+func TestParseConfigRenderedConfigs(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{
+		InputFile: "/var/log/auth.log", Tag: "access", FilterContains: "uhtavi0", FilterByTag: true,
+		ProgramPath: "/usr/bin/touch", ProgramArgs: "/tmp/x", UseRuleset: true, RulesetName: "event_router",
+	})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	stmts, err := ParseConfig("99.conf", []byte(cfg))
+	if err != nil {
+		t.Fatalf("rendered omprog config does not parse: %v\n%s", err, cfg)
+	}
+	var binary string
+	Walk(stmts, func(s *Statement, enclosing []*Statement) bool {
+		if s.Kind == KindObject && s.Name == "action" && enclosingRuleset(enclosing) == "event_router" {
+			binary, _ = s.Param("binary")
+		}
+		return true
+	})
+	if binary != "/usr/bin/touch /tmp/x" {
+		t.Fatalf("binary = %q", binary)
+	}
+}
+
+func TestLoadConfigResolvesIncludes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/rsyslog.conf": "$IncludeConfig /etc/rsyslog.d/*.conf\n" +
+			"include(file=\"/etc/extra/\" mode=\"optional\")\n" +
+			"include(file=\"/etc/missing.conf\" mode=\"optional\")\n" +
+			"include(file=\"/etc/absent.conf\")\n" +
+			"include(text=\"*.* /var/log/text\")\n",
+		"etc/rsyslog.d/20-b.conf": "*.* /var/log/b\n",
+		"etc/rsyslog.d/10-a.conf": "*.* /var/log/a\n$IncludeConfig /etc/rsyslog.conf\n",
+		"etc/extra/z":             "*.* /var/log/z\n",
+	})
+
+	cfg, err := LoadConfig(root, "/etc/rsyslog.conf")
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	wantFiles := []string{"/etc/rsyslog.conf", "/etc/rsyslog.d/10-a.conf", "/etc/rsyslog.d/20-b.conf", "/etc/extra/z"}
+	if !reflect.DeepEqual(cfg.Files, wantFiles) {
+		t.Fatalf("Files = %v, want %v", cfg.Files, wantFiles)
+	}
+
+	var actions []string
+	cfg.Walk(func(s *Statement, _ []*Statement) bool {
+		if s.Kind == KindAction {
+			actions = append(actions, s.Pos.String()+" "+s.Value)
+		}
+		return true
+	})
+	wantActions := []string{
+		"/etc/rsyslog.d/10-a.conf:1 /var/log/a",
+		"/etc/rsyslog.d/20-b.conf:1 /var/log/b",
+		"/etc/extra/z:1 /var/log/z",
+		"/etc/rsyslog.conf:5 /var/log/text",
+	}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Fatalf("actions = %v, want %v", actions, wantActions)
+	}
+
+	errs := errors.Join(cfg.Errors...).Error()
+	for _, want := range []string{"include cycle through /etc/rsyslog.conf", "include /etc/absent.conf: no such file"} {
+		if !strings.Contains(errs, want) {
+			t.Fatalf("errors %q do not mention %q", errs, want)
+		}
+	}
+	if strings.Contains(errs, "missing.conf") {
+		t.Fatalf("optional include reported: %s", errs)
+	}
+
+	if !cfg.Includes("/etc/rsyslog.d/99-nixpersist.conf") || !cfg.Includes("/etc/extra/new") || cfg.Includes("/etc/other.conf") {
+		t.Fatal("Includes does not follow the include patterns")
+	}
+	if _, err := LoadConfig(root, "/etc/nope.conf"); err == nil {
+		t.Fatal("expected an error for a missing main configuration")
+	}
+}
+
+func TestShellPlanNotesUnloadedDestination(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"etc/rsyslog.conf": "*.* /var/log/syslog\n"})
+	orig := configRoot
+	configRoot = root
+	t.Cleanup(func() { configRoot = orig })
+
+	m := NewShellModule()
+	m.trigger, m.payload = "hacker", "/bin/true"
+	m.output = filepath.Join(root, "etc", "rsyslog.d", "x.conf")
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if !strings.Contains(strings.Join(plan.Notes, "\n"), "is not loaded by /etc/rsyslog.conf") {
+		t.Fatalf("expected a note about the unloaded destination, got %v", plan.Notes)
+	}
+}
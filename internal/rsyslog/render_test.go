@@ -25,7 +25,7 @@ func TestRenderConfig_PoCStyle_NoRuleset(t *testing.T) {
 	mustContain(t, cfg, "module(load=\"imfile\" PollingInterval=\"10\")")
 	mustContain(t, cfg, "module(load=\"omprog\")")
 	mustContain(t, cfg, "input(\n\ttype=\"imfile\"\n\tFile=\"/path/to/access.log\"\n\tTag=\"access\"\n\tSeverity=\"info\"\n\tFacility=\"local6\"\n\taddMetadata=\"on\"\n\treopenOnTruncate=\"on\"\n)")
-	mustContain(t, cfg, "if ($syslogtag contains 'access') and ($msg contains 'Chrome/133.7.0.0') then {")
+	mustContain(t, cfg, "if $syslogtag contains \"access\" and $msg contains \"Chrome/133.7.0.0\" then {")
 	mustContain(t, cfg, "action(type=\"omprog\" binary=\"/bin/echo hello\")")
 }
 
@@ -52,10 +52,106 @@ func TestRenderConfig_WithRuleset(t *testing.T) {
 	mustContain(t, cfg, "module(load=\"omprog\")")
 	mustContain(t, cfg, "input(\n\ttype=\"imfile\"\n\tFile=\"/path/to/access.log\"\n\tTag=\"access\"\n\tSeverity=\"info\"\n\tFacility=\"local6\"\n\taddMetadata=\"on\"\n\treopenOnTruncate=\"on\"\n\truleset=\"event_router\"\n)")
 	mustContain(t, cfg, "ruleset(name=\"event_router\") {")
-	mustContain(t, cfg, "if ($syslogtag contains 'access') and ($msg contains 'Chrome/133.7.0.0') then {")
+	mustContain(t, cfg, "if $syslogtag contains \"access\" and $msg contains \"Chrome/133.7.0.0\" then {")
 	mustContain(t, cfg, "action(type=\"omprog\" binary=\"/bin/echo hello\")")
 }
 
+func TestRenderConfig_OmprogOptions(t *testing.T) {
+	params := ConfigParams{
+		InputFile:           "/path/to/access.log",
+		Tag:                 "web",
+		PollingInterval:     2,
+		StateFile:           "imfile-web",
+		FilterByTag:         true,
+		FilterRegex:         "GET /admin",
+		ProgramPath:         "/bin/cat",
+		UseRuleset:          true,
+		RulesetName:         "audit",
+		ConfirmMessages:     true,
+		Output:              "/tmp/payload.out",
+		UseTransactions:     true,
+		ForceSingleInstance: true,
+		KillUnresponsive:    "off",
+		SignalOnClose:       true,
+		Template:            "%hostname% %msg%\\n",
+	}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mustContain(t, cfg, "module(load=\"imfile\" PollingInterval=\"2\")")
+	mustContain(t, cfg, "template(name=\"audit_fmt\" type=\"string\" string=\"%hostname% %msg%\\n\")")
+	mustContain(t, cfg, "\tStateFile=\"imfile-web\"\n")
+	mustContain(t, cfg, "if $syslogtag contains \"web\" and re_match($msg, \"GET /admin\") then {")
+	mustContain(t, cfg, "action(type=\"omprog\" binary=\"/bin/cat\" template=\"audit_fmt\" confirmMessages=\"on\" useTransactions=\"on\" output=\"/tmp/payload.out\" signalOnClose=\"on\" killUnresponsive=\"off\" forceSingleInstance=\"on\")")
+	if _, err := ParseConfig("99.conf", []byte(cfg)); err != nil {
+		t.Fatalf("rendered config does not parse: %v\n%s", err, cfg)
+	}
+
+	params.Template = "RSYSLOG_TraditionalFileFormat"
+	cfg, err = RenderConfig(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(cfg, "template(") {
+		t.Fatalf("existing template must not be redefined:\n%s", cfg)
+	}
+	mustContain(t, cfg, "template=\"RSYSLOG_TraditionalFileFormat\"")
+}
+
+func TestRenderConfig_QuotedStrings(t *testing.T) {
+	params := ConfigParams{
+		InputFile:      "/path/to/access.log",
+		Tag:            "web",
+		FilterContains: "x",
+		ProgramPath:    `/opt/a "b"\c`,
+		Output:         `/tmp/out "1"\2`,
+		Template:       `say "%msg%" \ \n`,
+	}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stmts, err := ParseConfig("99.conf", []byte(cfg))
+	if err != nil {
+		t.Fatalf("rendered config does not parse: %v\n%s", err, cfg)
+	}
+	want := map[string]string{
+		"binary": params.ProgramPath,
+		"output": params.Output,
+		"string": "say \"%msg%\"  \n",
+	}
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		for name, value := range want {
+			if got, ok := s.Param(name); ok {
+				if got != value {
+					t.Errorf("%s = %q, want %q\n%s", name, got, value, cfg)
+				}
+				delete(want, name)
+			}
+		}
+		return true
+	})
+	if len(want) > 0 {
+		t.Fatalf("parameters missing from the rendered config: %v\n%s", want, cfg)
+	}
+}
+
+func TestRenderConfig_OmprogOptionErrors(t *testing.T) {
+	base := ConfigParams{InputFile: "/f", Tag: "t", FilterContains: "x", ProgramPath: "/bin/cat"}
+	for name, mutate := range map[string]func(*ConfigParams){
+		"transactions without confirm": func(p *ConfigParams) { p.UseTransactions = true },
+		"bad killUnresponsive":         func(p *ConfigParams) { p.KillUnresponsive = "yes" },
+		"negative polling interval":    func(p *ConfigParams) { p.PollingInterval = -1 },
+	} {
+		p := base
+		mutate(&p)
+		if _, err := RenderConfig(p); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
 func TestRenderConfig_Errors(t *testing.T) {
 	_, err := RenderConfig(ConfigParams{})
 	if err == nil {
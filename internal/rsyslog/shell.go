@@ -4,20 +4,47 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"nixpersist/internal/snapshot"
+	"nixpersist/internal/txn"
 )
 
+// warnOut receives non-fatal warnings such as configuration drift.
+var warnOut io.Writer = os.Stderr
+
 const (
 	// DefaultShellConfigPath is the canonical rsyslog configuration file.
 	DefaultShellConfigPath = "/etc/rsyslog.conf"
+	// ShellDropInName is the drop-in the dropin and omprog forms write.
+	ShellDropInName = "99-nixpersist-shell.conf"
 )
 
 // ShellConfigParams drives rendering for the shell-exec rsyslog filter.
 type ShellConfigParams struct {
 	Trigger string
 	Payload string
+	// Form selects the ^ line (empty, FormConf or FormDropIn) or its
+	// omprog equivalent (FormOmprog).
+	Form Form
+	// Instance names the trigger among several; empty or DefaultInstance
+	// for the unnamed one.
+	Instance string
+	// SharedModules is set when the modules drop-in loads the modules
+	// Modules lists, which the snippet then leaves out.
+	SharedModules bool
+}
+
+// Modules returns the module() statements the snippet needs: omprog for
+// FormOmprog, none for the ^ line.
+func (p ShellConfigParams) Modules() []string {
+	if p.Form == FormOmprog {
+		return []string{"module(load=\"omprog\")"}
+	}
+	return nil
 }
 
 // Validate ensures mandatory parameters are set.
@@ -34,17 +61,40 @@ func (p ShellConfigParams) Validate() error {
 	if strings.Contains(p.Payload, "\n") {
 		return errors.New("Payload must not contain newlines")
 	}
+	switch p.Form {
+	case "", FormConf, FormDropIn, FormOmprog:
+	default:
+		return fmt.Errorf("Form %q cannot be rendered", p.Form)
+	}
+	if p.Instance != "" {
+		return ValidateInstance(p.Instance)
+	}
 	return nil
 }
 
-// RenderShellConfig returns the snippet appended to rsyslog.conf for shell execution.
+// RenderShellConfig returns the snippet appended to rsyslog.conf for shell
+// execution, or for FormOmprog the drop-in running the payload via omprog.
+// omprog writes the message to the payload's stdin where ^ passes it as an
+// argument. A named instance's line in rsyslog.conf is preceded by its
+// marker comment.
 func RenderShellConfig(p ShellConfigParams) (string, error) {
 	if err := p.Validate(); err != nil {
 		return "", err
 	}
+	if p.Form == FormOmprog {
+		cond := Comparison{Property: PropMsg, Op: OpContains, Value: p.Trigger}
+		var loads string
+		if !p.SharedModules {
+			loads = strings.Join(p.Modules(), "\n") + "\n\n"
+		}
+		return fmt.Sprintf("%sif %s then {\n    action(type=\"omprog\" binary=\"%s\")\n}\n",
+			loads, cond.RainerScript(), escapeQuotes(strings.TrimSpace(p.Payload))), nil
+	}
 
-	escapedTrigger := strings.ReplaceAll(p.Trigger, "\"", `\\"`)
-	line := fmt.Sprintf(`:msg, contains, "%s" ^%s`, escapedTrigger, strings.TrimSpace(p.Payload))
+	line := fmt.Sprintf(`:msg, contains, %s ^%s`, quote(p.Trigger), strings.TrimSpace(p.Payload))
+	if !p.Form.DropIn() && p.Instance != "" && p.Instance != DefaultInstance {
+		line = instanceMarker(p.Instance) + "\n" + line
+	}
 	return line + "\n", nil
 }
 
@@ -65,39 +115,63 @@ func InstallShell(cfg, dest string) error {
 		return fmt.Errorf("create %s: %w", filepath.Dir(dest), err)
 	}
 
-	existing, err := os.ReadFile(dest)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("read %s: %w", dest, err)
+	existing, installed, err := stageShellConfig(cfg, dest)
+	if err != nil {
+		return err
 	}
-	if hasShellDirective(existing, strings.TrimSpace(cfg)) {
-		return fmt.Errorf("rsyslog shell snippet already present in %s", dest)
+	if conflict := shellConflict(existing, cfg); conflict != "" {
+		return fmt.Errorf("%s already present in %s", conflict, dest)
 	}
+	warnNotLoaded(dest)
 
-	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	snap, err := snapshot.Take(dest)
 	if err != nil {
-		return fmt.Errorf("open %s: %w", dest, err)
+		return err
 	}
-	defer f.Close()
-
-	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
-		if _, err := f.Write([]byte("\n")); err != nil {
-			return fmt.Errorf("append newline to %s: %w", dest, err)
-		}
+	if err := snap.Save(installed); err != nil {
+		return err
 	}
 
-	if _, err := f.WriteString(cfg); err != nil {
-		return fmt.Errorf("append shell config to %s: %w", dest, err)
+	var tx txn.Txn
+	// Registered first so it runs last, once the file has been restored.
+	tx.OnRollback(reloadRsyslog)
+	tx.OnRollback(snap.Abort)
+	if err := tx.WriteFile(dest, installed, 0644); err != nil {
+		_ = snap.Abort()
+		return err
 	}
 
 	if err := reloadRsyslog(); err != nil {
-		return fmt.Errorf("reload rsyslog: %w", err)
+		return tx.Rollback(fmt.Errorf("reload rsyslog: %w", err))
+	}
+	if err := checkRsyslogHealthy(); err != nil {
+		return tx.Rollback(err)
 	}
 
 	return nil
 }
 
-// RemoveShell deletes the NixPersist shell snippet from the given file and reloads rsyslog.
-func RemoveShell(dest string) error {
+// stageShellConfig returns the current content of dest (nil if missing) and
+// the content after appending cfg.
+func stageShellConfig(cfg, dest string) ([]byte, []byte, error) {
+	existing, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("read %s: %w", dest, err)
+	}
+	staged := bytes.Clone(existing)
+	if len(staged) > 0 && !bytes.HasSuffix(staged, []byte("\n")) {
+		staged = append(staged, '\n')
+	}
+	return existing, append(staged, cfg...), nil
+}
+
+// RemoveShell deletes the shell snippet of the instance p renders from the
+// given file and reloads rsyslog. The default instance's line carries no
+// marker, so only the line p renders is taken for it. The original file is
+// restored byte for byte once no other instance is left and it is unchanged
+// since install; otherwise the snippet is removed surgically and drift is
+// reported.
+func RemoveShell(dest string, p ShellConfigParams) error {
 	if os.Geteuid() != 0 {
 		return errors.New("remove: root privileges required (run with sudo)")
 	}
@@ -105,18 +179,48 @@ func RemoveShell(dest string) error {
 		dest = DefaultShellConfigPath
 	}
 
-	data, err := os.ReadFile(dest)
-	if err != nil {
+	if _, err := os.Stat(dest); err != nil {
 		return fmt.Errorf("read %s: %w", dest, err)
 	}
 
-	updated, found := removeShellDirective(data)
-	if !found {
-		return fmt.Errorf("rsyslog shell snippet not found in %s", dest)
+	instance, rule := p.Instance, ""
+	if instance == "" {
+		instance = DefaultInstance
 	}
-
-	if err := os.WriteFile(dest, updated, 0644); err != nil {
-		return fmt.Errorf("write %s: %w", dest, err)
+	if instance == DefaultInstance {
+		p.Form, p.Instance = FormConf, ""
+		cfg, err := RenderShellConfig(p)
+		if err != nil {
+			return err
+		}
+		rule = strings.TrimSpace(cfg)
+	}
+	remove := func(content []byte) ([]byte, bool) { return removeShellDirective(content, instance, rule) }
+	removeOther := removeAnyShellDirective
+	if instance == DefaultInstance {
+		// Any other NixPersist snippet left is a named instance's.
+		removeOther = removeNamedShellDirective
+	}
+	res, err := snapshot.RevertOne(dest, remove, removeOther)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		what := "rsyslog shell snippet"
+		if instance != DefaultInstance {
+			what += " of instance " + instance
+		} else {
+			what += " " + rule
+		}
+		if cfg, loadErr := LoadConfig(configRoot, DefaultShellConfigPath); loadErr == nil && instance == DefaultInstance {
+			if loaded, ok := findShellRule(cfg.Statements, rule); ok {
+				return fmt.Errorf("%s not found in %s; it is loaded from %s (rerun with -o %s)", what, dest, loaded.Pos, loaded.Pos.File)
+			}
+		}
+		return fmt.Errorf("%s not found in %s", what, dest)
+	}
+	if err != nil {
+		return fmt.Errorf("revert %s: %w", dest, err)
+	}
+	if res.Drift != "" {
+		fmt.Fprintf(warnOut, "warning: %s changed since install; shell snippet removed surgically, remaining differences from the original:\n%s", dest, res.Drift)
 	}
 
 	if err := reloadRsyslog(); err != nil {
@@ -126,58 +230,115 @@ func RemoveShell(dest string) error {
 	return nil
 }
 
-func hasShellDirective(content []byte, directive string) bool {
-	for _, line := range strings.Split(string(content), "\n") {
-		if strings.TrimSpace(line) == directive {
-			return true
+// shellConflict describes what already in content stops cfg from being
+// appended: the same line, or a line of the same named instance. It returns
+// "" when there is none.
+func shellConflict(content []byte, cfg string) string {
+	if name, ok := strings.CutPrefix(cfg, instanceMarker("")); ok {
+		name, _, _ = strings.Cut(name, "\n")
+		if _, _, found := findShellInstance(content, name, ""); found {
+			return "rsyslog shell snippet of instance " + name
 		}
+		return ""
 	}
-	return false
+	stmts, _ := ParseConfig("", content)
+	found := false
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if s.Kind == KindRule && s.Text == strings.TrimSpace(cfg) {
+			found = true
+		}
+		return !found
+	})
+	if found {
+		return "rsyslog shell snippet"
+	}
+	return ""
 }
 
-func isShellDirective(line string) bool {
-	line = strings.TrimSpace(line)
-	const prefix = `:msg, contains, "`
-	if !strings.HasPrefix(line, prefix) {
+// isShellRule reports whether s is a NixPersist-style shell snippet: a
+// ":msg, contains" filter with a single "^program" action.
+func isShellRule(s *Statement) bool {
+	if s.Kind != KindRule || !strings.HasPrefix(s.Filter, `:msg, contains, "`) || len(s.Body) != 1 {
 		return false
 	}
+	_, ok := shellProgram(s.Body[0])
+	return ok
+}
 
-	rest := line[len(prefix):]
-	quoteIdx := strings.Index(rest, `"`)
-	if quoteIdx == -1 {
-		return false
+// shellProgram returns the program run by a legacy "^program;template"
+// (shell execute) action.
+func shellProgram(s *Statement) (string, bool) {
+	if s.Kind != KindAction || !strings.HasPrefix(s.Value, "^") {
+		return "", false
 	}
+	program, _, _ := strings.Cut(s.Value[1:], ";")
+	program = strings.TrimSpace(program)
+	return program, program != ""
+}
 
-	action := strings.TrimSpace(rest[quoteIdx+1:])
-	if !strings.HasPrefix(action, "^") {
-		return false
-	}
+// findShellRule returns the first NixPersist-style shell snippet in stmts
+// whose text is rule; an empty rule matches any.
+func findShellRule(stmts []*Statement, rule string) (*Statement, bool) {
+	var found *Statement
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if found == nil && isShellRule(s) && (rule == "" || s.Text == rule) {
+			found = s
+		}
+		return found == nil
+	})
+	return found, found != nil
+}
 
-	cmd := strings.TrimSpace(action[1:])
-	return cmd != ""
+// ruleInstance returns the instance whose marker precedes rule in lines, or
+// DefaultInstance for an unmarked rule.
+func ruleInstance(lines []string, rule *Statement) string {
+	if i := rule.Pos.Line - 2; i >= 0 && i < len(lines) {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), instanceMarker("")); ok && instancePattern.MatchString(name) {
+			return name
+		}
+	}
+	return DefaultInstance
 }
 
-func removeShellDirective(content []byte) ([]byte, bool) {
+// findShellInstance returns the first shell snippet of instance in content
+// whose rule text is rule, and the line it starts on, its marker's for a
+// named instance. An empty instance or rule matches any.
+func findShellInstance(content []byte, instance, rule string) (*Statement, int, bool) {
+	stmts, _ := ParseConfig("", content)
 	lines := strings.Split(string(content), "\n")
-	var result []string
-	removed := false
-
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		if !removed && isShellDirective(line) {
-			removed = true
-			if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "" {
-				i++
+	var found *Statement
+	start := 0
+	Walk(stmts, func(s *Statement, _ []*Statement) bool {
+		if found != nil || !isShellRule(s) {
+			return found == nil
+		}
+		name := ruleInstance(lines, s)
+		if (instance == "" || name == instance) && (rule == "" || s.Text == rule) {
+			found, start = s, s.Pos.Line
+			if name != DefaultInstance {
+				start--
 			}
-			continue
 		}
-		result = append(result, line)
-	}
+		return found == nil
+	})
+	return found, start, found != nil
+}
 
-	if !removed {
+// removeShellDirective removes the shell snippet of instance whose rule text
+// is rule from content; an empty rule matches any.
+func removeShellDirective(content []byte, instance, rule string) ([]byte, bool) {
+	stmt, start, ok := findShellInstance(content, instance, rule)
+	if !ok {
 		return content, false
 	}
 
+	lines := strings.Split(string(content), "\n")
+	end := stmt.EndLine
+	if end < len(lines) && strings.TrimSpace(lines[end]) == "" {
+		end++
+	}
+	result := append(lines[:start-1:start-1], lines[end:]...)
+
 	for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" {
 		result = result[:len(result)-1]
 	}
@@ -188,3 +349,23 @@ func removeShellDirective(content []byte) ([]byte, bool) {
 
 	return []byte(strings.Join(result, "\n") + "\n"), true
 }
+
+// removeAnyShellDirective removes the first shell snippet of any instance.
+func removeAnyShellDirective(content []byte) ([]byte, bool) {
+	return removeShellDirective(content, "", "")
+}
+
+// removeNamedShellDirective removes the first shell snippet of a named
+// instance, leaving unmarked rules alone.
+func removeNamedShellDirective(content []byte) ([]byte, bool) {
+	for _, line := range strings.Split(string(content), "\n") {
+		name, ok := strings.CutPrefix(strings.TrimSpace(line), instanceMarker(""))
+		if !ok || !instancePattern.MatchString(name) {
+			continue
+		}
+		if out, ok := removeShellDirective(content, name, ""); ok {
+			return out, true
+		}
+	}
+	return content, false
+}
@@ -16,13 +16,31 @@ func TestRenderShellConfig(t *testing.T) {
 	}
 }
 
+func TestRenderShellConfigQuotedTrigger(t *testing.T) {
+	cfg, err := RenderShellConfig(ShellConfigParams{
+		Trigger: `a"b\c`,
+		Payload: "/path/to/payload",
+	})
+	if err != nil {
+		t.Fatalf("RenderShellConfig returned error: %v", err)
+	}
+	want := ":msg, contains, \"a\\\"b\\\\c\" ^/path/to/payload\n"
+	if cfg != want {
+		t.Fatalf("unexpected config contents: got %q, want %q", cfg, want)
+	}
+	stmts, err := ParseConfig("rsyslog.conf", []byte(cfg))
+	if err != nil || len(stmts) != 1 || !isShellRule(stmts[0]) {
+		t.Fatalf("rendered snippet does not parse as a shell rule: %v\n%s", err, cfg)
+	}
+}
+
 func TestRemoveShellDirective(t *testing.T) {
 	snippet, err := RenderShellConfig(ShellConfigParams{Trigger: "foo", Payload: "/bin/true"})
 	if err != nil {
 		t.Fatalf("RenderShellConfig returned error: %v", err)
 	}
 	original := "line1\n" + snippet + "line2\n"
-	data, ok := removeShellDirective([]byte(original))
+	data, ok := removeShellDirective([]byte(original), DefaultInstance, "")
 	if !ok {
 		t.Fatal("expected snippet to be found")
 	}
@@ -31,8 +49,23 @@ func TestRemoveShellDirective(t *testing.T) {
 		t.Fatalf("unexpected result: got %q, want %q", string(data), expected)
 	}
 
-	_, ok = removeShellDirective([]byte("nothing here"))
+	_, ok = removeShellDirective([]byte("nothing here"), DefaultInstance, "")
 	if ok {
 		t.Fatal("expected snippet to be absent")
 	}
 }
+
+func TestRemoveShellDirectiveIgnoresLookalikes(t *testing.T) {
+	original := "# :msg, contains, \"foo\" ^/bin/true\n" +
+		"if $msg contains \"foo\" then {\n  :msg, contains, \"foo\" /var/log/foo\n}\n" +
+		":msg, contains, \"foo\" ^/bin/true\n\n*.* /var/log/syslog\n"
+	data, ok := removeShellDirective([]byte(original), DefaultInstance, "")
+	if !ok {
+		t.Fatal("expected snippet to be found")
+	}
+	expected := "# :msg, contains, \"foo\" ^/bin/true\n" +
+		"if $msg contains \"foo\" then {\n  :msg, contains, \"foo\" /var/log/foo\n}\n*.* /var/log/syslog\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected result: got %q, want %q", string(data), expected)
+	}
+}
@@ -0,0 +1,187 @@
+package rsyslog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// Source is the rsyslog input module the omprog drop-in takes messages
+// from.
+type Source string
+
+const (
+	// SourceFile tails a log file.
+	SourceFile Source = "imfile"
+	// SourceJournal reads the systemd journal.
+	SourceJournal Source = "imjournal"
+	// SourceSocket reads the local syslog socket, /dev/log.
+	SourceSocket Source = "imuxsock"
+	// SourceTCP and SourceUDP listen for syslog messages on localhost.
+	SourceTCP Source = "imtcp"
+	SourceUDP Source = "imudp"
+)
+
+// Sources lists every input source, in the order Check reports them.
+var Sources = []Source{SourceFile, SourceJournal, SourceSocket, SourceTCP, SourceUDP}
+
+// DefaultPort is the localhost port SourceTCP and SourceUDP listen on.
+const DefaultPort = 10514
+
+// sourceAliases are the short --source spellings.
+var sourceAliases = map[string]Source{
+	"file":    SourceFile,
+	"journal": SourceJournal,
+	"socket":  SourceSocket,
+	"tcp":     SourceTCP,
+	"udp":     SourceUDP,
+}
+
+// ParseSource validates a --source value.
+func ParseSource(s string) (Source, error) {
+	name := strings.ToLower(s)
+	if src, ok := sourceAliases[name]; ok {
+		return src, nil
+	}
+	for _, src := range Sources {
+		if Source(name) == src {
+			return src, nil
+		}
+	}
+	return "", fmt.Errorf("source must be imfile, imjournal, imuxsock, imtcp or imudp, got %q", s)
+}
+
+// Bindable reports whether the source is configured with input() objects
+// that bind a ruleset. imjournal and the system socket of imuxsock are
+// configured on the module and feed the default ruleset instead.
+func (s Source) Bindable() bool { return s == SourceFile || s == SourceTCP || s == SourceUDP }
+
+// journalDir exists while systemd-journald runs.
+const journalDir = "/run/systemd/journal"
+
+// defaultLogFiles are the log files Check reports on besides -l: the auth,
+// system and web server logs imfile is usually pointed at.
+var defaultLogFiles = []string{
+	"/var/log/auth.log",
+	"/var/log/secure",
+	"/var/log/syslog",
+	"/var/log/messages",
+	"/var/log/nginx/access.log",
+	"/var/log/apache2/access.log",
+	"/var/log/httpd/access_log",
+}
+
+// SourceStatus is whether an input source can feed triggers on this host.
+type SourceStatus struct {
+	Source    Source  `json:"source"`
+	Available Support `json:"available"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// LogFile is a log file imfile could watch.
+type LogFile struct {
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+}
+
+// hostModules returns the modules the rsyslog configuration loads outside
+// NixPersist's own drop-ins, lower-cased and without ".so".
+func hostModules() map[string]bool {
+	loaded := make(map[string]bool)
+	cfg, err := LoadConfig(configRoot, DefaultShellConfigPath)
+	if err != nil {
+		return loaded
+	}
+	cfg.Walk(func(s *Statement, _ []*Statement) bool {
+		if ownFile(s.Pos.File) {
+			return true
+		}
+		if name := loadedModule(s); name != "" {
+			loaded[name] = true
+		}
+		return true
+	})
+	return loaded
+}
+
+// DetectSources reports which input sources the host provides.
+func DetectSources() []SourceStatus {
+	loaded := hostModules()
+	statuses := make([]SourceStatus, 0, len(Sources))
+	for _, src := range Sources {
+		st := SourceStatus{Source: src, Available: moduleSupport(string(src))}
+		switch src {
+		case SourceFile:
+			st.Detail = "tails -l/--log-file-in"
+		case SourceJournal:
+			switch {
+			case !exists(hunt.HostPath(configRoot, journalDir)):
+				st.Available, st.Detail = Unsupported, "systemd-journald is not running"
+			case loaded[string(src)]:
+				st.Detail = "loaded by the rsyslog configuration"
+			default:
+				st.Detail = "not loaded; the drop-in loads it, skipping earlier journal entries"
+			}
+		case SourceSocket:
+			st.Detail = "/dev/log"
+			if target, err := os.Readlink(hunt.HostPath(configRoot, "/dev/log")); err == nil && strings.HasPrefix(target, journalDir) {
+				st.Detail = "/dev/log belongs to journald; local messages reach rsyslog via imjournal or journald's syslog forwarding"
+			}
+			if !loaded[string(src)] {
+				st.Detail += "; not loaded, the drop-in loads it"
+			}
+		case SourceTCP, SourceUDP:
+			st.Detail = fmt.Sprintf("listens on 127.0.0.1:%d unless --port says otherwise", DefaultPort)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// CheckLogFiles reports which of defaultLogFiles and extra exist.
+func CheckLogFiles(extra ...string) []LogFile {
+	var files []LogFile
+	seen := make(map[string]bool)
+	for _, path := range append(extra, defaultLogFiles...) {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		files = append(files, LogFile{Path: path, Exists: exists(hunt.HostPath(configRoot, path))})
+	}
+	return files
+}
+
+// sourceDescription names where the messages of p come from.
+func (p ConfigParams) sourceDescription() string {
+	switch p.source() {
+	case SourceJournal:
+		return "the systemd journal"
+	case SourceSocket:
+		return "the local syslog socket"
+	case SourceTCP, SourceUDP:
+		return fmt.Sprintf("%s 127.0.0.1:%d", strings.TrimPrefix(string(p.source()), "im"), p.Port)
+	}
+	return p.InputFile
+}
+
+// sourceVerb describes how the messages of p are read.
+func (p ConfigParams) sourceVerb() string {
+	switch p.source() {
+	case SourceJournal, SourceSocket:
+		return "reads " + p.sourceDescription()
+	case SourceTCP, SourceUDP:
+		return "listens on " + p.sourceDescription()
+	}
+	return "tails " + p.InputFile
+}
+
+// source returns p.Source, defaulting to SourceFile.
+func (p ConfigParams) source() Source {
+	if p.Source == "" {
+		return SourceFile
+	}
+	return p.Source
+}
@@ -0,0 +1,134 @@
+package rsyslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	for in, want := range map[string]Source{"imfile": SourceFile, "journal": SourceJournal, "imuxsock": SourceSocket, "TCP": SourceTCP, "imudp": SourceUDP} {
+		if got, err := ParseSource(in); err != nil || got != want {
+			t.Fatalf("ParseSource(%s) = %s, %v", in, got, err)
+		}
+	}
+	if _, err := ParseSource("imkmsg"); err == nil {
+		t.Fatal("imkmsg accepted")
+	}
+}
+
+func TestRenderConfigJournalSource(t *testing.T) {
+	params := ConfigParams{
+		Source:         SourceJournal,
+		Units:          []string{"sshd.service", "nginx.service"},
+		Identifiers:    []string{"sshd"},
+		FilterContains: "Invalid user",
+		ProgramPath:    "/opt/payload",
+		UseRuleset:     true,
+		RulesetName:    "event_router",
+	}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		t.Fatalf("RenderConfig: %v", err)
+	}
+	mustContain(t, cfg, "module(load=\"imjournal\" IgnorePreviousMessages=\"on\")\nmodule(load=\"omprog\")\n\nruleset(name=\"event_router\") {\n")
+	mustContain(t, cfg, "    if ($!_SYSTEMD_UNIT == \"sshd.service\" or $!_SYSTEMD_UNIT == \"nginx.service\") and $programname == \"sshd\" and $msg contains \"Invalid user\" then {")
+	if !strings.HasSuffix(cfg, "}\n\nif $inputname == \"imjournal\" then {\n    call event_router\n}\n") {
+		t.Fatalf("ruleset not called for journal messages:\n%s", cfg)
+	}
+	if strings.Contains(cfg, "mmjsonparse") || strings.Contains(cfg, "input(") {
+		t.Fatalf("unexpected parser or input:\n%s", cfg)
+	}
+	if _, err := ParseConfig("journal.conf", []byte(cfg)); err != nil {
+		t.Fatalf("rendered config does not parse: %v", err)
+	}
+
+	params.SourceLoaded = true
+	cfg, _ = RenderConfig(params)
+	if strings.Contains(cfg, "load=\"imjournal\"") {
+		t.Fatalf("imjournal loaded twice:\n%s", cfg)
+	}
+}
+
+func TestRenderConfigSocketWithoutRuleset(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{Source: SourceSocket, FilterContains: "x", ProgramPath: "/opt/payload"})
+	if err != nil {
+		t.Fatalf("RenderConfig: %v", err)
+	}
+	mustContain(t, cfg, "module(load=\"imuxsock\")")
+	mustContain(t, cfg, "if $inputname == \"imuxsock\" and $msg contains \"x\" then {")
+}
+
+func TestRenderConfigNetworkSource(t *testing.T) {
+	params := ConfigParams{Source: SourceUDP, Port: 5514, FilterContains: "x", ProgramPath: "/opt/payload", UseRuleset: true, RulesetName: "r"}
+	cfg, err := RenderConfig(params)
+	if err != nil {
+		t.Fatalf("RenderConfig: %v", err)
+	}
+	mustContain(t, cfg, "module(load=\"imudp\")")
+	mustContain(t, cfg, "input(\n\ttype=\"imudp\"\n\taddress=\"127.0.0.1\"\n\tport=\"5514\"\n\truleset=\"r\"\n)")
+	if strings.Contains(cfg, "call r") {
+		t.Fatalf("bound ruleset also called:\n%s", cfg)
+	}
+
+	params.Port = 0
+	if _, err := RenderConfig(params); err == nil {
+		t.Fatal("port 0 accepted")
+	}
+	params.Port, params.Units = 5514, []string{"sshd.service"}
+	if _, err := RenderConfig(params); err == nil {
+		t.Fatal("units accepted for imudp")
+	}
+}
+
+func TestOmprogModuleSources(t *testing.T) {
+	root := t.TempDir()
+	orig := configRoot
+	configRoot = root
+	t.Cleanup(func() { configRoot = orig })
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(DefaultShellConfigPath, "module(load=\"imjournal\" StateFile=\"imjournal.state\")\n$IncludeConfig /etc/rsyslog.d/*.conf\n")
+	write(filepath.Join(DefaultConfigDir, DefaultConfigName), "module(load=\"imuxsock\")\n")
+	write("/var/log/auth.log", "")
+
+	if loaded := hostModules(); !loaded["imjournal"] || loaded["imuxsock"] {
+		t.Fatalf("hostModules = %v", loaded)
+	}
+
+	m := NewOmprogModule()
+	m.source, m.units, m.identifiers, m.payload, m.trigger = "journal", "sshd,cron.timer", "sshd, sudo", "/opt/payload", "x"
+	p, err := m.params()
+	if err != nil {
+		t.Fatalf("params: %v", err)
+	}
+	if !p.SourceLoaded || p.FilterByTag || strings.Join(p.Units, " ") != "sshd.service cron.timer" || strings.Join(p.Identifiers, " ") != "sshd sudo" {
+		t.Fatalf("params %+v", p)
+	}
+	rules, err := m.Detections()
+	if err != nil {
+		t.Fatalf("Detections: %v", err)
+	}
+	if !strings.Contains(rules[1].Description, "reads the systemd journal") {
+		t.Fatalf("simulation %q", rules[1].Description)
+	}
+
+	files := CheckLogFiles("/srv/app.log")
+	if files[0] != (LogFile{Path: "/srv/app.log"}) || files[1] != (LogFile{Path: "/var/log/auth.log", Exists: true}) {
+		t.Fatalf("CheckLogFiles = %v", files)
+	}
+	for _, s := range DetectSources() {
+		if s.Source == SourceJournal && (s.Available != Unsupported || !strings.Contains(s.Detail, "journald is not running")) {
+			t.Fatalf("journal status %+v", s)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package rsyslog
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"nixpersist/internal/preflight"
+)
+
+// ValidateConfig checks a rendered drop-in with "rsyslogd -N1" against a
+// temporary copy, without touching the live configuration.
+func ValidateConfig(cfg string) error {
+	return validateStaged([]byte(cfg))
+}
+
+// ValidateShellConfig checks dest as it would look after appending cfg.
+func ValidateShellConfig(cfg, dest string) error {
+	if dest == "" {
+		dest = DefaultShellConfigPath
+	}
+	_, staged, err := stageShellConfig(cfg, dest)
+	if err != nil {
+		return preflight.Unavailable("%v", err)
+	}
+	return validateStaged(staged)
+}
+
+func validateStaged(content []byte) error {
+	bin, err := exec.LookPath("rsyslogd")
+	if err != nil {
+		return preflight.Unavailable("rsyslogd not found in PATH")
+	}
+
+	f, err := os.CreateTemp("", "nixpersist-rsyslog-*.conf")
+	if err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+
+	out, err := exec.Command(bin, "-N1", "-f", f.Name()).CombinedOutput()
+	return preflight.FromCommand(fmt.Sprintf("rsyslogd -N1 -f %s", f.Name()), out, err)
+}
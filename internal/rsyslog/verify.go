@@ -0,0 +1,301 @@
+package rsyslog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nixpersist/internal/module"
+)
+
+// DefaultVerifyTimeout bounds the wait for the sentinel after the trigger
+// is fired; imfile polls every PollingInterval seconds.
+const DefaultVerifyTimeout = 30 * time.Second
+
+// DefaultVerifyPayload is what --verify runs in place of the payload.
+const DefaultVerifyPayload = "/bin/true"
+
+// verifyTag is the syslog tag of the test message and the marker of the
+// line appended to the watched file.
+const verifyTag = "nixpersist-verify"
+
+var (
+	// sentinelDir holds the sentinel scripts; a libexec path keeps the
+	// bin_t label rsyslogd may execute under SELinux.
+	sentinelDir = "/usr/local/libexec/nixpersist"
+	// evidenceDir holds the sentinel records; Write hands them to the user
+	// rsyslogd drops privileges to.
+	evidenceDir = "/var/log"
+	// syslogSocket is where the shell module's test message is sent.
+	syslogSocket = "/dev/log"
+	// verifyPoll is how often the sentinel records are read.
+	verifyPoll = 100 * time.Millisecond
+	// lookupUser resolves the user rsyslogd drops privileges to.
+	lookupUser = user.Lookup
+)
+
+// Sentinel wraps the verification payload while a trigger is verified:
+// rsyslog runs the script, which records its process lineage, runs the
+// verification payload and records its exit status.
+type Sentinel struct {
+	Script   string
+	Evidence string
+}
+
+// NewSentinel returns the sentinel of the module called name.
+func NewSentinel(name string) Sentinel {
+	return Sentinel{
+		Script:   filepath.Join(sentinelDir, "verify-"+name+".sh"),
+		Evidence: filepath.Join(evidenceDir, verifyTag+"-"+name+".log"),
+	}
+}
+
+// Render returns the script running payload in a subshell, with the
+// arguments rsyslog passed, so that its exit status is always recorded.
+func (s Sentinel) Render(payload string) string {
+	return `#!/bin/sh
+# NixPersist --verify sentinel: records the lineage and exit status of each
+# payload run rsyslog starts.
+evidence='` + s.Evidence + `'
+{
+	echo "start $(date +%s%N)"
+	p=$$
+	while [ "${p:-0}" -gt 0 ]; do
+		echo "lineage $p $(cat /proc/$p/comm 2>/dev/null)"
+		p=$(sed -n 's/^PPid:[[:space:]]*//p' /proc/$p/status 2>/dev/null)
+	done
+} >>"$evidence" 2>/dev/null
+(
+	` + strings.TrimSpace(payload) + ` "$@"
+)
+status=$?
+echo "exit $status $(date +%s%N)" >>"$evidence" 2>/dev/null
+exit $status
+`
+}
+
+// Write installs the script for payload and an empty record file, which
+// only the user rsyslogd runs programs as may read and write.
+func (s Sentinel) Write(payload string) error {
+	if err := os.MkdirAll(filepath.Dir(s.Script), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(s.Script), err)
+	}
+	if err := os.WriteFile(s.Script, []byte(s.Render(payload)), 0755); err != nil {
+		return fmt.Errorf("write sentinel: %w", err)
+	}
+	// Created afresh, so that neither a leftover record nor a link planted
+	// in its place keeps another owner.
+	if err := os.Remove(s.Evidence); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("write sentinel record: %w", err)
+	}
+	f, err := os.OpenFile(s.Evidence, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("write sentinel record: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write sentinel record: %w", err)
+	}
+	if uid, gid, ok := rsyslogUser(); ok {
+		if err := os.Chown(s.Evidence, uid, gid); err != nil {
+			return fmt.Errorf("write sentinel record: %w", err)
+		}
+	}
+	return nil
+}
+
+// rsyslogUser returns the user and group rsyslogd runs programs as when the
+// configuration drops privileges with $PrivDropToUser or
+// global(privDropToUser=...).
+func rsyslogUser() (uid, gid int, ok bool) {
+	cfg, err := LoadConfig(configRoot, DefaultShellConfigPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	var name string
+	cfg.Walk(func(s *Statement, _ []*Statement) bool {
+		switch {
+		case s.Kind == KindDirective && strings.EqualFold(s.Name, "PrivDropToUser"):
+			name = s.Value
+		case s.Kind == KindObject && s.Name == "global":
+			if v, found := s.Param("privDropToUser"); found {
+				name = v
+			}
+		}
+		return true
+	})
+	if name == "" {
+		return 0, 0, false
+	}
+	u, err := lookupUser(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	uid, uerr := strconv.Atoi(u.Uid)
+	gid, gerr := strconv.Atoi(u.Gid)
+	return uid, gid, uerr == nil && gerr == nil
+}
+
+// Remove deletes the sentinel files.
+func (s Sentinel) Remove() error {
+	for _, path := range []string{s.Script, s.Evidence} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify clears the records, fires the trigger with fire and waits up to
+// timeout for the sentinel to run.
+func (s Sentinel) Verify(fire func() (string, error), timeout time.Duration) module.Verification {
+	if err := os.Truncate(s.Evidence, 0); err != nil {
+		return module.Verification{Error: fmt.Sprintf("reset sentinel record: %v", err)}
+	}
+	fired := time.Now()
+	how, err := fire()
+	v := module.Verification{Fired: how}
+	if err != nil {
+		v.Error = "fire trigger: " + err.Error()
+		return v
+	}
+	deadline := fired.Add(timeout)
+	for {
+		run, ok := readEvidence(s.Evidence)
+		if ok {
+			v.Executed, v.Lineage, v.ExitStatus = true, run.lineage, run.exit
+			v.Latency = run.start.Sub(fired)
+			if run.start.IsZero() || v.Latency < 0 {
+				// date without %N: fall back to when the record was seen.
+				v.Latency = time.Since(fired)
+			}
+		}
+		if run.exit != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(verifyPoll)
+	}
+	if !v.Executed {
+		v.Error = fmt.Sprintf("sentinel did not run within %s of %s", timeout, how)
+	}
+	return v
+}
+
+// sentinelRun is the first payload run recorded by the sentinel.
+type sentinelRun struct {
+	start   time.Time
+	lineage []string
+	exit    *int
+}
+
+func readEvidence(path string) (sentinelRun, bool) {
+	var run sentinelRun
+	f, err := os.Open(path)
+	if err != nil {
+		return run, false
+	}
+	defer f.Close()
+	started := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "start":
+			if started {
+				return run, true
+			}
+			started = true
+			if ns, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				run.start = time.Unix(0, ns)
+			}
+		case "lineage":
+			comm := strings.Join(fields[2:], " ")
+			run.lineage = append(run.lineage, fmt.Sprintf("%s[%s]", comm, fields[1]))
+		case "exit":
+			if status, err := strconv.Atoi(fields[1]); err == nil && started {
+				run.exit = &status
+				return run, true
+			}
+		}
+	}
+	return run, started
+}
+
+// fireSyslog sends a message containing trigger to the local syslog
+// socket, falling back to logger.
+func fireSyslog(trigger string) (string, error) { return fireSyslogAs(verifyTag, trigger) }
+
+// fireSyslogAs is fireSyslog with tag as the program name.
+func fireSyslogAs(tag, trigger string) (string, error) {
+	msg := syslogMessage(tag, trigger)
+	conn, err := net.Dial("unixgram", syslogSocket)
+	if err == nil {
+		defer conn.Close()
+		if _, err = conn.Write([]byte(msg)); err == nil {
+			return "a test message on " + syslogSocket, nil
+		}
+	}
+	if _, lerr := exec.LookPath("logger"); lerr != nil {
+		return "", fmt.Errorf("%s: %v; logger not found", syslogSocket, err)
+	}
+	if out, lerr := exec.Command("logger", "-t", tag, "--", trigger).CombinedOutput(); lerr != nil {
+		return "", fmt.Errorf("logger: %w: %s", lerr, strings.TrimSpace(string(out)))
+	}
+	return "a test message via logger", nil
+}
+
+// fireNet sends a message containing trigger to the imtcp or imudp
+// listener on localhost port; TCP messages are newline-framed.
+func fireNet(network string, port int, tag, trigger string) (string, error) {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(syslogMessage(tag, trigger) + "\n")); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("a test message to %s %s", network, addr), nil
+}
+
+// syslogMessage formats an RFC 3164 user.notice message from tag.
+func syslogMessage(tag, trigger string) string {
+	return fmt.Sprintf("<13>%s %s: %s", time.Now().Format(time.Stamp), tag, trigger)
+}
+
+// fireFile appends a line containing trigger to the file imfile watches.
+func fireFile(path, trigger string) (string, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s %s\n", time.Now().Format(time.RFC3339), verifyTag, trigger); err != nil {
+		return "", err
+	}
+	return "a test line appended to " + path, nil
+}
+
+// planVerify adds the verification run to plan, for --verify: the trigger
+// is planted with the sentinel running payload, fired, and taken out again
+// before the install.
+func planVerify(plan *module.Plan, s Sentinel, payload, fire string, timeout time.Duration) {
+	plan.Commands = append(plan.Commands,
+		"--verify, before the install: write "+s.Script+" running "+payload+" and record its runs in "+s.Evidence,
+		"plant the trigger with "+s.Script+" as its program and reload rsyslog",
+		fire,
+		fmt.Sprintf("wait up to %s for the sentinel to record its run", timeout),
+		"take the trigger out, reload rsyslog and delete "+s.Script+" and "+s.Evidence)
+	plan.Notes = append(plan.Notes, "--verify runs "+payload+" in place of the payload; the installed trigger runs the payload")
+}
@@ -0,0 +1,220 @@
+package rsyslog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// useSentinelDirs points the sentinel and its record at temporary
+// directories.
+func useSentinelDirs(t *testing.T) Sentinel {
+	t.Helper()
+	root := t.TempDir()
+	origSentinel, origEvidence, origPoll, origRoot := sentinelDir, evidenceDir, verifyPoll, configRoot
+	sentinelDir, evidenceDir, verifyPoll, configRoot = filepath.Join(root, "libexec"), root, 10*time.Millisecond, root
+	t.Cleanup(func() {
+		sentinelDir, evidenceDir, verifyPoll, configRoot = origSentinel, origEvidence, origPoll, origRoot
+	})
+	return NewSentinel("rsyslog-omprog")
+}
+
+func TestReadEvidence(t *testing.T) {
+	s := useSentinelDirs(t)
+	record := "start 1700000000500000000\nlineage 42 sh\nlineage 7 rsyslogd\nlineage 1 systemd\nexit 3 1700000000600000000\n" +
+		"start 1700000001000000000\nlineage 43 sh\n"
+	if err := os.WriteFile(s.Evidence, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run, ok := readEvidence(s.Evidence)
+	if !ok || run.exit == nil || *run.exit != 3 || !run.start.Equal(time.Unix(0, 1700000000500000000)) {
+		t.Fatalf("got %+v %v", run, ok)
+	}
+	if got := strings.Join(run.lineage, " "); got != "sh[42] rsyslogd[7] systemd[1]" {
+		t.Fatalf("lineage %q", got)
+	}
+
+	if err := os.WriteFile(s.Evidence, []byte("start 1700000000500000000\nlineage 42 sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if run, ok := readEvidence(s.Evidence); !ok || run.exit != nil {
+		t.Fatalf("running payload: got %+v %v", run, ok)
+	}
+	if _, ok := readEvidence(filepath.Join(evidenceDir, "missing")); ok {
+		t.Fatal("missing record reported a run")
+	}
+}
+
+func TestSentinelVerify(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	s := useSentinelDirs(t)
+	if err := s.Write("exit 5"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if info, err := os.Stat(s.Evidence); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("record: %v %v", info, err)
+	}
+
+	v := s.Verify(func() (string, error) {
+		// Stands in for rsyslogd: run the sentinel once the trigger is seen.
+		go exec.Command(s.Script).Run()
+		return "a test line", nil
+	}, 5*time.Second)
+	if !v.Executed || v.ExitStatus == nil || *v.ExitStatus != 5 || v.Error != "" {
+		t.Fatalf("got %+v", v)
+	}
+	if len(v.Lineage) < 2 || !strings.HasSuffix(v.Lineage[1], "["+strconv.Itoa(os.Getpid())+"]") {
+		t.Fatalf("lineage should lead back to the test process: %v", v.Lineage)
+	}
+	if !strings.HasPrefix(v.Summary(), "trigger verified: payload started ") || !strings.Contains(v.Summary(), "after a test line, exit status 5") {
+		t.Fatalf("summary %q", v.Summary())
+	}
+
+	v = s.Verify(func() (string, error) { return "a test line", nil }, 50*time.Millisecond)
+	if v.Executed || !strings.Contains(v.Summary(), "FAILED: sentinel did not run within 50ms of a test line") {
+		t.Fatalf("timeout: got %+v", v)
+	}
+
+	if err := s.Remove(); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	for _, path := range []string{s.Script, s.Evidence} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s left behind: %v", path, err)
+		}
+	}
+}
+
+func TestSentinelRecordOwnedByRsyslogUser(t *testing.T) {
+	s := useSentinelDirs(t)
+	if err := os.MkdirAll(filepath.Join(configRoot, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configRoot, DefaultShellConfigPath), []byte("global(privDropToUser=\"syslog\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var looked string
+	orig := lookupUser
+	lookupUser = func(name string) (*user.User, error) {
+		looked = name
+		return &user.User{Uid: strconv.Itoa(os.Getuid()), Gid: strconv.Itoa(os.Getgid())}, nil
+	}
+	t.Cleanup(func() { lookupUser = orig })
+
+	// A leftover record open to everyone is replaced.
+	if err := os.WriteFile(s.Evidence, []byte("stale\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("true"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	info, err := os.Stat(s.Evidence)
+	if err != nil || info.Mode().Perm() != 0600 || info.Size() != 0 {
+		t.Fatalf("record: %v %v", info, err)
+	}
+	if looked != "syslog" {
+		t.Fatalf("looked up %q, want syslog", looked)
+	}
+}
+
+func TestFireFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	how, err := fireFile(path, "hacker")
+	if err != nil || how != "a test line appended to "+path {
+		t.Fatalf("got %q %v", how, err)
+	}
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "existing" || !strings.HasSuffix(lines[1], " nixpersist-verify hacker") {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
+
+func TestFireSyslog(t *testing.T) {
+	orig := syslogSocket
+	syslogSocket = filepath.Join(t.TempDir(), "log")
+	t.Cleanup(func() { syslogSocket = orig })
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: syslogSocket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	how, err := fireSyslog("hacker")
+	if err != nil || how != "a test message on "+syslogSocket {
+		t.Fatalf("got %q %v", how, err)
+	}
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<13>") || !strings.HasSuffix(msg, " nixpersist-verify: hacker") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestVerifyPlanKeepsPayload(t *testing.T) {
+	useSentinelDirs(t)
+
+	m := NewOmprogModule()
+	m.in, m.payload, m.trigger = "/var/log/app.log", "/opt/payload", "hacker"
+	m.verify, m.verifyPayload, m.instance = true, "/bin/echo checked", "verifyplan"
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	sentinel := NewSentinel(instanceFile(m.Name(), m.instance))
+	for _, f := range plan.Files {
+		if strings.Contains(string(f.After), sentinel.Script) {
+			t.Fatalf("%s installed with the sentinel:\n%s", f.Path, f.After)
+		}
+	}
+	if got := string(plan.Files[0].After); !strings.Contains(got, `binary="/opt/payload"`) {
+		t.Fatalf("payload not installed:\n%s", got)
+	}
+	cmds := strings.Join(plan.Commands, "\n")
+	if !strings.Contains(cmds, "write "+sentinel.Script+" running /bin/echo checked") || !strings.Contains(cmds, "take the trigger out") {
+		t.Fatalf("verification run not planned:\n%s", cmds)
+	}
+}
+
+func TestVerifyWhenRequiresTrigger(t *testing.T) {
+	useSentinelDirs(t)
+
+	m := NewOmprogModule()
+	fs := pflag.NewFlagSet(m.Name(), pflag.ContinueOnError)
+	m.Flags(fs)
+	args := []string{"--log-file-in", "/var/log/app.log", "--verify", "--when", "programname==sshd", "--instance", "verifywhen"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Plan(); err == nil || !strings.Contains(err.Error(), "requires -t") {
+		t.Fatalf("Plan without -t: %v", err)
+	}
+	if _, err := m.Install(); err == nil || !strings.Contains(err.Error(), "requires -t") {
+		t.Fatalf("Install without -t: %v", err)
+	}
+
+	if err := fs.Parse([]string{"-t", "hacker"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Plan(); err != nil {
+		t.Fatalf("Plan with -t: %v", err)
+	}
+}
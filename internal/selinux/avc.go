@@ -0,0 +1,103 @@
+package selinux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditLog is where auditd writes AVC records.
+var auditLog = "/var/log/audit/audit.log"
+
+// Denial is an AVC denial record from the audit log.
+type Denial struct {
+	Time   time.Time
+	Comm   string
+	Perms  []string
+	Name   string
+	Source Context
+	Target Context
+	Class  string
+	// Permissive is set when the access was only logged.
+	Permissive bool
+}
+
+func (d Denial) String() string {
+	s := fmt.Sprintf("%s %s (%s) denied { %s } on %s %s (%s)", d.Time.Format(time.RFC3339), d.Comm, d.Source.Type, strings.Join(d.Perms, " "), d.Class, d.Name, d.Target.Type)
+	if d.Permissive {
+		s += " [permissive]"
+	}
+	return s
+}
+
+// Denials returns the AVC denials logged since since whose source domain is
+// domain, or every denial when domain is empty, oldest first.
+func Denials(domain string, since time.Time) ([]Denial, error) {
+	f, err := os.Open(auditLog)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Denial
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		d, ok := parseAVC(sc.Text())
+		if !ok || d.Time.Before(since) || (domain != "" && d.Source.Type != domain) {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, sc.Err()
+}
+
+// parseAVC reads a record such as
+//
+//	type=AVC msg=audit(1700000000.123:456): avc:  denied  { execute } for  pid=1 comm="rsyslogd" name="x" scontext=... tcontext=... tclass=file permissive=0
+func parseAVC(line string) (Denial, bool) {
+	if !strings.HasPrefix(line, "type=AVC ") || !strings.Contains(line, " denied ") {
+		return Denial{}, false
+	}
+	var d Denial
+	if _, rest, ok := strings.Cut(line, "audit("); ok {
+		stamp, _, _ := strings.Cut(rest, ":")
+		secs, err := strconv.ParseFloat(stamp, 64)
+		if err != nil {
+			return Denial{}, false
+		}
+		d.Time = time.Unix(0, int64(secs*float64(time.Second)))
+	}
+	open, end := strings.Index(line, "{"), strings.Index(line, "}")
+	if open < 0 || end < open {
+		return Denial{}, false
+	}
+	d.Perms = strings.Fields(line[open+1 : end])
+	for _, field := range strings.Fields(line[end+1:]) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "comm":
+			d.Comm = value
+		case "name", "path":
+			d.Name = value
+		case "scontext":
+			d.Source, _ = ParseContext(value)
+		case "tcontext":
+			d.Target, _ = ParseContext(value)
+		case "tclass":
+			d.Class = value
+		case "permissive":
+			d.Permissive = value == "1"
+		}
+	}
+	return d, d.Source.Type != ""
+}
+
+// RecentWindow is how far back --check looks for AVC denials.
+const RecentWindow = 24 * time.Hour
@@ -0,0 +1,19 @@
+//go:build linux
+
+package selinux
+
+import "syscall"
+
+const labelXattr = "security.selinux"
+
+func readFileLabel(path string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, labelXattr, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(path, labelXattr, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package selinux
+
+import "errors"
+
+func readFileLabel(string) ([]byte, error) {
+	return nil, errors.New("file contexts are only readable on Linux")
+}
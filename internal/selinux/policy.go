@@ -0,0 +1,127 @@
+package selinux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Verdict is the predicted outcome of a domain executing a file.
+type Verdict string
+
+const (
+	Allowed Verdict = "allowed"
+	Denied  Verdict = "denied"
+	// Logged is a denial that permissive mode only records as an AVC.
+	Logged  Verdict = "logged only"
+	Unknown Verdict = "unknown"
+)
+
+// Prediction is a Verdict and what it was derived from.
+type Prediction struct {
+	Verdict Verdict `json:"verdict"`
+	Reason  string  `json:"reason"`
+}
+
+// unconfinedDomains are not restricted by the targeted policy.
+var unconfinedDomains = []string{"unconfined_t", "unconfined_service_t", "initrc_t", "kernel_t", "spc_t"}
+
+// execTypes approximate the targeted policy for the daemons NixPersist
+// plants in: the file types each domain may execute. They are used when
+// sesearch cannot query the loaded policy.
+var execTypes = map[string][]string{
+	"syslogd_t": {"bin_t", "shell_exec_t", "syslogd_exec_t"},
+	"httpd_t":   {"bin_t", "shell_exec_t", "httpd_exec_t", "httpd_rotatelogs_exec_t", "httpd_sys_script_exec_t"},
+}
+
+// Predict says whether domain may execute a file of fileType under mode:
+// from the loaded policy through sesearch when it is installed, otherwise
+// from execTypes.
+func Predict(mode Mode, domain, fileType string) Prediction {
+	if mode == Disabled || mode == "" {
+		return Prediction{Allowed, "SELinux is disabled"}
+	}
+	if contains(unconfinedDomains, domain) {
+		return Prediction{Allowed, domain + " is unconfined"}
+	}
+	allowed, reason, known := policyAllows(domain, fileType)
+	switch {
+	case !known:
+		return Prediction{Unknown, reason}
+	case allowed:
+		return Prediction{Allowed, reason}
+	case mode == Permissive:
+		return Prediction{Logged, reason + "; permissive mode logs the AVC and lets it run"}
+	}
+	return Prediction{Denied, reason}
+}
+
+func policyAllows(domain, fileType string) (bool, string, bool) {
+	if _, err := lookPath("sesearch"); err == nil {
+		out, err := execCommand("sesearch", "--allow", "-s", domain, "-t", fileType, "-c", "file", "-p", "execute").Output()
+		if err == nil {
+			return searchAllows(string(out), domain, fileType)
+		}
+	}
+	types, ok := execTypes[domain]
+	if !ok {
+		return false, fmt.Sprintf("no built-in rules for %s; install setools (sesearch) to query the policy", domain), false
+	}
+	if contains(types, fileType) {
+		return true, fmt.Sprintf("%s may execute %s (built-in targeted policy rules)", domain, fileType), true
+	}
+	return false, fmt.Sprintf("%s may not execute %s (built-in targeted policy rules; relabel the payload, e.g. to bin_t)", domain, fileType), true
+}
+
+// searchAllows interprets sesearch rules such as
+//
+//	allow httpd_t httpd_tmp_t:file { execute getattr }; [ httpd_tmp_exec ]:True
+//
+// An unconditional rule allows; a rule conditional on one boolean allows
+// while that boolean is set as its branch requires.
+func searchAllows(out, domain, fileType string) (bool, string, bool) {
+	var conditions []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "allow ") {
+			continue
+		}
+		open := strings.Index(line, "[")
+		if open < 0 {
+			return true, fmt.Sprintf("loaded policy allows %s to execute %s", domain, fileType), true
+		}
+		expr, branch, _ := strings.Cut(line[open+1:], "]")
+		expr = strings.TrimSpace(expr)
+		want := strings.TrimPrefix(strings.TrimSpace(branch), ":") == "True"
+		if strings.ContainsAny(expr, " &|!^") {
+			conditions = append(conditions, expr)
+			continue
+		}
+		if on, ok := Boolean(expr); ok && on == want {
+			return true, fmt.Sprintf("loaded policy allows %s to execute %s while boolean %s is %s", domain, fileType, expr, onOff(on)), true
+		}
+		conditions = append(conditions, fmt.Sprintf("boolean %s %s", expr, onOff(want)))
+	}
+	if len(conditions) > 0 {
+		return false, fmt.Sprintf("loaded policy lets %s execute %s only with %s", domain, fileType, strings.Join(conditions, " or ")), true
+	}
+	return false, fmt.Sprintf("loaded policy has no rule letting %s execute %s", domain, fileType), true
+}
+
+// Boolean reads the current value of a policy boolean from selinuxfs.
+func Boolean(name string) (bool, bool) {
+	data, err := os.ReadFile(filepath.Join(fsRoot, "booleans", name))
+	if err != nil {
+		return false, false
+	}
+	fields := strings.Fields(string(data))
+	return len(fields) > 0 && fields[0] == "1", len(fields) > 0
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,114 @@
+package selinux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Report is the SELinux view of a daemon executing a payload, shown by a
+// module's --check.
+type Report struct {
+	Mode Mode `json:"mode"`
+	// Daemon is the process that executes the payload.
+	Daemon string `json:"daemon"`
+	// Domain is the type the daemon runs as: read from the running process,
+	// or the policy's domain for it when it is not running.
+	Domain string `json:"domain,omitempty"`
+	// ProcessContext is the full context of the running daemon.
+	ProcessContext string `json:"process_context,omitempty"`
+	Pid            int    `json:"pid,omitempty"`
+	// Program is the payload executable whose label decides the access.
+	Program        string      `json:"program,omitempty"`
+	ProgramContext string      `json:"program_context,omitempty"`
+	Prediction     *Prediction `json:"prediction,omitempty"`
+	// Denials are AVC denials for Domain, when they were asked for.
+	Denials []string `json:"denials,omitempty"`
+}
+
+// Inspect reports the mode, the domain of the daemon named by names (domain
+// when it is not running), the context of payload's program and whether
+// that domain may execute it. The notes explain what could not be read.
+func Inspect(names []string, domain, payload string) (Report, []string) {
+	r := Report{Mode: CurrentMode(), Daemon: names[0], Domain: domain}
+	var notes []string
+	if r.Mode == Disabled {
+		if configured := ConfiguredMode(); configured != "" && configured != Disabled {
+			notes = append(notes, fmt.Sprintf("SELinux is disabled but %s sets %s for the next boot", configPath, configured))
+		}
+		return r, notes
+	}
+	if pid, ctx, ok := ProcessContext(names...); ok {
+		r.Pid, r.ProcessContext, r.Domain = pid, ctx.String(), ctx.Type
+	} else {
+		notes = append(notes, fmt.Sprintf("%s is not running; assuming the policy's %s domain", r.Daemon, domain))
+	}
+
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return r, notes
+	}
+	r.Program = fields[0]
+	ctx, err := FileContext(r.Program)
+	if errors.Is(err, os.ErrNotExist) {
+		// The payload is often dropped later; predict from the label it
+		// would get where it is expected to be created.
+		if ctx, err = ExpectedFileContext(r.Program); err == nil {
+			notes = append(notes, fmt.Sprintf("%s does not exist yet; a file created there is labelled %s", r.Program, ctx))
+		}
+	}
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("cannot determine the context of %s: %v", r.Program, err))
+		return r, notes
+	}
+	r.ProgramContext = ctx.String()
+	p := Predict(r.Mode, r.Domain, ctx.Type)
+	r.Prediction = &p
+	return r, notes
+}
+
+// LoadDenials fills Denials with the AVC denials for the report's domain
+// logged since since.
+func (r *Report) LoadDenials(since time.Time) error {
+	if r.Mode == Disabled {
+		return nil
+	}
+	denials, err := Denials(r.Domain, since)
+	if err != nil {
+		return fmt.Errorf("read AVC denials: %w", err)
+	}
+	r.Denials = nil
+	for _, d := range denials {
+		r.Denials = append(r.Denials, d.String())
+	}
+	return nil
+}
+
+// Lines renders the report as "- label: value" lines.
+func (r Report) Lines() []string {
+	lines := []string{"SELinux mode: " + string(r.Mode)}
+	if r.Mode == Disabled {
+		return lines
+	}
+	switch {
+	case r.ProcessContext != "":
+		lines = append(lines, fmt.Sprintf("%s domain: %s (pid %d)", r.Daemon, r.ProcessContext, r.Pid))
+	case r.Domain != "":
+		lines = append(lines, fmt.Sprintf("%s domain: %s (assumed)", r.Daemon, r.Domain))
+	}
+	if r.ProgramContext != "" {
+		lines = append(lines, fmt.Sprintf("payload context: %s %s", r.Program, r.ProgramContext))
+	}
+	if r.Prediction != nil {
+		lines = append(lines, fmt.Sprintf("payload execution: %s (%s)", r.Prediction.Verdict, r.Prediction.Reason))
+	}
+	for _, d := range r.Denials {
+		lines = append(lines, "AVC denial: "+d)
+	}
+	for i, l := range lines {
+		lines[i] = "- " + l
+	}
+	return lines
+}
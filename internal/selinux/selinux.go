@@ -0,0 +1,161 @@
+// Package selinux inspects the SELinux state that decides whether a daemon
+// may execute a payload: the enforcement mode, the domain the daemon runs
+// in, the context the payload file is labelled with, and the AVC denials the
+// kernel has logged for that domain.
+package selinux
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	execCommand = exec.Command
+	lookPath    = exec.LookPath
+
+	// fsRoot is the selinuxfs mount; it is absent when SELinux is disabled.
+	fsRoot = "/sys/fs/selinux"
+	// configPath holds the mode SELinux is set to at boot.
+	configPath = "/etc/selinux/config"
+	// procRoot is where daemon processes are looked up.
+	procRoot = "/proc"
+	// fileLabel returns the raw security.selinux attribute of a file.
+	fileLabel = readFileLabel
+)
+
+// Mode is the SELinux enforcement mode.
+type Mode string
+
+const (
+	Disabled   Mode = "disabled"
+	Permissive Mode = "permissive"
+	Enforcing  Mode = "enforcing"
+)
+
+// CurrentMode reads the running mode from selinuxfs.
+func CurrentMode() Mode {
+	data, err := os.ReadFile(filepath.Join(fsRoot, "enforce"))
+	if err != nil {
+		return Disabled
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return Enforcing
+	}
+	return Permissive
+}
+
+// ConfiguredMode returns the SELINUX= mode of the config file, which takes
+// effect at the next boot; empty when the file is missing.
+func ConfiguredMode() Mode {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return ""
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if ok && key == "SELINUX" {
+			return Mode(strings.ToLower(strings.Trim(value, `"'`)))
+		}
+	}
+	return ""
+}
+
+// Context is a security context, user:role:type:level.
+type Context struct {
+	User  string
+	Role  string
+	Type  string
+	Level string
+}
+
+// ParseContext splits a security context. The level may hold colons itself,
+// as in s0-s0:c0.c1023.
+func ParseContext(s string) (Context, error) {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Context{}, fmt.Errorf("not a security context: %q", s)
+	}
+	c := Context{User: parts[0], Role: parts[1], Type: parts[2]}
+	if len(parts) == 4 {
+		c.Level = parts[3]
+	}
+	return c, nil
+}
+
+func (c Context) String() string {
+	s := c.User + ":" + c.Role + ":" + c.Type
+	if c.Level != "" {
+		s += ":" + c.Level
+	}
+	return s
+}
+
+// ProcessContext returns the pid and context of the first process, by pid,
+// whose name is one of names. Processes labelled by another LSM, such as
+// AppArmor's "unconfined", are skipped.
+func ProcessContext(names ...string) (int, Context, bool) {
+	entries, _ := os.ReadDir(procRoot)
+	var pids []int
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil || !contains(names, strings.TrimSpace(string(comm))) {
+			continue
+		}
+		label, err := os.ReadFile(filepath.Join(dir, "attr", "current"))
+		if err != nil {
+			continue
+		}
+		if c, err := ParseContext(string(label)); err == nil {
+			return pid, c, true
+		}
+	}
+	return 0, Context{}, false
+}
+
+// FileContext returns the context path is labelled with.
+func FileContext(path string) (Context, error) {
+	label, err := fileLabel(path)
+	if err != nil {
+		return Context{}, fmt.Errorf("read context of %s: %w", path, err)
+	}
+	return ParseContext(string(label))
+}
+
+// ExpectedFileContext asks matchpathcon for the context the policy assigns
+// to path, which restorecon would relabel it to.
+func ExpectedFileContext(path string) (Context, error) {
+	if _, err := lookPath("matchpathcon"); err != nil {
+		return Context{}, errors.New("matchpathcon not found")
+	}
+	out, err := execCommand("matchpathcon", "-n", path).Output()
+	if err != nil {
+		return Context{}, fmt.Errorf("matchpathcon %s: %w", path, err)
+	}
+	return ParseContext(string(out))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package selinux
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useHost points the package at a fake selinuxfs, /proc and audit log with
+// no setools or matchpathcon on PATH, and labels files from labels.
+func useHost(t *testing.T, enforce string, labels map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	origFS, origConfig, origProc, origAudit := fsRoot, configPath, procRoot, auditLog
+	origLabel, origLookPath := fileLabel, lookPath
+	fsRoot = filepath.Join(root, "selinuxfs")
+	configPath = filepath.Join(root, "config")
+	procRoot = filepath.Join(root, "proc")
+	auditLog = filepath.Join(root, "audit.log")
+	fileLabel = func(path string) ([]byte, error) {
+		if label, ok := labels[path]; ok {
+			return []byte(label + "\x00"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	lookPath = func(name string) (string, error) { return "", exec.ErrNotFound }
+	t.Cleanup(func() {
+		fsRoot, configPath, procRoot, auditLog = origFS, origConfig, origProc, origAudit
+		fileLabel, lookPath = origLabel, origLookPath
+	})
+	if enforce != "" {
+		writeFile(t, filepath.Join(fsRoot, "enforce"), enforce)
+	}
+	return root
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func fakeProcess(t *testing.T, pid, comm, label string) {
+	t.Helper()
+	writeFile(t, filepath.Join(procRoot, pid, "comm"), comm+"\n")
+	writeFile(t, filepath.Join(procRoot, pid, "attr", "current"), label+"\x00")
+}
+
+func TestParseContext(t *testing.T) {
+	c, err := ParseContext("system_u:system_r:httpd_t:s0-s0:c0.c1023\x00")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if c.Type != "httpd_t" || c.Level != "s0-s0:c0.c1023" || c.String() != "system_u:system_r:httpd_t:s0-s0:c0.c1023" {
+		t.Fatalf("unexpected context %+v", c)
+	}
+	for _, bad := range []string{"unconfined", "rsyslogd (enforce)", ""} {
+		if _, err := ParseContext(bad); err == nil {
+			t.Fatalf("%q parsed as a context", bad)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	useHost(t, "", nil)
+	writeFile(t, configPath, "# comment\nSELINUX=enforcing\nSELINUXTYPE=targeted\n")
+	if CurrentMode() != Disabled || ConfiguredMode() != Enforcing {
+		t.Fatalf("got %s/%s, want disabled/enforcing", CurrentMode(), ConfiguredMode())
+	}
+	writeFile(t, filepath.Join(fsRoot, "enforce"), "0")
+	if CurrentMode() != Permissive {
+		t.Fatalf("got %s, want permissive", CurrentMode())
+	}
+	writeFile(t, filepath.Join(fsRoot, "enforce"), "1")
+	if CurrentMode() != Enforcing {
+		t.Fatalf("got %s, want enforcing", CurrentMode())
+	}
+}
+
+func TestProcessContextSkipsOtherLSMLabels(t *testing.T) {
+	useHost(t, "1", nil)
+	fakeProcess(t, "90", "rsyslogd", "unconfined")
+	fakeProcess(t, "812", "rsyslogd", "system_u:system_r:syslogd_t:s0")
+	fakeProcess(t, "900", "sshd", "system_u:system_r:sshd_t:s0-s0:c0.c1023")
+	pid, c, ok := ProcessContext("rsyslogd")
+	if !ok || pid != 812 || c.Type != "syslogd_t" {
+		t.Fatalf("got %d %+v %v", pid, c, ok)
+	}
+	if _, _, ok := ProcessContext("httpd"); ok {
+		t.Fatal("found a process that is not running")
+	}
+}
+
+func TestPredictBuiltInRules(t *testing.T) {
+	useHost(t, "1", nil)
+	cases := []struct {
+		mode     Mode
+		domain   string
+		fileType string
+		want     Verdict
+	}{
+		{Enforcing, "syslogd_t", "bin_t", Allowed},
+		{Enforcing, "syslogd_t", "user_tmp_t", Denied},
+		{Permissive, "httpd_t", "tmp_t", Logged},
+		{Enforcing, "httpd_t", "httpd_sys_script_exec_t", Allowed},
+		{Enforcing, "unconfined_service_t", "tmp_t", Allowed},
+		{Enforcing, "named_t", "bin_t", Unknown},
+		{Disabled, "syslogd_t", "tmp_t", Allowed},
+	}
+	for _, c := range cases {
+		if got := Predict(c.mode, c.domain, c.fileType); got.Verdict != c.want {
+			t.Errorf("%s %s executing %s: got %s (%s), want %s", c.mode, c.domain, c.fileType, got.Verdict, got.Reason, c.want)
+		}
+	}
+}
+
+func TestSearchAllowsConditionalRules(t *testing.T) {
+	useHost(t, "1", nil)
+	writeFile(t, filepath.Join(fsRoot, "booleans", "httpd_tmp_exec"), "0 0")
+	out := "allow httpd_t httpd_tmp_t:file { execute getattr open read }; [ httpd_tmp_exec ]:True\n"
+	allowed, reason, known := searchAllows(out, "httpd_t", "httpd_tmp_t")
+	if allowed || !known || !strings.Contains(reason, "boolean httpd_tmp_exec on") {
+		t.Fatalf("boolean off: got %v %v %q", allowed, known, reason)
+	}
+	writeFile(t, filepath.Join(fsRoot, "booleans", "httpd_tmp_exec"), "1 1")
+	if allowed, _, _ := searchAllows(out, "httpd_t", "httpd_tmp_t"); !allowed {
+		t.Fatal("boolean on: expected allowed")
+	}
+	if allowed, _, known := searchAllows("", "syslogd_t", "tmp_t"); allowed || !known {
+		t.Fatal("no rules: expected denied")
+	}
+	if allowed, _, _ := searchAllows("allow syslogd_t bin_t:file { execute };\n", "syslogd_t", "bin_t"); !allowed {
+		t.Fatal("unconditional rule: expected allowed")
+	}
+}
+
+func TestDenials(t *testing.T) {
+	useHost(t, "1", nil)
+	writeFile(t, auditLog, strings.Join([]string{
+		`type=AVC msg=audit(1700000000.100:10): avc:  denied  { execute } for  pid=812 comm="rsyslogd" name="payload" dev="dm-0" ino=1 scontext=system_u:system_r:syslogd_t:s0 tcontext=unconfined_u:object_r:user_tmp_t:s0 tclass=file permissive=0`,
+		`type=SYSCALL msg=audit(1700000000.100:10): arch=c000003e syscall=59 success=no exit=-13`,
+		`type=AVC msg=audit(1700000100.000:11): avc:  denied  { execute } for  pid=900 comm="httpd" name="beacon" scontext=system_u:system_r:httpd_t:s0 tcontext=system_u:object_r:tmp_t:s0 tclass=file permissive=1`,
+		`type=AVC msg=audit(1700000200.000:12): avc:  denied  { read } for  pid=812 comm="rsyslogd" name="secret" scontext=system_u:system_r:syslogd_t:s0 tcontext=system_u:object_r:shadow_t:s0 tclass=file permissive=0`,
+	}, "\n")+"\n")
+
+	got, err := Denials("syslogd_t", time.Unix(0, 0))
+	if err != nil || len(got) != 2 {
+		t.Fatalf("got %v %v, want two syslogd_t denials", got, err)
+	}
+	if got[0].Comm != "rsyslogd" || got[0].Name != "payload" || got[0].Target.Type != "user_tmp_t" || got[0].Perms[0] != "execute" {
+		t.Fatalf("unexpected denial %+v", got[0])
+	}
+	got, _ = Denials("", time.Unix(1700000050, 0))
+	if len(got) != 2 || !got[0].Permissive || !strings.HasSuffix(got[0].String(), "[permissive]") {
+		t.Fatalf("since filter: got %v", got)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	useHost(t, "1", map[string]string{"/tmp/beacon": "unconfined_u:object_r:user_tmp_t:s0"})
+	fakeProcess(t, "812", "rsyslogd", "system_u:system_r:syslogd_t:s0")
+
+	r, notes := Inspect([]string{"rsyslogd"}, "syslogd_t", "/tmp/beacon --quiet")
+	if r.Pid != 812 || r.Program != "/tmp/beacon" || r.Prediction == nil || r.Prediction.Verdict != Denied || len(notes) != 0 {
+		t.Fatalf("got %+v %v", r, notes)
+	}
+	out := strings.Join(r.Lines(), "\n")
+	for _, want := range []string{"- SELinux mode: enforcing", "- rsyslogd domain: system_u:system_r:syslogd_t:s0 (pid 812)", "- payload context: /tmp/beacon unconfined_u:object_r:user_tmp_t:s0", "- payload execution: denied"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	r, notes = Inspect([]string{"httpd"}, "httpd_t", "/opt/missing")
+	if r.Domain != "httpd_t" || r.Prediction != nil || len(notes) != 2 {
+		t.Fatalf("got %+v %v", r, notes)
+	}
+}
+
+func TestInspectDisabled(t *testing.T) {
+	useHost(t, "", nil)
+	writeFile(t, configPath, "SELINUX=permissive\n")
+	r, notes := Inspect([]string{"rsyslogd"}, "syslogd_t", "/tmp/beacon")
+	if r.Mode != Disabled || len(notes) != 1 || len(r.Lines()) != 1 {
+		t.Fatalf("got %+v %v", r, notes)
+	}
+	if err := r.LoadDenials(time.Time{}); err != nil {
+		t.Fatalf("denials on a disabled host: %v", err)
+	}
+	if _, err := Denials("", time.Time{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing audit log: got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package sigma models Sigma detection rules and renders them as YAML, so
+// every simulated persistence artefact ships with a matching detection.
+package sigma
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Defaults applied by Normalize to rules that leave the fields empty.
+const (
+	DefaultAuthor = "NixPersist"
+	DefaultStatus = "experimental"
+	Reference     = "https://github.com/uhtavi0/NixPersist"
+)
+
+// Rule is a single Sigma rule.
+type Rule struct {
+	Title          string    `json:"title"`
+	ID             string    `json:"id"`
+	Status         string    `json:"status"`
+	Description    string    `json:"description"`
+	References     []string  `json:"references,omitempty"`
+	Author         string    `json:"author"`
+	Date           string    `json:"date,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	LogSource      LogSource `json:"logsource"`
+	Detection      Detection `json:"detection"`
+	FalsePositives []string  `json:"falsepositives,omitempty"`
+	Level          string    `json:"level"`
+}
+
+// LogSource selects the telemetry a rule applies to.
+type LogSource struct {
+	Product  string `json:"product,omitempty"`
+	Category string `json:"category,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// Detection holds the named selections and the condition combining them.
+type Detection struct {
+	Selections []Selection
+	Condition  string
+}
+
+// Selection is either a map of field matches or, when Keywords is set, a
+// keyword list searched across the whole event.
+type Selection struct {
+	Name     string
+	Fields   []Field
+	Keywords []string
+}
+
+// Field matches one event field against one or more values. Modifiers are
+// Sigma value modifiers such as "contains", "endswith", "re" or "all".
+type Field struct {
+	Name      string
+	Modifiers []string
+	Values    []string
+}
+
+// Key returns the field name with its modifiers, e.g. "Image|endswith".
+func (f Field) Key() string {
+	return strings.Join(append([]string{f.Name}, f.Modifiers...), "|")
+}
+
+// MarshalJSON renders the detection the way Sigma's YAML lays it out: one
+// key per selection plus "condition".
+func (d Detection) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for _, s := range d.Selections {
+		name, _ := json.Marshal(s.Name)
+		var value []byte
+		var err error
+		if s.Keywords != nil {
+			value, err = json.Marshal(s.Keywords)
+		} else {
+			fields := make(map[string][]string, len(s.Fields))
+			for _, f := range s.Fields {
+				fields[f.Key()] = f.Values
+			}
+			value, err = json.Marshal(fields)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%s:%s,", name, value)
+	}
+	cond, _ := json.Marshal(d.Condition)
+	fmt.Fprintf(&b, `"condition":%s}`, cond)
+	return []byte(b.String()), nil
+}
+
+// Normalize fills in the author, status, reference and date, and derives a
+// stable ID from the rule content so the same parameters always produce the
+// same rule ID.
+func (r Rule) Normalize(date string) Rule {
+	if r.Author == "" {
+		r.Author = DefaultAuthor
+	}
+	if r.Status == "" {
+		r.Status = DefaultStatus
+	}
+	if len(r.References) == 0 {
+		r.References = []string{Reference}
+	}
+	if r.Date == "" {
+		r.Date = date
+	}
+	if r.ID == "" {
+		r.ID = stableID(r)
+	}
+	return r
+}
+
+// stableID returns a name-based (version 5 style) UUID over the title, log
+// source and detection.
+func stableID(r Rule) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", r.Title, r.LogSource.Product, r.LogSource.Category, r.LogSource.Service)
+	det, _ := json.Marshal(r.Detection)
+	h.Write(det)
+	sum := h.Sum(nil)
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// Render returns the rules as a multi-document YAML stream.
+func Render(rules []Rule) string {
+	docs := make([]string, 0, len(rules))
+	for _, r := range rules {
+		docs = append(docs, r.YAML())
+	}
+	return strings.Join(docs, "---\n")
+}
+
+// YAML renders a single rule using Sigma's conventional key order.
+func (r Rule) YAML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "title: %s\n", scalar(r.Title))
+	if r.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", r.ID)
+	}
+	fmt.Fprintf(&b, "status: %s\n", scalar(r.Status))
+	fmt.Fprintf(&b, "description: %s\n", scalar(r.Description))
+	writeList(&b, "references", r.References, "")
+	fmt.Fprintf(&b, "author: %s\n", scalar(r.Author))
+	if r.Date != "" {
+		fmt.Fprintf(&b, "date: %s\n", scalar(r.Date))
+	}
+	writeList(&b, "tags", r.Tags, "")
+
+	b.WriteString("logsource:\n")
+	if r.LogSource.Product != "" {
+		fmt.Fprintf(&b, "    product: %s\n", scalar(r.LogSource.Product))
+	}
+	if r.LogSource.Category != "" {
+		fmt.Fprintf(&b, "    category: %s\n", scalar(r.LogSource.Category))
+	}
+	if r.LogSource.Service != "" {
+		fmt.Fprintf(&b, "    service: %s\n", scalar(r.LogSource.Service))
+	}
+
+	b.WriteString("detection:\n")
+	for _, s := range r.Detection.Selections {
+		if s.Keywords != nil {
+			writeList(&b, s.Name, s.Keywords, "    ")
+			continue
+		}
+		fmt.Fprintf(&b, "    %s:\n", s.Name)
+		for _, f := range s.Fields {
+			if len(f.Values) == 1 {
+				fmt.Fprintf(&b, "        %s: %s\n", f.Key(), scalar(f.Values[0]))
+				continue
+			}
+			writeList(&b, f.Key(), f.Values, "        ")
+		}
+	}
+	fmt.Fprintf(&b, "    condition: %s\n", r.Detection.Condition)
+
+	writeList(&b, "falsepositives", r.FalsePositives, "")
+	fmt.Fprintf(&b, "level: %s\n", scalar(r.Level))
+	return b.String()
+}
+
+func writeList(b *strings.Builder, key string, values []string, indent string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s%s:\n", indent, key)
+	for _, v := range values {
+		fmt.Fprintf(b, "%s    - %s\n", indent, scalar(v))
+	}
+}
+
+// plain matches values that YAML reads back as the same string unquoted.
+var plain = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 ._/()-]*[A-Za-z0-9._/)]$|^[A-Za-z]$`)
+
+// scalar returns s as a YAML scalar, single-quoting it unless it is plain
+// text that cannot be mistaken for another type.
+func scalar(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+		return "'" + s + "'"
+	}
+	if plain.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Image returns the executable of a payload command line, i.e. the first
+// whitespace-separated field.
+func Image(payload string) string {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
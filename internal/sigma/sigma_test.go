@@ -0,0 +1,88 @@
+package sigma
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func sampleRule() Rule {
+	return Rule{
+		Title:       "Payload Spawned",
+		Description: "Detects it's payload",
+		Tags:        []string{"attack.persistence"},
+		LogSource:   LogSource{Product: "linux", Category: "process_creation"},
+		Detection: Detection{
+			Selections: []Selection{
+				{Name: "selection", Fields: []Field{
+					{Name: "ParentImage", Modifiers: []string{"endswith"}, Values: []string{"/rsyslogd"}},
+					{Name: "Image", Values: []string{"/usr/bin/a", "/usr/bin/b"}},
+				}},
+				{Name: "keywords", Keywords: []string{"true"}},
+			},
+			Condition: "selection or keywords",
+		},
+		Level: "high",
+	}
+}
+
+func TestRuleYAML(t *testing.T) {
+	got := sampleRule().Normalize("2024-01-02").YAML()
+	for _, want := range []string{
+		"title: Payload Spawned\n",
+		"description: 'Detects it''s payload'\n",
+		"author: NixPersist\n",
+		"date: '2024-01-02'\n",
+		"    product: linux\n    category: process_creation\n",
+		"    selection:\n        ParentImage|endswith: '/rsyslogd'\n        Image:\n            - '/usr/bin/a'\n            - '/usr/bin/b'\n",
+		"    keywords:\n        - 'true'\n",
+		"    condition: selection or keywords\n",
+		"level: high\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("YAML missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestNormalizeStableID(t *testing.T) {
+	a := sampleRule().Normalize("2024-01-02")
+	b := sampleRule().Normalize("2030-05-06")
+	if a.ID == "" || a.ID != b.ID {
+		t.Fatalf("expected stable non-empty ID, got %q and %q", a.ID, b.ID)
+	}
+	if len(a.ID) != 36 || a.ID[14] != '5' {
+		t.Fatalf("expected version 5 style UUID, got %q", a.ID)
+	}
+
+	other := sampleRule()
+	other.Detection.Selections[0].Fields[1].Values = []string{"/usr/bin/c"}
+	if other.Normalize("2024-01-02").ID == a.ID {
+		t.Fatal("expected different detections to produce different IDs")
+	}
+}
+
+func TestDetectionJSON(t *testing.T) {
+	data, err := json.Marshal(sampleRule().Detection)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+	if got["condition"] != "selection or keywords" {
+		t.Fatalf("unexpected condition in %s", data)
+	}
+	sel, ok := got["selection"].(map[string]any)
+	if !ok || sel["ParentImage|endswith"] == nil {
+		t.Fatalf("unexpected selection in %s", data)
+	}
+}
+
+func TestRenderSeparatesDocuments(t *testing.T) {
+	r := sampleRule().Normalize("2024-01-02")
+	if got := Render([]Rule{r, r}); strings.Count(got, "\n---\n") != 1 {
+		t.Fatalf("expected two YAML documents, got:\n%s", got)
+	}
+}
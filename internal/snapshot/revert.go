@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"nixpersist/internal/diff"
+)
+
+// ErrNotFound is returned by Revert when the snippet to remove is absent.
+var ErrNotFound = errors.New("snippet not found")
+
+// RevertResult describes how Revert undid an edit.
+type RevertResult struct {
+	// Restored is true when the original file was put back byte for byte.
+	Restored bool
+	// Drift is a unified diff from the original file to the result when the
+	// file had changed since install and could only be edited surgically.
+	Drift string
+}
+
+// Revert undoes an in-place edit of path. It returns ErrNotFound unless
+// remove finds the snippet in the current content. When a snapshot exists
+// and the file is exactly as NixPersist left it, the original is restored.
+// Otherwise the result of remove is used; if that yields the original
+// (ignoring trailing blank lines) the original bytes are restored, else the
+// surgical result is written and the remaining difference is reported.
+func Revert(path string, remove func([]byte) ([]byte, bool)) (RevertResult, error) {
+	return RevertOne(path, remove, remove)
+}
+
+// RevertOne is Revert for a file holding several NixPersist snippets: remove
+// takes out the one being reverted and removeOther any of those left. The
+// snapshot is kept while others remain, and they are not reported as drift.
+func RevertOne(path string, remove, removeOther func([]byte) ([]byte, bool)) (RevertResult, error) {
+	snap, err := Load(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return RevertResult{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return RevertResult{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return RevertResult{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	updated, found := remove(current)
+	if !found {
+		return RevertResult{}, ErrNotFound
+	}
+	rest, others := updated, false
+	for {
+		stripped, ok := removeOther(rest)
+		if !ok {
+			break
+		}
+		rest, others = stripped, true
+	}
+
+	// Only a file holding the requested snippet, and no other, may go back
+	// to the snapshot wholesale.
+	if snap != nil && !others {
+		if err := snap.Restore(); err == nil {
+			return RevertResult{Restored: true}, nil
+		} else if !errors.Is(err, ErrChanged) {
+			return RevertResult{}, err
+		}
+	}
+	if snap != nil && !others && bytes.Equal(trimTrailing(updated), trimTrailing(snap.Data)) {
+		if err := snap.Apply(); err != nil {
+			return RevertResult{}, err
+		}
+		return RevertResult{Restored: true}, nil
+	}
+
+	if err := os.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+		return RevertResult{}, fmt.Errorf("write %s: %w", path, err)
+	}
+	if snap == nil {
+		return RevertResult{}, nil
+	}
+
+	var res RevertResult
+	if !bytes.Equal(trimTrailing(rest), trimTrailing(snap.Data)) {
+		res.Drift = diff.Unified(path+" (original)", path, snap.Data, rest)
+	}
+	// Keep the snapshot while other NixPersist snippets remain in the file so
+	// the last removal can still restore the original.
+	if !others {
+		if err := Discard(path); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func trimTrailing(b []byte) []byte {
+	return bytes.TrimRight(b, " \t\r\n")
+}
@@ -0,0 +1,179 @@
+// Package snapshot preserves files before NixPersist edits them in place so
+// that removal can restore the original bytes, mode, owner and xattrs.
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"nixpersist/internal/state"
+)
+
+// ErrChanged is returned by Restore when the file no longer matches the
+// content NixPersist wrote, meaning someone else edited it since.
+var ErrChanged = errors.New("file changed since install")
+
+// Snapshot is the pre-edit state of a file.
+type Snapshot struct {
+	Path    string            `json:"path"`
+	Existed bool              `json:"existed"`
+	Data    []byte            `json:"data,omitempty"`
+	Mode    os.FileMode       `json:"mode"`
+	UID     int               `json:"uid"`
+	GID     int               `json:"gid"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"`
+	// Installed is the content written by NixPersist after the edit.
+	Installed []byte    `json:"installed"`
+	TakenAt   time.Time `json:"taken_at"`
+
+	// created is set when Save stored this snapshot, as opposed to keeping
+	// an earlier one for the same path.
+	created bool
+}
+
+// Dir returns where snapshots are stored inside the state directory.
+func Dir() string {
+	return filepath.Join(state.Dir(), "snapshots")
+}
+
+func storePath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(Dir(), hex.EncodeToString(sum[:8])+".json")
+}
+
+// Take captures the current state of path. A missing file is recorded as
+// such so that Restore deletes it again.
+func Take(path string) (*Snapshot, error) {
+	s := &Snapshot{Path: path, TakenAt: time.Now().UTC()}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("snapshot: stat %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: read %s: %w", path, err)
+	}
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: xattrs of %s: %w", path, err)
+	}
+	s.Existed = true
+	s.Data = data
+	s.Mode = info.Mode().Perm()
+	s.UID, s.GID = fileOwner(info)
+	s.Xattrs = xattrs
+	return s, nil
+}
+
+// Save records installed as the content NixPersist wrote and persists the
+// snapshot. An existing snapshot for the same path is kept untouched, since
+// it holds the true original of a file edited more than once.
+func (s *Snapshot) Save(installed []byte) error {
+	dest := storePath(s.Path)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	s.Installed = installed
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return fmt.Errorf("snapshot: create %s: %w", Dir(), err)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("snapshot: encode: %w", err)
+	}
+	if err := os.WriteFile(dest, data, 0600); err != nil {
+		return fmt.Errorf("snapshot: write %s: %w", dest, err)
+	}
+	s.created = true
+	return nil
+}
+
+// Abort discards the snapshot stored by Save after the edit it guarded
+// failed. Snapshots kept from an earlier edit are left alone.
+func (s *Snapshot) Abort() error {
+	if !s.created {
+		return nil
+	}
+	return Discard(s.Path)
+}
+
+// Load returns the stored snapshot for path. It returns an error matching
+// os.ErrNotExist when none was taken.
+func Load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(storePath(path))
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("snapshot: parse for %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Discard deletes the stored snapshot for path.
+func Discard(path string) error {
+	if err := os.Remove(storePath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("snapshot: discard for %s: %w", path, err)
+	}
+	return nil
+}
+
+// Unchanged reports whether path still holds exactly what NixPersist wrote.
+func (s *Snapshot) Unchanged() (bool, error) {
+	current, err := os.ReadFile(s.Path)
+	if err != nil {
+		return false, fmt.Errorf("snapshot: read %s: %w", s.Path, err)
+	}
+	return bytes.Equal(current, s.Installed), nil
+}
+
+// Restore puts back the original file when nothing else changed since the
+// install and discards the snapshot. It returns ErrChanged otherwise.
+func (s *Snapshot) Restore() error {
+	ok, err := s.Unchanged()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrChanged
+	}
+	return s.Apply()
+}
+
+// Apply unconditionally writes the original content and metadata back to
+// the path (or deletes it if it did not exist) and discards the snapshot.
+func (s *Snapshot) Apply() error {
+	if !s.Existed {
+		if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("snapshot: remove %s: %w", s.Path, err)
+		}
+		return Discard(s.Path)
+	}
+
+	// Truncate and rewrite in place so the inode, and with it any labels
+	// managed outside of xattrs, is preserved.
+	if err := os.WriteFile(s.Path, s.Data, s.Mode); err != nil {
+		return fmt.Errorf("snapshot: restore %s: %w", s.Path, err)
+	}
+	if err := os.Chmod(s.Path, s.Mode); err != nil {
+		return fmt.Errorf("snapshot: chmod %s: %w", s.Path, err)
+	}
+	if err := restoreOwner(s.Path, s.UID, s.GID); err != nil {
+		return fmt.Errorf("snapshot: chown %s: %w", s.Path, err)
+	}
+	if err := writeXattrs(s.Path, s.Xattrs); err != nil {
+		return fmt.Errorf("snapshot: xattrs of %s: %w", s.Path, err)
+	}
+	return Discard(s.Path)
+}
@@ -0,0 +1,186 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/state"
+)
+
+const snippet = "PIPE /bin/x\n"
+
+func removeSnippet(b []byte) ([]byte, bool) {
+	if !bytes.Contains(b, []byte(snippet)) {
+		return b, false
+	}
+	out := bytes.Replace(b, []byte(snippet), nil, 1)
+	return bytes.TrimRight(out, "\n"), true
+}
+
+func install(t *testing.T, path string) {
+	t.Helper()
+	snap, err := Take(path)
+	if err != nil {
+		t.Fatalf("Take returned error: %v", err)
+	}
+	current, _ := os.ReadFile(path)
+	updated := append(append([]byte{}, current...), snippet...)
+	if err := snap.Save(updated); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	if err := os.WriteFile(path, updated, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestRevertRestoresExactBytesAndMode(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "rsyslog.conf")
+	original := "line1\n\n\n"
+	if err := os.WriteFile(path, []byte(original), 0640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	install(t, path)
+	if err := os.Chmod(path, 0666); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	res, err := Revert(path, removeSnippet)
+	if err != nil {
+		t.Fatalf("Revert returned error: %v", err)
+	}
+	if !res.Restored || res.Drift != "" {
+		t.Fatalf("expected exact restore, got %+v", res)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != original {
+		t.Fatalf("content not restored: %q", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode not restored: %v", info.Mode())
+	}
+	if _, err := Load(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected snapshot to be discarded, got %v", err)
+	}
+}
+
+func TestRevertDeletesCreatedFile(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "new.conf")
+
+	install(t, path)
+	res, err := Revert(path, removeSnippet)
+	if err != nil || !res.Restored {
+		t.Fatalf("Revert = %+v, %v", res, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected file to be deleted, got %v", err)
+	}
+}
+
+func TestRevertSurgicalWithDrift(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "apache2.conf")
+	if err := os.WriteFile(path, []byte("ServerName a\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	install(t, path)
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, append([]byte("# admin edit\n"), data...), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	res, err := Revert(path, removeSnippet)
+	if err != nil {
+		t.Fatalf("Revert returned error: %v", err)
+	}
+	if res.Restored {
+		t.Fatalf("expected surgical removal, got %+v", res)
+	}
+	if !strings.Contains(res.Drift, "+# admin edit") {
+		t.Fatalf("expected drift diff to show admin edit:\n%s", res.Drift)
+	}
+	final, _ := os.ReadFile(path)
+	if strings.Contains(string(final), snippet) || !strings.Contains(string(final), "# admin edit") {
+		t.Fatalf("unexpected final content: %q", final)
+	}
+}
+
+func TestRevertWithoutSnapshot(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "conf")
+	if err := os.WriteFile(path, []byte("a\n"+snippet), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	res, err := Revert(path, removeSnippet)
+	if err != nil || res.Restored || res.Drift != "" {
+		t.Fatalf("Revert = %+v, %v", res, err)
+	}
+	if _, err := Revert(path, removeSnippet); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRevertOneKeepsSnapshotForOtherSnippets(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "rsyslog.conf")
+	original := []byte("*.* /var/log/syslog\n")
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	install(t, path)
+	const second = "# second"
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, append(data, second+"\n"...), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	removeSecond := func(b []byte) ([]byte, bool) {
+		if !bytes.Contains(b, []byte(second)) {
+			return b, false
+		}
+		return bytes.Replace(b, []byte(second), nil, 1), true
+	}
+
+	res, err := RevertOne(path, removeSnippet, removeSecond)
+	if err != nil || res.Restored || res.Drift != "" {
+		t.Fatalf("first removal: %+v, %v", res, err)
+	}
+	if _, err := Load(path); err != nil {
+		t.Fatalf("snapshot discarded while a snippet remains: %v", err)
+	}
+	res, err = RevertOne(path, removeSecond, removeSnippet)
+	if err != nil || !res.Restored {
+		t.Fatalf("last removal: %+v, %v", res, err)
+	}
+	if final, _ := os.ReadFile(path); !bytes.Equal(final, original) {
+		t.Fatalf("original not restored: %q", final)
+	}
+}
+
+func TestRevertOneMissingSnippetLeavesOthers(t *testing.T) {
+	t.Setenv(state.DirEnv, t.TempDir())
+	path := filepath.Join(t.TempDir(), "rsyslog.conf")
+	if err := os.WriteFile(path, []byte("*.* /var/log/syslog\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	install(t, path)
+	installed, _ := os.ReadFile(path)
+	removeAbsent := func(b []byte) ([]byte, bool) { return b, false }
+
+	if _, err := RevertOne(path, removeAbsent, removeSnippet); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, installed) {
+		t.Fatalf("file changed by a failed removal: %q", after)
+	}
+	if _, err := Load(path); err != nil {
+		t.Fatalf("snapshot discarded by a failed removal: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+func fileOwner(info os.FileInfo) (int, int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+func restoreOwner(path string, uid, gid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if cu, cg := fileOwner(info); cu == uid && cg == gid {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
+
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		vsize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		val := make([]byte, vsize)
+		if vsize > 0 {
+			if vsize, err = syscall.Getxattr(path, string(name), val); err != nil {
+				return nil, err
+			}
+		}
+		out[string(name)] = val[:vsize]
+	}
+	return out, nil
+}
+
+func writeXattrs(path string, want map[string][]byte) error {
+	current, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	for name := range current {
+		if _, keep := want[name]; !keep {
+			if err := syscall.Removexattr(path, name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, val := range want {
+		if cur, ok := current[name]; ok && bytes.Equal(cur, val) {
+			continue
+		}
+		if err := syscall.Setxattr(path, name, val, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
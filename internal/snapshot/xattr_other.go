@@ -0,0 +1,13 @@
+//go:build !linux
+
+package snapshot
+
+import "os"
+
+func fileOwner(os.FileInfo) (int, int) { return -1, -1 }
+
+func restoreOwner(string, int, int) error { return nil }
+
+func readXattrs(string) (map[string][]byte, error) { return nil, nil }
+
+func writeXattrs(string, map[string][]byte) error { return nil }
@@ -0,0 +1,248 @@
+// Package state maintains the local install ledger recording every change
+// NixPersist makes to a host.
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDir is where the ledger lives unless overridden.
+	DefaultDir = "/var/lib/nixpersist"
+	// LedgerName is the ledger file name inside the state directory.
+	LedgerName = "ledger.json"
+
+	// DirEnv overrides DefaultDir, mainly for tests and unprivileged runs.
+	DirEnv = "NIXPERSIST_STATE_DIR"
+)
+
+// File actions recorded in a FileChange.
+const (
+	FileCreated  = "created"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+)
+
+// FileChange records a single file touched by an install or remove.
+type FileChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// PreHash is the sha256 of the file before the change; empty when the
+	// file did not exist.
+	PreHash string `json:"pre_hash,omitempty"`
+}
+
+// Entry is one install recorded in the ledger.
+type Entry struct {
+	ID     int    `json:"id"`
+	Module string `json:"module"`
+	// Instance tells apart several installs of a module on one host; empty
+	// for the module's default instance.
+	Instance string `json:"instance,omitempty"`
+	// Params holds the module flag values used for the install so the
+	// matching remove can be replayed later.
+	Params   map[string]string `json:"params"`
+	Files    []FileChange      `json:"files,omitempty"`
+	Services []string          `json:"services,omitempty"`
+	AppArmor []string          `json:"apparmor,omitempty"`
+	// Audit lists auditd rules loaded by --install-audit.
+	Audit       []string   `json:"audit,omitempty"`
+	InstalledAt time.Time  `json:"installed_at"`
+	RemovedAt   *time.Time `json:"removed_at,omitempty"`
+}
+
+// Active reports whether the entry has not been removed yet.
+func (e Entry) Active() bool {
+	return e.RemovedAt == nil
+}
+
+// Render returns a human-readable description of the entry.
+func (e Entry) Render() string {
+	b := &strings.Builder{}
+	name := e.Module
+	if e.Instance != "" {
+		name += " instance " + e.Instance
+	}
+	fmt.Fprintf(b, "- [%d] %s (installed %s)\n", e.ID, name, e.InstalledAt.Format(time.RFC3339))
+	if len(e.Params) > 0 {
+		keys := make([]string, 0, len(e.Params))
+		for k := range e.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", k, e.Params[k]))
+		}
+		fmt.Fprintf(b, "    params: %s\n", strings.Join(pairs, " "))
+	}
+	for _, f := range e.Files {
+		if f.PreHash != "" {
+			fmt.Fprintf(b, "    file: %s (%s, sha256 before %s)\n", f.Path, f.Action, f.PreHash)
+		} else {
+			fmt.Fprintf(b, "    file: %s (%s)\n", f.Path, f.Action)
+		}
+	}
+	for _, s := range e.Services {
+		fmt.Fprintf(b, "    service: %s\n", s)
+	}
+	for _, a := range e.AppArmor {
+		fmt.Fprintf(b, "    apparmor: %s\n", a)
+	}
+	for _, r := range e.Audit {
+		fmt.Fprintf(b, "    audit: %s\n", r)
+	}
+	if e.RemovedAt != nil {
+		fmt.Fprintf(b, "    removed: %s\n", e.RemovedAt.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// Ledger is the on-disk list of installs.
+type Ledger struct {
+	Entries []Entry `json:"entries"`
+
+	path string
+}
+
+// Dir returns the state directory, honouring DirEnv.
+func Dir() string {
+	if dir := strings.TrimSpace(os.Getenv(DirEnv)); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// Load reads the ledger from the state directory. A missing ledger yields an
+// empty one.
+func Load() (*Ledger, error) {
+	l := &Ledger{path: filepath.Join(Dir(), LedgerName)}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("read ledger %s: %w", l.path, err)
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("parse ledger %s: %w", l.path, err)
+	}
+	return l, nil
+}
+
+// Save writes the ledger atomically, creating the state directory if needed.
+func (l *Ledger) Save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(l.path), err)
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode ledger: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write ledger: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("replace ledger: %w", err)
+	}
+	return nil
+}
+
+// Add appends e with a fresh ID and returns the stored entry.
+func (l *Ledger) Add(e Entry) Entry {
+	next := 1
+	for _, existing := range l.Entries {
+		if existing.ID >= next {
+			next = existing.ID + 1
+		}
+	}
+	e.ID = next
+	if e.InstalledAt.IsZero() {
+		e.InstalledAt = time.Now().UTC()
+	}
+	l.Entries = append(l.Entries, e)
+	return e
+}
+
+// Active returns the entries that have not been removed, oldest first.
+func (l *Ledger) Active() []Entry {
+	var out []Entry
+	for _, e := range l.Entries {
+		if e.Active() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// MarkRemoved flags the entry with the given ID as removed. It reports
+// whether an active entry was found.
+func (l *Ledger) MarkRemoved(id int, at time.Time) bool {
+	for i := range l.Entries {
+		if l.Entries[i].ID == id && l.Entries[i].Active() {
+			t := at.UTC()
+			l.Entries[i].RemovedAt = &t
+			return true
+		}
+	}
+	return false
+}
+
+// FindActive returns the most recent active entry for module and instance
+// that touched any of paths.
+func (l *Ledger) FindActive(module, instance string, paths []string) (Entry, bool) {
+	for i := len(l.Entries) - 1; i >= 0; i-- {
+		e := l.Entries[i]
+		if !e.Active() || e.Module != module || e.Instance != instance {
+			continue
+		}
+		for _, f := range e.Files {
+			for _, p := range paths {
+				if f.Path == p {
+					return e, true
+				}
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+// HashFile returns the hex sha256 of path, or "" when it does not exist.
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Observe builds a FileChange for path before it is modified, classifying it
+// as created or modified depending on whether it already exists.
+func Observe(path string) FileChange {
+	hash, _ := HashFile(path)
+	action := FileModified
+	if hash == "" {
+		action = FileCreated
+	}
+	return FileChange{Path: path, Action: action, PreHash: hash}
+}
+
+// ObserveDelete builds a FileChange for path before it is deleted.
+func ObserveDelete(path string) FileChange {
+	hash, _ := HashFile(path)
+	return FileChange{Path: path, Action: FileDeleted, PreHash: hash}
+}
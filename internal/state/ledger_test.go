@@ -0,0 +1,100 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgerRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DirEnv, dir)
+
+	l, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(l.Entries) != 0 {
+		t.Fatalf("expected empty ledger, got %+v", l.Entries)
+	}
+
+	first := l.Add(Entry{Module: "rsyslog", Params: map[string]string{"output": "/etc/rsyslog.conf"}, Files: []FileChange{{Path: "/etc/rsyslog.conf", Action: FileModified}}})
+	second := l.Add(Entry{Module: "apache-log", Files: []FileChange{{Path: "/etc/apache2/apache2.conf", Action: FileModified}}})
+	if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("unexpected IDs %d, %d", first.ID, second.ID)
+	}
+	if err := l.Save(); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, LedgerName)); err != nil {
+		t.Fatalf("expected ledger file: %v", err)
+	}
+
+	reloaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(reloaded.Active()) != 2 {
+		t.Fatalf("expected two active entries, got %+v", reloaded.Entries)
+	}
+	if reloaded.Entries[0].Params["output"] != "/etc/rsyslog.conf" {
+		t.Fatalf("params not persisted: %+v", reloaded.Entries[0])
+	}
+
+	e, ok := reloaded.FindActive("rsyslog", "", []string{"/etc/rsyslog.conf"})
+	if !ok || e.ID != 1 {
+		t.Fatalf("FindActive = %+v, %v", e, ok)
+	}
+	if !reloaded.MarkRemoved(1, time.Now()) {
+		t.Fatal("expected MarkRemoved to succeed")
+	}
+	if reloaded.MarkRemoved(1, time.Now()) {
+		t.Fatal("expected second MarkRemoved to report no active entry")
+	}
+	if _, ok := reloaded.FindActive("rsyslog", "", []string{"/etc/rsyslog.conf"}); ok {
+		t.Fatal("removed entry must not be found")
+	}
+	if next := reloaded.Add(Entry{Module: "docker-compose"}); next.ID != 3 {
+		t.Fatalf("expected ID 3, got %d", next.ID)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conf")
+
+	if c := Observe(path); c.Action != FileCreated || c.PreHash != "" {
+		t.Fatalf("unexpected change for missing file: %+v", c)
+	}
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	c := Observe(path)
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if c.Action != FileModified || c.PreHash != want {
+		t.Fatalf("unexpected change for existing file: %+v", c)
+	}
+	if d := ObserveDelete(path); d.Action != FileDeleted || d.PreHash != want {
+		t.Fatalf("unexpected delete change: %+v", d)
+	}
+}
+
+func TestFindActiveMatchesInstance(t *testing.T) {
+	var l Ledger
+	files := []FileChange{{Path: "/etc/rsyslog.conf", Action: FileModified}}
+	l.Add(Entry{Module: "rsyslog", Files: files})
+	named := l.Add(Entry{Module: "rsyslog", Instance: "auth", Files: files})
+	l.Add(Entry{Module: "rsyslog", Instance: "web", Files: files})
+
+	if e, ok := l.FindActive("rsyslog", "auth", []string{"/etc/rsyslog.conf"}); !ok || e.ID != named.ID {
+		t.Fatalf("FindActive(auth) = %+v, %v", e, ok)
+	}
+	if e, ok := l.FindActive("rsyslog", "", []string{"/etc/rsyslog.conf"}); !ok || e.ID != 1 {
+		t.Fatalf("FindActive(default) = %+v, %v", e, ok)
+	}
+	if !strings.HasPrefix(named.Render(), "- [2] rsyslog instance auth (installed ") {
+		t.Fatalf("render %q", named.Render())
+	}
+}
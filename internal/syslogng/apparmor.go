@@ -0,0 +1,31 @@
+package syslogng
+
+import "nixpersist/internal/apparmor"
+
+// profiles are the names the AppArmor profile confining syslog-ng ships
+// under: usr.sbin.syslog-ng, or sbin.syslog-ng from apparmor-profiles.
+var profiles = []apparmor.Profile{"usr.sbin.syslog-ng", "sbin.syslog-ng"}
+
+// syslogngProfile returns the first of profiles present on the host, or
+// the first when none is.
+func syslogngProfile() apparmor.Profile {
+	for _, p := range profiles {
+		if mode, err := p.Current(); err == nil && mode != apparmor.Absent {
+			return p
+		}
+	}
+	return profiles[0]
+}
+
+// RelaxProfile puts the syslog-ng profile in mode, complain or disabled, to
+// permit program() destinations, after recording its current state.
+// Requires root; RestoreProfile undoes it.
+func RelaxProfile(mode apparmor.Mode) (apparmor.Record, error) {
+	return apparmor.Relax(syslogngProfile(), mode)
+}
+
+// RestoreProfile returns the syslog-ng profile to the state recorded by
+// RelaxProfile. Requires root privileges.
+func RestoreProfile() (apparmor.Record, error) {
+	return apparmor.Restore(syslogngProfile())
+}
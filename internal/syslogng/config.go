@@ -0,0 +1,153 @@
+// Package syslogng plants a syslog-ng program() destination: a conf.d
+// drop-in whose log path hands the messages a filter selects to a payload
+// that syslog-ng keeps running.
+package syslogng
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Source is where the drop-in's log path reads messages from.
+type Source string
+
+const (
+	// SourceFile follows a log file.
+	SourceFile Source = "file"
+	// SourceSystem is the local system log: the host's own system() or
+	// /dev/log source when there is one.
+	SourceSystem Source = "system"
+	// SourceUDP and SourceTCP listen for syslog messages on localhost.
+	SourceUDP Source = "udp"
+	SourceTCP Source = "tcp"
+)
+
+// ParseSource validates a --source value.
+func ParseSource(s string) (Source, error) {
+	switch src := Source(strings.ToLower(s)); src {
+	case SourceFile, SourceSystem, SourceUDP, SourceTCP:
+		return src, nil
+	}
+	return "", fmt.Errorf("source must be file, system, udp or tcp, got %q", s)
+}
+
+// DefaultPort is the localhost port SourceUDP and SourceTCP listen on.
+const DefaultPort = 10514
+
+// Object names used in the drop-in.
+const (
+	sourceName      = "s_nixpersist"
+	filterName      = "f_nixpersist"
+	destinationName = "d_nixpersist"
+)
+
+// ConfigParams captures the inputs for rendering the drop-in.
+type ConfigParams struct {
+	Source Source
+	// InputFile is the log file SourceFile follows.
+	InputFile string
+	// Port is the localhost port of SourceUDP and SourceTCP.
+	Port int
+	// SourceRef names an existing source the log path reads instead of
+	// defining its own, such as the s_src of Debian's syslog-ng.conf. A
+	// second system() source would fail to bind /dev/log.
+	SourceRef string
+
+	// Message, when set, selects messages whose text contains it.
+	Message string
+	// Match, when set, selects messages whose text matches this PCRE.
+	Match string
+	// Program, when set, selects messages from this program name.
+	Program string
+
+	// Payload is the command line syslog-ng runs; matching messages are
+	// written to its stdin.
+	Payload string
+	// Template optionally formats the messages the payload reads, such as
+	// "$MSG\n"; empty keeps syslog-ng's default.
+	Template string
+}
+
+var objectName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Validate checks required fields.
+func (p ConfigParams) Validate() error {
+	if _, err := ParseSource(string(p.Source)); err != nil {
+		return err
+	}
+	if p.Source == SourceFile && p.InputFile == "" {
+		return errors.New("InputFile is required for the file source")
+	}
+	if (p.Source == SourceUDP || p.Source == SourceTCP) && (p.Port < 1 || p.Port > 65535) {
+		return fmt.Errorf("Port must be between 1 and 65535, got %d", p.Port)
+	}
+	if p.SourceRef != "" && !objectName.MatchString(p.SourceRef) {
+		return fmt.Errorf("invalid source name %q", p.SourceRef)
+	}
+	if strings.TrimSpace(p.Payload) == "" {
+		return errors.New("Payload is required")
+	}
+	if p.Message == "" && p.Match == "" && p.Program == "" {
+		return errors.New("at least one of Message, Match or Program must be set")
+	}
+	return nil
+}
+
+// Filter returns the filter expression: every given condition must hold.
+func (p ConfigParams) Filter() string {
+	var conds []string
+	if p.Program != "" {
+		conds = append(conds, fmt.Sprintf("program(%s type(string))", quote(p.Program)))
+	}
+	if p.Message != "" {
+		conds = append(conds, fmt.Sprintf("message(%s type(string) flags(substring))", quote(p.Message)))
+	}
+	if p.Match != "" {
+		conds = append(conds, fmt.Sprintf("match(%s value(\"MESSAGE\"))", quote(p.Match)))
+	}
+	return strings.Join(conds, " and ")
+}
+
+// sourceDriver returns the source driver of p's own source.
+func (p ConfigParams) sourceDriver() string {
+	switch p.Source {
+	case SourceSystem:
+		return "system();"
+	case SourceUDP, SourceTCP:
+		// Bound to loopback so the listener is not reachable remotely.
+		return fmt.Sprintf("network(ip(\"127.0.0.1\") port(%d) transport(\"%s\"));", p.Port, p.Source)
+	}
+	return fmt.Sprintf("file(%s follow-freq(1));", quote(p.InputFile))
+}
+
+// RenderConfig produces the conf.d drop-in for p. It has no @version line:
+// that belongs to syslog-ng.conf, which includes the drop-in.
+func RenderConfig(p ConfigParams) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	source := p.SourceRef
+	if source == "" {
+		source = sourceName
+		fmt.Fprintf(&b, "source %s {\n    %s\n};\n\n", sourceName, p.sourceDriver())
+	}
+	fmt.Fprintf(&b, "filter %s {\n    %s;\n};\n\n", filterName, p.Filter())
+	program := fmt.Sprintf("program(%s", quote(p.Payload))
+	if p.Template != "" {
+		// Unlike other strings, escapes such as \n are the user's own.
+		program += fmt.Sprintf(" template(\"%s\")", strings.ReplaceAll(p.Template, `"`, `\"`))
+	}
+	fmt.Fprintf(&b, "destination %s {\n    %s);\n};\n\n", destinationName, program)
+	fmt.Fprintf(&b, "log {\n    source(%s);\n    filter(%s);\n    destination(%s);\n};\n", source, filterName, destinationName)
+	return b.String(), nil
+}
+
+// quote renders s as a double-quoted syslog-ng string.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
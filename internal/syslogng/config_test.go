@@ -0,0 +1,98 @@
+package syslogng
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderConfigFileSource(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{
+		Source:    SourceFile,
+		InputFile: "/var/log/auth.log",
+		Message:   "uhtavi0",
+		Payload:   "/usr/bin/touch /tmp/nixpersist",
+	})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	want := `source s_nixpersist {
+    file("/var/log/auth.log" follow-freq(1));
+};
+
+filter f_nixpersist {
+    message("uhtavi0" type(string) flags(substring));
+};
+
+destination d_nixpersist {
+    program("/usr/bin/touch /tmp/nixpersist");
+};
+
+log {
+    source(s_nixpersist);
+    filter(f_nixpersist);
+    destination(d_nixpersist);
+};
+`
+	if cfg != want {
+		t.Fatalf("unexpected config:\n%s", cfg)
+	}
+}
+
+func TestRenderConfigReusesSourceRef(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{
+		Source:    SourceSystem,
+		SourceRef: "s_src",
+		Program:   "sshd",
+		Match:     `Invalid user \w+`,
+		Payload:   `/opt/p "x"`,
+		Template:  `$MSG\n`,
+	})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	if strings.Contains(cfg, "source s_") || !strings.Contains(cfg, "source(s_src);") {
+		t.Fatalf("expected the host source to be reused:\n%s", cfg)
+	}
+	for _, want := range []string{
+		`program("sshd" type(string)) and match("Invalid user \\w+" value("MESSAGE"));`,
+		`program("/opt/p \"x\"" template("$MSG\n"));`,
+	} {
+		if !strings.Contains(cfg, want) {
+			t.Fatalf("config missing %q:\n%s", want, cfg)
+		}
+	}
+}
+
+func TestRenderConfigNetworkSourceBindsLoopback(t *testing.T) {
+	cfg, err := RenderConfig(ConfigParams{Source: SourceUDP, Port: 5514, Message: "x", Payload: "/bin/p"})
+	if err != nil {
+		t.Fatalf("RenderConfig returned error: %v", err)
+	}
+	if !strings.Contains(cfg, `network(ip("127.0.0.1") port(5514) transport("udp"));`) {
+		t.Fatalf("unexpected source:\n%s", cfg)
+	}
+}
+
+func TestValidateRejectsIncompleteParams(t *testing.T) {
+	for name, p := range map[string]ConfigParams{
+		"source":  {Source: "journal", Message: "x", Payload: "/bin/p"},
+		"file":    {Source: SourceFile, Message: "x", Payload: "/bin/p"},
+		"port":    {Source: SourceTCP, Message: "x", Payload: "/bin/p"},
+		"ref":     {Source: SourceSystem, SourceRef: "s src", Message: "x", Payload: "/bin/p"},
+		"payload": {Source: SourceSystem, Message: "x", Payload: " "},
+		"filter":  {Source: SourceSystem, Payload: "/bin/p"},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected an error for %+v", name, p)
+		}
+	}
+}
+
+func TestQuoteEscapes(t *testing.T) {
+	if got := quote(`a\b"c`); got != `"a\\b\"c"` {
+		t.Fatalf("quote = %s", got)
+	}
+	if got := unquote(quote(`a\b"c`)); got != `a\b"c` {
+		t.Fatalf("unquote(quote()) = %s", got)
+	}
+}
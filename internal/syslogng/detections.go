@@ -0,0 +1,149 @@
+package syslogng
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/apparmor"
+	"nixpersist/internal/audit"
+	"nixpersist/internal/sigma"
+)
+
+var syslogngTags = []string{"attack.persistence", "attack.privilege-escalation", "attack.t1546"}
+
+// Detections returns Sigma rules for the drop-in rendered from p and
+// written to dest.
+func Detections(p ConfigParams, dest string) ([]sigma.Rule, error) {
+	if _, err := RenderConfig(p); err != nil {
+		return nil, err
+	}
+	image := sigma.Image(p.Payload)
+	rules := []sigma.Rule{
+		{
+			Title:       "Syslog-ng Configuration Modified",
+			Description: fmt.Sprintf("Detects writes to %s, where NixPersist plants its syslog-ng program destination.", dest),
+			Tags:        syslogngTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_event"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{{Name: "selection", Fields: []sigma.Field{{Name: "TargetFilename", Values: []string{dest}}}}},
+				Condition:  "selection",
+			},
+			FalsePositives: []string{"Package upgrades and configuration management touching syslog-ng"},
+			Level:          "medium",
+		},
+		{
+			Title:       "Syslog-ng Program Destination In Configuration",
+			Description: fmt.Sprintf("Detects a syslog-ng program() destination, which keeps a program running and writes log messages to its stdin. The simulation runs %q.", p.Payload),
+			Tags:        syslogngTags,
+			LogSource:   sigma.LogSource{Product: "linux", Category: "file_content"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_file", Fields: []sigma.Field{{Name: "TargetFilename", Modifiers: []string{"startswith"}, Values: []string{"/etc/syslog-ng/"}}}},
+					{Name: "selection_exact", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"contains"}, Values: []string{"program(" + quote(p.Payload)}}}},
+					{Name: "selection_generic", Fields: []sigma.Field{{Name: "Content", Modifiers: []string{"re"}, Values: []string{`(?s)destination\s+\S+\s*\{[^}]*\bprogram\s*\(`}}}},
+				},
+				Condition: "selection_file and (selection_exact or selection_generic)",
+			},
+			FalsePositives: []string{"Log shipping setups that pipe messages to a program"},
+			Level:          "high",
+		},
+		{
+			Title:       "Syslog-ng Spawning Payload Process",
+			Description: fmt.Sprintf("Detects syslog-ng executing %s, the program its destination keeps running.", image),
+			Tags:        append([]string{"attack.execution"}, syslogngTags...),
+			LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_parent", Fields: []sigma.Field{{Name: "ParentImage", Modifiers: []string{"endswith"}, Values: []string{"/syslog-ng"}}}},
+					{Name: "selection_payload", Fields: []sigma.Field{{Name: "Image", Values: []string{image}}}},
+				},
+				Condition: "all of selection_*",
+			},
+			FalsePositives: []string{"Legitimate program() destinations"},
+			Level:          "high",
+		},
+	}
+	if p.Message != "" {
+		rules = append(rules, sigma.Rule{
+			Title:       "Syslog-ng Persistence Trigger String Logged",
+			Description: fmt.Sprintf("Detects the string the syslog-ng filter hands to the payload appearing in %s.", p.sourceDescription()),
+			Tags:        syslogngTags,
+			LogSource:   sigma.LogSource{Product: "linux", Service: "syslog"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{{Name: "keywords", Keywords: []string{p.Message}}},
+				Condition:  "keywords",
+			},
+			FalsePositives: []string{"Benign messages that happen to contain the trigger string"},
+			Level:          "low",
+		})
+	}
+	return rules, nil
+}
+
+// sourceDescription names where the messages of p come from.
+func (p ConfigParams) sourceDescription() string {
+	switch p.Source {
+	case SourceSystem:
+		return "the system log"
+	case SourceUDP, SourceTCP:
+		return fmt.Sprintf("%s 127.0.0.1:%d", p.Source, p.Port)
+	}
+	return p.InputFile
+}
+
+// AppArmorDetection returns a rule for the syslog-ng profile being relaxed
+// as --apparmor-mode says: unloaded and disabled, or reloaded in complain
+// mode.
+func AppArmorDetection(mode apparmor.Mode) sigma.Rule {
+	if mode == apparmor.Complain {
+		return sigma.Rule{
+			Title:       "Syslog-ng AppArmor Profile Set To Complain Mode",
+			Description: "Detects the syslog-ng AppArmor profile being switched to complain mode, which logs but no longer blocks programs syslog-ng executes.",
+			Tags:        []string{"attack.defense-evasion", "attack.t1562.001"},
+			LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+			Detection: sigma.Detection{
+				Selections: []sigma.Selection{
+					{Name: "selection_utils", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"aa-complain", "syslog-ng"}}}},
+					{Name: "selection_parser", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"apparmor_parser", "-r", "syslog-ng"}}}},
+				},
+				Condition: "1 of selection_*",
+			},
+			FalsePositives: []string{"syslog-ng package upgrades reloading the profile", "Administrators troubleshooting syslog-ng confinement"},
+			Level:          "medium",
+		}
+	}
+	return sigma.Rule{
+		Title:       "Syslog-ng AppArmor Profile Disabled",
+		Description: "Detects the syslog-ng AppArmor profile being unloaded or disabled, which lifts confinement on programs syslog-ng executes.",
+		Tags:        []string{"attack.defense-evasion", "attack.t1562.001"},
+		LogSource:   sigma.LogSource{Product: "linux", Category: "process_creation"},
+		Detection: sigma.Detection{
+			Selections: []sigma.Selection{
+				{Name: "selection_parser", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"apparmor_parser", "-R", "syslog-ng"}}}},
+				{Name: "selection_disable", Fields: []sigma.Field{{Name: "CommandLine", Modifiers: []string{"contains", "all"}, Values: []string{"/etc/apparmor.d/disable", "syslog-ng"}}}},
+			},
+			Condition: "1 of selection_*",
+		},
+		FalsePositives: []string{"Administrators troubleshooting syslog-ng confinement"},
+		Level:          "high",
+	}
+}
+
+// AuditRules returns auditd rules for the drop-in dest and its payload:
+// watches on the syslog-ng configuration, execve of the payload and
+// syslog-ng, and AppArmor profile changes.
+func AuditRules(dest, payload string) []audit.Rule {
+	key := audit.KeyPrefix + "syslog-ng"
+	dir := filepath.Dir(DefaultMainConfig)
+	// A directory watch covers the files below it.
+	rules := []audit.Rule{audit.Watch(dir, "wa", key)}
+	if !strings.HasPrefix(dest, dir+"/") {
+		rules = append(rules, audit.Watch(dest, "wa", key))
+	}
+	if image := sigma.Image(payload); filepath.IsAbs(image) {
+		rules = append(rules, audit.Exec(image, key+"-exec"))
+	}
+	rules = append(rules, audit.Exec(audit.FirstExisting("/usr/sbin/syslog-ng", "/sbin/syslog-ng"), key+"-exec"))
+	return append(rules, audit.AppArmorRules()...)
+}
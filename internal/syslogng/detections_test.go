@@ -0,0 +1,46 @@
+package syslogng
+
+import (
+	"strings"
+	"testing"
+
+	"nixpersist/internal/sigma"
+)
+
+func TestDetectionsUseDropInPath(t *testing.T) {
+	m := NewModule()
+	m.in, m.payload, m.trigger = "/var/log/auth.log", "/opt/beacon", "uhtavi0"
+	rules, err := m.Detections()
+	if err != nil {
+		t.Fatalf("Detections returned error: %v", err)
+	}
+	out := sigma.Render(rules)
+	for _, want := range []string{
+		"TargetFilename: '/etc/syslog-ng/conf.d/99-nixpersist.conf'",
+		`Content|contains: 'program("/opt/beacon"'`,
+		"ParentImage|endswith: '/syslog-ng'",
+		"Image: '/opt/beacon'",
+		"    keywords:\n        - uhtavi0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("rules missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "AppArmor") {
+		t.Fatalf("AppArmor rule emitted without --apparmor:\n%s", out)
+	}
+}
+
+func TestAuditRulesWatchConfigDir(t *testing.T) {
+	var got []string
+	for _, r := range AuditRules("/etc/syslog-ng/conf.d/99-nixpersist.conf", "/opt/beacon -x") {
+		got = append(got, r.String())
+	}
+	out := strings.Join(got, "\n")
+	if !strings.Contains(out, "-w /etc/syslog-ng -p wa") || strings.Contains(out, "-w /etc/syslog-ng/conf.d/99-nixpersist.conf") {
+		t.Fatalf("unexpected watches:\n%s", out)
+	}
+	if !strings.Contains(out, "path=/opt/beacon") {
+		t.Fatalf("payload exec rule missing:\n%s", out)
+	}
+}
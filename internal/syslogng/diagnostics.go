@@ -0,0 +1,224 @@
+package syslogng
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"nixpersist/internal/selinux"
+)
+
+// syslogngNames are the process names of the daemon and syslogngDomain the
+// SELinux domain the targeted policy runs it in, shared with rsyslogd.
+var syslogngNames = []string{"syslog-ng"}
+
+const syslogngDomain = "syslogd_t"
+
+// Result captures feasibility checks for the syslog-ng module.
+type Result struct {
+	Installed bool `json:"syslog_ng_installed"`
+	Running   bool `json:"syslog_ng_running"`
+	// Version is the syslog-ng release and ConfigVersion the @version its
+	// configuration is read as; empty when syslog-ng --version failed.
+	Version       string `json:"version,omitempty"`
+	ConfigVersion string `json:"config_version,omitempty"`
+	// Included is whether syslog-ng.conf includes the drop-in directory.
+	Included bool `json:"config_dir_included"`
+	// SystemSource is the host's source reading the local system log,
+	// which --source system reuses.
+	SystemSource      string `json:"system_source,omitempty"`
+	AppArmorInstalled bool   `json:"apparmor_installed"`
+	// Profile is the AppArmor profile confining syslog-ng and ProfileMode
+	// its mode: enforce, complain, disabled or absent.
+	Profile     string `json:"apparmor_profile,omitempty"`
+	ProfileMode string `json:"profile_mode,omitempty"`
+	// Validation is the outcome of syslog-ng --syntax-only on the rendered
+	// drop-in; empty when none was validated.
+	Validation string `json:"validation,omitempty"`
+	// SELinux is the mode, syslog-ng's domain and whether it may execute
+	// the payload.
+	SELinux selinux.Report `json:"selinux"`
+
+	Notes []string `json:"notes"`
+}
+
+// Check performs environment checks and returns a Result.
+func Check() Result {
+	var r Result
+	r.Installed = checkInstalled(&r)
+	r.Running = checkRunning(&r)
+	v := DetectVersion()
+	r.Version, r.ConfigVersion = v.Release, v.Config
+
+	if main, err := readMain(); err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("cannot read %s: %v", DefaultMainConfig, err))
+	} else {
+		r.Included = includes(includePatterns(main), filepath.Join(DefaultConfigDir, DefaultConfigName))
+		if !r.Included {
+			r.Notes = append(r.Notes, fmt.Sprintf("%s does not @include %s; the drop-in would be ignored", DefaultMainConfig, DefaultConfigDir))
+		}
+		r.SystemSource = systemSource(ParseBlocks(main))
+	}
+
+	r.AppArmorInstalled = exists("/sys/kernel/security/apparmor") || exists("/sys/module/apparmor/parameters/enabled")
+	if r.AppArmorInstalled {
+		profile := syslogngProfile()
+		r.Profile = string(profile)
+		if mode, err := profile.Current(); err != nil {
+			r.Notes = append(r.Notes, fmt.Sprintf("cannot read the mode of %s: %v", profile, err))
+		} else {
+			r.ProfileMode = string(mode)
+		}
+	}
+	return r
+}
+
+// Version is what syslog-ng --version reports.
+type Version struct {
+	Release string
+	Config  string
+}
+
+var (
+	releasePattern       = regexp.MustCompile(`syslog-ng \d+ \(([\d.]+)\)`)
+	configVersionPattern = regexp.MustCompile(`Config version:\s*([\d.]+)`)
+)
+
+// parseVersion reads the release and config version from syslog-ng
+// --version output.
+func parseVersion(out string) Version {
+	var v Version
+	if m := releasePattern.FindStringSubmatch(out); m != nil {
+		v.Release = m[1]
+	}
+	if m := configVersionPattern.FindStringSubmatch(out); m != nil {
+		v.Config = m[1]
+	}
+	return v
+}
+
+// DetectVersion runs syslog-ng --version; the zero Version means it could
+// not be run.
+func DetectVersion() Version {
+	bin, err := lookPath("syslog-ng")
+	if err != nil {
+		return Version{}
+	}
+	out, _ := execCommand(bin, "--version").CombinedOutput()
+	return parseVersion(string(out))
+}
+
+func checkInstalled(r *Result) bool {
+	if _, err := lookPath("syslog-ng"); err == nil {
+		r.Notes = append(r.Notes, "found syslog-ng in PATH")
+		return true
+	}
+	if exists(DefaultMainConfig) {
+		r.Notes = append(r.Notes, "found "+DefaultMainConfig)
+		return true
+	}
+	return false
+}
+
+func checkRunning(r *Result) bool {
+	if _, err := lookPath("systemctl"); err == nil {
+		out, err := execCommand("systemctl", "is-active", serviceName+".service").CombinedOutput()
+		if err == nil && strings.TrimSpace(string(out)) == "active" {
+			r.Notes = append(r.Notes, serviceName+".service is active (systemd)")
+			return true
+		}
+	}
+	if _, err := lookPath("pgrep"); err == nil {
+		if out, err := execCommand("pgrep", "-x", "syslog-ng").CombinedOutput(); err == nil && strings.TrimSpace(string(out)) != "" {
+			r.Notes = append(r.Notes, "syslog-ng process found via pgrep")
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// inspectSELinux fills r.SELinux for payload; with avc it also lists the
+// denials of the last selinux.RecentWindow.
+func (r *Result) inspectSELinux(payload string, avc bool) {
+	var notes []string
+	r.SELinux, notes = selinux.Inspect(syslogngNames, syslogngDomain, payload)
+	r.Notes = append(r.Notes, notes...)
+	if avc {
+		if err := r.SELinux.LoadDenials(time.Now().Add(-selinux.RecentWindow)); err != nil {
+			r.Notes = append(r.Notes, err.Error())
+		}
+	}
+}
+
+// warnSELinux warns when SELinux is predicted to keep syslog-ng from
+// executing payload.
+func warnSELinux(payload string) {
+	r, _ := selinux.Inspect(syslogngNames, syslogngDomain, payload)
+	if r.Prediction != nil && r.Prediction.Verdict == selinux.Denied {
+		fmt.Fprintf(warnOut, "warning: SELinux would deny %s executing %s: %s\n", r.Domain, r.Program, r.Prediction.Reason)
+	}
+}
+
+// selinuxDenials prints the AVC denials logged for syslog-ng's domain since
+// since and returns a summary for the install message.
+func selinuxDenials(since time.Time) string {
+	r, _ := selinux.Inspect(syslogngNames, syslogngDomain, "")
+	if r.Mode == selinux.Disabled {
+		return "; SELinux disabled"
+	}
+	if err := r.LoadDenials(since); err != nil {
+		fmt.Fprintf(warnOut, "warning: %v\n", err)
+		return "; AVC denials not read"
+	}
+	for _, d := range r.Denials {
+		fmt.Fprintf(warnOut, "AVC denial: %s\n", d)
+	}
+	return fmt.Sprintf("; %d AVC denial(s) for %s since install", len(r.Denials), r.Domain)
+}
+
+// Render returns a human-readable summary of the checks.
+func (r Result) Render() string {
+	b := &strings.Builder{}
+	writeLine := func(label string, ok bool) {
+		status := "NO"
+		if ok {
+			status = "YES"
+		}
+		fmt.Fprintf(b, "- %s: %s\n", label, status)
+	}
+	writeLine("syslog-ng installed", r.Installed)
+	writeLine("syslog-ng running", r.Running)
+	if r.Version != "" {
+		fmt.Fprintf(b, "- syslog-ng version: %s (config version %s)\n", r.Version, r.ConfigVersion)
+	}
+	writeLine(DefaultConfigDir+" included", r.Included)
+	if r.SystemSource != "" {
+		fmt.Fprintf(b, "- system log source: %s\n", r.SystemSource)
+	}
+	writeLine("AppArmor installed", r.AppArmorInstalled)
+	if r.ProfileMode != "" {
+		fmt.Fprintf(b, "- AppArmor profile %s: %s\n", r.Profile, r.ProfileMode)
+	}
+	if r.Validation != "" {
+		fmt.Fprintf(b, "- config validation (syslog-ng --syntax-only): %s\n", r.Validation)
+	}
+	for _, line := range r.SELinux.Lines() {
+		b.WriteString(line + "\n")
+	}
+
+	if len(r.Notes) > 0 {
+		b.WriteString("\nNotes:\n")
+		for _, n := range r.Notes {
+			fmt.Fprintf(b, "- %s\n", n)
+		}
+	}
+	return b.String()
+}
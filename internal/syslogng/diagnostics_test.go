@@ -0,0 +1,17 @@
+package syslogng
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	out := `syslog-ng 3 (3.38.1)
+Config version: 3.38
+Installer-Version: 3.38.1
+Revision: 3.38.1-5
+`
+	if got := parseVersion(out); got != (Version{Release: "3.38.1", Config: "3.38"}) {
+		t.Fatalf("parseVersion = %+v", got)
+	}
+	if got := parseVersion("command not found"); got != (Version{}) {
+		t.Fatalf("parseVersion = %+v, want zero", got)
+	}
+}
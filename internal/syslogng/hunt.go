@@ -0,0 +1,101 @@
+package syslogng
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nixpersist/internal/hunt"
+)
+
+// Hunt reports every program() destination in the syslog-ng configuration
+// under root. Destinations no log path uses, and files syslog-ng.conf does
+// not include, are rated low.
+func Hunt(root string) hunt.Report {
+	var r hunt.Report
+	dir := filepath.Dir(DefaultMainConfig)
+	files := hunt.Walk(root, dir, 3, func(name string) bool { return strings.HasSuffix(name, ".conf") })
+	if len(files) == 0 {
+		return r
+	}
+
+	var patterns []string
+	main, err := os.ReadFile(hunt.HostPath(root, DefaultMainConfig))
+	if err != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("%s: %v; files in %s scanned as if loaded", DefaultMainConfig, err, dir))
+	} else {
+		patterns = includePatterns(string(main))
+	}
+
+	// Log paths may use destinations defined in other files, so every file
+	// is read before any destination is rated.
+	type program struct {
+		finding hunt.Finding
+		name    string
+		why     string
+	}
+	var programs []program
+	used := make(map[string]bool)
+	for _, path := range files {
+		data, err := os.ReadFile(hunt.HostPath(root, path))
+		if err != nil {
+			r.Notes = append(r.Notes, fmt.Sprintf("read %s: %v", path, err))
+			continue
+		}
+		why := ""
+		if main != nil && path != DefaultMainConfig && !includes(patterns, path) {
+			why = "not included by " + DefaultMainConfig
+		}
+		lines := strings.Split(string(data), "\n")
+		for _, b := range ParseBlocks(string(data)) {
+			switch b.Kind {
+			case "log":
+				for _, m := range destinationRef.FindAllStringSubmatch(b.Body, -1) {
+					used[m[1]] = true
+				}
+			case "destination":
+				for _, loc := range programDriver.FindAllStringSubmatchIndex(b.Body, -1) {
+					line := b.BodyLine + strings.Count(b.Body[:loc[2]], "\n")
+					cmd := unquote(b.Body[loc[2]:loc[3]])
+					programs = append(programs, program{
+						finding: hunt.Finding{
+							Technique: "syslog-ng",
+							Severity:  programSeverity(cmd),
+							Path:      path,
+							Line:      line,
+							Summary:   fmt.Sprintf("syslog-ng program destination %s runs %s", b.Name, cmd),
+							Evidence:  strings.TrimSpace(lines[line-1]),
+						},
+						name: b.Name,
+						why:  why,
+					})
+				}
+			}
+		}
+	}
+
+	for _, p := range programs {
+		f := p.finding
+		if p.why == "" && !used[p.name] {
+			p.why = "used by no log path"
+		}
+		if p.why != "" {
+			f.Severity = hunt.Low
+			f.Summary += " (" + p.why + ")"
+		}
+		r.Findings = append(r.Findings, f)
+	}
+	return r
+}
+
+// programSeverity rates an executed program: high when it lives somewhere
+// writable by unprivileged users, medium otherwise.
+func programSeverity(program string) hunt.Severity {
+	for _, dir := range []string{"/tmp/", "/var/tmp/", "/dev/shm/", "/home/", "/run/user/"} {
+		if strings.HasPrefix(program, dir) {
+			return hunt.High
+		}
+	}
+	return hunt.Medium
+}
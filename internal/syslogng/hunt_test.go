@@ -0,0 +1,64 @@
+package syslogng
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixpersist/internal/hunt"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestHuntRatesProgramDestinations(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"etc/syslog-ng/syslog-ng.conf": debianMain,
+		"etc/syslog-ng/conf.d/99-x.conf": "filter f { message(\"x\"); };\n" +
+			"destination d_x {\n    program(\"/tmp/implant --flag\");\n};\n" +
+			"log { source(s_src); filter(f); destination(d_x); };\n",
+		"etc/syslog-ng/conf.d/50-ship.conf": "destination d_ship { program(\"/usr/local/bin/ship\"); };\n",
+		"etc/syslog-ng/old/x.conf":          "destination d_old { program(\"/opt/old\"); };\nlog { source(s_src); destination(d_old); };\n",
+	})
+
+	r := Hunt(root)
+	if len(r.Findings) != 3 {
+		t.Fatalf("expected three findings, got %+v", r.Findings)
+	}
+	for _, f := range r.Findings {
+		switch f.Path {
+		case "/etc/syslog-ng/conf.d/99-x.conf":
+			if f.Line != 3 || f.Severity != hunt.High || f.Evidence != `program("/tmp/implant --flag");` {
+				t.Fatalf("unexpected finding %+v", f)
+			}
+		case "/etc/syslog-ng/conf.d/50-ship.conf":
+			if f.Severity != hunt.Low || !strings.Contains(f.Summary, "used by no log path") {
+				t.Fatalf("unused destination not rated low: %+v", f)
+			}
+		case "/etc/syslog-ng/old/x.conf":
+			if f.Severity != hunt.Low || !strings.Contains(f.Summary, "not included by") {
+				t.Fatalf("unincluded file not rated low: %+v", f)
+			}
+		default:
+			t.Fatalf("unexpected finding %+v", f)
+		}
+	}
+}
+
+func TestHuntWithoutSyslogNG(t *testing.T) {
+	if r := Hunt(t.TempDir()); len(r.Findings) != 0 || len(r.Notes) != 0 {
+		t.Fatalf("expected an empty report, got %+v", r)
+	}
+}
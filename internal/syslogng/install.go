@@ -0,0 +1,169 @@
+package syslogng
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/txn"
+)
+
+const (
+	// DefaultMainConfig is the configuration syslog-ng loads at startup.
+	DefaultMainConfig = "/etc/syslog-ng/syslog-ng.conf"
+	// DefaultConfigDir is the drop-in directory Debian and RHEL include.
+	DefaultConfigDir  = "/etc/syslog-ng/conf.d"
+	DefaultConfigName = "99-nixpersist.conf"
+)
+
+// serviceName is the systemd unit and the service recorded in the ledger.
+const serviceName = "syslog-ng"
+
+var (
+	execCommand = exec.Command
+	lookPath    = exec.LookPath
+
+	// warnOut receives non-fatal warnings.
+	warnOut io.Writer = os.Stderr
+
+	// configRoot is where the live syslog-ng configuration is read from.
+	configRoot = "/"
+
+	// healthDelay is how long to wait after a reload before confirming
+	// syslog-ng is still running.
+	healthDelay = time.Second
+)
+
+// Install writes cfg to dest and reloads syslog-ng. If the reload or the
+// follow-up health check fails, the previous drop-in is restored and
+// syslog-ng is reloaded again. Requires root privileges.
+func Install(cfg, dest string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("installer: root privileges required (run with sudo)")
+	}
+	if cfg == "" {
+		return errors.New("installer: empty configuration provided")
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(dest), err)
+	}
+	if note := loadNote(dest); note != "" {
+		fmt.Fprintf(warnOut, "warning: %s\n", note)
+	}
+
+	var tx txn.Txn
+	// Registered first so it runs last, once the file has been restored.
+	tx.OnRollback(reload)
+	if err := tx.WriteFile(dest, []byte(cfg), 0644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := reload(); err != nil {
+		return tx.Rollback(fmt.Errorf("reload syslog-ng: %w", err))
+	}
+	if err := checkHealthy(); err != nil {
+		return tx.Rollback(err)
+	}
+	return nil
+}
+
+// Remove deletes the drop-in dest and reloads syslog-ng. Requires root
+// privileges.
+func Remove(dest string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("remove: root privileges required (run with sudo)")
+	}
+	if _, err := os.Stat(dest); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove: %s not present", dest)
+		}
+		return fmt.Errorf("remove: stat %s: %w", dest, err)
+	}
+	if err := os.Remove(dest); err != nil {
+		return fmt.Errorf("remove: delete %s: %w", dest, err)
+	}
+	if err := reload(); err != nil {
+		return fmt.Errorf("reload syslog-ng after removal: %w", err)
+	}
+	return nil
+}
+
+// readMain returns syslog-ng.conf as seen under configRoot.
+func readMain() (string, error) {
+	data, err := os.ReadFile(hunt.HostPath(configRoot, DefaultMainConfig))
+	return string(data), err
+}
+
+// loadNote explains that syslog-ng would ignore path, or returns "" when
+// syslog-ng.conf includes it or cannot be read.
+func loadNote(path string) string {
+	main, err := readMain()
+	if err != nil || includes(includePatterns(main), path) {
+		return ""
+	}
+	return fmt.Sprintf("%s is not included by %s; syslog-ng would ignore it", path, DefaultMainConfig)
+}
+
+// reloadCommands are tried in order until one succeeds: systemd, the
+// control socket, then SysV scripts; restarts follow the reloads.
+func reloadCommands() [][]string {
+	var cmds [][]string
+	if _, err := lookPath("systemctl"); err == nil {
+		cmds = append(cmds, []string{"systemctl", "reload", serviceName}, []string{"systemctl", "restart", serviceName})
+	}
+	if _, err := lookPath("syslog-ng-ctl"); err == nil {
+		cmds = append(cmds, []string{"syslog-ng-ctl", "reload"})
+	}
+	if _, err := lookPath("service"); err == nil {
+		cmds = append(cmds, []string{"service", serviceName, "reload"}, []string{"service", serviceName, "restart"})
+	}
+	return cmds
+}
+
+func reload() error {
+	cmds := reloadCommands()
+	if len(cmds) == 0 {
+		return errors.New("could not find a method to reload syslog-ng (systemctl, syslog-ng-ctl or service not available)")
+	}
+	var errs []string
+	for _, cmd := range cmds {
+		out, err := execCommand(cmd[0], cmd[1:]...).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v: %s", strings.Join(cmd, " "), err, strings.TrimSpace(string(out))))
+	}
+	return fmt.Errorf("failed to reload or restart syslog-ng: %s", strings.Join(errs, "; "))
+}
+
+// reloadPlan describes the commands reload would try on this host.
+func reloadPlan() []string {
+	cmds := reloadCommands()
+	if len(cmds) == 0 {
+		return []string{"reload syslog-ng: no systemctl, syslog-ng-ctl or service found, install would fail here"}
+	}
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, strings.Join(cmd, " "))
+	}
+	if len(names) == 1 {
+		return names
+	}
+	return []string{names[0] + " (falls back to " + strings.Join(names[1:], ", then ") + ")"}
+}
+
+// checkHealthy confirms syslog-ng is still running after a reload; a
+// configuration error makes it exit.
+func checkHealthy() error {
+	time.Sleep(healthDelay)
+	var r Result
+	if !checkRunning(&r) {
+		return errors.New("health check: syslog-ng is not running after reload")
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package syslogng
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"nixpersist/internal/apparmor"
+	"nixpersist/internal/audit"
+	"nixpersist/internal/hunt"
+	"nixpersist/internal/module"
+	"nixpersist/internal/preflight"
+	"nixpersist/internal/sigma"
+	"nixpersist/internal/state"
+)
+
+// Module exposes the program() destination drop-in as the "syslog-ng"
+// subcommand.
+type Module struct {
+	manageAppArmor bool
+	appArmorMode   string
+	source         string
+	in             string
+	port           int
+	out            string
+	payload        string
+	trigger        string
+	match          string
+	program        string
+	template       string
+	selinuxAVC     bool
+}
+
+// NewModule returns the syslog-ng module.
+func NewModule() *Module {
+	return &Module{source: string(SourceFile), port: DefaultPort}
+}
+
+func (m *Module) Name() string { return "syslog-ng" }
+
+func (m *Module) Describe() string {
+	return "Persistence via syslog-ng program() destination drop-in"
+}
+
+func (m *Module) Flags(fs *pflag.FlagSet) {
+	fs.BoolVar(&m.manageAppArmor, "apparmor", false, "relax the syslog-ng AppArmor profile on install and restore its prior mode on remove")
+	fs.StringVar(&m.appArmorMode, "apparmor-mode", "disable", "how --apparmor relaxes the profile: disable (unload, link into disable/) or complain (aa-complain)")
+	fs.StringVar(&m.source, "source", string(SourceFile), "messages the filter reads: file (-l), system (the host's system log source, else system()), udp or tcp (127.0.0.1:--port)")
+	fs.StringVarP(&m.in, "log-file-in", "l", "/var/log/auth.log", "log file to follow (--source file)")
+	fs.IntVar(&m.port, "port", DefaultPort, "localhost port of the udp and tcp sources")
+	fs.StringVarP(&m.out, "outfile", "o", "", "write rendered config to this file (default stdout)")
+	fs.StringVarP(&m.payload, "payload", "p", "/usr/bin/touch /tmp/nixpersist", "command line syslog-ng keeps running and writes matching messages to")
+	fs.StringVarP(&m.trigger, "trigger", "t", "uhtavi0", "message substring the filter selects (message() filter; empty to rely on --match or --program)")
+	fs.StringVar(&m.match, "match", "", "also require the message to match this PCRE (match() filter)")
+	fs.StringVar(&m.program, "program", "", "also require messages to come from this program name (program() filter)")
+	fs.StringVar(&m.template, "template", "", `format of the messages on the payload's stdin, such as '$MSG\n' (default: syslog-ng's)`)
+	fs.BoolVar(&m.selinuxAVC, "selinux-avc", false, "report SELinux AVC denials for syslog-ng (--check: last 24 hours; --install: since the install)")
+}
+
+// dest returns the drop-in the module installs.
+func (m *Module) dest() string { return filepath.Join(DefaultConfigDir, DefaultConfigName) }
+
+func (m *Module) params() (ConfigParams, error) {
+	src, err := ParseSource(m.source)
+	if err != nil {
+		return ConfigParams{}, err
+	}
+	p := ConfigParams{
+		Source:    src,
+		InputFile: m.in,
+		Port:      m.port,
+		Message:   m.trigger,
+		Match:     m.match,
+		Program:   m.program,
+		Payload:   m.payload,
+		Template:  m.template,
+	}
+	if src == SourceSystem {
+		// Reuse the host's source: only one may hold /dev/log.
+		if main, err := readMain(); err == nil {
+			p.SourceRef = systemSource(ParseBlocks(main))
+		}
+	}
+	return p, nil
+}
+
+func (m *Module) render() (string, error) {
+	p, err := m.params()
+	if err != nil {
+		return "", err
+	}
+	return RenderConfig(p)
+}
+
+func (m *Module) Check() (module.Report, error) {
+	res := Check()
+	if cfg, err := m.render(); err != nil {
+		res.Validation = "skipped (" + err.Error() + ")"
+	} else {
+		res.Validation = preflight.Describe(ValidateConfig(cfg))
+	}
+	res.inspectSELinux(m.payload, m.selinuxAVC)
+	return res, nil
+}
+
+func (m *Module) Render() (string, error) {
+	if m.manageAppArmor {
+		return "", errors.New("--apparmor requires --install or --remove")
+	}
+	cfg, err := m.render()
+	if err != nil {
+		return "", err
+	}
+	if m.out == "" {
+		return cfg, nil
+	}
+	if err := os.WriteFile(m.out, []byte(cfg), 0644); err != nil {
+		return "", fmt.Errorf("write failed: %w", err)
+	}
+	return "", nil
+}
+
+func (m *Module) Plan() (module.Plan, error) {
+	cfg, err := m.render()
+	if err != nil {
+		return module.Plan{}, err
+	}
+	dest := m.dest()
+	before, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return module.Plan{}, fmt.Errorf("read %s: %w", dest, err)
+	}
+	plan := module.Plan{
+		Files:    []module.FileEdit{{Path: dest, Before: before, After: []byte(cfg)}},
+		Commands: planCommands(m.manageAppArmor, m.appArmorMode),
+		Notes:    []string{"install recorded in the NixPersist ledger", "syslog-ng starts the payload when it loads the drop-in and restarts it if it exits; matching messages go to its stdin"},
+	}
+	if before != nil {
+		plan.Notes = append(plan.Notes, dest+" already exists: --install would fail (run --remove first)")
+	}
+	if note := loadNote(dest); note != "" {
+		plan.Notes = append(plan.Notes, note)
+	}
+	return plan, nil
+}
+
+func (m *Module) Install() (module.Outcome, error) {
+	cfg, err := m.render()
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	dest := m.dest()
+	if exists(dest) {
+		return module.Outcome{}, fmt.Errorf("%s already exists: run --remove first", dest)
+	}
+	if err := preflight.Gate(ValidateConfig(cfg), os.Stderr); err != nil {
+		return module.Outcome{}, err
+	}
+	rec, err := prepareAppArmor(m.manageAppArmor, m.appArmorMode)
+	if err != nil {
+		return module.Outcome{}, err
+	}
+	warnSELinux(m.payload)
+	start := time.Now()
+	if err := Install(cfg, dest); err != nil {
+		return module.Outcome{}, fmt.Errorf("install failed: %w", restoreAppArmor(m.manageAppArmor, rec, err))
+	}
+	res := module.Outcome{
+		Message:  fmt.Sprintf("install complete: %s applied and syslog-ng reloaded", dest),
+		Files:    []state.FileChange{state.Observe(dest)},
+		Services: []string{serviceName},
+	}
+	if m.manageAppArmor {
+		res.Message += "; AppArmor profile " + rec.String()
+		res.AppArmor = []string{rec.String()}
+	}
+	if m.selinuxAVC {
+		res.Message += selinuxDenials(start)
+	}
+	return res, nil
+}
+
+func (m *Module) Remove() (module.Outcome, error) {
+	var res module.Outcome
+	dest := m.dest()
+	var appArmorNote string
+	if m.manageAppArmor {
+		rec, err := RestoreProfile()
+		if err != nil {
+			return res, fmt.Errorf("failed to restore AppArmor profile: %w", err)
+		}
+		res.AppArmor = []string{rec.Restored()}
+		appArmorNote = "; AppArmor profile " + rec.Restored()
+	}
+	files := []state.FileChange{state.ObserveDelete(dest)}
+	if err := Remove(dest); err != nil {
+		return res, fmt.Errorf("remove failed: %w", err)
+	}
+	res.Message = fmt.Sprintf("remove complete: %s removed and syslog-ng reloaded", dest) + appArmorNote
+	res.Files = files
+	res.Services = []string{serviceName}
+	return res, nil
+}
+
+// Detections returns rules for the drop-in the module would install.
+func (m *Module) Detections() ([]sigma.Rule, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := Detections(params, m.dest())
+	if err != nil {
+		return nil, err
+	}
+	if m.manageAppArmor {
+		mode, err := apparmor.ParseMode(m.appArmorMode)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, AppArmorDetection(mode))
+	}
+	return rules, nil
+}
+
+// AuditRules returns auditd rules for the drop-in and its payload.
+func (m *Module) AuditRules() ([]audit.Rule, error) {
+	if _, err := m.render(); err != nil {
+		return nil, err
+	}
+	return AuditRules(m.dest(), m.payload), nil
+}
+
+// Hunt reports program() destinations anywhere in the syslog-ng
+// configuration.
+func (m *Module) Hunt(root string) hunt.Report { return Hunt(root) }
+
+// planCommands lists what --install runs besides writing the drop-in.
+func planCommands(manageAppArmor bool, appArmorMode string) []string {
+	cmds := []string{"syslog-ng --syntax-only --cfgfile=<staged copy of " + DefaultMainConfig + " with the drop-in appended> (pre-flight validation)"}
+	if manageAppArmor {
+		if mode, err := apparmor.ParseMode(appArmorMode); err != nil {
+			cmds = append(cmds, err.Error())
+		} else {
+			profile := syslogngProfile()
+			cmds = append(cmds, "record the current mode of "+string(profile)+" for --remove")
+			cmds = append(cmds, apparmor.RelaxPlan(profile, mode)...)
+		}
+	}
+	cmds = append(cmds, reloadPlan()...)
+	return append(cmds, "systemctl is-active syslog-ng.service or pgrep -x syslog-ng (health check)")
+}
+
+// restoreAppArmor restores the syslog-ng profile after a failed install
+// that had relaxed it, unless an earlier install relaxed it first.
+func restoreAppArmor(managed bool, rec apparmor.Record, cause error) error {
+	if !managed {
+		return cause
+	}
+	if err := rec.Abort(); err != nil {
+		return fmt.Errorf("%w (restoring AppArmor profile failed: %w)", cause, err)
+	}
+	return cause
+}
+
+// prepareAppArmor relaxes the syslog-ng profile as appArmorMode says when
+// manage is set, and otherwise warns if the profile is enforced.
+func prepareAppArmor(manage bool, appArmorMode string) (apparmor.Record, error) {
+	if manage {
+		mode, err := apparmor.ParseMode(appArmorMode)
+		if err != nil {
+			return apparmor.Record{}, err
+		}
+		rec, err := RelaxProfile(mode)
+		if err != nil {
+			return rec, fmt.Errorf("failed to relax AppArmor profile: %w", err)
+		}
+		return rec, nil
+	}
+	if mode, err := syslogngProfile().Current(); err == nil && mode == apparmor.Enforce {
+		fmt.Fprintln(warnOut, "warning: syslog-ng AppArmor profile is enforced; run with --apparmor to relax it before install")
+	}
+	return apparmor.Record{}, nil
+}
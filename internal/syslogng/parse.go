@@ -0,0 +1,173 @@
+package syslogng
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Block is a top-level statement of a syslog-ng configuration, such as
+// "destination d_x { program("/bin/x"); };".
+type Block struct {
+	// Kind is the statement keyword: source, filter, destination, log, ...
+	Kind string
+	// Name is the object's name; empty for log paths and options.
+	Name string
+	// Body is the text between the braces, with comments blanked out.
+	Body string
+	// Line is the line of the keyword and BodyLine that of the opening
+	// brace, both 1-based.
+	Line     int
+	BodyLine int
+}
+
+// ParseBlocks splits src into its top-level blocks. Pragmas such as
+// @version and @include are skipped; use includePatterns for those.
+func ParseBlocks(src string) []Block {
+	clean := stripComments(src)
+	var blocks []Block
+	depth, start, bodyStart := 0, 0, 0
+	var quote byte
+	var cur Block
+	for i := 0; i < len(clean); i++ {
+		c := clean[i]
+		if quote != 0 {
+			switch {
+			case c == '\\' && quote == '"':
+				i++
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{':
+			if depth == 0 {
+				fields := strings.Fields(clean[start:i])
+				cur = Block{}
+				if len(fields) > 0 {
+					cur.Kind = fields[0]
+				}
+				if len(fields) > 1 {
+					cur.Name = fields[1]
+				}
+				head := start + len(clean[start:i]) - len(strings.TrimLeft(clean[start:i], " \t\r\n"))
+				cur.Line = lineAt(clean, head)
+				cur.BodyLine = lineAt(clean, i)
+				bodyStart = i + 1
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				cur.Body = clean[bodyStart:i]
+				blocks = append(blocks, cur)
+				start = i + 1
+			}
+		case ';':
+			if depth == 0 {
+				start = i + 1
+			}
+		case '\n':
+			if depth == 0 && strings.HasPrefix(strings.TrimSpace(clean[start:i]), "@") {
+				start = i + 1
+			}
+		}
+	}
+	return blocks
+}
+
+// stripComments blanks out # comments outside strings, keeping offsets and
+// line numbers.
+func stripComments(src string) string {
+	b := []byte(src)
+	var quote byte
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if quote != 0 {
+			switch {
+			case c == '\\' && quote == '"':
+				i++
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '#':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b)
+}
+
+func lineAt(s string, off int) int { return strings.Count(s[:off], "\n") + 1 }
+
+// unquote returns the value of a syslog-ng string literal.
+func unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	if s[0] == '\'' {
+		return s[1 : len(s)-1]
+	}
+	s = s[1 : len(s)-1]
+	s = strings.ReplaceAll(s, `\"`, `"`)
+	return strings.ReplaceAll(s, `\\`, `\`)
+}
+
+var (
+	includePattern = regexp.MustCompile(`(?m)^\s*@include\s+("(?:[^"\\]|\\.)*"|'[^']*')`)
+	stringLiteral  = `("(?:[^"\\]|\\.)*"|'[^']*')`
+	programDriver  = regexp.MustCompile(`(?:^|[^\w-])program\s*\(\s*` + stringLiteral)
+	systemDriver   = regexp.MustCompile(`(?:^|[^\w-])(?:system\s*\(|unix-(?:dgram|stream)\s*\(\s*["']/dev/log["'])`)
+	destinationRef = regexp.MustCompile(`(?:^|[^\w-])destination\s*\(\s*([A-Za-z_][\w-]*)\s*\)`)
+)
+
+// includePatterns returns the @include paths of src, made absolute against
+// the directory of the main configuration.
+func includePatterns(src string) []string {
+	var patterns []string
+	for _, m := range includePattern.FindAllStringSubmatch(src, -1) {
+		p := unquote(m[1])
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(DefaultMainConfig), p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// includes reports whether one of patterns loads path: a glob matching
+// it, or its directory.
+func includes(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if filepath.Clean(pattern) == filepath.Dir(path) {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// systemSource returns the name of the first source in blocks that reads
+// the local system log, or "".
+func systemSource(blocks []Block) string {
+	for _, b := range blocks {
+		if b.Kind == "source" && b.Name != "" && systemDriver.MatchString(b.Body) {
+			return b.Name
+		}
+	}
+	return ""
+}
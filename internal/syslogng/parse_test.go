@@ -0,0 +1,80 @@
+package syslogng
+
+import (
+	"reflect"
+	"testing"
+)
+
+const debianMain = `@version: 3.38
+@include "scl.conf"
+
+# First, set some global options.
+options { chain_hostnames(off); flush_lines(0); };
+
+source s_src {
+       system();
+       internal();
+};
+
+destination d_auth { file("/var/log/auth.log"); };
+filter f_auth { facility(auth, authpriv); };
+log { source(s_src); filter(f_auth); destination(d_auth); };
+
+@include "/etc/syslog-ng/conf.d/*.conf"
+`
+
+func TestParseBlocks(t *testing.T) {
+	src := "# source s_commented { system(); };\n" +
+		"destination d_x {\n  program(\"/bin/x '}'\" # trailing }\n  );\n};\n" +
+		"log { source(s_src); destination(d_x); };\n"
+	blocks := ParseBlocks(src)
+	var got []string
+	for _, b := range blocks {
+		got = append(got, b.Kind+" "+b.Name)
+	}
+	if want := []string{"destination d_x", "log "}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("blocks = %q, want %q", got, want)
+	}
+	if b := blocks[0]; b.Line != 2 || b.BodyLine != 2 {
+		t.Fatalf("unexpected position %+v", b)
+	}
+	if b := blocks[1]; b.Line != 6 {
+		t.Fatalf("unexpected log position %+v", b)
+	}
+}
+
+func TestParseBlocksSkipsPragmas(t *testing.T) {
+	blocks := ParseBlocks(debianMain)
+	if len(blocks) != 5 || blocks[0].Kind != "options" || blocks[1].Name != "s_src" || blocks[1].Line != 7 {
+		t.Fatalf("unexpected blocks %+v", blocks)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	patterns := includePatterns(debianMain)
+	if want := []string{"/etc/syslog-ng/scl.conf", "/etc/syslog-ng/conf.d/*.conf"}; !reflect.DeepEqual(patterns, want) {
+		t.Fatalf("patterns = %q, want %q", patterns, want)
+	}
+	if !includes(patterns, "/etc/syslog-ng/conf.d/99-nixpersist.conf") {
+		t.Fatal("expected the drop-in to be included")
+	}
+	if includes(patterns, "/etc/syslog-ng/other/x.conf") {
+		t.Fatal("unexpected include of other/x.conf")
+	}
+	if !includes([]string{"/etc/syslog-ng/conf.d"}, "/etc/syslog-ng/conf.d/x.conf") {
+		t.Fatal("expected a directory include to load its files")
+	}
+}
+
+func TestSystemSource(t *testing.T) {
+	if got := systemSource(ParseBlocks(debianMain)); got != "s_src" {
+		t.Fatalf("systemSource = %q", got)
+	}
+	rhel := `source s_sys { unix-dgram("/dev/log"); internal(); };`
+	if got := systemSource(ParseBlocks(rhel)); got != "s_sys" {
+		t.Fatalf("systemSource = %q", got)
+	}
+	if got := systemSource(ParseBlocks(`source s_net { network(port(514)); };`)); got != "" {
+		t.Fatalf("systemSource = %q, want none", got)
+	}
+}
@@ -0,0 +1,43 @@
+package syslogng
+
+import (
+	"fmt"
+	"os"
+
+	"nixpersist/internal/preflight"
+)
+
+// ValidateConfig checks a rendered drop-in with "syslog-ng --syntax-only"
+// against a staged copy of syslog-ng.conf with the drop-in appended, so
+// references to the host's sources resolve. Without a readable
+// syslog-ng.conf the drop-in is staged behind a minimal header.
+func ValidateConfig(cfg string) error {
+	bin, err := lookPath("syslog-ng")
+	if err != nil {
+		return preflight.Unavailable("syslog-ng not found in PATH")
+	}
+	main, err := readMain()
+	if err != nil {
+		v := DetectVersion()
+		if v.Config == "" {
+			return preflight.Unavailable("%s unreadable and syslog-ng --version reports no config version", DefaultMainConfig)
+		}
+		main = fmt.Sprintf("@version: %s\n@include \"scl.conf\"\n", v.Config)
+	}
+
+	f, err := os.CreateTemp("", "nixpersist-syslog-ng-*.conf")
+	if err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%s\n%s", main, cfg); err != nil {
+		f.Close()
+		return preflight.Unavailable("stage config: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return preflight.Unavailable("stage config: %v", err)
+	}
+
+	out, err := execCommand(bin, "--syntax-only", "--cfgfile="+f.Name()).CombinedOutput()
+	return preflight.FromCommand("syslog-ng --syntax-only --cfgfile="+f.Name(), out, err)
+}
@@ -0,0 +1,68 @@
+// Package txn tracks undo actions for multi-step host changes so that a
+// failure part-way through an install puts the host back the way it was.
+package txn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Txn accumulates undo actions. The zero value is ready to use.
+type Txn struct {
+	undo []func() error
+}
+
+// OnRollback registers fn to run if the transaction is rolled back. Undo
+// actions run in reverse registration order, so register actions that must
+// happen last (such as reloading a service) first.
+func (t *Txn) OnRollback(fn func() error) {
+	t.undo = append(t.undo, fn)
+}
+
+// WriteFile writes data to path and registers an undo action that restores
+// the previous content and mode, or deletes the file if it did not exist.
+func (t *Txn) WriteFile(path string, data []byte, perm os.FileMode) error {
+	prev, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	prevMode := perm
+	if info, err := os.Stat(path); err == nil {
+		prevMode = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	t.OnRollback(func() error {
+		if !existed {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove %s: %w", path, err)
+			}
+			return nil
+		}
+		if err := os.WriteFile(path, prev, prevMode); err != nil {
+			return fmt.Errorf("restore %s: %w", path, err)
+		}
+		return nil
+	})
+	return nil
+}
+
+// Rollback runs the registered undo actions and returns cause annotated with
+// any rollback failures. The transaction is empty afterwards.
+func (t *Txn) Rollback(cause error) error {
+	var errs []error
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		if err := t.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.undo = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("%w (rollback failed: %w)", cause, errors.Join(errs...))
+	}
+	return fmt.Errorf("%w (changes rolled back)", cause)
+}
@@ -0,0 +1,59 @@
+package txn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollbackRestoresFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.conf")
+	created := filepath.Join(dir, "created.conf")
+	if err := os.WriteFile(existing, []byte("original\n"), 0640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var order []string
+	var tx Txn
+	tx.OnRollback(func() error { order = append(order, "reload"); return nil })
+	if err := tx.WriteFile(existing, []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if err := tx.WriteFile(created, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	tx.OnRollback(func() error { order = append(order, "last-registered"); return nil })
+
+	err := tx.Rollback(errors.New("reload failed"))
+	if err == nil || !strings.Contains(err.Error(), "reload failed") || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("unexpected rollback error: %v", err)
+	}
+
+	data, _ := os.ReadFile(existing)
+	if string(data) != "original\n" {
+		t.Fatalf("existing file not restored: %q", data)
+	}
+	info, _ := os.Stat(existing)
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode not restored: %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(created); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("created file should be removed, got %v", err)
+	}
+	if strings.Join(order, ",") != "last-registered,reload" {
+		t.Fatalf("unexpected undo order: %v", order)
+	}
+}
+
+func TestRollbackReportsUndoFailures(t *testing.T) {
+	var tx Txn
+	tx.OnRollback(func() error { return errors.New("reload again failed") })
+	cause := errors.New("health check failed")
+	err := tx.Rollback(cause)
+	if !errors.Is(err, cause) || !strings.Contains(err.Error(), "reload again failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}